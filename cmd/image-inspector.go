@@ -1,50 +1,146 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 
 	iiapi "github.com/openshift/image-inspector/pkg/api"
 	iicmd "github.com/openshift/image-inspector/pkg/cmd"
 	ii "github.com/openshift/image-inspector/pkg/inspector"
+	"github.com/openshift/image-inspector/pkg/logging"
+	"github.com/openshift/image-inspector/pkg/version"
 )
 
 func main() {
 	inspectorOptions := iicmd.NewDefaultImageInspectorOptions()
 
 	flag.StringVar(&inspectorOptions.URI, "docker", inspectorOptions.URI, "Daemon socket to connect to")
+	flag.StringVar(&inspectorOptions.DockerTLSCert, "docker-tls-cert", inspectorOptions.DockerTLSCert, "Path to the client certificate used to connect with TLS to a tcp:// docker daemon")
+	flag.StringVar(&inspectorOptions.DockerTLSKey, "docker-tls-key", inspectorOptions.DockerTLSKey, "Path to the key of the client certificate used to connect with TLS to a tcp:// docker daemon")
+	flag.StringVar(&inspectorOptions.DockerTLSCA, "docker-tls-ca", inspectorOptions.DockerTLSCA, "Path to the CA certificate verifying the tcp:// docker daemon connected to with TLS")
 	flag.StringVar(&inspectorOptions.Image, "image", inspectorOptions.Image, "Docker image to inspect (cannot be used with the container option)")
 	flag.StringVar(&inspectorOptions.Container, "container", inspectorOptions.Container, "Docker container to inspect (cannot be used with the image option)")
+	flag.StringVar(&inspectorOptions.RootfsPath, "rootfs-path", inspectorOptions.RootfsPath, "Directory holding an already extracted filesystem to inspect (cannot be used with the image and container options)")
+	flag.StringVar(&inspectorOptions.ImageTar, "image-tar", inspectorOptions.ImageTar, "Docker save tarball of the image to inspect without the docker daemon, - to read it from stdin (cannot be used with the image, container and rootfs-path options)")
 	flag.BoolVar(&inspectorOptions.ScanContainerChanges, "container-changes", inspectorOptions.ScanContainerChanges, "Scan only changed files inside running container")
 	flag.StringVar(&inspectorOptions.DstPath, "path", inspectorOptions.DstPath, "Destination path for the image files")
-	flag.StringVar(&inspectorOptions.Serve, "serve", inspectorOptions.Serve, "Host and port where to serve the image with webdav")
+	flag.BoolVar(&inspectorOptions.Cleanup, "cleanup", inspectorOptions.Cleanup, "Remove the image files and the scan results directory once the inspection is done, they are kept otherwise")
+	flag.Var(&inspectorOptions.ExtractPaths, "extract-path", "Absolute path of the image to extract, the whole image is extracted by default. May be specified more than once")
+	flag.BoolVar(&inspectorOptions.MetadataOnly, "metadata-only", inspectorOptions.MetadataOnly, "Report the layers, the size and the labels of the image from its metadata, without extracting and scanning it")
+	flag.BoolVar(&inspectorOptions.ScanVolumesOnly, "scan-volumes-only", inspectorOptions.ScanVolumesOnly, "Extract and scan only the volumes declared in the image config, the scan is skipped when the image declares no volumes")
+	flag.StringVar(&inspectorOptions.Serve, "serve", inspectorOptions.Serve, "Host and port where to serve the image with webdav, the port is picked by the system and logged when it is 0")
+	flag.BoolVar(&inspectorOptions.ServeOnScanError, "serve-on-scan-error", inspectorOptions.ServeOnScanError, "Serve the image when the scan fails, reporting the error in the metadata, instead of failing the inspection")
 	flag.BoolVar(&inspectorOptions.Chroot, "chroot", inspectorOptions.Chroot, "Change root when serving the image with webdav")
-	flag.Var(&inspectorOptions.DockerCfg, "dockercfg", "Location of the docker configuration files. May be specified more than once")
+	flag.BoolVar(&inspectorOptions.ServePartialResults, "serve-partial-results", inspectorOptions.ServePartialResults, "Serve the image while scanning it, with the results found so far served as incomplete")
+	flag.DurationVar(&inspectorOptions.ReadTimeout, "read-timeout", inspectorOptions.ReadTimeout, "Time limit of reading each request sent to the webdav server, 0 for no limit")
+	flag.DurationVar(&inspectorOptions.WriteTimeout, "write-timeout", inspectorOptions.WriteTimeout, "Time limit of writing each response of the webdav server, including the downloads of the image files, 0 for no limit")
+	flag.DurationVar(&inspectorOptions.IdleTimeout, "idle-timeout", inspectorOptions.IdleTimeout, "Time an idle connection to the webdav server is kept open, the read timeout is used when 0")
+	flag.BoolVar(&inspectorOptions.DisableCompression, "disable-compression", inspectorOptions.DisableCompression, "Don't gzip-compress the responses of the webdav server, e.g. the reports, the metadata and the image files, for the clients accepting it")
+	flag.BoolVar(&inspectorOptions.WebdavIndex, "webdav-index", inspectorOptions.WebdavIndex, "List the image directories in HTML when browsing the webdav content endpoint")
+	flag.StringVar(&inspectorOptions.AllowedMethods, "allowed-methods", inspectorOptions.AllowedMethods, fmt.Sprintf("Comma separated list of the HTTP methods accepted on the webdav content endpoint, out of %v, default is to accept the read-only %v", iiapi.WebdavMethods, iiapi.ReadOnlyWebdavMethods))
+	flag.BoolVar(&inspectorOptions.AllowWrite, "allow-write", inspectorOptions.AllowWrite, "Accept all the HTTP methods on the webdav content endpoint, letting the clients modify the served image")
+	flag.Var(&inspectorOptions.DockerCfg, "dockercfg", "Location of the docker configuration files, their credHelpers and credsStore credential helpers are run to get the registry credentials. May be specified more than once")
 	flag.StringVar(&inspectorOptions.Username, "username", inspectorOptions.Username, "username for authenticating with the docker registry")
-	flag.StringVar(&inspectorOptions.PasswordFile, "password-file", inspectorOptions.PasswordFile, "Location of a file that contains the password for authentication with the docker registry")
+	flag.StringVar(&inspectorOptions.PasswordFile, "password-file", inspectorOptions.PasswordFile, fmt.Sprintf("Location of a file that contains the password for authentication with the docker registry, the password is read from the %s environment variable when missing", iicmd.RegistryPasswordEnv))
+	flag.BoolVar(&inspectorOptions.AnonymousFallback, "anonymous-fallback", inspectorOptions.AnonymousFallback, "Pull the image without authentication when all the given authentications fail, the image is always pulled anonymously when no authentication is given")
 	flag.StringVar(&inspectorOptions.ScanType, "scan-type", inspectorOptions.ScanType, fmt.Sprintf("The type of the scan to be done on the inspected image. Available scan types are: %v", iiapi.ScanOptions))
 	flag.StringVar(&inspectorOptions.ScanResultsDir, "scan-results-dir", inspectorOptions.ScanResultsDir, "The directory that will contain the results of the scan")
-	flag.BoolVar(&inspectorOptions.OpenScapHTML, "openscap-html-report", inspectorOptions.OpenScapHTML, "Generate an OpenScap HTML report in addition to the ARF formatted report")
-	flag.StringVar(&inspectorOptions.CVEUrlPath, "cve-url", inspectorOptions.CVEUrlPath, "An alternative URL source for CVE files")
+	flag.BoolVar(&inspectorOptions.Strict, "strict", inspectorOptions.Strict, "Fail if the scan results directory contains the reports of a previous scan instead of removing them")
+	flag.BoolVar(&inspectorOptions.OpenSCAP.HTML, "openscap-html-report", inspectorOptions.OpenSCAP.HTML, "Generate an OpenScap HTML report in addition to the ARF formatted report")
+	flag.BoolVar(&inspectorOptions.OpenSCAP.XCCDFResults, "openscap-xccdf-results", inspectorOptions.OpenSCAP.XCCDFResults, "Write the plain XCCDF results document in addition to the ARF formatted report")
+	flag.BoolVar(&inspectorOptions.OpenSCAP.JUnit, "openscap-junit-report", inspectorOptions.OpenSCAP.JUnit, "Generate a JUnit report of the rules evaluated by the OpenSCAP scan")
+	flag.StringVar(&inspectorOptions.OpenSCAP.Profile, "openscap-profile", inspectorOptions.OpenSCAP.Profile, "Id of the XCCDF profile evaluated by the OpenSCAP scan, default is the profile of the CVE feed")
+	flag.StringVar(&inspectorOptions.OpenSCAP.TailoringFile, "openscap-tailoring-file", inspectorOptions.OpenSCAP.TailoringFile, "XCCDF tailoring file customizing the rules selected by the OpenSCAP scan")
+	flag.StringVar(&inspectorOptions.OpenSCAP.CVEUrlAltPath, "cve-url", inspectorOptions.OpenSCAP.CVEUrlAltPath, "An alternative URL source for CVE files, default is the source of the distribution of the image")
+	flag.Var((*iicmd.StringSliceVar)(&inspectorOptions.OpenSCAP.ExtraArgs), "oscap-arg", "Extra argument appended verbatim to the oscap xccdf eval command, ex. --skip-valid. May be specified more than once")
+	flag.BoolVar(&inspectorOptions.OpenSCAP.FetchRemote, "oscap-fetch-remote", inspectorOptions.OpenSCAP.FetchRemote, "Let oscap download the remote resources referenced by the CVE feed, the scan then requires network access (the proxy environment variables are honored)")
+	flag.BoolVar(&inspectorOptions.OpenSCAP.ValidateCVE, "oscap-validate-cve", inspectorOptions.OpenSCAP.ValidateCVE, "Check that the CVE feed is a well-formed datastream that oscap can read before scanning")
+	flag.StringVar(&inspectorOptions.OpenSCAP.LocalCVEFile, "cve-file", inspectorOptions.OpenSCAP.LocalCVEFile, "A local CVE file to use instead of downloading it, its name must match the distribution release of the image (e.g. com.redhat.rhsa-RHEL7.ds.xml.bz2 or rhel-8.ds.xml.bz2)")
+	flag.Var(&inspectorOptions.KmodInitPaths, "kmod-init-path", "Glob of the init scripts searched for commands loading kernel modules by the kmod scan-type, matched against the file names when it has no slash, replacing the default init scripts and systemd units. May be specified more than once")
+	flag.Var(&inspectorOptions.ExcludePaths, "exclude-path", "Glob of the paths of the image left out of the scan, e.g. /var/cache, matched against the file names when it has no slash. May be specified more than once")
+	flag.Var(&inspectorOptions.HygienePatterns, "hygiene-pattern", "Glob of the paths reported by the hygiene scan-type, matched against the file names when it has no slash, replacing the default shell histories, SSH keys, temporary files and package caches. May be specified more than once")
+	flag.Var(&inspectorOptions.ApkSecDB, "apk-secdb", "Path or URL of an Alpine SecDB feed used by the apk scan-type, default are the main and community feeds of the Alpine release of the image. May be specified more than once")
 	flag.StringVar(&inspectorOptions.ClamSocket, "clam-socket", inspectorOptions.ClamSocket, "Location of clamav socket file (default: '')")
+	flag.Int64Var(&inspectorOptions.ClamMaxFileSize, "clam-max-file-size", inspectorOptions.ClamMaxFileSize, "Size in bytes above which the files are skipped instead of being submitted to clamd, e.g. to stay below its StreamMaxLength, 0 for no limit")
+	flag.BoolVar(&inspectorOptions.ClamDebug, "clam-debug", inspectorOptions.ClamDebug, "Log the messages exchanged with clamd to the standard error")
+	flag.BoolVar(&inspectorOptions.KeepOriginalImageName, "keep-original-image-name", inspectorOptions.KeepOriginalImageName, "Add the image name as given in input to the results besides the normalized image name")
+	flag.BoolVar(&inspectorOptions.EmbedProvenance, "embed-provenance", inspectorOptions.EmbedProvenance, "Add the build provenance (version, commit and build date) of image-inspector to the results")
+	flag.BoolVar(&inspectorOptions.DetectOS, "detect-os", inspectorOptions.DetectOS, "Add the detected OS family (rhel, debian or alpine) and package manager (rpm, dpkg or apk) of the image to the results")
+	flag.StringVar(&inspectorOptions.OutputFile, "output-file", inspectorOptions.OutputFile, "After scan finish, write the results in JSON to this file")
+	flag.BoolVar(&inspectorOptions.OutputGzip, "output-gzip", inspectorOptions.OutputGzip, "Write the output file gzip-compressed, as it is when its name ends with .gz")
+	flag.StringVar(&inspectorOptions.SplitResults, "split-results", inspectorOptions.SplitResults, "After scan finish, also write the results of each scanner in JSON to <scanner>.json in this directory, created when missing")
+	flag.StringVar(&inspectorOptions.AttestationFile, "attestation-file", inspectorOptions.AttestationFile, "After scan finish, write the results as an in-toto attestation statement about the image to this file")
+	flag.StringVar(&inspectorOptions.AttestationKeyFile, "attestation-key", inspectorOptions.AttestationKeyFile, "PEM private key (ECDSA, RSA or Ed25519) signing the attestation, which is then written in a DSSE envelope")
+	flag.BoolVar(&inspectorOptions.FailOnScanError, "fail-on-scan-error", inspectorOptions.FailOnScanError, "Fail the inspection when a scanner failed, once the results with the error status are written and posted, unless the image is served")
+	flag.StringVar(&inspectorOptions.FailOnSeverity, "fail-on-severity", inspectorOptions.FailOnSeverity, fmt.Sprintf("Fail the inspection when any result is at least of this severity, one of %v", iiapi.SeverityOptions))
+	flag.StringVar(&inspectorOptions.BaselineResult, "baseline-result", inspectorOptions.BaselineResult, "File holding the JSON results of a previous scan, the results then report the findings added, removed and unchanged since then")
 	flag.StringVar(&inspectorOptions.PostResultURL, "post-results-url", inspectorOptions.PostResultURL, "After scan finish, HTTP POST the results to this URL")
 	flag.StringVar(&inspectorOptions.PostResultTokenFile, "post-results-token-file", inspectorOptions.PostResultTokenFile, "If specified, content of it will be added to the POST result URL (?token=....)")
-	flag.StringVar(&inspectorOptions.AuthTokenFile, "webdav-token-file", inspectorOptions.AuthTokenFile, "If specified, token used to authenticate to Image Inspector will be read from this file")
+	flag.IntVar(&inspectorOptions.PostResultRetries, "post-results-retries", inspectorOptions.PostResultRetries, "Number of times posting the results failing with a network or server error is retried")
+	flag.Var(&inspectorOptions.OutputDirMode, "output-dir-mode", "Octal permissions of the extraction, scan results and layer cache directories created, e.g. 0700, the defaults are 0755 for the given paths and 0700 for the temporary ones")
+	flag.DurationVar(&inspectorOptions.ScanTimeout, "scan-timeout", inspectorOptions.ScanTimeout, "Time limit of the scan, reporting the scan as failed with the problems found until then, 0 for no limit")
+	flag.IntVar(&inspectorOptions.MaxFiles, "max-files", inspectorOptions.MaxFiles, "Number of files after which the scan stops, recording in the metadata that it was truncated, 0 for no limit")
+	flag.DurationVar(&inspectorOptions.PostTimeout, "post-results-timeout", inspectorOptions.PostTimeout, "Time limit of each attempt of posting the results, 0 for no limit")
+	flag.BoolVar(&inspectorOptions.IgnorePostErrors, "ignore-post-errors", inspectorOptions.IgnorePostErrors, "Log the failures of posting the results instead of failing the inspection")
+	flag.BoolVar(&inspectorOptions.PostMultipart, "post-multipart", inspectorOptions.PostMultipart, "Post the results as multipart/form-data with the scan reports attached as file parts")
+	flag.StringVar(&inspectorOptions.AuthTokenFile, "webdav-token-file", inspectorOptions.AuthTokenFile, fmt.Sprintf("If specified, token used to authenticate to Image Inspector will be read from this file instead of the %s environment variable, either may hold a comma-separated list of tokens accepted alike", iicmd.AuthTokenEnv))
+	flag.Var(&inspectorOptions.RegistryMirrors, "registry-mirror", "Registry, optionally with a path prefix, the image is pulled from before its own registry, e.g. mirror.example.com/dockerhub. May be specified more than once, the mirrors are tried in order")
 	flag.StringVar(&inspectorOptions.PullPolicy, "pull-policy", inspectorOptions.PullPolicy, fmt.Sprintf("Pull policy, default is %s, options are: %v", iiapi.PullIfNotPresent, iiapi.PullPolicyOptions))
+	flag.IntVar(&inspectorOptions.PullRetryCount, "pull-retries", inspectorOptions.PullRetryCount, "Number of times a pull failing with a network or registry server error is retried")
+	flag.DurationVar(&inspectorOptions.PullRetryInterval, "pull-retry-interval", inspectorOptions.PullRetryInterval, "Time to wait before retrying a failed pull, doubled after each retry")
+	flag.DurationVar(&inspectorOptions.DockerDialTimeout, "docker-dial-timeout", inspectorOptions.DockerDialTimeout, "Time limit of connecting to the docker daemon, 0 for no limit")
+	flag.DurationVar(&inspectorOptions.DockerKeepAlive, "docker-keep-alive", inspectorOptions.DockerKeepAlive, "Interval of the keep-alive probes of the idle connections to the docker daemon, 0 to disable them")
+	flag.StringVar(&inspectorOptions.LayerCacheDir, "layer-cache-dir", inspectorOptions.LayerCacheDir, "Directory where extracted image layers are cached and reused by later inspections")
+	flag.IntVar(&inspectorOptions.ExtractConcurrency, "extract-concurrency", inspectorOptions.ExtractConcurrency, "Number of layers extracted at once into the layer-cache-dir while the image is exported, 0 to extract them once it's exported")
+	flag.StringVar(&inspectorOptions.DeniedDigestsFile, "denied-digests-file", inspectorOptions.DeniedDigestsFile, "File listing the digests of known-bad images and layers, one per line, refusing to inspect the images matching them")
+	flag.BoolVar(&inspectorOptions.UTCTimestamps, "utc-timestamps", inspectorOptions.UTCTimestamps, "Emit the timestamps of the results and metadata in UTC and RFC3339 format")
+	flag.StringVar(&inspectorOptions.SymlinkPolicy, "symlink-policy", inspectorOptions.SymlinkPolicy, fmt.Sprintf("How to extract symlinks with absolute or escaping targets, default is %s, options are: %v", iiapi.SymlinkRelative, iiapi.SymlinkPolicyOptions))
+	flag.StringVar(&inspectorOptions.ArchMismatchPolicy, "arch-mismatch-policy", inspectorOptions.ArchMismatchPolicy, fmt.Sprintf("How to scan an image built for an architecture other than the host's, default is %s, options are: %v", iiapi.ArchMismatchWarn, iiapi.ArchMismatchPolicyOptions))
+	flag.StringVar(&inspectorOptions.UnknownOSPolicy, "unknown-os-policy", inspectorOptions.UnknownOSPolicy, fmt.Sprintf("How to scan an image without an os-release or redhat-release file, default is %s, options are: %v", iiapi.UnknownOSProbe, iiapi.UnknownOSPolicyOptions))
+	flag.BoolVar(&inspectorOptions.ExtractSpecialFiles, "extract-special-files", inspectorOptions.ExtractSpecialFiles, "Recreate the device nodes and FIFOs of the image when extracting it (device nodes require privileges)")
+	flag.BoolVar(&inspectorOptions.PreserveXattrs, "preserve-xattrs", inspectorOptions.PreserveXattrs, "Restore the extended attributes of the image files, e.g. the file capabilities, when extracting the image")
+
+	flag.StringVar(&inspectorOptions.LogLevel, "log-level", inspectorOptions.LogLevel, fmt.Sprintf("Minimum level of the logged messages, default is info, options are: %v", logging.LevelOptions))
+	flag.StringVar(&inspectorOptions.LogFormat, "log-format", inspectorOptions.LogFormat, fmt.Sprintf("Format of the logged messages, default is %s, options are: %v", logging.TextFormat, logging.FormatOptions))
+	flag.BoolVar(&inspectorOptions.DryRun, "dry-run", inspectorOptions.DryRun, "Check the options, the docker daemon, the registry auths and the scanner, and print the plan of the inspection without pulling, extracting or scanning the image")
+	flag.BoolVar(&inspectorOptions.Quiet, "quiet", inspectorOptions.Quiet, "Log only the warnings and the errors, whatever the log-level")
+	flag.BoolVar(&inspectorOptions.Verbose, "verbose", inspectorOptions.Verbose, "Log the debug messages, whatever the log-level, and the messages exchanged with clamd as with clam-debug")
+
+	printVersion := flag.Bool("version", false, "Print the version of image-inspector and exit")
+
+	// the selftest subcommand scans a built-in image instead of the inspected one
+	selfTest := len(os.Args) > 1 && os.Args[1] == "selftest"
+	if selfTest {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
 	flag.Parse()
 
-	if inspectorOptions.AuthTokenFile != "" {
-		authToken, err := ioutil.ReadFile(inspectorOptions.AuthTokenFile)
-		if err != nil {
-			log.Fatalf("error reading auth token file: %v", err)
+	if *printVersion {
+		fmt.Println(version.String())
+		return
+	}
+
+	if err := logging.Configure(os.Stderr, inspectorOptions.EffectiveLogLevel(), inspectorOptions.LogFormat); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if selfTest {
+		if err := inspectorOptions.ValidateSelfTest(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := ii.SelfTest(context.Background(), *inspectorOptions); err != nil {
+			log.Fatalf("Error: %v", err)
 		}
-		inspectorOptions.AuthToken = string(authToken)
-	} else {
-		inspectorOptions.AuthToken = os.Getenv("INSPECTOR_AUTH_TOKEN")
+		log.Printf("Selftest of scan-type %s passed", inspectorOptions.ScanType)
+		return
+	}
+
+	if err := inspectorOptions.LoadAuthToken(); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
 	if err := inspectorOptions.Validate(); err != nil {
@@ -68,6 +68,15 @@ EOF
   export II_TARGET_BIN=${II_GOPATH}/bin
 }
 
+# Prints the linker flags that embed the build provenance into the binary.
+ii::build::ldflags() {
+  local pkg="github.com/openshift/image-inspector/pkg/version"
+  local version commit
+  version=$(git describe --tags --always --dirty 2>/dev/null || echo unknown)
+  commit=$(git rev-parse HEAD 2>/dev/null || echo unknown)
+  echo "-X ${pkg}.Version=${version} -X ${pkg}.Commit=${commit} -X ${pkg}.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+}
+
 # Build image-inspector.go binary.
 ii::build::build_binaries() {
   # Create a sub-shell so that we don't pollute the outer environment
@@ -80,6 +89,6 @@ ii::build::build_binaries() {
     export GOBIN="${II_OUTPUT_BINPATH}/${platform}"
 
     mkdir -p "${II_OUTPUT_BINPATH}/${platform}"
-    go install "cmd/image-inspector.go"
+    go install -ldflags "$(ii::build::ldflags)" "cmd/image-inspector.go"
   )
 }
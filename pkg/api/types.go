@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"os"
+	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -15,12 +16,50 @@ const (
 	StatusNotRequested OpenSCAPStatus = "NotRequested"
 	StatusSuccess      OpenSCAPStatus = "Success"
 	StatusError        OpenSCAPStatus = "Error"
+	StatusSkipped      OpenSCAPStatus = "Skipped"
+	StatusUnknownOS    OpenSCAPStatus = "UnknownOS"
 	// PullAlways means that image-inspector always attempts to pull the latest image.  Inspection will fail If the pull fails.
 	PullAlways string = "always"
 	// PullNever means that image-inspector never pulls an image, but only uses a local image.  Inspection will fail if the image isn't present
 	PullNever string = "never"
 	// PullIfNotPresent means that image-inspector pulls if the image isn't present on disk. Inspection will fail if the image isn't present and the pull fails.
 	PullIfNotPresent string = "when-missing"
+	// SymlinkRelative means that absolute or escaping symlink targets are rewritten as relative
+	// paths that resolve inside the extraction root.
+	SymlinkRelative string = "relative"
+	// SymlinkSkip means that symlinks with absolute or escaping targets are not extracted.
+	SymlinkSkip string = "skip"
+	// SymlinkClamp means that absolute or escaping symlink targets are rewritten as absolute
+	// paths under the extraction root.
+	SymlinkClamp string = "clamp"
+	// SymlinkKeep means that symlinks are extracted as found in the image. This is insecure since
+	// absolute targets may point to files of the hosting system.
+	SymlinkKeep string = "keep"
+	// ArchMismatchWarn means that an image built for an architecture other than the host's
+	// is scanned by all the scanners after logging a warning.
+	ArchMismatchWarn string = "warn"
+	// ArchMismatchSkip means that the architecture sensitive scanners (e.g. OpenSCAP) are
+	// skipped for an image built for an architecture other than the host's.
+	ArchMismatchSkip string = "skip"
+	// UnknownOSProbe means that the OS dependent scanners (e.g. OpenSCAP) attempt a best
+	// effort detection of the OS of an image without an os-release or redhat-release file.
+	UnknownOSProbe string = "probe"
+	// UnknownOSSkip means that the OS dependent scanners are skipped for an image without
+	// an os-release or redhat-release file.
+	UnknownOSSkip string = "skip"
+	// OSFamilyRHEL is the OS family of RHEL and of the distributions derived from it or from
+	// Fedora.
+	OSFamilyRHEL string = "rhel"
+	// OSFamilyDebian is the OS family of Debian and of the distributions derived from it.
+	OSFamilyDebian string = "debian"
+	// OSFamilyAlpine is the OS family of Alpine Linux.
+	OSFamilyAlpine string = "alpine"
+	// PackageManagerRPM is the package manager of the rhel OS family.
+	PackageManagerRPM string = "rpm"
+	// PackageManagerDpkg is the package manager of the debian OS family.
+	PackageManagerDpkg string = "dpkg"
+	// PackageManagerApk is the package manager of the alpine OS family.
+	PackageManagerApk string = "apk"
 )
 
 // The default version for the result API object
@@ -32,6 +71,9 @@ type ScanResult struct {
 	APIVersion string `json:"apiVersion"`
 	// ImageName is a full pull spec of the input image
 	ImageName string `json:"imageName"`
+	// OriginalImageName is the image name as given in input, before being normalized
+	// into ImageName. It is set only when requested.
+	OriginalImageName string `json:"originalImageName,omitempty"`
 	// ImageIUD is a SHA256 identifier of the scanned image
 	// Note that we don't set the imageID when container is the target of the scan.
 	ImageID string `json:"imageID,omitempty"`
@@ -40,6 +82,205 @@ type ScanResult struct {
 	// Results contains compacted results of various scans performed on the image.
 	// Empty results means no problems were found with the given image.
 	Results []Result `json:"results,omitempty"`
+	// ToolProvenance describes the image-inspector build that produced the results.
+	// It is set only when requested.
+	ToolProvenance *ToolProvenance `json:"toolProvenance,omitempty"`
+	// OSFamily is the detected OS family of the image (e.g. rhel, debian or alpine).
+	// It is set only when requested.
+	OSFamily string `json:"osFamily,omitempty"`
+	// PackageManager is the detected package manager of the image (e.g. rpm, dpkg or apk).
+	// It is set only when requested.
+	PackageManager string `json:"packageManager,omitempty"`
+	// ScanDuration is how long the scan of the image took, in nanoseconds in JSON.
+	ScanDuration time.Duration `json:"scanDuration"`
+	// ExtractedBytes is the size of the regular files of the scanned filesystem.
+	// It is not set when inspecting a container.
+	ExtractedBytes int64 `json:"extractedBytes,omitempty"`
+	// ExtractedFileCount is the number of regular files of the scanned filesystem.
+	// It is not set when inspecting a container.
+	ExtractedFileCount int `json:"extractedFileCount,omitempty"`
+	// Delta compares the results with the ones of a baseline scan.
+	// It is set only when a baseline is given.
+	Delta *ResultsDelta `json:"delta,omitempty"`
+	// Complete is false when the results are the partial results of a scan in progress.
+	Complete bool `json:"complete"`
+	// Status is the outcome of the scan, telling apart the scans without results that
+	// failed from the clean ones. It is set once the scan is complete.
+	Status ScanStatus `json:"status,omitempty"`
+	// ImageMetadata describes the layers and the labels of the image.
+	// It is set only when inspecting the metadata of the image without scanning it.
+	ImageMetadata *ImageMetadata `json:"imageMetadata,omitempty"`
+}
+
+// ScanStatus is the outcome of a complete scan.
+type ScanStatus string
+
+const (
+	// ScanStatusClean means that the scanners succeeded without finding any problem.
+	ScanStatusClean ScanStatus = "clean"
+	// ScanStatusFindings means that the scanners succeeded and found problems.
+	ScanStatusFindings ScanStatus = "findings"
+	// ScanStatusError means that a scanner failed, the results may be incomplete.
+	ScanStatusError ScanStatus = "error"
+)
+
+// ImageMetadata describes an image from its metadata, without extracting its filesystem.
+type ImageMetadata struct {
+	// LayerCount is the number of layers adding files to the image
+	LayerCount int `json:"layerCount"`
+	// Layers are the layers adding files to the image, from the base one. The history
+	// entries that don't change the filesystem, e.g. ENV or LABEL, are left out.
+	Layers []LayerMetadata `json:"layers"`
+	// TotalSize is the sum of the sizes of the layers, in bytes
+	TotalSize int64 `json:"totalSize"`
+	// Labels are the labels of the image
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// LayerMetadata describes a layer of an image.
+type LayerMetadata struct {
+	// ID is the ID of the layer image, it is "<missing>" for the layers that were pulled
+	ID string `json:"id"`
+	// Size is the size of the files added by the layer, in bytes
+	Size int64 `json:"size"`
+	// CreatedBy is the instruction that created the layer
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// PartialResults holds the results of a scan in progress, they can be accessed concurrently.
+type PartialResults struct {
+	lock   sync.Mutex
+	result ScanResult
+}
+
+// Set replaces the results.
+func (p *PartialResults) Set(result ScanResult) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.result = result
+}
+
+// Add adds the results found so far by a scanner.
+func (p *PartialResults) Add(results ...Result) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.result.Results = append(p.result.Results, results...)
+}
+
+// Complete returns whether the scan is done.
+func (p *PartialResults) Complete() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.result.Complete
+}
+
+// Get returns a copy of the results.
+func (p *PartialResults) Get() ScanResult {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := p.result
+	result.Results = append([]Result(nil), p.result.Results...)
+	return result
+}
+
+// PartialMetadata holds the metadata of an inspection in progress, they can be accessed
+// concurrently.
+type PartialMetadata struct {
+	lock sync.Mutex
+	meta InspectorMetadata
+}
+
+// Set replaces the metadata with a copy of meta, the inspection can keep updating meta.
+func (p *PartialMetadata) Set(meta InspectorMetadata) {
+	if meta.OpenSCAP != nil {
+		openSCAP := *meta.OpenSCAP
+		meta.OpenSCAP = &openSCAP
+	}
+	meta.Scanners = append([]ScannerMetadata(nil), meta.Scanners...)
+	for n := range meta.Scanners {
+		meta.Scanners[n].Errors = append([]string(nil), meta.Scanners[n].Errors...)
+		meta.Scanners[n].Skipped = append([]string(nil), meta.Scanners[n].Skipped...)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.meta = meta
+}
+
+// Get returns the metadata, they are never modified once returned.
+func (p *PartialMetadata) Get() InspectorMetadata {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.meta
+}
+
+// ResultsDelta describes the changes of the results since a baseline scan, the results are
+// matched by scanner name and reference.
+type ResultsDelta struct {
+	// Baseline is the image name of the baseline results
+	Baseline string `json:"baseline"`
+	// Added are the results not found in the baseline
+	Added []Result `json:"added"`
+	// Removed are the baseline results that are no longer found
+	Removed []Result `json:"removed"`
+	// Unchanged are the results also found in the baseline
+	Unchanged []Result `json:"unchanged"`
+}
+
+const (
+	// InTotoStatementType is the type of the in-toto attestation statements
+	InTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// InTotoPayloadType is the payload type of the signed in-toto attestation statements
+	InTotoPayloadType = "application/vnd.in-toto+json"
+	// ScanResultPredicateType is the predicate type of the attestations holding a ScanResult
+	ScanResultPredicateType = "https://github.com/openshift/image-inspector/ScanResult/" + DefaultResultsAPIVersion
+)
+
+// AttestationStatement is an in-toto attestation statement about the scanned image
+type AttestationStatement struct {
+	// Type is InTotoStatementType
+	Type string `json:"_type"`
+	// Subject identifies the scanned image by its digests
+	Subject []AttestationSubject `json:"subject"`
+	// PredicateType is ScanResultPredicateType
+	PredicateType string `json:"predicateType"`
+	// Predicate is the result of the scan
+	Predicate ScanResult `json:"predicate"`
+}
+
+// AttestationSubject is an artifact an attestation statement is about
+type AttestationSubject struct {
+	// Name is the name of the artifact
+	Name string `json:"name"`
+	// Digest are the digests of the artifact keyed by algorithm (e.g. sha256)
+	Digest map[string]string `json:"digest"`
+}
+
+// AttestationEnvelope is a DSSE envelope holding a signed attestation statement
+type AttestationEnvelope struct {
+	// PayloadType is InTotoPayloadType
+	PayloadType string `json:"payloadType"`
+	// Payload is the serialized attestation statement, base64 encoded in JSON
+	Payload []byte `json:"payload"`
+	// Signatures are the signatures of the payload
+	Signatures []AttestationSignature `json:"signatures"`
+}
+
+// AttestationSignature is a signature of an AttestationEnvelope payload
+type AttestationSignature struct {
+	// KeyID identifies the signing key, it may be empty
+	KeyID string `json:"keyid"`
+	// Sig is the signature, base64 encoded in JSON
+	Sig []byte `json:"sig"`
+}
+
+// ToolProvenance describes the build of image-inspector
+type ToolProvenance struct {
+	// Version is the version of image-inspector
+	Version string `json:"version"`
+	// Commit is the git commit image-inspector was built from
+	Commit string `json:"commit"`
+	// BuildDate is the time image-inspector was built at
+	BuildDate string `json:"buildDate"`
 }
 
 // Result represents the compacted result of a single scan
@@ -67,6 +308,25 @@ var (
 	SeverityCritical  Severity = "critical"
 )
 
+// severityOrder are the severities from the lowest to the highest.
+var severityOrder = []Severity{SeverityLow, SeverityModerate, SeverityImportant, SeverityCritical}
+
+// AtLeast returns true if s is as severe as threshold or more. Severities that are not
+// known are lower than any other.
+func (s Severity) AtLeast(threshold Severity) bool {
+	return severityRank(s) >= severityRank(threshold) && severityRank(s) >= 0
+}
+
+// severityRank returns the position of s in severityOrder, -1 if s is not known.
+func severityRank(s Severity) int {
+	for n, severity := range severityOrder {
+		if s == severity {
+			return n
+		}
+	}
+	return -1
+}
+
 // Summary represents a severy of a given result. The result can have multiple severieties
 // defined.
 type Summary struct {
@@ -80,23 +340,136 @@ type OpenSCAPMetadata struct {
 	ContentTimeStamp string         // Timestamp for this data
 }
 
-func (osm *OpenSCAPMetadata) SetError(err error) {
+func (osm *OpenSCAPMetadata) SetError(err error, ts Timestamps) {
 	osm.Status = StatusError
 	osm.ErrorMessage = err.Error()
-	osm.ContentTimeStamp = string(time.Now().Format(time.RFC850))
+	osm.ContentTimeStamp = ts.Format(time.Now())
+}
+
+// ScannerMetadata describes the state of the scan of one of the scanners.
+type ScannerMetadata struct {
+	Name             string         // Name of the scanner
+	Status           OpenSCAPStatus // Status of the scan
+	ErrorMessage     string         `json:",omitempty"` // Error message of the scanner
+	Errors           []string       `json:",omitempty"` // Errors of the parts of the image that couldn't be scanned by a successful scan
+	Skipped          []string       `json:",omitempty"` // Notes of the parts of the image that were left out of the scan on purpose
+	ContentTimeStamp string         // Timestamp for this data
+}
+
+func (sm *ScannerMetadata) SetError(err error, ts Timestamps) {
+	sm.Status = StatusError
+	sm.ErrorMessage = err.Error()
+	sm.ContentTimeStamp = ts.Format(time.Now())
+}
+
+// Timestamps describes how the timestamps of results and metadata are emitted, in local time
+// and RFC850 format by default.
+type Timestamps struct {
+	// UTC controls whether the timestamps are emitted in UTC and RFC3339 format
+	UTC bool
+}
+
+// Time returns t in the timezone of the timestamps.
+func (ts Timestamps) Time(t time.Time) time.Time {
+	if ts.UTC {
+		return t.UTC()
+	}
+	return t
+}
+
+// Format formats t as a metadata timestamp.
+func (ts Timestamps) Format(t time.Time) string {
+	if ts.UTC {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return t.Format(time.RFC850)
 }
 
 var (
-	ScanOptions       = []string{"openscap", "clamav"}
-	PullPolicyOptions = []string{PullAlways, PullNever, PullIfNotPresent}
+	ScanOptions               = []string{"openscap", "clamav", "unowned", "apk", "capabilities", "hygiene", "kmod", "permissions", "shadowed"}
+	PullPolicyOptions         = []string{PullAlways, PullNever, PullIfNotPresent}
+	SymlinkPolicyOptions      = []string{SymlinkRelative, SymlinkSkip, SymlinkClamp, SymlinkKeep}
+	ArchMismatchPolicyOptions = []string{ArchMismatchWarn, ArchMismatchSkip}
+	UnknownOSPolicyOptions    = []string{UnknownOSProbe, UnknownOSSkip}
+	SeverityOptions           = []string{string(SeverityLow), string(SeverityModerate), string(SeverityImportant), string(SeverityCritical)}
+	// WebdavMethods are the HTTP methods handled by the webdav content endpoint
+	WebdavMethods = []string{"OPTIONS", "GET", "HEAD", "POST", "PUT", "DELETE", "MKCOL",
+		"COPY", "MOVE", "LOCK", "UNLOCK", "PROPFIND", "PROPPATCH"}
+	// ReadOnlyWebdavMethods are the WebdavMethods browsing the content without changing it
+	ReadOnlyWebdavMethods = []string{"OPTIONS", "GET", "HEAD", "PROPFIND"}
 )
 
 // InspectorMetadata is the metadata type with information about image-inspector's operation
 type InspectorMetadata struct {
 	docker.Image // Metadata about the inspected image
 
-	// OpenSCAP describes the state of the OpenSCAP scan
+	// InspectionMetadata is flattened besides the image fields for backward compatibility,
+	// VersionedInspectorMetadata keeps them apart
+	InspectionMetadata
+}
+
+// MetadataVersion is the version of the schema of VersionedInspectorMetadata, it's increased
+// when fields are renamed or removed.
+const MetadataVersion = "1"
+
+// VersionedInspectorMetadata is the envelope of the InspectorMetadata with an explicit
+// schema version, where the metadata about the inspected image isn't mixed with the
+// metadata of the inspection.
+type VersionedInspectorMetadata struct {
+	// MetadataVersion is the MetadataVersion of the schema of the envelope
+	MetadataVersion string `json:"metadataVersion"`
+	// Image is the metadata about the inspected image, nil when no image was inspected
+	Image *docker.Image `json:"image,omitempty"`
+	// Inspection is the metadata of the inspection
+	Inspection InspectionMetadata `json:"inspection"`
+}
+
+// NewVersionedInspectorMetadata returns the envelope of meta at the current MetadataVersion.
+func NewVersionedInspectorMetadata(meta *InspectorMetadata) *VersionedInspectorMetadata {
+	versioned := &VersionedInspectorMetadata{
+		MetadataVersion: MetadataVersion,
+		Inspection:      meta.InspectionMetadata,
+	}
+	if len(meta.ID) > 0 {
+		image := meta.Image
+		versioned.Image = &image
+	}
+	return versioned
+}
+
+// InspectionMetadata describes the state of the inspection of an image.
+type InspectionMetadata struct {
+	// OpenSCAP describes the state of the OpenSCAP scan, it is kept besides Scanners for
+	// backward compatibility
 	OpenSCAP *OpenSCAPMetadata
+
+	// Scanners describe the state of the scans of all the scanners that were run
+	Scanners []ScannerMetadata `json:",omitempty"`
+
+	// OSFamily and PackageManager are the detected OS family and package manager of the
+	// image, they are set only when requested
+	OSFamily       string `json:",omitempty"`
+	PackageManager string `json:",omitempty"`
+
+	// RootfsPath is the inspected directory when no docker image was involved
+	RootfsPath string `json:",omitempty"`
+
+	// DeniedDigest is the digest of the image, or of one of its layers, that is on the
+	// denylist and made the inspection fail
+	DeniedDigest string `json:",omitempty"`
+
+	// ArchMismatch describes how the image was handled when its architecture differs
+	// from the host's
+	ArchMismatch *ArchMismatchMetadata `json:",omitempty"`
+}
+
+// ArchMismatchMetadata records the decision taken for an image built for an architecture
+// other than the host's.
+type ArchMismatchMetadata struct {
+	ImageArchitecture string // Architecture of the inspected image
+	HostArchitecture  string // Architecture of the host running image-inspector
+	Policy            string // The arch-mismatch-policy that was applied
+	Skipped           bool   // Whether the architecture sensitive scanners were skipped
 }
 
 // APIVersions holds a slice of supported API versions.
@@ -118,3 +491,12 @@ type Scanner interface {
 	// Name is the scanner's name
 	Name() string
 }
+
+// IncrementalScanner is a Scanner that can report its results while scanning.
+type IncrementalScanner interface {
+	Scanner
+
+	// SetResultsSink makes the scanner pass each result to sink as soon as it's found, the
+	// results are still all returned by Scan.
+	SetResultsSink(sink func(Result))
+}
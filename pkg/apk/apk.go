@@ -0,0 +1,228 @@
+package apk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "apk"
+	ScannerVersion = "0.1"
+
+	// InstalledDB is the database of the installed apk packages
+	InstalledDB = "lib/apk/db/installed"
+	// AlpineReleaseFile holds the release of an Alpine image, ex. 3.8.1
+	AlpineReleaseFile = "etc/alpine-release"
+	// SecDBURLFmt is the url format of the SecDB feed of an Alpine release and repository
+	SecDBURLFmt = "https://secdb.alpinelinux.org/v%s/%s.json"
+
+	CVEDetailsUrl = "https://cve.mitre.org/cgi-bin/cvename.cgi?name="
+)
+
+var (
+	// SecDBRepositories are the repositories whose SecDB feeds are used by default
+	SecDBRepositories = []string{"main", "community"}
+)
+
+// secDB is an Alpine SecDB feed, listing the CVEs fixed by the package versions.
+type secDB struct {
+	Packages []struct {
+		Pkg struct {
+			Name string `json:"name"`
+			// SecFixes are the CVEs fixed, keyed by the first fixed version
+			SecFixes map[string][]string `json:"secfixes"`
+		} `json:"pkg"`
+	} `json:"packages"`
+}
+
+// openFunc provides an injectable way to open a SecDB feed for testing.
+type openFunc func(ctx context.Context, source string) (io.ReadCloser, error)
+
+type apkScanner struct {
+	// secDBs are the paths or urls of the SecDB feeds, the feeds of the Alpine release of
+	// the image are used when empty
+	secDBs []string
+
+	open openFunc
+}
+
+// ensure interface is implemented
+var _ iiapi.Scanner = &apkScanner{}
+
+// NewScanner returns a new scanner reporting the CVEs affecting the installed apk packages
+// according to the given SecDB feeds.
+func NewScanner(secDBs []string) iiapi.Scanner {
+	return &apkScanner{secDBs: secDBs, open: openSecDB}
+}
+
+// openSecDB opens the SecDB feed source, which is either a local file or an http(s) url.
+func openSecDB(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the server replied %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// installedPackages returns the versions of the apk packages installed under root, keyed by
+// package name.
+func installedPackages(root string) (map[string]string, error) {
+	db, err := os.Open(path.Join(root, InstalledDB))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the apk database: %v\n", err)
+	}
+	defer db.Close()
+
+	packages := map[string]string{}
+	name := ""
+	scanner := bufio.NewScanner(db)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case len(line) == 0:
+			name = ""
+		case strings.HasPrefix(line, "P:"):
+			name = strings.TrimPrefix(line, "P:")
+		case strings.HasPrefix(line, "V:") && len(name) > 0:
+			packages[name] = strings.TrimPrefix(line, "V:")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the apk database: %v\n", err)
+	}
+	return packages, nil
+}
+
+// defaultSecDBs returns the urls of the SecDB feeds of the Alpine release of the image
+// extracted in root.
+func defaultSecDBs(root string) ([]string, error) {
+	content, err := ioutil.ReadFile(path.Join(root, AlpineReleaseFile))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to detect the Alpine release, an apk-secdb is required: %v\n", err)
+	}
+	release := strings.SplitN(strings.TrimSpace(string(content)), ".", 3)
+	if len(release) < 2 {
+		return nil, fmt.Errorf("Unable to parse the Alpine release %q, an apk-secdb is required\n", content)
+	}
+	urls := []string{}
+	for _, repo := range SecDBRepositories {
+		urls = append(urls, fmt.Sprintf(SecDBURLFmt, strings.Join(release[:2], "."), repo))
+	}
+	return urls, nil
+}
+
+// loadSecFixes returns the CVEs fixed by the package versions of the SecDB feeds, keyed by
+// package name and fixed version.
+func (s *apkScanner) loadSecFixes(ctx context.Context, sources []string) (map[string]map[string][]string, error) {
+	fixes := map[string]map[string][]string{}
+	for _, source := range sources {
+		r, err := s.open(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open the SecDB feed %s: %v\n", source, err)
+		}
+		var db secDB
+		err = json.NewDecoder(r).Decode(&db)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the SecDB feed %s: %v\n", source, err)
+		}
+		for _, p := range db.Packages {
+			if fixes[p.Pkg.Name] == nil {
+				fixes[p.Pkg.Name] = map[string][]string{}
+			}
+			for version, cves := range p.Pkg.SecFixes {
+				fixes[p.Pkg.Name][version] = append(fixes[p.Pkg.Name][version], cves...)
+			}
+		}
+	}
+	return fixes, nil
+}
+
+// Scan reports the CVEs fixed in versions newer than the installed apk packages of path.
+func (s *apkScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := walker.Start("apk", nil)
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	packages, err := installedPackages(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := s.secDBs
+	if len(sources) == 0 {
+		if sources, err = defaultSecDBs(root); err != nil {
+			return nil, nil, err
+		}
+	}
+	fixes, err := s.loadSecFixes(ctx, sources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := []string{}
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		installed := packages[name]
+		fixed := []string{}
+		for version := range fixes[name] {
+			// the version 0 lists the CVEs that never affected the Alpine package
+			if version != "0" && compareVersions(installed, version) < 0 {
+				fixed = append(fixed, version)
+			}
+		}
+		sort.Slice(fixed, func(i, j int) bool { return compareVersions(fixed[i], fixed[j]) < 0 })
+		for _, version := range fixed {
+			for _, cve := range fixes[name][version] {
+				// the entries may list further identifiers after the CVE one
+				fields := strings.Fields(cve)
+				if len(fields) == 0 {
+					continue
+				}
+				scanResults.Add(iiapi.Result{
+					Name:           ScannerName,
+					ScannerVersion: ScannerVersion,
+					Timestamp:      scanResults.Started(),
+					Reference:      fmt.Sprintf("%s%s", CVEDetailsUrl, fields[0]),
+					Description:    fmt.Sprintf("%s %s is affected by %s, fixed in %s", name, installed, fields[0], version),
+				})
+			}
+		}
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *apkScanner) Name() string {
+	return ScannerName
+}
@@ -0,0 +1,185 @@
+package apk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+const installedDB = `C:Q1xJtaNLxHDUU0WZbvqBKs5HDxq6E=
+P:musl
+V:1.1.19-r10
+A:x86_64
+F:lib
+R:libc.musl-x86_64.so.1
+
+C:Q1q3RPrn4nZTTm6wGo/NeNkQWpUZk=
+P:openssl
+V:1.0.2o-r2
+A:x86_64
+
+P:busybox
+V:1.28.4-r1
+A:x86_64
+
+P:zlib
+V:1.2.11-r1
+A:x86_64
+`
+
+const mainSecDB = `{
+  "distroversion": "v3.8",
+  "reponame": "main",
+  "packages": [
+    {"pkg": {"name": "musl", "secfixes": {"1.1.19-r10": ["CVE-2018-1000001"], "1.1.20-r1": ["CVE-2019-14697"]}}},
+    {"pkg": {"name": "openssl", "secfixes": {
+      "1.0.2o-r1": ["CVE-2018-0732"],
+      "1.0.2p-r0": ["CVE-2018-0737 CVE-2018-0737-extra"],
+      "1.0.2q-r0": ["CVE-2018-0734", "CVE-2018-5407"]
+    }}},
+    {"pkg": {"name": "busybox", "secfixes": {"0": ["CVE-2017-16544"], "1.28.4-r1": ["CVE-2017-15873"]}}}
+  ]
+}`
+
+const communitySecDB = `{
+  "distroversion": "v3.8",
+  "reponame": "community",
+  "packages": [
+    {"pkg": {"name": "zlib", "secfixes": {"1.2.11-r2_p1": ["CVE-2018-25032"]}}}
+  ]
+}`
+
+// fixtureOpen opens the SecDB feeds of the given contents keyed by source.
+func fixtureOpen(feeds map[string]string, opened *[]string) openFunc {
+	return func(ctx context.Context, source string) (io.ReadCloser, error) {
+		*opened = append(*opened, source)
+		content, ok := feeds[source]
+		if !ok {
+			return nil, fmt.Errorf("no such feed")
+		}
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	}
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	feeds := map[string]string{
+		"https://secdb.alpinelinux.org/v3.8/main.json":      mainSecDB,
+		"https://secdb.alpinelinux.org/v3.8/community.json": communitySecDB,
+		"/secdb/main.json": mainSecDB,
+	}
+	allFindings := []string{
+		"musl 1.1.19-r10 is affected by CVE-2019-14697, fixed in 1.1.20-r1",
+		"openssl 1.0.2o-r2 is affected by CVE-2018-0737, fixed in 1.0.2p-r0",
+		"openssl 1.0.2o-r2 is affected by CVE-2018-0734, fixed in 1.0.2q-r0",
+		"openssl 1.0.2o-r2 is affected by CVE-2018-5407, fixed in 1.0.2q-r0",
+		"zlib 1.2.11-r1 is affected by CVE-2018-25032, fixed in 1.2.11-r2_p1",
+	}
+
+	for k, v := range map[string]struct {
+		files            map[string]string
+		secDBs           []string
+		expectedOpened   []string
+		expectedFindings []string
+		expectedError    string
+	}{
+		"default feeds of the alpine release": {
+			files:            map[string]string{InstalledDB: installedDB, AlpineReleaseFile: "3.8.1\n"},
+			expectedOpened:   []string{"https://secdb.alpinelinux.org/v3.8/main.json", "https://secdb.alpinelinux.org/v3.8/community.json"},
+			expectedFindings: allFindings,
+		},
+		"given feed": {
+			files:            map[string]string{InstalledDB: installedDB},
+			secDBs:           []string{"/secdb/main.json"},
+			expectedOpened:   []string{"/secdb/main.json"},
+			expectedFindings: allFindings[:4],
+		},
+		"no apk database": {
+			files:         map[string]string{AlpineReleaseFile: "3.8.1\n"},
+			expectedError: "Unable to open the apk database",
+		},
+		"unknown alpine release": {
+			files:         map[string]string{InstalledDB: installedDB},
+			expectedError: "an apk-secdb is required",
+		},
+		"missing feed": {
+			files:         map[string]string{InstalledDB: installedDB},
+			secDBs:        []string{"/secdb/nosuch.json"},
+			expectedError: "Unable to open the SecDB feed /secdb/nosuch.json",
+		},
+	} {
+		root := walkertest.NewFixture(t, walkertest.Contents(v.files)...)
+		defer os.RemoveAll(root)
+		opened := []string{}
+		s := &apkScanner{secDBs: v.secDBs, open: fixtureOpen(feeds, &opened)}
+
+		results, _, err := s.Scan(ctx, root, nil, nil)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		if !reflect.DeepEqual(opened, v.expectedOpened) {
+			t.Errorf("%s: expected the feeds %v to be opened, got %v", k, v.expectedOpened, opened)
+		}
+		findings := []string{}
+		for _, r := range results {
+			if r.Name != ScannerName || !strings.HasPrefix(r.Reference, CVEDetailsUrl+"CVE-") {
+				t.Errorf("%s: unexpected result %v", k, r)
+			}
+			findings = append(findings, r.Description)
+		}
+		if !reflect.DeepEqual(findings, v.expectedFindings) {
+			t.Errorf("%s: expected the findings\n%v\ngot\n%v", k, v.expectedFindings, findings)
+		}
+	}
+}
+
+func TestScanRequiresDirectory(t *testing.T) {
+	s := NewScanner(nil)
+	if _, _, err := s.Scan(context.Background(), "apk.go", nil, nil); err == nil {
+		t.Errorf("expected the scan of a file to fail")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, v := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0-r1", "1.0-r1", 0},
+		{"1.0-r1", "1.0-r2", -1},
+		{"1.0-r10", "1.0-r9", 1},
+		{"1.9", "1.10", -1},
+		{"1.0", "1.0.1", -1},
+		{"1.0.2o", "1.0.2p", -1},
+		{"1.0.2", "1.0.2a", -1},
+		{"1.0_rc1", "1.0", -1},
+		{"1.0_alpha1", "1.0_beta1", -1},
+		{"1.0_rc1", "1.0_rc2", -1},
+		{"1.0_p1", "1.0", 1},
+		{"1.0_p1", "1.0_p2", -1},
+		{"1.2.11-r1", "1.2.11-r2_p1", -1},
+		{"2.0", "1.99.99-r99", 1},
+	} {
+		if c := compareVersions(v.a, v.b); c != v.expected {
+			t.Errorf("expected %s compared to %s to be %d, got %d", v.a, v.b, v.expected, c)
+		}
+		if c := compareVersions(v.b, v.a); c != -v.expected {
+			t.Errorf("expected %s compared to %s to be %d, got %d", v.b, v.a, -v.expected, c)
+		}
+	}
+}
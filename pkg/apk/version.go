@@ -0,0 +1,134 @@
+package apk
+
+import (
+	"strconv"
+	"strings"
+)
+
+// suffixRanks orders the apk version suffixes, the pre-release ones sort before the version
+// without suffix.
+var suffixRanks = map[string]int{
+	"alpha": -4,
+	"beta":  -3,
+	"pre":   -2,
+	"rc":    -1,
+	"cvs":   1,
+	"svn":   2,
+	"git":   3,
+	"hg":    4,
+	"p":     5,
+}
+
+// versionToken is a component of an apk version: a number, a letter or a suffix.
+type versionToken struct {
+	kind   byte // 'n' for the numbers, 'l' for the letters and 's' for the suffixes
+	text   string
+	number int
+}
+
+// tokenizeVersion splits the version part (without the -r release) of an apk version,
+// ex. 1.0.2o_rc1, into its numbers, letters and suffixes.
+func tokenizeVersion(v string) []versionToken {
+	tokens := []versionToken{}
+	for len(v) > 0 {
+		switch c := v[0]; {
+		case c == '.':
+			v = v[1:]
+		case c >= '0' && c <= '9':
+			n := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+			if n < 0 {
+				n = len(v)
+			}
+			number, _ := strconv.Atoi(v[:n])
+			tokens = append(tokens, versionToken{kind: 'n', text: v[:n], number: number})
+			v = v[n:]
+		case c == '_':
+			v = v[1:]
+			n := strings.IndexFunc(v, func(r rune) bool { return r < 'a' || r > 'z' })
+			if n < 0 {
+				n = len(v)
+			}
+			tokens = append(tokens, versionToken{kind: 's', text: v[:n]})
+			v = v[n:]
+		default:
+			tokens = append(tokens, versionToken{kind: 'l', text: v[:1]})
+			v = v[1:]
+		}
+	}
+	return tokens
+}
+
+// splitRelease splits an apk version into its version and its -r release number.
+func splitRelease(v string) (string, int) {
+	i := strings.LastIndex(v, "-r")
+	if i < 0 {
+		return v, 0
+	}
+	release, err := strconv.Atoi(v[i+2:])
+	if err != nil {
+		return v, 0
+	}
+	return v[:i], release
+}
+
+// compare returns -1, 0 or 1 when a is respectively lower, equal or greater than b.
+func compare(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares the apk versions a and b, ex. 1.0.2o-r1, returning -1, 0 or 1
+// when a is respectively older, equal or newer than b.
+func compareVersions(a, b string) int {
+	a, releaseA := splitRelease(a)
+	b, releaseB := splitRelease(b)
+	tokensA, tokensB := tokenizeVersion(a), tokenizeVersion(b)
+	for i := 0; i < len(tokensA) || i < len(tokensB); i++ {
+		if i >= len(tokensA) || i >= len(tokensB) {
+			// the longer version is newer, unless it continues with a pre-release suffix
+			longer, sign := tokensB, -1
+			if i < len(tokensA) {
+				longer, sign = tokensA, 1
+			}
+			if t := longer[i]; t.kind == 's' && suffixRanks[t.text] < 0 {
+				return -sign
+			}
+			return sign
+		}
+		ta, tb := tokensA[i], tokensB[i]
+		if ta.kind != tb.kind {
+			// the suffixes sort by rank against the other components, the numbers are
+			// newer than the letters
+			if ta.kind == 's' {
+				return compare(suffixRanks[ta.text], 0)
+			}
+			if tb.kind == 's' {
+				return compare(0, suffixRanks[tb.text])
+			}
+			if ta.kind == 'n' {
+				return 1
+			}
+			return -1
+		}
+		switch ta.kind {
+		case 'n':
+			if c := compare(ta.number, tb.number); c != 0 {
+				return c
+			}
+		case 's':
+			if c := compare(suffixRanks[ta.text], suffixRanks[tb.text]); c != 0 {
+				return c
+			}
+		default:
+			if c := strings.Compare(ta.text, tb.text); c != 0 {
+				return c
+			}
+		}
+	}
+	return compare(releaseA, releaseB)
+}
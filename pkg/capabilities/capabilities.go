@@ -0,0 +1,230 @@
+package capabilities
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "capabilities"
+	ScannerVersion = "0.1"
+
+	// CapabilityXattr is the extended attribute holding the file capabilities
+	CapabilityXattr = "security.capability"
+
+	// the layout of the vfs_cap_data stored in CapabilityXattr, see linux/capability.h
+	vfsCapRevisionMask    = 0xFF000000
+	vfsCapRevision1       = 0x01000000
+	vfsCapRevision2       = 0x02000000
+	vfsCapRevision3       = 0x03000000
+	vfsCapFlagsEffective  = 0x000001
+	vfsCapRevision1Size   = 12
+	vfsCapRevision2Size   = 20
+	maxCapabilityXattrLen = 64
+)
+
+var (
+	// capabilityNames are the names of the capabilities indexed by number.
+	capabilityNames = []string{
+		"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner", "cap_fsetid",
+		"cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap", "cap_linux_immutable",
+		"cap_net_bind_service", "cap_net_broadcast", "cap_net_admin", "cap_net_raw",
+		"cap_ipc_lock", "cap_ipc_owner", "cap_sys_module", "cap_sys_rawio", "cap_sys_chroot",
+		"cap_sys_ptrace", "cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+		"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod", "cap_lease",
+		"cap_audit_write", "cap_audit_control", "cap_setfcap", "cap_mac_override",
+		"cap_mac_admin", "cap_syslog", "cap_wake_alarm", "cap_block_suspend", "cap_audit_read",
+		"cap_perfmon", "cap_bpf", "cap_checkpoint_restore",
+	}
+
+	// rootEquivalentCapabilities are the capabilities that are as good as root, the files
+	// granting them are reported as important rather than moderate.
+	rootEquivalentCapabilities = map[string]bool{
+		"cap_chown": true, "cap_dac_override": true, "cap_dac_read_search": true,
+		"cap_fowner": true, "cap_setgid": true, "cap_setuid": true, "cap_setfcap": true,
+		"cap_sys_module": true, "cap_sys_rawio": true, "cap_sys_ptrace": true,
+		"cap_sys_admin": true, "cap_bpf": true,
+	}
+)
+
+// fileCapsFunc provides an injectable way to read the CapabilityXattr of a file for testing.
+// It returns nil when the file has no capabilities.
+type fileCapsFunc func(p string) ([]byte, error)
+
+type capabilitiesScanner struct {
+	walker.Sink
+	fileCaps fileCapsFunc
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &capabilitiesScanner{}
+
+// NewScanner returns a new scanner reporting the files carrying capabilities. The extended
+// attributes of the files must have been preserved when extracting the image.
+func NewScanner() iiapi.Scanner {
+	return &capabilitiesScanner{fileCaps: readFileCaps}
+}
+
+// readFileCaps returns the CapabilityXattr of p, nil when p or its filesystem has none.
+func readFileCaps(p string) ([]byte, error) {
+	buf := make([]byte, maxCapabilityXattrLen)
+	n, err := syscall.Getxattr(p, CapabilityXattr, buf)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// fileCapabilities are the capabilities decoded from a CapabilityXattr.
+type fileCapabilities struct {
+	effective   bool
+	permitted   uint64
+	inheritable uint64
+}
+
+// parseFileCaps decodes the vfs_cap_data of a CapabilityXattr.
+func parseFileCaps(data []byte) (*fileCapabilities, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("capabilities of %d bytes are too short", len(data))
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	size := vfsCapRevision2Size
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		size = vfsCapRevision1Size
+	case vfsCapRevision2, vfsCapRevision3:
+	default:
+		return nil, fmt.Errorf("unknown capabilities revision %#x", magic&vfsCapRevisionMask)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("capabilities of %d bytes are too short", len(data))
+	}
+	caps := &fileCapabilities{
+		effective:   magic&vfsCapFlagsEffective != 0,
+		permitted:   uint64(binary.LittleEndian.Uint32(data[4:])),
+		inheritable: uint64(binary.LittleEndian.Uint32(data[8:])),
+	}
+	if size > vfsCapRevision1Size {
+		caps.permitted |= uint64(binary.LittleEndian.Uint32(data[12:])) << 32
+		caps.inheritable |= uint64(binary.LittleEndian.Uint32(data[16:])) << 32
+	}
+	return caps, nil
+}
+
+// capabilityName returns the name of the capability n.
+func capabilityName(n uint) string {
+	if int(n) < len(capabilityNames) {
+		return capabilityNames[n]
+	}
+	return fmt.Sprintf("cap_%d", n)
+}
+
+// String returns the capabilities in the getcap format, e.g. cap_net_admin,cap_net_raw=ep.
+func (c *fileCapabilities) String() string {
+	groups := []string{}
+	names := map[string][]string{}
+	for n := uint(0); n < 64; n++ {
+		flags := ""
+		if c.effective && c.permitted&(1<<n) != 0 {
+			flags += "e"
+		}
+		if c.inheritable&(1<<n) != 0 {
+			flags += "i"
+		}
+		if c.permitted&(1<<n) != 0 {
+			flags += "p"
+		}
+		if len(flags) == 0 {
+			continue
+		}
+		if _, ok := names[flags]; !ok {
+			groups = append(groups, flags)
+		}
+		names[flags] = append(names[flags], capabilityName(n))
+	}
+	set := []string{}
+	for _, flags := range groups {
+		set = append(set, strings.Join(names[flags], ",")+"="+flags)
+	}
+	return strings.Join(set, " ")
+}
+
+// severity returns the severity of the files carrying the capabilities.
+func (c *fileCapabilities) severity() iiapi.Severity {
+	for n := uint(0); n < 64; n++ {
+		if (c.permitted|c.inheritable)&(1<<n) != 0 && rootEquivalentCapabilities[capabilityName(n)] {
+			return iiapi.SeverityImportant
+		}
+	}
+	return iiapi.SeverityModerate
+}
+
+// Scan reports the regular files under path carrying capabilities.
+func (s *capabilitiesScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("capabilities")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = walker.Walk(ctx, root, filter, func(p string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name := path.Join("/", strings.TrimPrefix(p, root))
+		data, err := s.fileCaps(p)
+		if err != nil {
+			log.Printf("WARNING: Unable to read the capabilities of %s: %v", name, err)
+			return nil
+		}
+		if data == nil {
+			return nil
+		}
+		caps, err := parseFileCaps(data)
+		if err != nil {
+			log.Printf("WARNING: Unable to parse the capabilities of %s: %v", name, err)
+			return nil
+		}
+		if caps.permitted == 0 && caps.inheritable == 0 {
+			return nil
+		}
+		description := fmt.Sprintf("file carrying the capabilities %s", caps)
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			description += ", it is also setuid or setgid"
+		}
+		scanResults.Add(iiapi.Result{
+			Name:           ScannerName,
+			ScannerVersion: ScannerVersion,
+			Timestamp:      scanResults.Started(),
+			Reference:      fmt.Sprintf("file://%s", name),
+			Description:    description,
+			Summary:        []iiapi.Summary{{Label: caps.severity()}},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to scan %s: %v\n", mountPath, err)
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *capabilitiesScanner) Name() string {
+	return ScannerName
+}
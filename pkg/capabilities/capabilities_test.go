@@ -0,0 +1,174 @@
+package capabilities
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+// vfsCapData encodes the revision 2 CapabilityXattr of the given capability numbers.
+func vfsCapData(effective bool, permitted, inheritable []uint) []byte {
+	magic := uint32(vfsCapRevision2)
+	if effective {
+		magic |= vfsCapFlagsEffective
+	}
+	var p, i uint64
+	for _, n := range permitted {
+		p |= 1 << n
+	}
+	for _, n := range inheritable {
+		i |= 1 << n
+	}
+	data := make([]byte, vfsCapRevision2Size)
+	binary.LittleEndian.PutUint32(data, magic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(p))
+	binary.LittleEndian.PutUint32(data[8:], uint32(i))
+	binary.LittleEndian.PutUint32(data[12:], uint32(p>>32))
+	binary.LittleEndian.PutUint32(data[16:], uint32(i>>32))
+	return data
+}
+
+const (
+	capNetAdmin = 12
+	capNetRaw   = 13
+	capSysAdmin = 21
+	capBPF      = 39
+)
+
+func TestScan(t *testing.T) {
+	root := walkertest.NewFixture(t,
+		walkertest.File{Name: "usr/bin/ping", Content: "#!/bin/sh\n", Mode: 0755},
+		walkertest.File{Name: "usr/bin/arping", Content: "#!/bin/sh\n", Mode: 0755 | os.ModeSetuid},
+		walkertest.File{Name: "usr/sbin/bpftool", Content: "#!/bin/sh\n", Mode: 0755},
+		walkertest.File{Name: "usr/bin/ls", Content: "#!/bin/sh\n", Mode: 0755},
+		walkertest.File{Name: "usr/bin/empty", Content: "#!/bin/sh\n", Mode: 0755},
+		walkertest.File{Name: "usr/bin/corrupted", Content: "#!/bin/sh\n", Mode: 0755},
+		walkertest.File{Name: "skipped/ping", Content: "#!/bin/sh\n", Mode: 0755},
+	)
+	defer os.RemoveAll(root)
+	if err := os.Symlink("ping", path.Join(root, "usr/bin/ping6")); err != nil {
+		t.Fatalf("unable to create symlink: %v", err)
+	}
+
+	fixtures := map[string][]byte{
+		"usr/bin/ping":      vfsCapData(true, []uint{capNetRaw}, nil),
+		"usr/bin/arping":    vfsCapData(false, []uint{capNetRaw, capNetAdmin}, []uint{capNetRaw}),
+		"usr/sbin/bpftool":  vfsCapData(true, []uint{capSysAdmin, capBPF}, nil),
+		"usr/bin/empty":     vfsCapData(true, nil, nil),
+		"usr/bin/corrupted": {0x00, 0x00, 0x00, 0x09},
+		"skipped/ping":      vfsCapData(true, []uint{capNetRaw}, nil),
+	}
+	s := &capabilitiesScanner{fileCaps: func(p string) ([]byte, error) {
+		return fixtures[strings.TrimPrefix(p, root+"/")], nil
+	}}
+	filter := func(p string, info os.FileInfo) bool {
+		return !strings.HasPrefix(p, path.Join(root, "skipped"))
+	}
+
+	results, _, err := s.Scan(context.Background(), root, nil, filter)
+	if err != nil {
+		t.Fatalf("expected to succeed but failed with %v", err)
+	}
+	expected := map[string]struct {
+		description string
+		severity    iiapi.Severity
+	}{
+		"file:///usr/bin/ping":     {"file carrying the capabilities cap_net_raw=ep", iiapi.SeverityModerate},
+		"file:///usr/bin/arping":   {"file carrying the capabilities cap_net_admin=p cap_net_raw=ip, it is also setuid or setgid", iiapi.SeverityModerate},
+		"file:///usr/sbin/bpftool": {"file carrying the capabilities cap_sys_admin,cap_bpf=ep", iiapi.SeverityImportant},
+	}
+	if len(results) != len(expected) {
+		t.Errorf("expected %d results, got %v", len(expected), results)
+	}
+	for _, r := range results {
+		e, ok := expected[r.Reference]
+		if !ok {
+			t.Errorf("unexpected result %v", r)
+			continue
+		}
+		if r.Name != ScannerName || r.Description != e.description ||
+			!reflect.DeepEqual(r.Summary, []iiapi.Summary{{Label: e.severity}}) {
+			t.Errorf("expected the result of %s to be %q of severity %s, got %v", r.Reference, e.description, e.severity, r)
+		}
+	}
+}
+
+func TestScanRequiresDirectory(t *testing.T) {
+	s := NewScanner()
+	if _, _, err := s.Scan(context.Background(), "capabilities.go", nil, nil); err == nil {
+		t.Errorf("expected the scan of a file to fail")
+	}
+}
+
+func TestParseFileCaps(t *testing.T) {
+	revision1 := make([]byte, vfsCapRevision1Size)
+	binary.LittleEndian.PutUint32(revision1, vfsCapRevision1|vfsCapFlagsEffective)
+	binary.LittleEndian.PutUint32(revision1[4:], 1<<capNetRaw)
+	// the revision 3 appends the root id of the user namespace
+	revision3 := append(vfsCapData(false, []uint{capBPF}, nil), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(revision3, vfsCapRevision3)
+
+	for k, v := range map[string]struct {
+		data          []byte
+		expected      string
+		expectedError string
+	}{
+		"revision 1":         {data: revision1, expected: "cap_net_raw=ep"},
+		"revision 2":         {data: vfsCapData(true, []uint{capNetAdmin, capNetRaw}, []uint{capNetRaw}), expected: "cap_net_admin=ep cap_net_raw=eip"},
+		"revision 3":         {data: revision3, expected: "cap_bpf=p"},
+		"unknown capability": {data: vfsCapData(true, []uint{63}, nil), expected: "cap_63=ep"},
+		"unknown revision":   {data: []byte{0, 0, 0, 0x09}, expectedError: "unknown capabilities revision"},
+		"too short":          {data: revision1[:8], expectedError: "too short"},
+	} {
+		caps, err := parseFileCaps(v.data)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		if caps.String() != v.expected {
+			t.Errorf("%s: expected the capabilities %q, got %q", k, v.expected, caps.String())
+		}
+	}
+}
+
+func TestReadFileCaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capabilities-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ping := path.Join(dir, "ping")
+	if err := ioutil.WriteFile(ping, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("unable to create the fixture binary: %v", err)
+	}
+
+	if data, err := readFileCaps(ping); err != nil || data != nil {
+		t.Errorf("expected no capabilities, got %v (%v)", data, err)
+	}
+	netRaw := vfsCapData(true, []uint{capNetRaw}, nil)
+	if err := syscall.Setxattr(ping, CapabilityXattr, netRaw, 0); err != nil {
+		t.Skipf("unable to set the capabilities of the fixture binary: %v", err)
+	}
+	data, err := readFileCaps(ping)
+	if err != nil || !reflect.DeepEqual(data, netRaw) {
+		t.Errorf("expected the capabilities %v, got %v (%v)", netRaw, data, err)
+	}
+	if _, err := readFileCaps(path.Join(dir, "nosuchfile")); err == nil {
+		t.Errorf("expected the capabilities of a missing file to fail")
+	}
+}
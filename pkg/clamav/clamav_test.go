@@ -1,10 +1,22 @@
 package clamav
 
 import (
+	"bufio"
+	gocontext "context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
-	"golang.org/x/net/context"
 	"github.com/openshift/clam-scanner/pkg/clamav"
+	"golang.org/x/net/context"
 )
 
 type fakeClamSession struct {
@@ -14,6 +26,8 @@ type fakeClamSession struct {
 	filter             clamav.FilterFiles
 	waitTillDoneCalled bool
 	closeCalled        bool
+	// results are returned by GetResults instead of a single virus found when set
+	results *clamav.ClamdScanResult
 }
 
 func (f *fakeClamSession) ScanPath(ctx context.Context, path string, filter clamav.FilterFiles) error {
@@ -30,6 +44,9 @@ func (f *fakeClamSession) Close() error {
 	return nil
 }
 func (f *fakeClamSession) GetResults() clamav.ClamdScanResult {
+	if f.results != nil {
+		return *f.results
+	}
 	return clamav.ClamdScanResult{
 		Files: []clamav.ClamdFileResult{{
 			Filename: "/foo/bar/usr/bin/virus",
@@ -38,9 +55,14 @@ func (f *fakeClamSession) GetResults() clamav.ClamdScanResult {
 	}
 }
 
+const fakeVersion = "0.103.8/26823/Mon Feb 27 09:21:30 2023"
+
 func TestScan(t *testing.T) {
 	ctx := context.Background()
-	scanner := &ClamScanner{clamd: &fakeClamSession{t: t}}
+	scanner := &ClamScanner{
+		clamd:   &fakeClamSession{t: t},
+		version: func(gocontext.Context) (string, error) { return fakeVersion, nil },
+	}
 
 	results, _, err := scanner.Scan(ctx, "/foo/bar", nil, nil)
 	if err != nil {
@@ -51,9 +73,8 @@ func TestScan(t *testing.T) {
 		t.Fatalf("expected results, got none")
 	}
 
-	// TODO: Mock this once we report real versions
-	if results[0].ScannerVersion != "0.99.2" {
-		t.Errorf("expected scanner version to be mock-0.1, got %q", results[0].ScannerVersion)
+	if results[0].ScannerVersion != fakeVersion {
+		t.Errorf("expected scanner version to be %q, got %q", fakeVersion, results[0].ScannerVersion)
 	}
 
 	if results[0].Reference != "file:///usr/bin/virus" {
@@ -65,9 +86,212 @@ func TestScan(t *testing.T) {
 	}
 }
 
+func TestScanUnknownVersion(t *testing.T) {
+	// the scanner version is queried with the standard library context
+	for k, v := range map[string]func(gocontext.Context) (string, error){
+		"version error": func(gocontext.Context) (string, error) { return "", fmt.Errorf("connection refused") },
+		"no version":    nil,
+	} {
+		scanner := &ClamScanner{clamd: &fakeClamSession{t: t}, version: v}
+		results, _, err := scanner.Scan(context.Background(), "/foo/bar", nil, nil)
+		if err != nil || len(results) == 0 {
+			t.Errorf("%s: expected results, got %v (%v)", k, results, err)
+			continue
+		}
+		if results[0].ScannerVersion != UnknownVersion {
+			t.Errorf("%s: expected scanner version to be %q, got %q", k, UnknownVersion, results[0].ScannerVersion)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	session := &fakeClamSession{t: t, results: &clamav.ClamdScanResult{
+		Files: []clamav.ClamdFileResult{
+			{Filename: "/foo/bar/usr/bin/virus", Result: "boo virus found"},
+			{Filename: "/foo/bar/usr/bin/locked", Errors: []string{"lstat() failed: Permission denied. ERROR"}},
+			{Filename: "/foo/bar/usr/bin/truncated", Result: "Eicar-Test-Signature FOUND", Errors: []string{"partial response"}},
+		},
+		Errors: []string{"request not recognized: 42"},
+	}}
+	scanner := &ClamScanner{clamd: session}
+
+	results, reportObj, err := scanner.Scan(context.Background(), "/foo/bar", nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	references := []string{}
+	for _, r := range results {
+		references = append(references, r.Reference)
+	}
+	if !reflect.DeepEqual(references, []string{"file:///usr/bin/virus", "file:///usr/bin/truncated"}) {
+		t.Errorf("expected the results of the scanned files only, got %v", results)
+	}
+	report, ok := reportObj.(ClamScanReport)
+	if !ok {
+		t.Fatalf("expected a ClamScanReport, got %#v", reportObj)
+	}
+	expected := []string{
+		"request not recognized: 42",
+		"file:///usr/bin/locked: lstat() failed: Permission denied. ERROR",
+		"file:///usr/bin/truncated: partial response",
+	}
+	if !reflect.DeepEqual(report.Errors, expected) {
+		t.Errorf("expected the errors %v, got %v", expected, report.Errors)
+	}
+}
+
+// stuckClamSession is a fakeClamSession whose walk is aborted only by the context and whose
+// clamd never replies to the files submitted.
+type stuckClamSession struct {
+	fakeClamSession
+}
+
+func (f *stuckClamSession) ScanPath(ctx context.Context, path string, filter clamav.FilterFiles) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+func (f *stuckClamSession) WaitTillDone() {
+	select {}
+}
+
+func TestScanTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	scanner := &ClamScanner{clamd: &stuckClamSession{fakeClamSession{t: t}}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		results, _, err := scanner.Scan(ctx, "/foo/bar", nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+			t.Errorf("expected the scan to time out, got %v", err)
+		}
+		if len(results) != 1 || results[0].Reference != "file:///usr/bin/virus" {
+			t.Errorf("expected the results found before the timeout, got %v", results)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the scan to return once timed out")
+	}
+}
+
+func TestClamdSessionCancelledWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := path.Join(dir, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatalf("unable to create the files directory: %v", err)
+	}
+	const count, cancelled = 10, 3
+	for n := 0; n < count; n++ {
+		if err := ioutil.WriteFile(path.Join(files, fmt.Sprintf("file-%02d", n)), []byte("virus"), 0644); err != nil {
+			t.Fatalf("unable to create the file %d: %v", n, err)
+		}
+	}
+	socket := path.Join(dir, "clamd.sock")
+	listener := fakeClamdSession(t, socket, 0)
+	defer listener.Close()
+	session, err := newClamdSession(socket, true)
+	if err != nil {
+		t.Fatalf("unable to start the clamd session: %v", err)
+	}
+	scanner := &ClamScanner{clamd: session}
+
+	// the context is cancelled in the middle of the walk, after the first files
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+	walked := 0
+	filter := func(p string, info os.FileInfo) bool {
+		if info.Mode().IsRegular() {
+			if walked++; walked == cancelled {
+				cancel()
+			}
+		}
+		return true
+	}
+	results, _, err := scanner.Scan(ctx, files, nil, filter)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("expected the scan to be aborted, got %v", err)
+	}
+	if walked != cancelled || len(results) > cancelled {
+		t.Errorf("expected the walk to stop after %d files, walked %d with the results %v", cancelled, walked, results)
+	}
+}
+
+// fakeClamd listens at socket replying reply to the first command received on each
+// connection, which is sent to commands.
+func fakeClamd(t *testing.T, socket, reply string, commands chan<- string) net.Listener {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %v", socket, err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString(0)
+			commands <- command
+			conn.Write([]byte(reply))
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestClamdVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for k, v := range map[string]struct {
+		reply         string
+		expected      string
+		expectedError string
+	}{
+		"version":             {reply: "ClamAV " + fakeVersion + "\x00", expected: fakeVersion},
+		"version without nul": {reply: "ClamAV 0.99.2\n", expected: "0.99.2"},
+		"unexpected reply":    {reply: "UNKNOWN COMMAND\x00", expectedError: "unexpected clamd version reply"},
+		"no reply":            {expectedError: "EOF"},
+	} {
+		socket := path.Join(dir, strings.Replace(k, " ", "-", -1)+".sock")
+		commands := make(chan string, 1)
+		listener := fakeClamd(t, socket, v.reply, commands)
+		defer listener.Close()
+
+		version, err := clamdVersion(context.Background(), socket)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+		} else if err != nil || version != v.expected {
+			t.Errorf("%s: expected the version %q, got %q (%v)", k, v.expected, version, err)
+		}
+		if command := <-commands; command != versionCommand {
+			t.Errorf("%s: expected the command %q, got %q", k, versionCommand, command)
+		}
+	}
+
+	if _, err := clamdVersion(context.Background(), path.Join(dir, "missing.sock")); err == nil {
+		t.Errorf("expected the version of a missing clamd to fail")
+	}
+}
+
 func TestNewScanner(t *testing.T) {
-	if _, err := NewScanner("missing.socket"); err == nil {
-		t.Errorf("expected socket error, got none")
+	if _, err := NewScanner("missing.socket", 0); err == nil || !strings.Contains(err.Error(), "clamd socket missing.socket not found") {
+		t.Errorf("expected the missing socket error, got %v", err)
+	}
+	// nothing is listening on a regular file
+	if _, err := NewScanner("scanner.go", 0); err == nil || !strings.Contains(err.Error(), "clamd is not reachable at scanner.go") {
+		t.Errorf("expected the unreachable clamd error, got %v", err)
 	}
 }
 
@@ -79,3 +303,296 @@ func TestName(t *testing.T) {
 		t.Fatalf("scanner name should be clamav")
 	}
 }
+
+func TestEnableProtocolLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a fake clamd that only receives the session start
+	socket := path.Join(dir, "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %v", socket, err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	stderr := os.Stderr
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unable to create pipe: %v", err)
+	}
+	os.Stderr = writer
+	defer func() { os.Stderr = stderr }()
+
+	// the glog flags are global, restore them for the other tests
+	for _, name := range []string{"logtostderr", "v"} {
+		value := flag.Lookup(name).Value.String()
+		defer flag.Set(name, value)
+	}
+
+	if err := EnableProtocolLog(); err != nil {
+		t.Fatalf("unable to enable the protocol log: %v", err)
+	}
+	if _, err := NewScanner(socket, 0); err != nil {
+		t.Fatalf("unable to create scanner: %v", err)
+	}
+
+	os.Stderr = stderr
+	writer.Close()
+	logged, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unable to read the log: %v", err)
+	}
+	if !strings.Contains(string(logged), "zIDSESSION") {
+		t.Errorf("expected the clamd session start to be logged, got %q", logged)
+	}
+}
+
+// fakeClamdSession listens at socket for a clamd session, replying that a virus was found to
+// each file submitted, as soon as it's received but for the first reply which is sent after
+// delay.
+func fakeClamdSession(t *testing.T, socket string, delay time.Duration) net.Listener {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %v", socket, err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received, replied := "", 0
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received += string(buf[:n])
+			for ; replied < strings.Count(received, "zFILDES"); replied++ {
+				if replied == 0 {
+					time.Sleep(delay)
+				}
+				fmt.Fprintf(conn, "%d: fd[10]: Virus-%d FOUND\x00", replied+1, replied+1)
+			}
+		}
+	}()
+	return listener
+}
+
+// cpuTime returns the user and system CPU time consumed by the process.
+func cpuTime(t *testing.T) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		t.Fatalf("unable to get the resource usage: %v", err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+func TestClamdSessionWaitTillDone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := path.Join(dir, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatalf("unable to create the files directory: %v", err)
+	}
+	for _, name := range []string{"eicar", "virus"} {
+		if err := ioutil.WriteFile(path.Join(files, name), []byte(name), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", name, err)
+		}
+	}
+
+	// clamd replies to the files submitted only after delay, WaitTillDone has to wait
+	const delay = 500 * time.Millisecond
+	socket := path.Join(dir, "clamd.sock")
+	listener := fakeClamdSession(t, socket, delay)
+	defer listener.Close()
+
+	session, err := newClamdSession(socket, true)
+	if err != nil {
+		t.Fatalf("unable to start the clamd session: %v", err)
+	}
+	defer session.Close()
+	if err := session.ScanPath(context.Background(), files, nil); err != nil {
+		t.Fatalf("unable to submit the files: %v", err)
+	}
+
+	done := make(chan struct{})
+	started, startedCPU := time.Now(), cpuTime(t)
+	go func() {
+		session.WaitTillDone()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected WaitTillDone to return once all the responses were received")
+	}
+	waited, usedCPU := time.Since(started), cpuTime(t)-startedCPU
+
+	if waited < delay/2 {
+		t.Errorf("expected WaitTillDone to wait for the responses, it returned after %v", waited)
+	}
+	// a busy wait would use about as much CPU as the time waited
+	if usedCPU > waited/4 {
+		t.Errorf("expected WaitTillDone to block, it used %v of CPU in %v", usedCPU, waited)
+	}
+	if results := session.GetResults(); len(results.Files) != 2 {
+		t.Errorf("expected the results of the two files, got %+v", results)
+	}
+}
+
+func TestClamdSessionConcurrentResponses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := path.Join(dir, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatalf("unable to create the files directory: %v", err)
+	}
+	const count = 100
+	for n := 0; n < count; n++ {
+		if err := ioutil.WriteFile(path.Join(files, fmt.Sprintf("file-%03d", n)), []byte("virus"), 0644); err != nil {
+			t.Fatalf("unable to create the file %d: %v", n, err)
+		}
+	}
+	// clamd replies to each file as soon as it's submitted, the responses are handled by
+	// the session while the next files are submitted
+	socket := path.Join(dir, "clamd.sock")
+	listener := fakeClamdSession(t, socket, 0)
+	defer listener.Close()
+
+	session, err := newClamdSession(socket, true)
+	if err != nil {
+		t.Fatalf("unable to start the clamd session: %v", err)
+	}
+	defer session.Close()
+	if err := session.ScanPath(context.Background(), files, nil); err != nil {
+		t.Fatalf("unable to submit the files: %v", err)
+	}
+	session.WaitTillDone()
+
+	results := session.GetResults()
+	if len(results.Errors) > 0 {
+		t.Errorf("expected no errors, got %v", results.Errors)
+	}
+	if len(results.Files) != count {
+		t.Fatalf("expected the results of the %d files, got %d", count, len(results.Files))
+	}
+	for n, r := range results.Files {
+		if expected := path.Join(files, fmt.Sprintf("file-%03d", n)); r.Filename != expected || len(r.Errors) > 0 {
+			t.Errorf("expected the result %d to be the one of %s, got %+v", n, expected, r)
+		}
+	}
+}
+
+func TestScanMaxFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := path.Join(dir, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatalf("unable to create the files directory: %v", err)
+	}
+	// the files of the directory walked after the skipped one are still scanned
+	for name, content := range map[string]string{
+		"a-large": strings.Repeat("x", 100),
+		"b-small": "virus",
+		"c-limit": "0123456789",
+	} {
+		if err := ioutil.WriteFile(path.Join(files, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", name, err)
+		}
+	}
+	socket := path.Join(dir, "clamd.sock")
+	listener := fakeClamdSession(t, socket, 0)
+	defer listener.Close()
+
+	session, err := newClamdSession(socket, true)
+	if err != nil {
+		t.Fatalf("unable to start the clamd session: %v", err)
+	}
+	scanner := &ClamScanner{MaxFileSize: 10, clamd: session}
+
+	results, report, err := scanner.Scan(context.Background(), files, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	references := []string{}
+	for _, r := range results {
+		references = append(references, r.Reference)
+	}
+	if expected := []string{"file:///b-small", "file:///c-limit"}; !reflect.DeepEqual(references, expected) {
+		t.Errorf("expected the results of %v, got %v", expected, references)
+	}
+	expected := ClamScanReport{
+		Errors:  []string{},
+		Skipped: []string{"file:///a-large: skipped, its 100 bytes are above the maximum file size of 10 bytes"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected the report %+v, got %+v", expected, report)
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := path.Join(dir, "files")
+	for _, name := range []string{"cache/a-virus", "cache/b-virus", "usr/virus", "usr/virus.log"} {
+		p := path.Join(files, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("unable to create directory for %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(p, []byte("virus"), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", name, err)
+		}
+	}
+	socket := path.Join(dir, "clamd.sock")
+	listener := fakeClamdSession(t, socket, 0)
+	defer listener.Close()
+
+	session, err := newClamdSession(socket, true)
+	if err != nil {
+		t.Fatalf("unable to start the clamd session: %v", err)
+	}
+	scanner := &ClamScanner{clamd: session}
+	// the excluded directories are pruned, none of their files is submitted
+	visited := []string{}
+	filter := func(p string, info os.FileInfo) bool {
+		visited = append(visited, strings.TrimPrefix(p, files))
+		return p != path.Join(files, "cache") && !strings.HasSuffix(p, ".log")
+	}
+
+	results, _, err := scanner.Scan(context.Background(), files, nil, filter)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Reference != "file:///usr/virus" {
+		t.Errorf("expected only the result of /usr/virus, got %v", results)
+	}
+	for _, p := range visited {
+		if strings.HasPrefix(p, "/cache/") {
+			t.Errorf("expected the excluded directory to be pruned, %s was visited", p)
+		}
+	}
+}
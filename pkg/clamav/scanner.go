@@ -1,9 +1,14 @@
 package clamav
 
 import (
+	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -13,29 +18,104 @@ import (
 	"github.com/openshift/image-inspector/pkg/api"
 )
 
-const ScannerName = "clamav"
+const (
+	ScannerName = "clamav"
+	// protocolLogVerbosity is the glog verbosity at which the clam-scanner package logs the
+	// messages exchanged with clamd.
+	protocolLogVerbosity = "6"
+	// UnknownVersion is the scanner version of the results when clamd doesn't report it.
+	UnknownVersion = "unknown"
+	// versionCommand makes clamd reply with the versions of its engine and signatures.
+	versionCommand = "zVERSION\x00"
+	// versionTimeout is how long clamd has to reply with its version.
+	versionTimeout = 10 * time.Second
+)
 
 type ClamScanner struct {
 	// Socket is the location of the clamav socket.
 	Socket string
+	// MaxFileSize is the size in bytes above which the files are skipped instead of being
+	// submitted to clamd, no limit when 0.
+	MaxFileSize int64
 
 	clamd clamav.ClamdSession
+	// version returns the version of clamd, see clamdVersion.
+	version func(ctx context.Context) (string, error)
 }
 
 var _ api.Scanner = &ClamScanner{}
 
-func NewScanner(socket string) (api.Scanner, error) {
+// ClamScanReport is the report of the ClamAV scans, it holds the errors of clamd and of the
+// files that couldn't be scanned, which don't fail the scan, and the notes of the files that
+// were skipped. The errors and notes of a file are prefixed with its file:// reference.
+type ClamScanReport struct {
+	Errors  []string
+	Skipped []string
+}
+
+// EnableProtocolLog makes the clam-scanner package log the messages exchanged with clamd
+// to the standard error.
+func EnableProtocolLog() error {
+	if err := flag.Set("logtostderr", "true"); err != nil {
+		return err
+	}
+	return flag.Set("v", protocolLogVerbosity)
+}
+
+// NewScanner returns a new scanner submitting the files to the clamd listening at socket,
+// skipping the files larger than maxFileSize bytes, no limit when 0.
+func NewScanner(socket string, maxFileSize int64) (api.Scanner, error) {
+	// the socket is checked first, the errors of the session don't tell what is missing
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("clamd socket %s not found: %v", socket, err)
+	}
 	// TODO: Make the ignoreNegatives configurable
-	clamSession, err := clamav.NewClamdSession(socket, true)
+	clamSession, err := newClamdSession(socket, true)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("clamd is not reachable at %s: %v", socket, err)
 	}
 	return &ClamScanner{
-		Socket: socket,
-		clamd:  clamSession,
+		Socket:      socket,
+		MaxFileSize: maxFileSize,
+		clamd:       clamSession,
+		version: func(ctx context.Context) (string, error) {
+			return clamdVersion(ctx, socket)
+		},
 	}, nil
 }
 
+// clamdVersion returns the version reported by the clamd listening at socket, in the
+// ENGINE/SIGNATURES/SIGNATURES-DATE format of clamd without the ClamAV prefix, for example
+// 0.103.8/26823/Mon Feb 27 09:21:30 2023.
+func clamdVersion(ctx context.Context, socket string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(versionTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(versionCommand)); err != nil {
+		return "", err
+	}
+	// the replies to the z commands are terminated by a NUL, clamd then closes the connection
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || len(reply) == 0) {
+		return "", err
+	}
+	version := strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	if !strings.HasPrefix(version, "ClamAV ") {
+		return "", fmt.Errorf("unexpected clamd version reply %q", version)
+	}
+	return strings.TrimPrefix(version, "ClamAV "), nil
+}
+
 // Scan will scan the image
 func (s *ClamScanner) Scan(ctx context.Context, path string, image *docker.Image, filter api.FilesFilter) ([]api.Result, interface{}, error) {
 	scanResults := []api.Result{}
@@ -44,26 +124,83 @@ func (s *ClamScanner) Scan(ctx context.Context, path string, image *docker.Image
 	defer func() {
 		log.Printf("clamav scan took %ds (%d problems found)", int64(time.Since(scanStarted).Seconds()), len(scanResults))
 	}()
-	if err := s.clamd.ScanPath(ctx, path, clamav.FilterFiles(filter)); err != nil {
-		return nil, nil, err
+	report := ClamScanReport{}
+	clamFilter := clamav.FilterFiles(filter)
+	if s.MaxFileSize > 0 {
+		clamFilter = func(p string, info os.FileInfo) bool {
+			if filter != nil && !filter(p, info) {
+				return false
+			}
+			if info.Mode().IsRegular() && info.Size() > s.MaxFileSize {
+				report.Skipped = append(report.Skipped, fmt.Sprintf("file://%s: skipped, its %d bytes are above the maximum file size of %d bytes",
+					strings.TrimPrefix(p, path), info.Size(), s.MaxFileSize))
+				return false
+			}
+			return true
+		}
+	}
+	// the walk is aborted when the context is done, the results of the files submitted until
+	// then are still returned
+	scanErr := s.clamd.ScanPath(ctx, path, clamFilter)
+	done := make(chan struct{})
+	go func() {
+		s.clamd.WaitTillDone()
+		close(done)
+	}()
+	select {
+	case <-done:
+		defer s.clamd.Close()
+	case <-ctx.Done():
+		// the session can be closed only once it's done polling the responses, when they
+		// are all received or clamd closes the connection
+		go func() {
+			<-done
+			s.clamd.Close()
+		}()
+		scanErr = ctx.Err()
 	}
-	s.clamd.WaitTillDone()
-	defer s.clamd.Close()
 
 	clamResults := s.clamd.GetResults()
 
+	version := UnknownVersion
+	if s.version != nil {
+		if v, err := s.version(ctx); err != nil {
+			log.Printf("WARNING: Unable to get the clamd version: %v", err)
+		} else {
+			version = v
+		}
+	}
+
+	report.Errors = append([]string{}, clamResults.Errors...)
 	for _, r := range clamResults.Files {
+		reference := fmt.Sprintf("file://%s", strings.TrimPrefix(r.Filename, path))
+		for _, e := range r.Errors {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", reference, e))
+		}
+		if len(r.Result) == 0 && len(r.Errors) > 0 {
+			// the file couldn't be scanned, there is no result but the errors
+			continue
+		}
 		r := api.Result{
 			Name:           ScannerName,
-			ScannerVersion: "0.99.2", // TODO: this must be returned from clam-scanner
+			ScannerVersion: version,
 			Timestamp:      scanStarted,
-			Reference:      fmt.Sprintf("file://%s", strings.TrimPrefix(r.Filename, path)),
+			Reference:      reference,
 			Description:    r.Result,
 		}
 		scanResults = append(scanResults, r)
 	}
+	if len(report.Errors) > 0 {
+		log.Printf("WARNING: clamav reported %d errors, some files may not have been scanned", len(report.Errors))
+	}
+	if len(report.Skipped) > 0 {
+		log.Printf("clamav skipped %d files above the maximum file size of %d bytes", len(report.Skipped), s.MaxFileSize)
+	}
+	if scanErr != nil {
+		return scanResults, report, fmt.Errorf("clamav scan aborted with %d problems found so far: %v", len(scanResults), scanErr)
+	}
 
-	return scanResults, nil, nil
+	return scanResults, report, nil
 }
 
 func (s *ClamScanner) Name() string {
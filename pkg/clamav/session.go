@@ -0,0 +1,328 @@
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/openshift/clam-scanner/pkg/clamav"
+	"golang.org/x/net/context"
+)
+
+// clamdSession is a clamav.ClamdSession submitting the files of a walk to clamd over a
+// clamav.ClamdConn. It follows the session of clamav.NewClamdSession, which busy waits in
+// WaitTillDone, shares its counters and results between the walk and the polling of the
+// responses without synchronization, records the requests only once they are written,
+// and skips the rest of a directory when the filter skips one of its files.
+type clamdSession struct {
+	// conn is the Unix domain socket connection to clamd.
+	conn clamav.ClamdConn
+
+	// partialResponse holds any partial response in case a response is
+	// split across multiple reads.
+	partialResponse []byte
+
+	// closeChan is closed by pollResponses to signal to WaitTillDone that
+	// all responses have been received.
+	closeChan chan bool
+
+	// allFilesSubmitted is set to 1 by WaitTillDone once all files have been
+	// submitted to clamd, it is accessed atomically since pollResponses reads
+	// it concurrently.
+	allFilesSubmitted int32
+
+	// numFilesSubmitted is the number of files that have been submitted to
+	// clamd for scanning, it is protected by requestIDToFilenameMutex.
+	numFilesSubmitted int
+
+	// numResponsesReceived is the number of responses that have been
+	// received from clamd.  There should be one response for each file
+	// submitted for scanning.  It is accessed only by pollResponses.
+	numResponsesReceived int
+
+	// requestIDToFilename maps request ID to filename.
+	// requestIDToFilename[1] is the filename of the first file submitted
+	// for scanning, requestIDToFilename[2] is the filename of the second
+	// file submitted, and so on.
+	requestIDToFilename map[int]string
+
+	// requestIDToFilenameMutex is a lock protecting requestIDToFilename and
+	// numFilesSubmitted.
+	requestIDToFilenameMutex sync.Mutex
+
+	// ignoreNegatives indicates whether negative ("OK") scan results should
+	// be omitted from the results.
+	ignoreNegatives bool
+
+	// results holds the results of the scan.  It is built incrementally as
+	// responses (or errors) are received from clamd.
+	results clamav.ClamdScanResult
+
+	// resultsMutex is a lock protecting results, the errors are logged both
+	// while submitting the files and while polling the responses.
+	resultsMutex sync.Mutex
+}
+
+var _ clamav.ClamdSession = &clamdSession{}
+
+// newClamdSession opens a connection to the clamd listening at socket, starts a session,
+// and returns a session object for that session.
+func newClamdSession(socket string, ignoreNegatives bool) (clamav.ClamdSession, error) {
+	conn, err := clamav.NewClamdConn(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.Write([]byte("zIDSESSION\000"), nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &clamdSession{
+		closeChan:           make(chan bool),
+		conn:                conn,
+		requestIDToFilename: map[int]string{},
+		ignoreNegatives:     ignoreNegatives,
+		results: clamav.ClamdScanResult{
+			Files: []clamav.ClamdFileResult{},
+		},
+	}
+
+	go s.pollResponses()
+
+	return s, nil
+}
+
+// Close ends the session with clamd and closes the connection.
+func (s *clamdSession) Close() error {
+	if err := s.conn.Write([]byte("zEND\000"), nil); err != nil {
+		s.conn.Close()
+		return err
+	}
+
+	return s.conn.Close()
+}
+
+// WaitTillDone blocks until the responses for each file submitted to clamd have been
+// received.  It should be called only after all files have been submitted.
+func (s *clamdSession) WaitTillDone() {
+	atomic.StoreInt32(&s.allFilesSubmitted, 1)
+
+	<-s.closeChan
+}
+
+// GetResults returns the scan results.
+func (s *clamdSession) GetResults() clamav.ClamdScanResult {
+	s.resultsMutex.Lock()
+	defer s.resultsMutex.Unlock()
+
+	return s.results
+}
+
+// filesSubmitted returns the number of files submitted so far.
+func (s *clamdSession) filesSubmitted() int {
+	s.requestIDToFilenameMutex.Lock()
+	defer s.requestIDToFilenameMutex.Unlock()
+
+	return s.numFilesSubmitted
+}
+
+// pollResponses polls clamd for responses, reads them, and handles them.  It
+// closes closeChan and returns once all files have been submitted and all
+// responses received, or when the connection to clamd is closed.
+func (s *clamdSession) pollResponses() {
+	defer close(s.closeChan)
+
+	for {
+		if atomic.LoadInt32(&s.allFilesSubmitted) == 1 && s.filesSubmitted() == s.numResponsesReceived {
+			return
+		}
+
+		buf, err := s.conn.Read()
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				continue
+			}
+
+			s.log(err)
+
+			if err == io.EOF {
+				return
+			}
+
+			continue
+		}
+
+		s.handleResponses(buf)
+	}
+}
+
+// handleResponses takes a buffer that may contain 1 or more responses from
+// clamd and handles those responses individually.
+func (s *clamdSession) handleResponses(buf []byte) {
+	buf = append(s.partialResponse, buf...)
+	s.partialResponse = nil
+
+	for {
+		end := bytes.IndexByte(buf, '\x00')
+		if end <= 0 {
+			s.partialResponse = buf
+			return
+		}
+
+		response := string(buf[:end])
+		buf = buf[end+1:]
+
+		glog.V(6).Infof("Parsed response:\n  %#v\nremaining buffer:\n  %#v\n", response, string(buf))
+
+		s.handleResponse(response)
+	}
+}
+
+// handleResponse takes a response that was received from clamd and handles it.
+func (s *clamdSession) handleResponse(response string) {
+	errors := []string{}
+
+	requestID, requestResult, err := parseClamdResponse(response)
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	path := "<unknown>"
+	if requestID != 0 {
+		var ok bool
+
+		s.requestIDToFilenameMutex.Lock()
+		path, ok = s.requestIDToFilename[requestID]
+		s.requestIDToFilenameMutex.Unlock()
+		if !ok {
+			errors = append(errors, fmt.Sprintf("request not recognized: %d", requestID))
+		}
+
+		s.numResponsesReceived++
+	}
+
+	result := clamav.ClamdFileResult{
+		Filename: path,
+		Result:   requestResult,
+		Errors:   errors,
+	}
+
+	glog.V(6).Infof("Received scan result for request %d out of %d submitted:\n  %#v\n",
+		requestID, s.filesSubmitted(), result)
+
+	if !s.ignoreNegatives || !result.IsNegative() {
+		s.resultsMutex.Lock()
+		s.results.Files = append(s.results.Files, result)
+		s.resultsMutex.Unlock()
+	}
+}
+
+// parseClamdResponse takes a response that was received from clamd and parses it.
+func parseClamdResponse(response string) (int, string, error) {
+	glog.V(6).Infof("Parsing clamd response: %q\n", response)
+
+	// Response should have the form "<requestID>: fd[<fd>]: <response>"
+	// where requestID is an integer, fd[<fd>] is the file descriptor on
+	// clamd's side (which is useless to us), and response is the result of
+	// the clamd scan on that file descriptor.
+	parts := strings.SplitN(response, ": ", 3)
+	if len(parts) < 3 {
+		return 0, "", fmt.Errorf("unexpected response from clamd: %s", response)
+	}
+
+	requestID, err := strconv.ParseInt(parts[0], 10, 0)
+	if err != nil {
+		return 0, "", fmt.Errorf("strconv.ParseInt failed: %s", response)
+	}
+
+	return int(requestID), parts[2], nil
+}
+
+// log appends an error to the scan results.
+func (s *clamdSession) log(err error) {
+	s.resultsMutex.Lock()
+	defer s.resultsMutex.Unlock()
+
+	s.results.Errors = append(s.results.Errors, err.Error())
+}
+
+// ScanPath performs a scan on a path by walking the path and submitting files
+// to clamd.  Recoverable errors are added to the scan result.  In the case of a
+// non-recoverable error, an error is returned instead.
+func (s *clamdSession) ScanPath(ctx context.Context, rootPath string, filter clamav.FilterFiles) error {
+	walkFn := func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			s.log(err)
+			return nil
+		}
+
+		if ctx != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+		}
+
+		if filter != nil && !filter(path, fileInfo) {
+			// skipping a file must not skip the rest of its directory
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if path == rootPath || !fileInfo.Mode().IsRegular() {
+			return nil
+		}
+
+		if err := s.scanFile(path); err != nil {
+			s.log(err)
+		}
+
+		return nil
+	}
+
+	return filepath.Walk(rootPath, walkFn)
+}
+
+// scanFile submits a file to clamd for scanning.
+func (s *clamdSession) scanFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rights := syscall.UnixRights(int(f.Fd()))
+	msg := []byte("zFILDES\000\000")
+
+	// the request is recorded before it is written since its response can be
+	// received as soon as it is written
+	s.requestIDToFilenameMutex.Lock()
+	s.numFilesSubmitted++
+	requestID := s.numFilesSubmitted
+	s.requestIDToFilename[requestID] = path
+	s.requestIDToFilenameMutex.Unlock()
+
+	if err = s.conn.Write(msg, rights); err != nil {
+		// requests are not written concurrently, the last one is still this one
+		s.requestIDToFilenameMutex.Lock()
+		delete(s.requestIDToFilename, requestID)
+		s.numFilesSubmitted--
+		s.requestIDToFilenameMutex.Unlock()
+		return err
+	}
+
+	return nil
+}
@@ -2,17 +2,43 @@ package cmd
 
 import (
 	"fmt"
-
-	oscapscanner "github.com/openshift/image-inspector/pkg/openscap"
+	"io/ioutil"
 
 	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/hygiene"
+	"github.com/openshift/image-inspector/pkg/logging"
+	"github.com/openshift/image-inspector/pkg/openscap"
 
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	util "github.com/openshift/image-inspector/pkg/util"
 )
 
-const DefaultDockerSocketLocation = "unix:///var/run/docker.sock"
+const (
+	DefaultDockerSocketLocation = "unix:///var/run/docker.sock"
+	DefaultPullRetryCount       = 3
+	DefaultPullRetryInterval    = 2 * time.Second
+	DefaultPostResultRetries    = 3
+	DefaultPostTimeout          = time.Minute
+	DefaultDockerDialTimeout    = 30 * time.Second
+	DefaultDockerKeepAlive      = 30 * time.Second
+	DefaultReadTimeout          = 30 * time.Second
+	DefaultWriteTimeout         = 10 * time.Minute
+	DefaultIdleTimeout          = 2 * time.Minute
+	// DefaultExtractConcurrency is the number of layers extracted at once while the
+	// image is exported
+	DefaultExtractConcurrency = 2
+	// RegistryPasswordEnv is the environment variable holding the password for authentication
+	// to the docker registry when no PasswordFile is given.
+	RegistryPasswordEnv = "INSPECTOR_REGISTRY_PASSWORD"
+	// AuthTokenEnv is the environment variable holding the AuthToken when no AuthTokenFile
+	// is given.
+	AuthTokenEnv = "INSPECTOR_AUTH_TOKEN"
+)
 
 // MultiStringVar is implementing flag.Value
 type MultiStringVar struct {
@@ -28,11 +54,50 @@ func (sv *MultiStringVar) String() string {
 	return fmt.Sprintf("%v", sv.Values)
 }
 
+// StringSliceVar is implementing flag.Value, appending the value at each use of the flag.
+type StringSliceVar []string
+
+func (sv *StringSliceVar) Set(s string) error {
+	*sv = append(*sv, s)
+	return nil
+}
+
+func (sv *StringSliceVar) String() string {
+	return fmt.Sprintf("%v", []string(*sv))
+}
+
+// FileModeVar is implementing flag.Value for octal permissions, e.g. 0700.
+type FileModeVar struct {
+	// Mode is 0 when not set
+	Mode os.FileMode
+}
+
+func (mv *FileModeVar) Set(s string) error {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("%s is not octal permissions such as 0700", s)
+	}
+	mv.Mode = os.FileMode(mode)
+	return nil
+}
+
+func (mv *FileModeVar) String() string {
+	if mv.Mode == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(mv.Mode))
+}
+
 // ImageInspectorOptions is the main inspector implementation and holds the configuration
 // for an image inspector.
 type ImageInspectorOptions struct {
 	// URI contains the location of the docker daemon socket to connect to.
 	URI string
+	// DockerTLSCert, DockerTLSKey and DockerTLSCA are the paths of the client certificate, its
+	// key and the CA certificate used to connect with TLS to a tcp:// docker daemon URI.
+	DockerTLSCert string
+	DockerTLSKey  string
+	DockerTLSCA   string
 	// Image contains the docker image to inspect.
 	Image string
 	// Container contains the docker container to inspect.
@@ -41,53 +106,266 @@ type ImageInspectorOptions struct {
 	ScanContainerChanges bool
 	// DstPath is the destination path for image files.
 	DstPath string
+	// Cleanup removes DstPath and ScanResultsDir once the inspection is done, they are kept
+	// otherwise and their paths are logged.
+	Cleanup bool
+	// RootfsPath is a directory holding an already extracted filesystem that is inspected
+	// instead of a docker image or container.
+	RootfsPath string
+	// ImageTar is a docker save tarball, read from stdin when "-", that is inspected instead
+	// of a docker image, without involving the docker daemon.
+	ImageTar string
+	// ExtractPaths are the absolute paths of the image that are extracted, the whole image
+	// filesystem is extracted when empty.
+	ExtractPaths MultiStringVar
+	// ScanVolumesOnly restricts the extraction and the scan to the volumes declared in the
+	// image config.
+	ScanVolumesOnly bool
+	// MetadataOnly reports the layers, the size and the labels of the image from its
+	// metadata, without creating a container to extract and scan its filesystem.
+	MetadataOnly bool
 	// Serve holds the host and port for where to serve the image with webdav.
 	Serve string
 	// Chroot controls whether or not a chroot is excuted when serving the image with webdav.
 	Chroot bool
+	// ServeOnScanError controls whether the image is still served, with the scan errors
+	// reported in the metadata, when a scan fails.
+	ServeOnScanError bool
+	// ServePartialResults controls whether the image is served while it's scanned, with the
+	// results found so far served as incomplete until the scan is done.
+	ServePartialResults bool
+	// ReadTimeout is the time limit of reading each request, headers and body, sent to the
+	// webdav server, no limit when 0
+	ReadTimeout time.Duration
+	// WriteTimeout is the time limit of writing each response of the webdav server, it must
+	// leave time to download the largest files of the image, no limit when 0
+	WriteTimeout time.Duration
+	// IdleTimeout is the time the webdav server keeps an idle connection open waiting for
+	// the next request, ReadTimeout is used when 0
+	IdleTimeout time.Duration
 	// DockerCfg is the location of the docker config file.
 	DockerCfg MultiStringVar
 	// Username is the username for authenticating to the docker registry.
 	Username string
 	// PasswordFile is the location of the file containing the password for authentication to the
-	// docker registry.
+	// docker registry, the password is read from RegistryPasswordEnv when empty.
 	PasswordFile string
+	// RegistryMirrors are the registries, optionally with a path prefix, the image is pulled
+	// from in order before its own registry. The auths are still the ones of its registry.
+	RegistryMirrors MultiStringVar
+	// AnonymousFallback controls whether the image is pulled without authentication when
+	// all the given authentications fail. Without authentications the image is always
+	// pulled anonymously.
+	AnonymousFallback bool
 	// ScanType is the type of the scan to be done on the inspected image
 	ScanType string
 	// ScanResultsDir is the directory that will contain the results of the scan
 	ScanResultsDir string
-	// OpenScapHTML controls whether or not to generate an HTML report
-	// TODO: Move this into openscap plugin options.
-	OpenScapHTML bool
-	// CVEUrlPath An alternative source for the cve files
-	// TODO: Move this into openscap plugin options.
-	CVEUrlPath string
+	// Strict makes the inspection fail instead of removing the reports of a previous scan
+	// found in ScanResultsDir.
+	Strict bool
+	// OpenSCAP are the options of the openscap scan.
+	OpenSCAP openscap.Options
+	// ApkSecDB are the paths or urls of the Alpine SecDB feeds used by the apk scan, the
+	// feeds of the Alpine release of the image are downloaded when empty.
+	ApkSecDB MultiStringVar
+	// HygienePatterns are the globs of the paths reported by the hygiene scan, replacing the
+	// default ones when given.
+	HygienePatterns MultiStringVar
+	// KmodInitPaths are the globs of the init scripts searched by the kmod scan for commands
+	// loading kernel modules, replacing the default init scripts and systemd units.
+	KmodInitPaths MultiStringVar
+	// ExcludePaths are the globs of the paths of the image left out of the scan, matched
+	// against the file names when they have no slash. The excluded directories aren't walked.
+	ExcludePaths MultiStringVar
 	// ClamSocket is the location of clamav socket file
 	ClamSocket string
+	// ClamDebug controls whether the messages exchanged with clamd are logged
+	ClamDebug bool
+	// ClamMaxFileSize is the size in bytes above which the files are not submitted to clamd,
+	// no limit when 0. The skipped files are noted in the metadata of the scan.
+	ClamMaxFileSize int64
+	// AllowedMethods is a comma separated list of the HTTP methods accepted by the webdav
+	// content endpoint, the read-only methods are accepted when empty.
+	AllowedMethods string
+	// AllowWrite makes the webdav content endpoint accept all the methods, including the
+	// ones modifying the served image, when AllowedMethods is empty.
+	AllowWrite bool
+	// DisableCompression disables the gzip compression of the responses of the webdav
+	// server to the clients accepting it.
+	DisableCompression bool
+	// WebdavIndex controls whether the directories of the image are listed in HTML when
+	// browsing the webdav content endpoint.
+	WebdavIndex bool
+	// KeepOriginalImageName controls whether the image name given in input is kept in the
+	// results besides the normalized one.
+	KeepOriginalImageName bool
+	// EmbedProvenance controls whether the build provenance of image-inspector is added to
+	// the results.
+	EmbedProvenance bool
+	// DetectOS controls whether the detected OS family and package manager of the image are
+	// added to the results and metadata.
+	DetectOS bool
 	// PostResultURL represents an URL where the image-inspector should post the results of
 	// the scan.
 	PostResultURL string
+	// OutputFile is the path of the file where the results of the scan are written in JSON.
+	OutputFile string
+	// OutputGzip writes the OutputFile gzip-compressed, as it is when its name has the .gz
+	// extension.
+	OutputGzip bool
+	// SplitResults is the directory where the results of each scanner are also written in
+	// JSON to <scanner>.json, with the identity of the image.
+	SplitResults string
+	// BaselineResult is a file holding the results of a previous scan, the changes of the
+	// results since then are added to the results.
+	BaselineResult string
+	// AttestationFile is the path of the file where the results are written as an in-toto
+	// attestation statement about the image.
+	AttestationFile string
+	// AttestationKeyFile is a PEM private key signing the attestation, which is then written
+	// in a DSSE envelope.
+	AttestationKeyFile string
+	// FailOnScanError fails the inspection, once the results are written and posted, when a
+	// scanner failed. The image is served instead when ServeOnScanError is set.
+	FailOnScanError bool
+	// FailOnSeverity is the severity at or above which any result fails the inspection once
+	// the results are written and posted, none when empty.
+	FailOnSeverity string
 	// PostResultTokenFile if specified the content of the file will be added as a token to
 	// the result POST URL (eg. http://foo/?token=CONTENT.
 	PostResultTokenFile string
-	// AuthToken is a Shared Secret used to validate HTTP Requests.
-	// AuthToken can be set through AuthTokenFile or ENV
+	// PostMultipart controls whether the results are posted as multipart/form-data together
+	// with the scan reports.
+	PostMultipart bool
+	// PostResultRetries is the number of times posting the results is retried when the
+	// failure may be transient (network or result sink server errors)
+	PostResultRetries int
+	// ScanTimeout is the time limit of the scan, the results found until then are kept when
+	// it's reached, no limit when 0
+	ScanTimeout time.Duration
+	// MaxFiles is the number of files after which the scan stops, recording in the metadata
+	// that it was truncated, no limit when 0
+	MaxFiles int
+	// PostTimeout is the time limit of each attempt of posting the results, no limit when 0
+	PostTimeout time.Duration
+	// IgnorePostErrors controls whether the inspection goes on, instead of failing, when the
+	// results can't be posted.
+	IgnorePostErrors bool
+	// AuthToken is a Shared Secret used to validate HTTP Requests, or a comma-separated list
+	// of them accepted alike to rotate the secret without downtime.
+	// AuthToken is set by LoadAuthToken from AuthTokenFile or AuthTokenEnv
 	AuthToken string
-	// AuthTokenFile is the path to a file containing the AuthToken
-	// If it is not provided, the AuthToken will be read from the ENV
+	// AuthTokenFile is the path to a file containing the AuthToken, it takes precedence over
+	// AuthTokenEnv. The surrounding whitespace, e.g. the trailing newline, is ignored.
 	AuthTokenFile string
 	// PullPolicy controls whether we try to pull the inspected image
 	PullPolicy string
+	// PullRetryCount is the number of times a failed pull is retried when the error may be
+	// transient (network or registry server errors)
+	PullRetryCount int
+	// PullRetryInterval is the wait before retrying a failed pull, doubled after each retry
+	PullRetryInterval time.Duration
+	// DockerDialTimeout is the time limit of connecting to the docker daemon, no limit when 0
+	DockerDialTimeout time.Duration
+	// DockerKeepAlive is the interval of the keep-alive probes of the idle connections to the
+	// docker daemon, which are reused by the later requests and inspections, disabled when 0
+	DockerKeepAlive time.Duration
+	// LayerCacheDir is the directory where the extracted image layers are cached so that layers
+	// shared between inspected images are extracted only once
+	LayerCacheDir string
+	// ExtractConcurrency is the number of layers extracted at once into LayerCacheDir while
+	// the rest of the image is still being exported, they are extracted only once the image
+	// is exported when 0.
+	ExtractConcurrency int
+	// OutputDirMode are the permissions of the extraction, scan results and layer cache
+	// directories that are created, 0755 for the given paths and 0700 for the temporary ones
+	// when not set. The existing directories are left untouched.
+	OutputDirMode FileModeVar
+	// UTCTimestamps controls whether timestamps are emitted in UTC and RFC3339 format
+	UTCTimestamps bool
+	// SymlinkPolicy controls how symlinks with absolute or escaping targets are extracted
+	SymlinkPolicy string
+	// ExtractSpecialFiles controls whether device nodes and FIFOs are recreated when
+	// extracting the image.
+	ExtractSpecialFiles bool
+	// PreserveXattrs controls whether the extended attributes (e.g. file capabilities) are
+	// restored when extracting the image.
+	PreserveXattrs bool
+	// DeniedDigestsFile is a file listing the digests of known-bad images and layers, one
+	// per line, that are never inspected. The digests of the layers are known only when
+	// extracting the image through LayerCacheDir.
+	DeniedDigestsFile string
+	// ArchMismatchPolicy controls how an image built for an architecture other than the
+	// host's is scanned.
+	ArchMismatchPolicy string
+	// UnknownOSPolicy controls how an image without an os-release or redhat-release file is
+	// scanned by the OS dependent scanners.
+	UnknownOSPolicy string
+	// LogLevel is the minimum level of the logged messages, one of logging.LevelOptions.
+	LogLevel string
+	// LogFormat is the format of the logged messages, one of logging.FormatOptions.
+	LogFormat string
+	// Quiet logs only the warnings and the errors, whatever the LogLevel.
+	Quiet bool
+	// DryRun checks the options, the docker daemon, the registry auths and the scanner, and
+	// reports the plan of the inspection without pulling, extracting or scanning the image.
+	DryRun bool
+	// Verbose logs the debug messages, whatever the LogLevel, and raises the glog verbosity
+	// so that the clamav scans log the messages exchanged with clamd as with ClamDebug.
+	Verbose bool
 }
 
 // NewDefaultImageInspectorOptions provides a new ImageInspectorOptions with default values.
 func NewDefaultImageInspectorOptions() *ImageInspectorOptions {
 	return &ImageInspectorOptions{
-		URI:        DefaultDockerSocketLocation,
-		DockerCfg:  MultiStringVar{[]string{}},
-		CVEUrlPath: oscapscanner.CVEUrl,
-		PullPolicy: iiapi.PullIfNotPresent,
+		URI:                DefaultDockerSocketLocation,
+		DockerCfg:          MultiStringVar{[]string{}},
+		PullPolicy:         iiapi.PullIfNotPresent,
+		PullRetryCount:     DefaultPullRetryCount,
+		PullRetryInterval:  DefaultPullRetryInterval,
+		DockerDialTimeout:  DefaultDockerDialTimeout,
+		DockerKeepAlive:    DefaultDockerKeepAlive,
+		PostResultRetries:  DefaultPostResultRetries,
+		PostTimeout:        DefaultPostTimeout,
+		ReadTimeout:        DefaultReadTimeout,
+		WriteTimeout:       DefaultWriteTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		ExtractConcurrency: DefaultExtractConcurrency,
+		SymlinkPolicy:      iiapi.SymlinkRelative,
+		ArchMismatchPolicy: iiapi.ArchMismatchWarn,
+		UnknownOSPolicy:    iiapi.UnknownOSProbe,
+		ServeOnScanError:   true,
+		FailOnScanError:    true,
+		LogLevel:           "info",
+		LogFormat:          logging.TextFormat,
+	}
+}
+
+// LoadAuthToken sets AuthToken from AuthTokenFile when given, otherwise from AuthTokenEnv.
+func (i *ImageInspectorOptions) LoadAuthToken() error {
+	if len(i.AuthTokenFile) == 0 {
+		i.AuthToken = os.Getenv(AuthTokenEnv)
+		return nil
 	}
+	token, err := ioutil.ReadFile(i.AuthTokenFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the webdav-token-file: %v", err)
+	}
+	i.AuthToken = strings.TrimSpace(string(token))
+	return nil
+}
+
+// EffectiveLogLevel returns the minimum level of the logged messages, given by Quiet and
+// Verbose when set.
+func (i *ImageInspectorOptions) EffectiveLogLevel() string {
+	switch {
+	case i.Quiet:
+		return "warning"
+	case i.Verbose:
+		return "debug"
+	}
+	return i.LogLevel
 }
 
 // Validate performs validation on the field settings.
@@ -95,10 +373,42 @@ func (i *ImageInspectorOptions) Validate() error {
 	if len(i.URI) == 0 {
 		return fmt.Errorf("docker socket connection must be specified")
 	}
+	if len(i.DockerTLSCert) > 0 || len(i.DockerTLSKey) > 0 || len(i.DockerTLSCA) > 0 {
+		if len(i.DockerTLSCert) == 0 || len(i.DockerTLSKey) == 0 || len(i.DockerTLSCA) == 0 {
+			return fmt.Errorf("docker-tls-cert, docker-tls-key and docker-tls-ca must be specified together")
+		}
+		if !strings.HasPrefix(i.URI, "tcp://") {
+			return fmt.Errorf("docker-tls-cert, docker-tls-key and docker-tls-ca can be used only with a tcp:// docker socket")
+		}
+	}
 	if len(i.Image) > 0 && len(i.Container) > 0 {
 		return fmt.Errorf("options container and image are mutually exclusive")
 	}
-	if len(i.Image) == 0 && len(i.Container) == 0 {
+	if len(i.ImageTar) > 0 && len(i.RootfsPath) > 0 {
+		return fmt.Errorf("option image-tar is mutually exclusive with image, container and rootfs-path")
+	}
+	if len(i.RootfsPath) > 0 {
+		if len(i.Image) > 0 || len(i.Container) > 0 {
+			return fmt.Errorf("option rootfs-path is mutually exclusive with image and container")
+		}
+		fi, err := os.Stat(i.RootfsPath)
+		if err != nil {
+			return fmt.Errorf("rootfs-path %q does not exist", i.RootfsPath)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("rootfs-path %q is not a directory", i.RootfsPath)
+		}
+	} else if len(i.ImageTar) > 0 {
+		if len(i.Image) > 0 || len(i.Container) > 0 {
+			return fmt.Errorf("option image-tar is mutually exclusive with image, container and rootfs-path")
+		}
+		if len(i.LayerCacheDir) > 0 || i.ExtractSpecialFiles || i.PreserveXattrs {
+			return fmt.Errorf("image-tar can't be used together with layer-cache-dir, extract-special-files or preserve-xattrs")
+		}
+		if _, err := os.Stat(i.ImageTar); i.ImageTar != "-" && err != nil {
+			return fmt.Errorf("image-tar %q does not exist", i.ImageTar)
+		}
+	} else if len(i.Image) == 0 && len(i.Container) == 0 {
 		return fmt.Errorf("docker image or container must be specified to inspect")
 	}
 	if i.ScanContainerChanges && len(i.Container) == 0 {
@@ -107,12 +417,116 @@ func (i *ImageInspectorOptions) Validate() error {
 	if len(i.DockerCfg.Values) > 0 && len(i.Username) > 0 {
 		return fmt.Errorf("only specify dockercfg file or username/password pair for authentication")
 	}
-	if len(i.Username) > 0 && len(i.PasswordFile) == 0 {
-		return fmt.Errorf("please specify password-file for the given username")
+	if len(i.Username) > 0 && len(i.PasswordFile) == 0 && len(os.Getenv(RegistryPasswordEnv)) == 0 {
+		return fmt.Errorf("please specify password-file or %s for the given username", RegistryPasswordEnv)
 	}
 	if len(i.Serve) == 0 && i.Chroot {
 		return fmt.Errorf("change root can be used only when serving the image through webdav")
 	}
+	if i.Cleanup {
+		if len(i.Serve) > 0 {
+			return fmt.Errorf("cleanup can't be used when serving the image through webdav")
+		}
+		if len(i.RootfsPath) > 0 || len(i.Container) > 0 {
+			return fmt.Errorf("cleanup can be used only when inspecting an image, the other filesystems aren't extracted")
+		}
+	}
+	if i.ServePartialResults {
+		if len(i.Serve) == 0 {
+			return fmt.Errorf("serve-partial-results can be used only when serving the image through webdav")
+		}
+		if i.Chroot {
+			return fmt.Errorf("serve-partial-results can't be used with chroot, the image is scanned while served")
+		}
+		if i.ScanType == "openscap" {
+			return fmt.Errorf("serve-partial-results can't be used with the openscap scan type, its reports are available only when the scan is done")
+		}
+	}
+	if len(i.Serve) == 0 && i.WebdavIndex {
+		return fmt.Errorf("webdav-index can be used only when serving the image through webdav")
+	}
+	if len(i.Serve) == 0 && i.DisableCompression {
+		return fmt.Errorf("disable-compression can be used only when serving the image through webdav")
+	}
+	if i.AllowWrite {
+		if len(i.Serve) == 0 {
+			return fmt.Errorf("allow-write can be used only when serving the image through webdav")
+		}
+		if len(i.AllowedMethods) > 0 {
+			return fmt.Errorf("option allow-write is mutually exclusive with allowed-methods")
+		}
+	}
+	if len(i.AllowedMethods) > 0 {
+		if len(i.Serve) == 0 {
+			return fmt.Errorf("allowed-methods can be used only when serving the image through webdav")
+		}
+		for _, method := range util.SplitList(strings.ToUpper(i.AllowedMethods), ",") {
+			if !util.StringInList(method, iiapi.WebdavMethods) {
+				return fmt.Errorf("%s is not one of the available allowed-methods which are %v",
+					method, iiapi.WebdavMethods)
+			}
+		}
+	}
+	if len(i.ExtractPaths.Values) > 0 {
+		if len(i.Container) > 0 || len(i.LayerCacheDir) > 0 {
+			return fmt.Errorf("extract-path can be used only when inspecting an image without layer-cache-dir")
+		}
+		for _, p := range i.ExtractPaths.Values {
+			if !path.IsAbs(p) {
+				return fmt.Errorf("extract-path %q is not an absolute path", p)
+			}
+		}
+	}
+	if i.ScanVolumesOnly {
+		if len(i.Image) == 0 || len(i.LayerCacheDir) > 0 {
+			return fmt.Errorf("scan-volumes-only can be used only when inspecting an image without layer-cache-dir")
+		}
+		if len(i.ExtractPaths.Values) > 0 {
+			return fmt.Errorf("options scan-volumes-only and extract-path are mutually exclusive")
+		}
+	}
+	if i.MetadataOnly {
+		if len(i.Image) == 0 {
+			return fmt.Errorf("metadata-only can be used only when inspecting an image")
+		}
+		if len(i.ScanType) > 0 || len(i.Serve) > 0 || len(i.AttestationFile) > 0 || len(i.FailOnSeverity) > 0 {
+			return fmt.Errorf("metadata-only can't be used together with scan-type, serve, attestation-file or fail-on-severity, the image is not scanned")
+		}
+		if len(i.LayerCacheDir) > 0 || len(i.ExtractPaths.Values) > 0 || i.ScanVolumesOnly {
+			return fmt.Errorf("metadata-only can't be used together with layer-cache-dir, extract-path or scan-volumes-only, the image is not extracted")
+		}
+	}
+	if len(i.RegistryMirrors.Values) > 0 {
+		if len(i.Image) == 0 {
+			return fmt.Errorf("registry-mirror can be used only when inspecting an image")
+		}
+		for _, mirror := range i.RegistryMirrors.Values {
+			if len(strings.Trim(mirror, "/")) == 0 || strings.Contains(mirror, "://") {
+				return fmt.Errorf("registry-mirror %q must be a registry host, optionally with a path, without the scheme", mirror)
+			}
+		}
+	}
+	if len(i.AttestationFile) > 0 && len(i.Image) == 0 {
+		return fmt.Errorf("attestation-file can be used only when inspecting an image")
+	}
+	if len(i.AttestationKeyFile) > 0 && len(i.AttestationFile) == 0 {
+		return fmt.Errorf("attestation-key can be used only when writing an attestation-file")
+	}
+	if len(i.LayerCacheDir) > 0 && len(i.Container) > 0 {
+		return fmt.Errorf("layer-cache-dir can be used only when inspecting an image")
+	}
+	if i.ExtractConcurrency < 0 {
+		return fmt.Errorf("extract-concurrency can't be negative")
+	}
+	if i.ExtractSpecialFiles && len(i.LayerCacheDir) > 0 {
+		return fmt.Errorf("extract-special-files can't be used together with layer-cache-dir")
+	}
+	if i.ScanType == "capabilities" && len(i.Image) > 0 && !i.PreserveXattrs {
+		return fmt.Errorf("scan-type \"capabilities\" requires preserve-xattrs when inspecting an image")
+	}
+	if i.PreserveXattrs && len(i.LayerCacheDir) > 0 {
+		return fmt.Errorf("preserve-xattrs can't be used together with layer-cache-dir")
+	}
 	if len(i.ScanResultsDir) > 0 && len(i.ScanType) == 0 {
 		return fmt.Errorf("scan-result-dir can be used only when spacifing scan-type")
 	}
@@ -122,19 +536,121 @@ func (i *ImageInspectorOptions) Validate() error {
 			return fmt.Errorf("scan-results-dir %q is not a directory", i.ScanResultsDir)
 		}
 	}
+	if i.PostMultipart && len(i.PostResultURL) == 0 {
+		return fmt.Errorf("post-results-url must be set to use post-multipart")
+	}
 	if len(i.PostResultTokenFile) > 0 && len(i.PostResultURL) == 0 {
 		return fmt.Errorf("post-results-url must be set to use post-results-token-file")
 	}
-	if i.OpenScapHTML && (len(i.ScanType) == 0 || i.ScanType != "openscap") {
-		return fmt.Errorf("openscap-html-report can be used only when specifying scan-type as \"openscap\"")
+	if len(i.DeniedDigestsFile) > 0 && len(i.RootfsPath) > 0 {
+		return fmt.Errorf("denied-digests-file can be used only when inspecting an image or a container")
 	}
-	for _, fl := range append(i.DockerCfg.Values, i.PasswordFile) {
+	for _, fl := range append(i.DockerCfg.Values, i.PasswordFile, i.OpenSCAP.LocalCVEFile, i.OpenSCAP.TailoringFile, i.DeniedDigestsFile, i.BaselineResult, i.AttestationKeyFile) {
 		if len(fl) > 0 {
 			if _, err := os.Stat(fl); os.IsNotExist(err) {
 				return fmt.Errorf("%s does not exist", fl)
 			}
 		}
 	}
+	if err := i.validateScanner(); err != nil {
+		return err
+	}
+
+	// A valid scan-type must be specified, unless the image is not scanned.
+	if !i.MetadataOnly && !util.StringInList(i.ScanType, iiapi.ScanOptions) {
+		return fmt.Errorf("%s is not one of the available scan-types which are %v",
+			i.ScanType, iiapi.ScanOptions)
+	}
+	if !util.StringInList(i.PullPolicy, iiapi.PullPolicyOptions) {
+		return fmt.Errorf("%s is not one of the available pull-policy options which are %v",
+			i.PullPolicy, iiapi.PullPolicyOptions)
+
+	}
+	if i.PullRetryCount < 0 {
+		return fmt.Errorf("pull-retries can't be negative")
+	}
+	if i.PullRetryInterval < 0 {
+		return fmt.Errorf("pull-retry-interval can't be negative")
+	}
+	if i.DockerDialTimeout < 0 {
+		return fmt.Errorf("docker-dial-timeout can't be negative")
+	}
+	if i.DockerKeepAlive < 0 {
+		return fmt.Errorf("docker-keep-alive can't be negative")
+	}
+	if i.PostResultRetries < 0 {
+		return fmt.Errorf("post-results-retries can't be negative")
+	}
+	if i.OutputDirMode.Mode != 0 && i.OutputDirMode.Mode&0700 != 0700 {
+		return fmt.Errorf("output-dir-mode %s must give all the permissions to the owner", i.OutputDirMode.String())
+	}
+	if i.ScanTimeout < 0 {
+		return fmt.Errorf("scan-timeout can't be negative")
+	}
+	if i.MaxFiles < 0 {
+		return fmt.Errorf("max-files can't be negative")
+	}
+	if i.MaxFiles > 0 && len(i.ScanType) == 0 {
+		return fmt.Errorf("max-files can be used only when specifying scan-type")
+	}
+	if i.PostTimeout < 0 {
+		return fmt.Errorf("post-results-timeout can't be negative")
+	}
+	if i.ReadTimeout < 0 {
+		return fmt.Errorf("read-timeout can't be negative")
+	}
+	if i.WriteTimeout < 0 {
+		return fmt.Errorf("write-timeout can't be negative")
+	}
+	if i.IdleTimeout < 0 {
+		return fmt.Errorf("idle-timeout can't be negative")
+	}
+	if len(i.SplitResults) > 0 {
+		if i.MetadataOnly {
+			return fmt.Errorf("split-results can't be used together with metadata-only, the image is not scanned")
+		}
+		if fi, err := os.Stat(i.SplitResults); err == nil && !fi.IsDir() {
+			return fmt.Errorf("split-results %q is not a directory", i.SplitResults)
+		}
+	}
+	if len(i.OutputFile) == 0 && i.OutputGzip {
+		return fmt.Errorf("output-gzip can be used only when writing the results to an output-file")
+	}
+	if len(i.PostResultURL) == 0 && i.IgnorePostErrors {
+		return fmt.Errorf("ignore-post-errors can be used only when posting the results")
+	}
+	if !util.StringInList(i.SymlinkPolicy, iiapi.SymlinkPolicyOptions) {
+		return fmt.Errorf("%s is not one of the available symlink-policy options which are %v",
+			i.SymlinkPolicy, iiapi.SymlinkPolicyOptions)
+	}
+	if !util.StringInList(i.ArchMismatchPolicy, iiapi.ArchMismatchPolicyOptions) {
+		return fmt.Errorf("%s is not one of the available arch-mismatch-policy options which are %v",
+			i.ArchMismatchPolicy, iiapi.ArchMismatchPolicyOptions)
+	}
+	if !util.StringInList(i.UnknownOSPolicy, iiapi.UnknownOSPolicyOptions) {
+		return fmt.Errorf("%s is not one of the available unknown-os-policy options which are %v",
+			i.UnknownOSPolicy, iiapi.UnknownOSPolicyOptions)
+	}
+	if len(i.FailOnSeverity) > 0 && !util.StringInList(i.FailOnSeverity, iiapi.SeverityOptions) {
+		return fmt.Errorf("%s is not one of the available fail-on-severity options which are %v",
+			i.FailOnSeverity, iiapi.SeverityOptions)
+	}
+	if !util.StringInList(i.LogLevel, logging.LevelOptions) {
+		return fmt.Errorf("%s is not one of the available log-level options which are %v",
+			i.LogLevel, logging.LevelOptions)
+	}
+	if !util.StringInList(i.LogFormat, logging.FormatOptions) {
+		return fmt.Errorf("%s is not one of the available log-format options which are %v",
+			i.LogFormat, logging.FormatOptions)
+	}
+	if i.Quiet && i.Verbose {
+		return fmt.Errorf("option quiet is mutually exclusive with verbose")
+	}
+	return nil
+}
+
+// validateScanner validates the options of the scanners against the scan-type.
+func (i *ImageInspectorOptions) validateScanner() error {
 	if len(i.ScanType) > 0 {
 		if !util.StringInList(i.ScanType, iiapi.ScanOptions) {
 			return fmt.Errorf("%s is not one of the available scan-types which are %v",
@@ -144,16 +660,123 @@ func (i *ImageInspectorOptions) Validate() error {
 	if i.ScanType == "clamav" && len(i.ClamSocket) == 0 {
 		return fmt.Errorf("clam-socket must be set to use clamav scan type")
 	}
+	if i.ClamDebug && i.ScanType != "clamav" {
+		return fmt.Errorf("clam-debug can be used only when specifying scan-type as \"clamav\"")
+	}
+	if i.ClamMaxFileSize < 0 {
+		return fmt.Errorf("clam-max-file-size can't be negative")
+	}
+	if i.ClamMaxFileSize > 0 && i.ScanType != "clamav" {
+		return fmt.Errorf("clam-max-file-size can be used only when specifying scan-type as \"clamav\"")
+	}
+	if len(i.ApkSecDB.Values) > 0 && i.ScanType != "apk" {
+		return fmt.Errorf("apk-secdb can be used only when specifying scan-type as \"apk\"")
+	}
+	if len(i.ExcludePaths.Values) > 0 {
+		if i.ScanType == "openscap" || i.ScanType == "apk" {
+			return fmt.Errorf("exclude-path can't be used with the %s scan type, it evaluates the package database instead of the files", i.ScanType)
+		}
+		for _, glob := range i.ExcludePaths.Values {
+			if _, err := path.Match(glob, ""); err != nil || len(glob) == 0 {
+				return fmt.Errorf("%q is not a valid exclude-path glob", glob)
+			}
+		}
+	}
+	if len(i.KmodInitPaths.Values) > 0 {
+		if i.ScanType != "kmod" {
+			return fmt.Errorf("kmod-init-path can be used only when specifying scan-type as \"kmod\"")
+		}
+		for _, glob := range i.KmodInitPaths.Values {
+			if _, err := path.Match(glob, ""); err != nil || len(glob) == 0 {
+				return fmt.Errorf("%q is not a valid kmod-init-path glob", glob)
+			}
+		}
+	}
+	if len(i.HygienePatterns.Values) > 0 {
+		if i.ScanType != "hygiene" {
+			return fmt.Errorf("hygiene-pattern can be used only when specifying scan-type as \"hygiene\"")
+		}
+		for _, glob := range i.HygienePatterns.Values {
+			if err := hygiene.ValidateGlob(glob); err != nil {
+				return fmt.Errorf("%q is not a valid hygiene-pattern: %v", glob, err)
+			}
+		}
+	}
+	if i.OpenSCAP.HTML && (len(i.ScanType) == 0 || i.ScanType != "openscap") {
+		return fmt.Errorf("openscap-html-report can be used only when specifying scan-type as \"openscap\"")
+	}
+	if i.OpenSCAP.XCCDFResults && i.ScanType != "openscap" {
+		return fmt.Errorf("openscap-xccdf-results can be used only when specifying scan-type as \"openscap\"")
+	}
+	if i.OpenSCAP.JUnit && i.ScanType != "openscap" {
+		return fmt.Errorf("openscap-junit-report can be used only when specifying scan-type as \"openscap\"")
+	}
+	if len(i.OpenSCAP.LocalCVEFile) > 0 && i.ScanType != "openscap" {
+		return fmt.Errorf("cve-file can be used only when specifying scan-type as \"openscap\"")
+	}
+	if i.OpenSCAP.FetchRemote && i.ScanType != "openscap" {
+		return fmt.Errorf("oscap-fetch-remote can be used only when specifying scan-type as \"openscap\"")
+	}
+	if i.OpenSCAP.ValidateCVE && i.ScanType != "openscap" {
+		return fmt.Errorf("oscap-validate-cve can be used only when specifying scan-type as \"openscap\"")
+	}
+	if len(i.OpenSCAP.ExtraArgs) > 0 {
+		if i.ScanType != "openscap" {
+			return fmt.Errorf("oscap-arg can be used only when specifying scan-type as \"openscap\"")
+		}
+		if err := openscap.CheckExtraArgs(i.OpenSCAP.ExtraArgs); err != nil {
+			return err
+		}
+	}
+	if len(i.OpenSCAP.Profile) > 0 {
+		if i.ScanType != "openscap" {
+			return fmt.Errorf("openscap-profile can be used only when specifying scan-type as \"openscap\"")
+		}
+		for _, arg := range i.OpenSCAP.ExtraArgs {
+			if arg == "--profile" || strings.HasPrefix(arg, "--profile=") {
+				return fmt.Errorf("openscap-profile can't be used with the oscap-arg --profile")
+			}
+		}
+	}
+	if len(i.OpenSCAP.TailoringFile) > 0 {
+		if i.ScanType != "openscap" {
+			return fmt.Errorf("openscap-tailoring-file can be used only when specifying scan-type as \"openscap\"")
+		}
+		for _, arg := range i.OpenSCAP.ExtraArgs {
+			if arg == "--tailoring-file" || strings.HasPrefix(arg, "--tailoring-file=") {
+				return fmt.Errorf("openscap-tailoring-file can't be used with the oscap-arg --tailoring-file")
+			}
+		}
+	}
+	return nil
+}
 
-	// A valid scan-type must be specified.
+// ValidateSelfTest validates the options of the selftest, which scans a built-in image
+// instead of the inspected one.
+func (i *ImageInspectorOptions) ValidateSelfTest() error {
+	if len(i.Image) > 0 || len(i.Container) > 0 || len(i.RootfsPath) > 0 || len(i.ImageTar) > 0 {
+		return fmt.Errorf("selftest scans a built-in image, it can't be used together with image, container, rootfs-path or image-tar")
+	}
 	if !util.StringInList(i.ScanType, iiapi.ScanOptions) {
 		return fmt.Errorf("%s is not one of the available scan-types which are %v",
 			i.ScanType, iiapi.ScanOptions)
 	}
-	if !util.StringInList(i.PullPolicy, iiapi.PullPolicyOptions) {
-		return fmt.Errorf("%s is not one of the available pull-policy options which are %v",
-			i.PullPolicy, iiapi.PullPolicyOptions)
-
+	if err := i.validateScanner(); err != nil {
+		return err
+	}
+	if i.OutputDirMode.Mode != 0 && i.OutputDirMode.Mode&0700 != 0700 {
+		return fmt.Errorf("output-dir-mode %s must give all the permissions to the owner", i.OutputDirMode.String())
+	}
+	if i.ScanTimeout < 0 {
+		return fmt.Errorf("scan-timeout can't be negative")
+	}
+	if !util.StringInList(i.LogLevel, logging.LevelOptions) {
+		return fmt.Errorf("%s is not one of the available log-level options which are %v",
+			i.LogLevel, logging.LevelOptions)
+	}
+	if !util.StringInList(i.LogFormat, logging.FormatOptions) {
+		return fmt.Errorf("%s is not one of the available log-format options which are %v",
+			i.LogFormat, logging.FormatOptions)
 	}
 	return nil
 }
@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/openshift/image-inspector/pkg/logging"
 )
 
 func TestValidate(t *testing.T) {
@@ -42,7 +49,7 @@ func TestValidate(t *testing.T) {
 	goodScanOptions.Image = "image"
 	goodScanOptions.ScanType = "openscap"
 	goodScanOptions.ScanResultsDir = "."
-	goodScanOptions.OpenScapHTML = true
+	goodScanOptions.OpenSCAP.HTML = true
 
 	notADirResScan := NewDefaultImageInspectorOptions()
 	notADirResScan.Image = "image"
@@ -60,17 +67,553 @@ func TestValidate(t *testing.T) {
 
 	badScanOptionsHTMLnoScan := NewDefaultImageInspectorOptions()
 	badScanOptionsHTMLnoScan.Image = "image"
-	badScanOptionsHTMLnoScan.OpenScapHTML = true
+	badScanOptionsHTMLnoScan.OpenSCAP.HTML = true
 
 	badScanOptionsHTMLWrongScan := NewDefaultImageInspectorOptions()
 	badScanOptionsHTMLWrongScan.Image = "image"
-	badScanOptionsHTMLWrongScan.OpenScapHTML = true
+	badScanOptionsHTMLWrongScan.OpenSCAP.HTML = true
 	badScanOptionsHTMLWrongScan.ScanType = "nosuchscantype"
 
 	noSuchPullPolicy := NewDefaultImageInspectorOptions()
 	noSuchPullPolicy.Image = "image"
 	noSuchPullPolicy.PullPolicy = "whatisdocker?"
 
+	negativeDockerDialTimeout := NewDefaultImageInspectorOptions()
+	negativeDockerDialTimeout.Image = "image"
+	negativeDockerDialTimeout.ScanType = "openscap"
+	negativeDockerDialTimeout.DockerDialTimeout = -time.Second
+
+	negativeDockerKeepAlive := NewDefaultImageInspectorOptions()
+	negativeDockerKeepAlive.Image = "image"
+	negativeDockerKeepAlive.ScanType = "openscap"
+	negativeDockerKeepAlive.DockerKeepAlive = -time.Second
+
+	negativePullRetries := NewDefaultImageInspectorOptions()
+	negativePullRetries.Image = "image"
+	negativePullRetries.ScanType = "openscap"
+	negativePullRetries.PullRetryCount = -1
+
+	negativePostRetries := NewDefaultImageInspectorOptions()
+	negativePostRetries.Image = "image"
+	negativePostRetries.ScanType = "openscap"
+	negativePostRetries.PostResultRetries = -1
+
+	negativeScanTimeout := NewDefaultImageInspectorOptions()
+	negativeScanTimeout.Image = "image"
+	negativeScanTimeout.ScanType = "openscap"
+	negativeScanTimeout.ScanTimeout = -time.Second
+
+	negativePostTimeout := NewDefaultImageInspectorOptions()
+	negativePostTimeout.Image = "image"
+	negativePostTimeout.ScanType = "openscap"
+	negativePostTimeout.PostTimeout = -time.Second
+
+	ignorePostErrorsWithoutURL := NewDefaultImageInspectorOptions()
+	ignorePostErrorsWithoutURL.Image = "image"
+	ignorePostErrorsWithoutURL.IgnorePostErrors = true
+
+	clamDebugWrongScan := NewDefaultImageInspectorOptions()
+	clamDebugWrongScan.Image = "image"
+	clamDebugWrongScan.ScanType = "openscap"
+	clamDebugWrongScan.ClamDebug = true
+
+	cveFileWrongScan := NewDefaultImageInspectorOptions()
+	cveFileWrongScan.Image = "image"
+	cveFileWrongScan.ScanType = "clamav"
+	cveFileWrongScan.ClamSocket = "clamav"
+	cveFileWrongScan.OpenSCAP.LocalCVEFile = "types.go"
+
+	apkSecDBWrongScan := NewDefaultImageInspectorOptions()
+	apkSecDBWrongScan.Image = "image"
+	apkSecDBWrongScan.ScanType = "openscap"
+	apkSecDBWrongScan.ApkSecDB.Values = []string{"main.json"}
+
+	oscapArgsWrongScan := NewDefaultImageInspectorOptions()
+	oscapArgsWrongScan.Image = "image"
+	oscapArgsWrongScan.ScanType = "unowned"
+	oscapArgsWrongScan.OpenSCAP.ExtraArgs = []string{"--skip-valid"}
+
+	managedOscapArgs := NewDefaultImageInspectorOptions()
+	managedOscapArgs.Image = "image"
+	managedOscapArgs.ScanType = "openscap"
+	managedOscapArgs.OpenSCAP.ExtraArgs = []string{"--skip-valid", "--results", "results.xml"}
+
+	goodOscapArgs := NewDefaultImageInspectorOptions()
+	goodOscapArgs.Image = "image"
+	goodOscapArgs.ScanType = "openscap"
+	goodOscapArgs.OpenSCAP.ExtraArgs = []string{"--fetch-remote-resources", "--skip-valid"}
+
+	fetchRemoteWrongScan := NewDefaultImageInspectorOptions()
+	fetchRemoteWrongScan.Image = "image"
+	fetchRemoteWrongScan.ScanType = "clamav"
+	fetchRemoteWrongScan.ClamSocket = "clamav"
+	fetchRemoteWrongScan.OpenSCAP.FetchRemote = true
+
+	fetchRemoteWithCVEFile := NewDefaultImageInspectorOptions()
+	fetchRemoteWithCVEFile.Image = "image"
+	fetchRemoteWithCVEFile.ScanType = "openscap"
+	fetchRemoteWithCVEFile.OpenSCAP.LocalCVEFile = "types.go"
+	fetchRemoteWithCVEFile.OpenSCAP.FetchRemote = true
+
+	fetchRemoteWithCVEUrl := NewDefaultImageInspectorOptions()
+	fetchRemoteWithCVEUrl.Image = "image"
+	fetchRemoteWithCVEUrl.ScanType = "openscap"
+	fetchRemoteWithCVEUrl.OpenSCAP.CVEUrlAltPath = "https://mirror.example.com/feeds/"
+	fetchRemoteWithCVEUrl.OpenSCAP.FetchRemote = true
+	fetchRemoteWithCVEUrl.OpenSCAP.ExtraArgs = []string{"--fetch-remote-resources"}
+
+	volumesOnlyWithContainer := NewDefaultImageInspectorOptions()
+	volumesOnlyWithContainer.Container = "container"
+	volumesOnlyWithContainer.ScanVolumesOnly = true
+
+	volumesOnlyWithExtractPath := NewDefaultImageInspectorOptions()
+	volumesOnlyWithExtractPath.Image = "image"
+	volumesOnlyWithExtractPath.ScanVolumesOnly = true
+	volumesOnlyWithExtractPath.ExtractPaths.Values = []string{"/etc"}
+
+	badFailOnSeverity := NewDefaultImageInspectorOptions()
+	badFailOnSeverity.Image = "image"
+	badFailOnSeverity.ScanType = "openscap"
+	badFailOnSeverity.FailOnSeverity = "high"
+
+	failOnSeverity := NewDefaultImageInspectorOptions()
+	failOnSeverity.Image = "image"
+	failOnSeverity.ScanType = "openscap"
+	failOnSeverity.FailOnSeverity = "important"
+
+	attestationContainer := NewDefaultImageInspectorOptions()
+	attestationContainer.Container = "container"
+	attestationContainer.ScanType = "openscap"
+	attestationContainer.AttestationFile = "attestation.json"
+
+	attestationKeyOnly := NewDefaultImageInspectorOptions()
+	attestationKeyOnly.Image = "image"
+	attestationKeyOnly.ScanType = "openscap"
+	attestationKeyOnly.AttestationKeyFile = "types.go"
+
+	attestation := NewDefaultImageInspectorOptions()
+	attestation.Image = "image"
+	attestation.ScanType = "openscap"
+	attestation.AttestationFile = "attestation.json"
+	attestation.AttestationKeyFile = "types.go"
+
+	capabilitiesWithoutXattrs := NewDefaultImageInspectorOptions()
+	capabilitiesWithoutXattrs.Image = "image"
+	capabilitiesWithoutXattrs.ScanType = "capabilities"
+
+	capabilitiesScan := NewDefaultImageInspectorOptions()
+	capabilitiesScan.Image = "image"
+	capabilitiesScan.ScanType = "capabilities"
+	capabilitiesScan.PreserveXattrs = true
+
+	partialResultsWithoutServe := NewDefaultImageInspectorOptions()
+	partialResultsWithoutServe.Image = "image"
+	partialResultsWithoutServe.ScanType = "clamav"
+	partialResultsWithoutServe.ClamSocket = "/run/clamd.sock"
+	partialResultsWithoutServe.ServePartialResults = true
+
+	partialResultsWithChroot := NewDefaultImageInspectorOptions()
+	partialResultsWithChroot.Image = "image"
+	partialResultsWithChroot.ScanType = "clamav"
+	partialResultsWithChroot.ClamSocket = "/run/clamd.sock"
+	partialResultsWithChroot.Serve = "localhost:8080"
+	partialResultsWithChroot.Chroot = true
+	partialResultsWithChroot.ServePartialResults = true
+
+	partialResultsWithOpenSCAP := NewDefaultImageInspectorOptions()
+	partialResultsWithOpenSCAP.Image = "image"
+	partialResultsWithOpenSCAP.ScanType = "openscap"
+	partialResultsWithOpenSCAP.Serve = "localhost:8080"
+	partialResultsWithOpenSCAP.ServePartialResults = true
+
+	partialResults := NewDefaultImageInspectorOptions()
+	partialResults.Image = "image"
+	partialResults.ScanType = "clamav"
+	partialResults.ClamSocket = "/run/clamd.sock"
+	partialResults.Serve = "localhost:8080"
+	partialResults.ServePartialResults = true
+
+	hygienePatternWithoutScan := NewDefaultImageInspectorOptions()
+	hygienePatternWithoutScan.Image = "image"
+	hygienePatternWithoutScan.ScanType = "unowned"
+	hygienePatternWithoutScan.HygienePatterns.Values = []string{".env"}
+
+	badHygienePattern := NewDefaultImageInspectorOptions()
+	badHygienePattern.Image = "image"
+	badHygienePattern.ScanType = "hygiene"
+	badHygienePattern.HygienePatterns.Values = []string{".env", "[a-"}
+
+	hygienePatterns := NewDefaultImageInspectorOptions()
+	hygienePatterns.Image = "image"
+	hygienePatterns.ScanType = "hygiene"
+	hygienePatterns.HygienePatterns.Values = []string{".env", "/var/cache/*"}
+
+	outputDirModeWithoutOwner := NewDefaultImageInspectorOptions()
+	outputDirModeWithoutOwner.Image = "image"
+	outputDirModeWithoutOwner.OutputDirMode.Mode = 0550
+
+	outputDirMode := NewDefaultImageInspectorOptions()
+	outputDirMode.Image = "image"
+	outputDirMode.ScanType = "clamav"
+	outputDirMode.ClamSocket = "clamav"
+	outputDirMode.OutputDirMode.Mode = 0750
+
+	metadataOnlyContainer := NewDefaultImageInspectorOptions()
+	metadataOnlyContainer.Container = "container"
+	metadataOnlyContainer.MetadataOnly = true
+
+	metadataOnlyWithScan := NewDefaultImageInspectorOptions()
+	metadataOnlyWithScan.Image = "image"
+	metadataOnlyWithScan.ScanType = "unowned"
+	metadataOnlyWithScan.MetadataOnly = true
+
+	metadataOnlyWithLayerCache := NewDefaultImageInspectorOptions()
+	metadataOnlyWithLayerCache.Image = "image"
+	metadataOnlyWithLayerCache.LayerCacheDir = "/var/cache/layers"
+	metadataOnlyWithLayerCache.MetadataOnly = true
+
+	metadataOnly := NewDefaultImageInspectorOptions()
+	metadataOnly.Image = "image"
+	metadataOnly.MetadataOnly = true
+
+	negativeClamMaxFileSize := NewDefaultImageInspectorOptions()
+	negativeClamMaxFileSize.Image = "image"
+	negativeClamMaxFileSize.ScanType = "clamav"
+	negativeClamMaxFileSize.ClamSocket = "clamav"
+	negativeClamMaxFileSize.ClamMaxFileSize = -1
+
+	clamMaxFileSizeWrongScan := NewDefaultImageInspectorOptions()
+	clamMaxFileSizeWrongScan.Image = "image"
+	clamMaxFileSizeWrongScan.ScanType = "unowned"
+	clamMaxFileSizeWrongScan.ClamMaxFileSize = 1 << 20
+
+	clamMaxFileSize := NewDefaultImageInspectorOptions()
+	clamMaxFileSize.Image = "image"
+	clamMaxFileSize.ScanType = "clamav"
+	clamMaxFileSize.ClamSocket = "clamav"
+	clamMaxFileSize.ClamMaxFileSize = 1 << 20
+
+	excludePathWithOpenSCAP := NewDefaultImageInspectorOptions()
+	excludePathWithOpenSCAP.Image = "image"
+	excludePathWithOpenSCAP.ScanType = "openscap"
+	excludePathWithOpenSCAP.ExcludePaths.Values = []string{"/var/cache"}
+
+	badExcludePath := NewDefaultImageInspectorOptions()
+	badExcludePath.Image = "image"
+	badExcludePath.ScanType = "unowned"
+	badExcludePath.ExcludePaths.Values = []string{"/var/cache/[a-"}
+
+	excludePaths := NewDefaultImageInspectorOptions()
+	excludePaths.Image = "image"
+	excludePaths.ScanType = "unowned"
+	excludePaths.ExcludePaths.Values = []string{"/var/cache", "*.log"}
+
+	kmodInitPathWithHygiene := NewDefaultImageInspectorOptions()
+	kmodInitPathWithHygiene.Image = "image"
+	kmodInitPathWithHygiene.ScanType = "hygiene"
+	kmodInitPathWithHygiene.KmodInitPaths.Values = []string{"start.sh"}
+
+	kmodInitPaths := NewDefaultImageInspectorOptions()
+	kmodInitPaths.Image = "image"
+	kmodInitPaths.ScanType = "kmod"
+	kmodInitPaths.KmodInitPaths.Values = []string{"start.sh", "/etc/rc.d/*"}
+
+	registryMirrorWithScheme := NewDefaultImageInspectorOptions()
+	registryMirrorWithScheme.Image = "image"
+	registryMirrorWithScheme.ScanType = "unowned"
+	registryMirrorWithScheme.RegistryMirrors.Values = []string{"https://mirror.example.com"}
+
+	registryMirrorOfContainer := NewDefaultImageInspectorOptions()
+	registryMirrorOfContainer.Container = "container"
+	registryMirrorOfContainer.ScanType = "unowned"
+	registryMirrorOfContainer.RegistryMirrors.Values = []string{"mirror.example.com"}
+
+	registryMirrors := NewDefaultImageInspectorOptions()
+	registryMirrors.Image = "image"
+	registryMirrors.ScanType = "unowned"
+	registryMirrors.RegistryMirrors.Values = []string{"mirror.example.com", "registry.example.com/dockerhub"}
+
+	negativeReadTimeout := NewDefaultImageInspectorOptions()
+	negativeReadTimeout.ReadTimeout = -time.Second
+
+	negativeWriteTimeout := NewDefaultImageInspectorOptions()
+	negativeWriteTimeout.WriteTimeout = -time.Second
+
+	negativeIdleTimeout := NewDefaultImageInspectorOptions()
+	negativeIdleTimeout.IdleTimeout = -time.Second
+
+	noServeTimeouts := NewDefaultImageInspectorOptions()
+	noServeTimeouts.Image = "image"
+	noServeTimeouts.ScanType = "unowned"
+	noServeTimeouts.Serve = "0.0.0.0:8080"
+	noServeTimeouts.ReadTimeout = 0
+	noServeTimeouts.WriteTimeout = 0
+	noServeTimeouts.IdleTimeout = 0
+
+	partialDockerTLS := NewDefaultImageInspectorOptions()
+	partialDockerTLS.URI = "tcp://docker.example.com:2376"
+	partialDockerTLS.DockerTLSCert = "/certs/cert.pem"
+	partialDockerTLS.DockerTLSKey = "/certs/key.pem"
+
+	dockerTLSWithUnixSocket := NewDefaultImageInspectorOptions()
+	dockerTLSWithUnixSocket.DockerTLSCert = "/certs/cert.pem"
+	dockerTLSWithUnixSocket.DockerTLSKey = "/certs/key.pem"
+	dockerTLSWithUnixSocket.DockerTLSCA = "/certs/ca.pem"
+
+	dockerTLS := NewDefaultImageInspectorOptions()
+	dockerTLS.Image = "image"
+	dockerTLS.ScanType = "unowned"
+	dockerTLS.URI = "tcp://docker.example.com:2376"
+	dockerTLS.DockerTLSCert = "/certs/cert.pem"
+	dockerTLS.DockerTLSKey = "/certs/key.pem"
+	dockerTLS.DockerTLSCA = "/certs/ca.pem"
+
+	cleanupWhileServing := NewDefaultImageInspectorOptions()
+	cleanupWhileServing.Image = "image"
+	cleanupWhileServing.Serve = "0.0.0.0:8080"
+	cleanupWhileServing.Cleanup = true
+
+	cleanupOfContainer := NewDefaultImageInspectorOptions()
+	cleanupOfContainer.Container = "container"
+	cleanupOfContainer.Cleanup = true
+
+	cleanup := NewDefaultImageInspectorOptions()
+	cleanup.Image = "image"
+	cleanup.ScanType = "unowned"
+	cleanup.Cleanup = true
+
+	outputGzipWithoutFile := NewDefaultImageInspectorOptions()
+	outputGzipWithoutFile.Image = "image"
+	outputGzipWithoutFile.ScanType = "unowned"
+	outputGzipWithoutFile.OutputGzip = true
+
+	outputGzip := NewDefaultImageInspectorOptions()
+	outputGzip.Image = "image"
+	outputGzip.ScanType = "unowned"
+	outputGzip.OutputFile = "results.json"
+	outputGzip.OutputGzip = true
+
+	validateCVEWrongScan := NewDefaultImageInspectorOptions()
+	validateCVEWrongScan.Image = "image"
+	validateCVEWrongScan.ScanType = "unowned"
+	validateCVEWrongScan.OpenSCAP.ValidateCVE = true
+
+	validateCVE := NewDefaultImageInspectorOptions()
+	validateCVE.Image = "image"
+	validateCVE.ScanType = "openscap"
+	validateCVE.OpenSCAP.ValidateCVE = true
+
+	profileWrongScan := NewDefaultImageInspectorOptions()
+	profileWrongScan.Image = "image"
+	profileWrongScan.ScanType = "unowned"
+	profileWrongScan.OpenSCAP.Profile = "xccdf_org.ssgproject.content_profile_cis"
+
+	profileWithProfileArg := NewDefaultImageInspectorOptions()
+	profileWithProfileArg.Image = "image"
+	profileWithProfileArg.ScanType = "openscap"
+	profileWithProfileArg.OpenSCAP.Profile = "xccdf_org.ssgproject.content_profile_cis"
+	profileWithProfileArg.OpenSCAP.ExtraArgs = []string{"--profile=standard"}
+
+	openscapProfile := NewDefaultImageInspectorOptions()
+	openscapProfile.Image = "image"
+	openscapProfile.ScanType = "openscap"
+	openscapProfile.OpenSCAP.Profile = "xccdf_org.ssgproject.content_profile_cis"
+
+	tailoringWrongScan := NewDefaultImageInspectorOptions()
+	tailoringWrongScan.Image = "image"
+	tailoringWrongScan.ScanType = "unowned"
+	tailoringWrongScan.OpenSCAP.TailoringFile = "types.go"
+
+	tailoringWithTailoringArg := NewDefaultImageInspectorOptions()
+	tailoringWithTailoringArg.Image = "image"
+	tailoringWithTailoringArg.ScanType = "openscap"
+	tailoringWithTailoringArg.OpenSCAP.TailoringFile = "types.go"
+	tailoringWithTailoringArg.OpenSCAP.ExtraArgs = []string{"--tailoring-file", "tailoring.xml"}
+
+	noSuchTailoringFile := NewDefaultImageInspectorOptions()
+	noSuchTailoringFile.Image = "image"
+	noSuchTailoringFile.ScanType = "openscap"
+	noSuchTailoringFile.OpenSCAP.TailoringFile = "nosuchfile"
+
+	tailoringFile := NewDefaultImageInspectorOptions()
+	tailoringFile.Image = "image"
+	tailoringFile.ScanType = "openscap"
+	tailoringFile.OpenSCAP.TailoringFile = "types.go"
+
+	xccdfResultsWrongScan := NewDefaultImageInspectorOptions()
+	xccdfResultsWrongScan.Image = "image"
+	xccdfResultsWrongScan.ScanType = "unowned"
+	xccdfResultsWrongScan.OpenSCAP.XCCDFResults = true
+
+	junitWrongScan := NewDefaultImageInspectorOptions()
+	junitWrongScan.Image = "image"
+	junitWrongScan.ScanType = "unowned"
+	junitWrongScan.OpenSCAP.JUnit = true
+
+	openscapReports := NewDefaultImageInspectorOptions()
+	openscapReports.Image = "image"
+	openscapReports.ScanType = "openscap"
+	openscapReports.OpenSCAP.XCCDFResults = true
+	openscapReports.OpenSCAP.JUnit = true
+
+	imageTarStdin := NewDefaultImageInspectorOptions()
+	imageTarStdin.ImageTar = "-"
+	imageTarStdin.ScanType = "hygiene"
+
+	imageTarWithImage := NewDefaultImageInspectorOptions()
+	imageTarWithImage.ImageTar = "-"
+	imageTarWithImage.Image = "image"
+
+	imageTarWithRootfs := NewDefaultImageInspectorOptions()
+	imageTarWithRootfs.ImageTar = "-"
+	imageTarWithRootfs.RootfsPath = "."
+
+	imageTarWithLayerCache := NewDefaultImageInspectorOptions()
+	imageTarWithLayerCache.ImageTar = "image.tar"
+	imageTarWithLayerCache.LayerCacheDir = "/var/tmp/layers"
+
+	noSuchImageTar := NewDefaultImageInspectorOptions()
+	noSuchImageTar.ImageTar = "nosuchfile.tar"
+
+	negativeMaxFiles := NewDefaultImageInspectorOptions()
+	negativeMaxFiles.Image = "image"
+	negativeMaxFiles.ScanType = "hygiene"
+	negativeMaxFiles.MaxFiles = -1
+
+	maxFilesNoScan := NewDefaultImageInspectorOptions()
+	maxFilesNoScan.Image = "image"
+	maxFilesNoScan.MaxFiles = 1000
+
+	maxFiles := NewDefaultImageInspectorOptions()
+	maxFiles.Image = "image"
+	maxFiles.ScanType = "hygiene"
+	maxFiles.MaxFiles = 1000
+
+	splitResultsMetadataOnly := NewDefaultImageInspectorOptions()
+	splitResultsMetadataOnly.Image = "image"
+	splitResultsMetadataOnly.MetadataOnly = true
+	splitResultsMetadataOnly.SplitResults = "results"
+
+	splitResultsFile := NewDefaultImageInspectorOptions()
+	splitResultsFile.Image = "image"
+	splitResultsFile.ScanType = "hygiene"
+	splitResultsFile.SplitResults = "types.go"
+
+	splitResults := NewDefaultImageInspectorOptions()
+	splitResults.Image = "image"
+	splitResults.ScanType = "hygiene"
+	splitResults.SplitResults = "nosuchdir"
+
+	allowWriteWithoutServe := NewDefaultImageInspectorOptions()
+	allowWriteWithoutServe.Image = "image"
+	allowWriteWithoutServe.ScanType = "hygiene"
+	allowWriteWithoutServe.AllowWrite = true
+
+	allowWriteWithMethods := NewDefaultImageInspectorOptions()
+	allowWriteWithMethods.Image = "image"
+	allowWriteWithMethods.ScanType = "hygiene"
+	allowWriteWithMethods.Serve = "localhost:8080"
+	allowWriteWithMethods.AllowWrite = true
+	allowWriteWithMethods.AllowedMethods = "GET,PUT"
+
+	allowWrite := NewDefaultImageInspectorOptions()
+	allowWrite.Image = "image"
+	allowWrite.ScanType = "hygiene"
+	allowWrite.Serve = "localhost:8080"
+	allowWrite.AllowWrite = true
+
+	disableCompressionWithoutServe := NewDefaultImageInspectorOptions()
+	disableCompressionWithoutServe.Image = "image"
+	disableCompressionWithoutServe.ScanType = "hygiene"
+	disableCompressionWithoutServe.DisableCompression = true
+
+	jsonLogFormat := NewDefaultImageInspectorOptions()
+	jsonLogFormat.Image = "image"
+	jsonLogFormat.ScanType = "hygiene"
+	jsonLogFormat.LogLevel = "warning"
+	jsonLogFormat.LogFormat = "json"
+
+	unknownLogLevel := NewDefaultImageInspectorOptions()
+	unknownLogLevel.Image = "image"
+	unknownLogLevel.ScanType = "hygiene"
+	unknownLogLevel.LogLevel = "verbose"
+
+	unknownLogFormat := NewDefaultImageInspectorOptions()
+	unknownLogFormat.Image = "image"
+	unknownLogFormat.ScanType = "hygiene"
+	unknownLogFormat.LogFormat = "xml"
+
+	quietAndVerbose := NewDefaultImageInspectorOptions()
+	quietAndVerbose.Image = "image"
+	quietAndVerbose.ScanType = "hygiene"
+	quietAndVerbose.Quiet = true
+	quietAndVerbose.Verbose = true
+
+	negativeExtractConcurrency := NewDefaultImageInspectorOptions()
+	negativeExtractConcurrency.Image = "image"
+	negativeExtractConcurrency.LayerCacheDir = "/var/tmp/layers"
+	negativeExtractConcurrency.ExtractConcurrency = -1
+
+	sequentialExtraction := NewDefaultImageInspectorOptions()
+	sequentialExtraction.Image = "image"
+	sequentialExtraction.LayerCacheDir = "/var/tmp/layers"
+	sequentialExtraction.ScanType = "hygiene"
+	sequentialExtraction.ExtractConcurrency = 0
+
+	noSuchBaselineResult := NewDefaultImageInspectorOptions()
+	noSuchBaselineResult.Image = "image"
+	noSuchBaselineResult.ScanType = "openscap"
+	noSuchBaselineResult.BaselineResult = "nosuchfile"
+
+	noSuchCVEFile := NewDefaultImageInspectorOptions()
+	noSuchCVEFile.Image = "image"
+	noSuchCVEFile.ScanType = "openscap"
+	noSuchCVEFile.OpenSCAP.LocalCVEFile = "nosuchfile"
+
+	noSuchArchMismatchPolicy := NewDefaultImageInspectorOptions()
+	noSuchArchMismatchPolicy.Image = "image"
+	noSuchArchMismatchPolicy.ScanType = "openscap"
+	noSuchArchMismatchPolicy.ArchMismatchPolicy = "emulate"
+
+	noSuchUnknownOSPolicy := NewDefaultImageInspectorOptions()
+	noSuchUnknownOSPolicy.Image = "image"
+	noSuchUnknownOSPolicy.ScanType = "openscap"
+	noSuchUnknownOSPolicy.UnknownOSPolicy = "fail"
+
+	noSuchDeniedDigestsFile := NewDefaultImageInspectorOptions()
+	noSuchDeniedDigestsFile.Image = "image"
+	noSuchDeniedDigestsFile.ScanType = "openscap"
+	noSuchDeniedDigestsFile.DeniedDigestsFile = "nosuchfile"
+
+	rootfsWithImage := NewDefaultImageInspectorOptions()
+	rootfsWithImage.Image = "image"
+	rootfsWithImage.RootfsPath = "/"
+
+	missingRootfs := NewDefaultImageInspectorOptions()
+	missingRootfs.RootfsPath = "/no/such/rootfs"
+
+	validRootfs := NewDefaultImageInspectorOptions()
+	validRootfs.RootfsPath = "/"
+	validRootfs.ScanType = "unowned"
+
+	relativeExtractPath := NewDefaultImageInspectorOptions()
+	relativeExtractPath.Image = "image"
+	relativeExtractPath.ExtractPaths.Values = []string{"/etc", "usr/bin"}
+
+	unknownAllowedMethod := NewDefaultImageInspectorOptions()
+	unknownAllowedMethod.Image = "image"
+	unknownAllowedMethod.Serve = "localhost:8080"
+	unknownAllowedMethod.AllowedMethods = "GET,FETCH"
+
+	indexWithoutServe := NewDefaultImageInspectorOptions()
+	indexWithoutServe.Image = "image"
+	indexWithoutServe.WebdavIndex = true
+
+	specialFilesWithLayerCache := NewDefaultImageInspectorOptions()
+	specialFilesWithLayerCache.Image = "image"
+	specialFilesWithLayerCache.LayerCacheDir = "/var/tmp/layers"
+	specialFilesWithLayerCache.ExtractSpecialFiles = true
+
 	conflictOptions := NewDefaultImageInspectorOptions()
 	conflictOptions.Image = "image"
 	conflictOptions.Container = "container"
@@ -95,8 +638,116 @@ func TestValidate(t *testing.T) {
 		"bad config with html and wrong scan": {inspector: badScanOptionsHTMLWrongScan, shouldValidate: false},
 		"no such pull policy available":       {inspector: noSuchPullPolicy, shouldValidate: false},
 		"conflict options":                    {inspector: conflictOptions, shouldValidate: false},
+		"negative pull retries":               {inspector: negativePullRetries, shouldValidate: false},
+		"negative docker dial timeout":        {inspector: negativeDockerDialTimeout, shouldValidate: false},
+		"negative docker keep alive":          {inspector: negativeDockerKeepAlive, shouldValidate: false},
+		"negative post retries":               {inspector: negativePostRetries, shouldValidate: false},
+		"negative post timeout":               {inspector: negativePostTimeout, shouldValidate: false},
+		"negative scan timeout":               {inspector: negativeScanTimeout, shouldValidate: false},
+		"ignore post errors without url":      {inspector: ignorePostErrorsWithoutURL, shouldValidate: false},
+		"clam debug with wrong scan":          {inspector: clamDebugWrongScan, shouldValidate: false},
+		"special files with layer cache":      {inspector: specialFilesWithLayerCache, shouldValidate: false},
+		"unknown allowed method":              {inspector: unknownAllowedMethod, shouldValidate: false},
+		"webdav index without serve":          {inspector: indexWithoutServe, shouldValidate: false},
+		"relative extract path":               {inspector: relativeExtractPath, shouldValidate: false},
+		"cve file with wrong scan":            {inspector: cveFileWrongScan, shouldValidate: false},
+		"apk secdb with wrong scan":           {inspector: apkSecDBWrongScan, shouldValidate: false},
+		"oscap args with wrong scan":          {inspector: oscapArgsWrongScan, shouldValidate: false},
+		"managed oscap args":                  {inspector: managedOscapArgs, shouldValidate: false},
+		"oscap args":                          {inspector: goodOscapArgs, shouldValidate: true},
+		"fetch remote with wrong scan":        {inspector: fetchRemoteWrongScan, shouldValidate: false},
+		"fetch remote with cve file":          {inspector: fetchRemoteWithCVEFile, shouldValidate: true},
+		"fetch remote with cve url":           {inspector: fetchRemoteWithCVEUrl, shouldValidate: true},
+		"scan volumes only with container":    {inspector: volumesOnlyWithContainer, shouldValidate: false},
+		"scan volumes only with extract path": {inspector: volumesOnlyWithExtractPath, shouldValidate: false},
+		"no such cve file":                    {inspector: noSuchCVEFile, shouldValidate: false},
+		"no such baseline result":             {inspector: noSuchBaselineResult, shouldValidate: false},
+		"partial results without serve":       {inspector: partialResultsWithoutServe, shouldValidate: false},
+		"partial results with chroot":         {inspector: partialResultsWithChroot, shouldValidate: false},
+		"partial results with openscap":       {inspector: partialResultsWithOpenSCAP, shouldValidate: false},
+		"partial results":                     {inspector: partialResults, shouldValidate: true},
+		"hygiene pattern with unowned":        {inspector: hygienePatternWithoutScan, shouldValidate: false},
+		"bad hygiene pattern":                 {inspector: badHygienePattern, shouldValidate: false},
+		"hygiene patterns":                    {inspector: hygienePatterns, shouldValidate: true},
+		"attestation of a container":          {inspector: attestationContainer, shouldValidate: false},
+		"attestation key without file":        {inspector: attestationKeyOnly, shouldValidate: false},
+		"signed attestation":                  {inspector: attestation, shouldValidate: true},
+		"capabilities without xattrs":         {inspector: capabilitiesWithoutXattrs, shouldValidate: false},
+		"capabilities scan":                   {inspector: capabilitiesScan, shouldValidate: true},
+		"bad fail-on-severity":                {inspector: badFailOnSeverity, shouldValidate: false},
+		"fail-on-severity":                    {inspector: failOnSeverity, shouldValidate: true},
+		"no such arch mismatch policy":        {inspector: noSuchArchMismatchPolicy, shouldValidate: false},
+		"no such unknown os policy":           {inspector: noSuchUnknownOSPolicy, shouldValidate: false},
+		"no such denied digests file":         {inspector: noSuchDeniedDigestsFile, shouldValidate: false},
+		"rootfs path with image":              {inspector: rootfsWithImage, shouldValidate: false},
+		"missing rootfs path":                 {inspector: missingRootfs, shouldValidate: false},
+		"rootfs path":                         {inspector: validRootfs, shouldValidate: true},
+		"output dir mode without owner":       {inspector: outputDirModeWithoutOwner, shouldValidate: false},
+		"output dir mode":                     {inspector: outputDirMode, shouldValidate: true},
+		"metadata only of a container":        {inspector: metadataOnlyContainer, shouldValidate: false},
+		"metadata only with scan type":        {inspector: metadataOnlyWithScan, shouldValidate: false},
+		"metadata only with layer cache":      {inspector: metadataOnlyWithLayerCache, shouldValidate: false},
+		"metadata only":                       {inspector: metadataOnly, shouldValidate: true},
+		"negative clam max file size":         {inspector: negativeClamMaxFileSize, shouldValidate: false},
+		"clam max file size with wrong scan":  {inspector: clamMaxFileSizeWrongScan, shouldValidate: false},
+		"clam max file size":                  {inspector: clamMaxFileSize, shouldValidate: true},
+		"exclude path with openscap":          {inspector: excludePathWithOpenSCAP, shouldValidate: false},
+		"bad exclude path":                    {inspector: badExcludePath, shouldValidate: false},
+		"exclude paths":                       {inspector: excludePaths, shouldValidate: true},
+		"kmod init path with hygiene":         {inspector: kmodInitPathWithHygiene, shouldValidate: false},
+		"kmod init paths":                     {inspector: kmodInitPaths, shouldValidate: true},
+		"registry mirror with scheme":         {inspector: registryMirrorWithScheme, shouldValidate: false},
+		"registry mirror of a container":      {inspector: registryMirrorOfContainer, shouldValidate: false},
+		"registry mirrors":                    {inspector: registryMirrors, shouldValidate: true},
+		"negative read timeout":               {inspector: negativeReadTimeout, shouldValidate: false},
+		"negative write timeout":              {inspector: negativeWriteTimeout, shouldValidate: false},
+		"negative idle timeout":               {inspector: negativeIdleTimeout, shouldValidate: false},
+		"no serve timeouts":                   {inspector: noServeTimeouts, shouldValidate: true},
+		"partial docker tls":                  {inspector: partialDockerTLS, shouldValidate: false},
+		"docker tls with unix socket":         {inspector: dockerTLSWithUnixSocket, shouldValidate: false},
+		"docker tls":                          {inspector: dockerTLS, shouldValidate: true},
+		"cleanup while serving":               {inspector: cleanupWhileServing, shouldValidate: false},
+		"cleanup of a container":              {inspector: cleanupOfContainer, shouldValidate: false},
+		"cleanup":                             {inspector: cleanup, shouldValidate: true},
+		"output gzip without output file":     {inspector: outputGzipWithoutFile, shouldValidate: false},
+		"output gzip":                         {inspector: outputGzip, shouldValidate: true},
+		"validate cve with wrong scan":        {inspector: validateCVEWrongScan, shouldValidate: false},
+		"validate cve":                        {inspector: validateCVE, shouldValidate: true},
+		"openscap profile with wrong scan":    {inspector: profileWrongScan, shouldValidate: false},
+		"openscap profile with profile arg":   {inspector: profileWithProfileArg, shouldValidate: false},
+		"openscap profile":                    {inspector: openscapProfile, shouldValidate: true},
+		"negative extract concurrency":        {inspector: negativeExtractConcurrency, shouldValidate: false},
+		"sequential extraction":               {inspector: sequentialExtraction, shouldValidate: true},
+		"tailoring file with wrong scan":      {inspector: tailoringWrongScan, shouldValidate: false},
+		"tailoring file with tailoring arg":   {inspector: tailoringWithTailoringArg, shouldValidate: false},
+		"no such tailoring file":              {inspector: noSuchTailoringFile, shouldValidate: false},
+		"tailoring file":                      {inspector: tailoringFile, shouldValidate: true},
+		"xccdf results with wrong scan":       {inspector: xccdfResultsWrongScan, shouldValidate: false},
+		"junit report with wrong scan":        {inspector: junitWrongScan, shouldValidate: false},
+		"openscap reports":                    {inspector: openscapReports, shouldValidate: true},
+		"image tar from stdin":                {inspector: imageTarStdin, shouldValidate: true},
+		"image tar with image":                {inspector: imageTarWithImage, shouldValidate: false},
+		"image tar with rootfs path":          {inspector: imageTarWithRootfs, shouldValidate: false},
+		"image tar with layer cache":          {inspector: imageTarWithLayerCache, shouldValidate: false},
+		"no such image tar":                   {inspector: noSuchImageTar, shouldValidate: false},
+		"negative max files":                  {inspector: negativeMaxFiles, shouldValidate: false},
+		"max files without scan type":         {inspector: maxFilesNoScan, shouldValidate: false},
+		"max files":                           {inspector: maxFiles, shouldValidate: true},
+		"split results with metadata only":    {inspector: splitResultsMetadataOnly, shouldValidate: false},
+		"split results to a file":             {inspector: splitResultsFile, shouldValidate: false},
+		"split results":                       {inspector: splitResults, shouldValidate: true},
+		"allow write without serve":           {inspector: allowWriteWithoutServe, shouldValidate: false},
+		"allow write with allowed methods":    {inspector: allowWriteWithMethods, shouldValidate: false},
+		"allow write":                         {inspector: allowWrite, shouldValidate: true},
+		"disable compression without serve":   {inspector: disableCompressionWithoutServe, shouldValidate: false},
+		"json log format":                     {inspector: jsonLogFormat, shouldValidate: true},
+		"unknown log level":                   {inspector: unknownLogLevel, shouldValidate: false},
+		"unknown log format":                  {inspector: unknownLogFormat, shouldValidate: false},
+		"quiet and verbose":                   {inspector: quietAndVerbose, shouldValidate: false},
 	}
 
+	defer os.Setenv(RegistryPasswordEnv, os.Getenv(RegistryPasswordEnv))
+	os.Unsetenv(RegistryPasswordEnv)
 	for k, v := range tests {
 		err := v.inspector.Validate()
 
@@ -118,3 +769,177 @@ func TestValidate(t *testing.T) {
 		t.Errorf("MultiStringVar Set didn't add to the right values or Strings didn't return them")
 	}
 }
+
+func TestFileModeVar(t *testing.T) {
+	for value, expected := range map[string]string{
+		"0750": "0750",
+		"700":  "0700",
+		"0":    "",
+		"1777": "error",
+		"rwx":  "error",
+		"-1":   "error",
+	} {
+		mode := FileModeVar{}
+		err := mode.Set(value)
+		if expected == "error" {
+			if err == nil {
+				t.Errorf("%q: expected to be invalid but received no error", value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected to be valid but received %v", value, err)
+			continue
+		}
+		if mode.String() != expected {
+			t.Errorf("%q: expected the mode %q, got %q", value, expected, mode.String())
+		}
+	}
+}
+
+func TestValidateRegistryPassword(t *testing.T) {
+	defer os.Setenv(RegistryPasswordEnv, os.Getenv(RegistryPasswordEnv))
+	for k, v := range map[string]struct {
+		passwordFile   string
+		passwordEnv    string
+		shouldValidate bool
+	}{
+		"no password":           {shouldValidate: false},
+		"password file":         {passwordFile: "types.go", shouldValidate: true},
+		"password environment":  {passwordEnv: "password", shouldValidate: true},
+		"password file and env": {passwordFile: "types.go", passwordEnv: "password", shouldValidate: true},
+		"missing file and env":  {passwordFile: "nosuchfile", passwordEnv: "password", shouldValidate: false},
+	} {
+		os.Setenv(RegistryPasswordEnv, v.passwordEnv)
+		opts := NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.ScanType = "unowned"
+		opts.Username = "username"
+		opts.PasswordFile = v.passwordFile
+
+		err := opts.Validate()
+		if v.shouldValidate && err != nil {
+			t.Errorf("%s expected to validate but received %v", k, err)
+		}
+		if !v.shouldValidate && err == nil {
+			t.Errorf("%s expected to be invalid but received no error", k)
+		}
+	}
+}
+
+func TestValidateSelfTest(t *testing.T) {
+	for k, v := range map[string]struct {
+		configure      func(*ImageInspectorOptions)
+		shouldValidate bool
+	}{
+		"scan type":             {configure: func(o *ImageInspectorOptions) { o.ScanType = "unowned" }, shouldValidate: true},
+		"no scan type":          {configure: func(o *ImageInspectorOptions) {}, shouldValidate: false},
+		"unknown scan type":     {configure: func(o *ImageInspectorOptions) { o.ScanType = "nosuchscan" }, shouldValidate: false},
+		"clamav without socket": {configure: func(o *ImageInspectorOptions) { o.ScanType = "clamav" }, shouldValidate: false},
+		"option of another scanner": {configure: func(o *ImageInspectorOptions) {
+			o.ScanType = "unowned"
+			o.ClamDebug = true
+		}, shouldValidate: false},
+		"image": {configure: func(o *ImageInspectorOptions) {
+			o.ScanType = "unowned"
+			o.Image = "image"
+		}, shouldValidate: false},
+		"unknown log level": {configure: func(o *ImageInspectorOptions) {
+			o.ScanType = "unowned"
+			o.LogLevel = "nosuchlevel"
+		}, shouldValidate: false},
+	} {
+		opts := NewDefaultImageInspectorOptions()
+		v.configure(opts)
+
+		err := opts.ValidateSelfTest()
+		if v.shouldValidate && err != nil {
+			t.Errorf("%s expected to validate but received %v", k, err)
+		}
+		if !v.shouldValidate && err == nil {
+			t.Errorf("%s expected to be invalid but received no error", k)
+		}
+	}
+}
+
+func TestLoadAuthToken(t *testing.T) {
+	defer os.Setenv(AuthTokenEnv, os.Getenv(AuthTokenEnv))
+	tokenFile, err := ioutil.TempFile("", "webdav-token-")
+	if err != nil {
+		t.Fatalf("unable to create the token file: %v", err)
+	}
+	defer os.Remove(tokenFile.Name())
+	if _, err := tokenFile.WriteString("file-token\n"); err != nil {
+		t.Fatalf("unable to write the token file: %v", err)
+	}
+	tokenFile.Close()
+
+	for k, v := range map[string]struct {
+		tokenFile  string
+		tokenEnv   string
+		expected   string
+		shouldLoad bool
+	}{
+		"no token":           {shouldLoad: true},
+		"token environment":  {tokenEnv: "env-token", expected: "env-token", shouldLoad: true},
+		"token file":         {tokenFile: tokenFile.Name(), expected: "file-token", shouldLoad: true},
+		"token file and env": {tokenFile: tokenFile.Name(), tokenEnv: "env-token", expected: "file-token", shouldLoad: true},
+		"missing token file": {tokenFile: "nosuchfile", tokenEnv: "env-token", shouldLoad: false},
+	} {
+		os.Setenv(AuthTokenEnv, v.tokenEnv)
+		opts := NewDefaultImageInspectorOptions()
+		opts.AuthTokenFile = v.tokenFile
+
+		err := opts.LoadAuthToken()
+		if v.shouldLoad && err != nil {
+			t.Errorf("%s expected to load but received %v", k, err)
+		}
+		if !v.shouldLoad && err == nil {
+			t.Errorf("%s expected to fail but received no error", k)
+		}
+		if opts.AuthToken != v.expected {
+			t.Errorf("%s expected the token %q, got %q", k, v.expected, opts.AuthToken)
+		}
+	}
+}
+
+func TestQuietSuppressesInfo(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	opts := NewDefaultImageInspectorOptions()
+	opts.Quiet = true
+	out := &bytes.Buffer{}
+	if err := logging.Configure(out, opts.EffectiveLogLevel(), logging.TextFormat); err != nil {
+		t.Fatalf("unable to configure the logger: %v", err)
+	}
+	defer logging.Configure(os.Stderr, "debug", logging.TextFormat)
+
+	logging.Info("Downloading Image (1024Kb downloaded)", logging.Fields{"phase": "pull"})
+	log.Printf("Extracting image fedora:26 to /tmp/image")
+	logging.Warning("It is insecure to serve the image content without setting an auth token.", nil)
+	log.Printf("WARNING: Unable to remove /tmp/image")
+
+	logged := out.String()
+	if strings.Contains(logged, "Downloading") || strings.Contains(logged, "Extracting") {
+		t.Errorf("expected the info messages to be suppressed, got %q", logged)
+	}
+	if !strings.Contains(logged, "without setting an auth token") || !strings.Contains(logged, "Unable to remove") {
+		t.Errorf("expected the warnings to be logged, got %q", logged)
+	}
+
+	for k, v := range map[string]struct {
+		quiet, verbose bool
+		expected       string
+	}{
+		"default": {expected: "info"},
+		"quiet":   {quiet: true, expected: "warning"},
+		"verbose": {verbose: true, expected: "debug"},
+	} {
+		opts := NewDefaultImageInspectorOptions()
+		opts.Quiet, opts.Verbose = v.quiet, v.verbose
+		if level := opts.EffectiveLogLevel(); level != v.expected {
+			t.Errorf("%s expected the log level %s, got %s", k, v.expected, level)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package hygiene
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "hygiene"
+	ScannerVersion = "0.1"
+)
+
+// Pattern describes the paths of a kind of file that shouldn't be left in an image.
+type Pattern struct {
+	// Glob is matched against the absolute paths in the image, or against the file names
+	// anywhere in the image when it has no slash. A matching directory is reported once,
+	// when it holds non-empty files.
+	Glob string
+	// Description is what the matching files are
+	Description string
+	// Remediation is how to keep the matching files out of the image
+	Remediation string
+	// Severity is the severity of the matching files
+	Severity iiapi.Severity
+}
+
+const (
+	removeInLayer = "remove it in the layer that creates it"
+	buildSecret   = "pass it to the build as a secret instead of copying it into the image"
+)
+
+// DefaultPatterns are the patterns reported when no pattern is given.
+var DefaultPatterns = []Pattern{
+	{Glob: ".bash_history", Description: "shell history", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: ".zsh_history", Description: "shell history", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: ".ash_history", Description: "shell history", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: ".sh_history", Description: "shell history", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: ".python_history", Description: "python history", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: "id_rsa", Description: "SSH private key", Remediation: buildSecret, Severity: iiapi.SeverityImportant},
+	{Glob: "id_dsa", Description: "SSH private key", Remediation: buildSecret, Severity: iiapi.SeverityImportant},
+	{Glob: "id_ecdsa", Description: "SSH private key", Remediation: buildSecret, Severity: iiapi.SeverityImportant},
+	{Glob: "id_ed25519", Description: "SSH private key", Remediation: buildSecret, Severity: iiapi.SeverityImportant},
+	{Glob: "/etc/ssh/ssh_host_*_key", Description: "SSH host private key", Remediation: "generate it when the container starts so that the containers don't share it", Severity: iiapi.SeverityImportant},
+	{Glob: "/tmp/*", Description: "temporary build file", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: "/var/tmp/*", Description: "temporary build file", Remediation: removeInLayer, Severity: iiapi.SeverityLow},
+	{Glob: "/var/cache/apt/archives", Description: "apt package cache", Remediation: "run apt-get clean in the layer that installs the packages", Severity: iiapi.SeverityLow},
+	{Glob: "/var/lib/apt/lists", Description: "apt package lists", Remediation: "remove them in the layer that installs the packages", Severity: iiapi.SeverityLow},
+	{Glob: "/var/cache/yum", Description: "yum package cache", Remediation: "run yum clean all in the layer that installs the packages", Severity: iiapi.SeverityLow},
+	{Glob: "/var/cache/dnf", Description: "dnf package cache", Remediation: "run dnf clean all in the layer that installs the packages", Severity: iiapi.SeverityLow},
+	{Glob: "/var/cache/apk", Description: "apk package cache", Remediation: "install the packages with apk add --no-cache", Severity: iiapi.SeverityLow},
+}
+
+// ValidateGlob returns an error when glob is not a valid pattern.
+func ValidateGlob(glob string) error {
+	if len(glob) == 0 {
+		return fmt.Errorf("the pattern is empty")
+	}
+	_, err := path.Match(glob, "")
+	return err
+}
+
+type hygieneScanner struct {
+	walker.Sink
+	patterns []Pattern
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &hygieneScanner{}
+
+// NewScanner returns a new scanner reporting the files matching the globs, or matching the
+// DefaultPatterns when no glob is given.
+func NewScanner(globs []string) iiapi.Scanner {
+	if len(globs) == 0 {
+		return &hygieneScanner{patterns: DefaultPatterns}
+	}
+	patterns := []Pattern{}
+	for _, glob := range globs {
+		patterns = append(patterns, Pattern{
+			Glob:        glob,
+			Description: fmt.Sprintf("file matching %s", glob),
+			Remediation: removeInLayer,
+			Severity:    iiapi.SeverityLow,
+		})
+	}
+	return &hygieneScanner{patterns: patterns}
+}
+
+// match returns the pattern matching the image path name.
+func (s *hygieneScanner) match(name string) *Pattern {
+	for n, p := range s.patterns {
+		target := name
+		if !strings.Contains(p.Glob, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(p.Glob, target); ok {
+			return &s.patterns[n]
+		}
+	}
+	return nil
+}
+
+// Scan reports the files and directories under path matching the patterns.
+func (s *hygieneScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("hygiene")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = walker.Walk(ctx, root, filter, func(p string, info os.FileInfo) error {
+		if p == root {
+			return nil
+		}
+		name := path.Join("/", strings.TrimPrefix(p, root))
+		pattern := s.match(name)
+		if pattern == nil {
+			return nil
+		}
+		description := fmt.Sprintf("%s left in the image, %s", pattern.Description, pattern.Remediation)
+		if info.IsDir() {
+			count := walker.NonEmptyFiles(p)
+			if count == 0 {
+				return filepath.SkipDir
+			}
+			description = fmt.Sprintf("%s left in the image (%d files), %s", pattern.Description, count, pattern.Remediation)
+		}
+		scanResults.Add(iiapi.Result{
+			Name:           ScannerName,
+			ScannerVersion: ScannerVersion,
+			Timestamp:      scanResults.Started(),
+			Reference:      fmt.Sprintf("file://%s", name),
+			Description:    description,
+			Summary:        []iiapi.Summary{{Label: pattern.Severity}},
+		})
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to scan %s: %v\n", mountPath, err)
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *hygieneScanner) Name() string {
+	return ScannerName
+}
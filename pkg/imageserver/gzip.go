@@ -0,0 +1,84 @@
+package imageserver
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// acceptsGzip returns whether the client of req accepts gzip-compressed responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// gzip;q=0 refuses the encoding
+		return len(parts) == 1 || strings.TrimSpace(parts[1]) != "q=0"
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body of the response once the headers are written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.started {
+		return
+	}
+	w.started = true
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	// the responses without a body and the ones already encoded are left alone
+	if status != http.StatusNoContent && status != http.StatusNotModified && len(h.Get("Content-Encoding")) == 0 {
+		h.Set("Content-Encoding", "gzip")
+		// the length is the one of the uncompressed body
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		if len(w.Header().Get("Content-Type")) == 0 {
+			// the type is detected from the uncompressed body
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// close terminates the compressed body.
+func (w *gzipResponseWriter) close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// gzipHandler compresses the responses of next for the clients accepting gzip. The range
+// requests are not compressed, the ranges are of the uncompressed content.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !acceptsGzip(req) || len(req.Header.Get("Range")) > 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, req)
+		if !gw.started {
+			// the empty responses are compressed too, as announced by the headers
+			gw.WriteHeader(http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package imageserver
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// indexTemplate is the HTML page listing the entries of a served directory.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- if ne .Path "/"}}
+<li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// indexEntry is an entry of a directory index.
+type indexEntry struct {
+	Name string
+	Href string
+}
+
+// directoryIndex serves an HTML listing of the entries of the directories of fs
+// requested with GET or HEAD under prefix, the other requests are passed to next.
+func directoryIndex(prefix string, fs webdav.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+		name := strings.TrimPrefix(req.URL.Path, prefix)
+		if len(name) == len(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		f, err := fs.OpenFile(req.Context(), name, os.O_RDONLY, 0)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		defer f.Close()
+		if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// the entries links are relative to the directory
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			http.Redirect(w, req, req.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			http.Error(w, "Unable to read the directory", http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+		entries := make([]indexEntry, 0, len(infos))
+		for _, fi := range infos {
+			entryName := fi.Name()
+			if fi.IsDir() {
+				entryName += "/"
+			}
+			// a leading ./ keeps names containing a colon from being read as a scheme
+			href := url.URL{Path: "./" + entryName}
+			entries = append(entries, indexEntry{Name: entryName, Href: href.String()})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		indexTemplate.Execute(w, struct {
+			Path    string
+			Entries []indexEntry
+		}{
+			Path:    "/" + strings.TrimPrefix(name, "/"),
+			Entries: entries,
+		})
+	})
+}
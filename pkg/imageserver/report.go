@@ -0,0 +1,46 @@
+package imageserver
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// reportTemplate is the HTML page listing the results of a scan.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"severities": severities,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Scan report of {{.ImageID}}</title></head>
+<body>
+<h1>Scan report of {{.ImageID}}</h1>
+{{- if not .Complete}}
+<p>The scan is still running, the results found so far are listed.</p>
+{{- end}}
+<p>{{len .Results}} problems found.</p>
+<table>
+<tr><th>Scanner</th><th>Severity</th><th>Reference</th><th>Description</th></tr>
+{{- range .Results}}
+<tr><td>{{.Name}}</td><td>{{severities .Summary}}</td><td>{{.Reference}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// severities returns the labels of summary separated by commas.
+func severities(summary []iiapi.Summary) string {
+	labels := []string{}
+	for _, s := range summary {
+		labels = append(labels, string(s.Label))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// serveResultsReport serves the results, of any scanner, as an HTML table.
+func serveResultsReport(w http.ResponseWriter, results iiapi.ScanResult) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	reportTemplate.Execute(w, results)
+}
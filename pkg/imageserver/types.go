@@ -1,6 +1,8 @@
 package imageserver
 
 import (
+	"time"
+
 	iiapi "github.com/openshift/image-inspector/pkg/api"
 )
 
@@ -8,12 +10,19 @@ import (
 type ImageServer interface {
 	// ServeImage Serves the image
 	// ImageServeURL is the location that the image is being served from.
+	// scanReport, htmlScanReport, xccdfResults and junitReport are the paths of the report
+	// files, streamed from disk when requested, empty when not available.
 	// TODO: Move the scanReport and htmlScanReport into OpenSCAP results?
 	ServeImage(meta *iiapi.InspectorMetadata,
 		ImageServeURL string,
 		results iiapi.ScanResult,
-		scanReport []byte,
-		htmlScanReport []byte) error
+		scanReport string,
+		htmlScanReport string,
+		xccdfResults string,
+		junitReport string) error
+	// Addr returns the address the image is served on, with the port picked by the system
+	// when the port of ServePath is 0. It's empty until the image is served.
+	Addr() string
 }
 
 // ImageServerOptions is used to configure an image server.
@@ -21,15 +30,28 @@ type ImageServerOptions struct {
 	// ServePath is the root path/port of serving. ex 0.0.0.0:8080
 	ServePath string
 	// HealthzURL is the relative url of the health check. ex /healthz
+	// It fails until the scan is done when PartialResults are served.
 	HealthzURL string
+	// ReadyzURL is the relative url of the readiness check, it succeeds as soon as the image
+	// is served. ex /readyz
+	ReadyzURL string
 	// APIURL is the relative url where the api will be served.  ex /api
 	APIURL string
-	// ResultAPIUrlPath is the relative url where the results JSON will be served. ex. /results
+	// ResultAPIUrlPath is the relative url where the results JSON will be served. ex. /api/v1/results
 	ResultAPIUrlPath string
+	// PartialResults, when set, are served on ResultAPIUrlPath instead of the results given
+	// to ServeImage, so that the image can be served while it's still being scanned.
+	PartialResults *iiapi.PartialResults
+	// PartialMetadata, when set, are served on MetadataURL and VersionedMetaURL instead of
+	// the metadata given to ServeImage, which are still updated while the image is scanned.
+	PartialMetadata *iiapi.PartialMetadata
 	// APIVersions are the supported API versions.
 	APIVersions iiapi.APIVersions
 	// MetadataURL is the relative url of the metadata content.  ex /api/v1/metadata
 	MetadataURL string
+	// VersionedMetaURL is the relative url of the metadata in a versioned envelope, not
+	// served when empty. ex /api/v1/metadata/versioned
+	VersionedMetaURL string
 	// ContentURL is the relative url of the content.  ex /api/v1/content/
 	ContentURL string
 	// ScanType is the type of the scan that was done on the inspected image
@@ -40,10 +62,35 @@ type ImageServerOptions struct {
 	HTMLScanReport bool
 	// HTMLScanReportURL url for the scan html report
 	HTMLScanReportURL string
-	// AuthToken is a Shared Secret used to validate HTTP Requests.
-	// AuthToken is set through ENV rather than passed as a parameter
-	AuthToken string
+	// ResultsHTMLURL is the url of the HTML report of the results of any scanner, the HTML
+	// report of the scanner is served instead when it wrote one. Not served when empty.
+	ResultsHTMLURL string
+	// XCCDFResultsURL is the url of the XCCDF results document, not served when empty
+	XCCDFResultsURL string
+	// JUnitReportURL is the url of the JUnit report, not served when empty
+	JUnitReportURL string
+	// AuthTokens are the Shared Secrets used to validate HTTP Requests, a request is accepted
+	// with any of them so that a new token can be added before the old one is retired.
+	// AuthTokens are read from a file or the ENV rather than passed as a parameter
+	AuthTokens []string
 	// Chroot indicates whether image-inspector will execute a chroot
 	// to the root directory of the image before serving its contents
 	Chroot bool
+	// AllowedMethods are the HTTP methods accepted by the content handler. When empty the
+	// ReadOnlyWebdavMethods are accepted, or all the methods with AllowWrite.
+	AllowedMethods []string
+	// AllowWrite makes the content handler accept all the methods when AllowedMethods is
+	// empty, letting the clients modify the served image.
+	AllowWrite bool
+	// DirectoryIndex enables an HTML listing of the directories requested with GET
+	// from the content url.
+	DirectoryIndex bool
+	// DisableGzip disables the gzip compression of the responses to the clients accepting it
+	DisableGzip bool
+	// ReadTimeout is the time limit of reading each request, no limit when 0
+	ReadTimeout time.Duration
+	// WriteTimeout is the time limit of writing each response, no limit when 0
+	WriteTimeout time.Duration
+	// IdleTimeout is the time an idle connection is kept open, ReadTimeout is used when 0
+	IdleTimeout time.Duration
 }
@@ -3,13 +3,19 @@ package imageserver
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"syscall"
 
 	"golang.org/x/net/webdav"
 
 	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/logging"
+	"github.com/openshift/image-inspector/pkg/util"
 )
 
 const (
@@ -28,6 +34,9 @@ const (
 // webdavImageServer implements ImageServer.
 type webdavImageServer struct {
 	opts ImageServerOptions
+	// addr is the address of the listener, once serving
+	addr     string
+	addrLock sync.Mutex
 }
 
 // ensures this always implements the interface or fail compilation.
@@ -44,15 +53,56 @@ func NewWebdavImageServer(opts ImageServerOptions) ImageServer {
 func (s *webdavImageServer) ServeImage(meta *iiapi.InspectorMetadata,
 	ImageServeURL string,
 	results iiapi.ScanResult,
-	scanReport []byte,
-	htmlScanReport []byte,
+	scanReport string,
+	htmlScanReport string,
+	xccdfResults string,
+	junitReport string,
 ) error {
-	handler, err := s.GetHandler(meta, ImageServeURL, results, scanReport, htmlScanReport)
+	handler, err := s.GetHandler(meta, ImageServeURL, results, scanReport, htmlScanReport, xccdfResults, junitReport)
 	if err != nil {
 		return fmt.Errorf("failed to initialize imageserver: %v", err)
 	}
-	log.Printf("Serving image content on webdav://%s%s", s.opts.ServePath, s.opts.ContentURL)
-	return http.ListenAndServe(s.opts.ServePath, handler)
+	listener, err := net.Listen("tcp", s.opts.ServePath)
+	if err != nil {
+		return listenError(s.opts.ServePath, err)
+	}
+	// the port is picked by the system when the port of ServePath is 0
+	s.addrLock.Lock()
+	s.addr = listener.Addr().String()
+	s.addrLock.Unlock()
+	logging.Info(fmt.Sprintf("Serving image content on webdav://%s%s", listener.Addr(), s.opts.ContentURL),
+		logging.Fields{"phase": "serve", "addr": listener.Addr().String(), "path": ImageServeURL})
+	return s.newServer(handler).Serve(listener)
+}
+
+// Addr returns the address the image is served on, empty until the image is served.
+func (s *webdavImageServer) Addr() string {
+	s.addrLock.Lock()
+	defer s.addrLock.Unlock()
+	return s.addr
+}
+
+// newServer returns the server of handler, with the timeouts of the options.
+func (s *webdavImageServer) newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ReadTimeout:  s.opts.ReadTimeout,
+		WriteTimeout: s.opts.WriteTimeout,
+		IdleTimeout:  s.opts.IdleTimeout,
+	}
+}
+
+// listenError returns the error of listening on servePath, telling how to solve it when
+// the address is already in use.
+func listenError(servePath string, err error) error {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok && sysErr.Err == syscall.EADDRINUSE {
+			host, _, _ := net.SplitHostPort(servePath)
+			return fmt.Errorf("Unable to serve the image on %s, the address is already in use: stop the process listening on it, "+
+				"or pass another port to serve, e.g. %s to let the system pick a free one\n", servePath, net.JoinHostPort(host, "0"))
+		}
+	}
+	return fmt.Errorf("Unable to serve the image on %s: %v\n", servePath, err)
 }
 
 // GetHandler Returns an http.Handler that serves the scan results
@@ -60,11 +110,30 @@ func (s *webdavImageServer) ServeImage(meta *iiapi.InspectorMetadata,
 func (s *webdavImageServer) GetHandler(meta *iiapi.InspectorMetadata,
 	ImageServeURL string,
 	results iiapi.ScanResult,
-	scanReport []byte,
-	htmlScanReport []byte,
+	scanReport string,
+	htmlScanReport string,
+	xccdfResults string,
+	junitReport string,
 ) (http.Handler, error) {
 	mux := http.NewServeMux()
 	servePath := ImageServeURL
+	// the reports are opened before changing root, they are outside of the image
+	scanReportFile, err := openReport(scanReport)
+	if err != nil {
+		return nil, err
+	}
+	htmlScanReportFile, err := openReport(htmlScanReport)
+	if err != nil {
+		return nil, err
+	}
+	xccdfResultsFile, err := openReport(xccdfResults)
+	if err != nil {
+		return nil, err
+	}
+	junitReportFile, err := openReport(junitReport)
+	if err != nil {
+		return nil, err
+	}
 	if s.opts.Chroot {
 		if err := syscall.Chroot(ImageServeURL); err != nil {
 			return nil, fmt.Errorf("Unable to chroot into %s: %v\n", ImageServeURL, err)
@@ -74,15 +143,26 @@ func (s *webdavImageServer) GetHandler(meta *iiapi.InspectorMetadata,
 		}
 		servePath = chrootServePath
 	} else {
-		log.Printf("!!!WARNING!!! It is insecure to serve the image content without changing")
-		log.Printf("root (--chroot). Absolute-path symlinks in the image can lead to disclose")
-		log.Printf("information of the hosting system.")
+		logging.Warning("It is insecure to serve the image content without changing root (--chroot). "+
+			"Absolute-path symlinks in the image can lead to disclose information of the hosting system.",
+			logging.Fields{"phase": "serve"})
 	}
 
 	mux.HandleFunc(s.opts.HealthzURL, func(w http.ResponseWriter, r *http.Request) {
+		// the image is served while it's still being scanned with partial results
+		if s.opts.PartialResults != nil && !s.opts.PartialResults.Complete() {
+			http.Error(w, "scanning", http.StatusServiceUnavailable)
+			return
+		}
 		w.Write([]byte("ok\n"))
 	})
 
+	if len(s.opts.ReadyzURL) > 0 {
+		mux.HandleFunc(s.opts.ReadyzURL, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok\n"))
+		})
+	}
+
 	mux.HandleFunc(s.opts.APIURL, func(w http.ResponseWriter, r *http.Request) {
 		body, err := json.MarshalIndent(s.opts.APIVersions, "", "  ")
 		if err != nil {
@@ -93,7 +173,34 @@ func (s *webdavImageServer) GetHandler(meta *iiapi.InspectorMetadata,
 	})
 
 	mux.HandleFunc(s.opts.MetadataURL, func(w http.ResponseWriter, r *http.Request) {
-		body, err := json.MarshalIndent(meta, "", "  ")
+		body, err := json.MarshalIndent(s.servedMeta(meta), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	})
+
+	if len(s.opts.VersionedMetaURL) > 0 {
+		mux.HandleFunc(s.opts.VersionedMetaURL, func(w http.ResponseWriter, r *http.Request) {
+			body, err := json.MarshalIndent(iiapi.NewVersionedInspectorMetadata(s.servedMeta(meta)), "", "  ")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(body)
+		})
+	}
+
+	servedResults := func() iiapi.ScanResult {
+		if s.opts.PartialResults != nil {
+			return s.opts.PartialResults.Get()
+		}
+		return results
+	}
+
+	mux.HandleFunc(s.opts.ResultAPIUrlPath, func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.MarshalIndent(servedResults(), "", "  ")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -102,8 +209,9 @@ func (s *webdavImageServer) GetHandler(meta *iiapi.InspectorMetadata,
 	})
 
 	mux.HandleFunc(s.opts.ScanReportURL, func(w http.ResponseWriter, r *http.Request) {
+		meta := s.servedMeta(meta)
 		if s.opts.ScanType != "" && meta.OpenSCAP.Status == iiapi.StatusSuccess {
-			w.Write(scanReport)
+			serveReport(w, r, scanReportFile)
 		} else {
 			if meta.OpenSCAP.Status == iiapi.StatusError {
 				http.Error(w, fmt.Sprintf("OpenSCAP Error: %s", meta.OpenSCAP.ErrorMessage),
@@ -114,35 +222,139 @@ func (s *webdavImageServer) GetHandler(meta *iiapi.InspectorMetadata,
 		}
 	})
 
+	// the OpenSCAP HTML report is the special case of the HTML report written by the scanner
 	mux.HandleFunc(s.opts.HTMLScanReportURL, func(w http.ResponseWriter, r *http.Request) {
-		if s.opts.ScanType != "" && meta.OpenSCAP.Status == iiapi.StatusSuccess && s.opts.HTMLScanReport {
-			w.Write(htmlScanReport)
-		} else {
-			if meta.OpenSCAP.Status == iiapi.StatusError {
-				http.Error(w, fmt.Sprintf("OpenSCAP Error: %s", meta.OpenSCAP.ErrorMessage),
-					http.StatusInternalServerError)
-			} else {
-				http.Error(w, "OpenSCAP option was not chosen", http.StatusNotFound)
-			}
-		}
+		s.serveHTMLReport(w, r, s.servedMeta(meta), nil, htmlScanReportFile, s.opts.HTMLScanReport)
 	})
 
-	mux.Handle(s.opts.ContentURL, &webdav.Handler{
+	if len(s.opts.ResultsHTMLURL) > 0 {
+		mux.HandleFunc(s.opts.ResultsHTMLURL, func(w http.ResponseWriter, r *http.Request) {
+			results := servedResults()
+			s.serveHTMLReport(w, r, s.servedMeta(meta), &results, htmlScanReportFile, s.opts.HTMLScanReport && htmlScanReportFile != nil)
+		})
+	}
+
+	s.handleOpenSCAPReport(mux, s.opts.XCCDFResultsURL, meta, xccdfResultsFile)
+	s.handleOpenSCAPReport(mux, s.opts.JUnitReportURL, meta, junitReportFile)
+
+	var content http.Handler = &webdav.Handler{
 		Prefix:     s.opts.ContentURL,
 		FileSystem: webdav.Dir(servePath),
 		LockSystem: webdav.NewMemLS(),
+	}
+	if s.opts.DirectoryIndex {
+		content = directoryIndex(s.opts.ContentURL, webdav.Dir(servePath), content)
+	}
+	methods := s.opts.AllowedMethods
+	if len(methods) == 0 && !s.opts.AllowWrite {
+		methods = iiapi.ReadOnlyWebdavMethods
+	}
+	mux.Handle(s.opts.ContentURL, allowMethods(methods, content))
+
+	if s.opts.DisableGzip {
+		return s.checkAuth(mux), nil
+	}
+	return s.checkAuth(gzipHandler(mux)), nil
+}
+
+// openReport opens the report file name, nil is returned when name is empty.
+func openReport(name string) (*os.File, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the scan report: %v\n", err)
+	}
+	return f, nil
+}
+
+// servedMeta returns the metadata to serve, the PartialMetadata instead of meta when set.
+func (s *webdavImageServer) servedMeta(meta *iiapi.InspectorMetadata) *iiapi.InspectorMetadata {
+	if s.opts.PartialMetadata != nil {
+		partial := s.opts.PartialMetadata.Get()
+		return &partial
+	}
+	return meta
+}
+
+// serveHTMLReport serves the HTML report scannerReport written by the scanner when
+// hasScannerReport is set and the scan succeeded, otherwise the results rendered as an HTML
+// table. Without results it's not found.
+func (s *webdavImageServer) serveHTMLReport(w http.ResponseWriter, r *http.Request, meta *iiapi.InspectorMetadata,
+	results *iiapi.ScanResult, scannerReport *os.File, hasScannerReport bool) {
+	switch {
+	case s.opts.ScanType != "" && meta.OpenSCAP.Status == iiapi.StatusSuccess && hasScannerReport:
+		serveReport(w, r, scannerReport)
+	case meta.OpenSCAP.Status == iiapi.StatusError:
+		http.Error(w, fmt.Sprintf("OpenSCAP Error: %s", meta.OpenSCAP.ErrorMessage),
+			http.StatusInternalServerError)
+	case results == nil:
+		http.Error(w, "OpenSCAP option was not chosen", http.StatusNotFound)
+	default:
+		serveResultsReport(w, *results)
+	}
+}
+
+// handleOpenSCAPReport serves the optional OpenSCAP report f on url, when url is set. The
+// report is not found when it wasn't requested.
+func (s *webdavImageServer) handleOpenSCAPReport(mux *http.ServeMux, url string, meta *iiapi.InspectorMetadata, f *os.File) {
+	if len(url) == 0 {
+		return
+	}
+	mux.HandleFunc(url, func(w http.ResponseWriter, r *http.Request) {
+		meta := s.servedMeta(meta)
+		switch {
+		case meta.OpenSCAP.Status == iiapi.StatusError:
+			http.Error(w, fmt.Sprintf("OpenSCAP Error: %s", meta.OpenSCAP.ErrorMessage),
+				http.StatusInternalServerError)
+		case s.opts.ScanType != "" && meta.OpenSCAP.Status == iiapi.StatusSuccess && f != nil:
+			serveReport(w, r, f)
+		default:
+			http.Error(w, "OpenSCAP report was not requested", http.StatusNotFound)
+		}
 	})
+}
 
-	return s.checkAuth(mux), nil
+// serveReport streams the report f from disk, the response is empty when f is nil.
+func serveReport(w http.ResponseWriter, r *http.Request, f *os.File) {
+	if f == nil {
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the scan report: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// a section reader is used since the file is shared by the concurrent requests
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), io.NewSectionReader(f, 0, fi.Size()))
+}
+
+// allowMethods rejects the requests whose method is not one of methods.
+// All the methods are allowed when methods is empty.
+func allowMethods(methods []string, next http.Handler) http.Handler {
+	if len(methods) == 0 {
+		return next
+	}
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !util.StringInList(req.Method, methods) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, fmt.Sprintf("Method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
 }
 
 //middleware handler for checking auth
 func (s *webdavImageServer) checkAuth(next http.Handler) http.Handler {
-	authToken := s.opts.AuthToken
+	authTokens := s.opts.AuthTokens
 	// allow running without authorization
-	if len(authToken) == 0 {
-		log.Printf("!!!WARNING!!! It is insecure to serve the image content without setting")
-		log.Printf("an auth token. Please set INSPECTOR_AUTH_TOKEN in your environment.")
+	if len(authTokens) == 0 {
+		logging.Warning("It is insecure to serve the image content without setting an auth token. "+
+			"Please set INSPECTOR_AUTH_TOKEN in your environment or use webdav-token-file.",
+			logging.Fields{"phase": "serve"})
 		return next
 	}
 
@@ -152,7 +364,7 @@ func (s *webdavImageServer) checkAuth(next http.Handler) http.Handler {
 			if len(token) == 0 {
 				return fmt.Errorf("must provide %s header with this request", authTokenHeader)
 			}
-			if token != authToken {
+			if !util.StringInList(token, authTokens) {
 				return fmt.Errorf("invalid auth token provided")
 			}
 			return nil
@@ -1,13 +1,21 @@
 package imageserver
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	. "github.com/onsi/ginkgo"
@@ -18,13 +26,20 @@ import (
 const (
 	versionTag             = "v1"
 	healthzPath            = "/healthz"
+	readyzPath             = "/readyz"
 	apiPrefix              = "/api"
 	contentPath            = apiPrefix + "/" + versionTag + "/content/"
 	metadataPath           = apiPrefix + "/" + versionTag + "/metadata"
+	versionedMetadataPath  = metadataPath + "/versioned"
+	resultsPath            = apiPrefix + "/" + versionTag + "/results"
 	openscapReportPath     = apiPrefix + "/" + versionTag + "/openscap"
 	openScapHTMLReportPath = apiPrefix + "/" + versionTag + "/openscap-report"
+	openScapXCCDFPath      = apiPrefix + "/" + versionTag + "/openscap-xccdf-results"
+	openScapJUnitPath      = apiPrefix + "/" + versionTag + "/openscap-junit-report"
+	resultsHTMLPath        = apiPrefix + "/" + versionTag + "/report"
 	scanType               = "openscap"
 	authToken              = "12345"
+	rotatedAuthToken       = "67890"
 )
 
 var _ = Describe("Webdav", func() {
@@ -32,46 +47,88 @@ var _ = Describe("Webdav", func() {
 		server           *httptest.Server
 		options          ImageServerOptions
 		dstPath          string
+		reportsDir       string
+		allowedMethods   []string
+		directoryIndex   bool
+		allowWrite       bool
+		disableGzip      bool
+		partialResults   *api.PartialResults
+		partialMetadata  *api.PartialMetadata
 		dummyScanResults = api.ScanResult{
 			APIVersion: api.DefaultResultsAPIVersion,
 			Results:    []api.Result{},
+			Complete:   true,
 		}
 		dummyMetadata = &api.InspectorMetadata{
 			Image: docker.Image{
 				ID: "dummy",
 			},
-			OpenSCAP: &api.OpenSCAPMetadata{
-				Status: api.StatusSuccess,
+			InspectionMetadata: api.InspectionMetadata{
+				OpenSCAP: &api.OpenSCAPMetadata{
+					Status: api.StatusSuccess,
+				},
+				Scanners: []api.ScannerMetadata{
+					{Name: "openscap", Status: api.StatusSuccess},
+					{Name: "clamav", Status: api.StatusError, ErrorMessage: "clamd is not running"},
+				},
 			},
 		}
 		dummyScanReport     = []byte("this is a dummy scan report")
 		dummyHTMLScanReport = []byte("this is a dummy HTML scan report")
+		dummyXCCDFResults   = []byte("this is a dummy XCCDF results document")
 		apiVersions         = api.APIVersions{Versions: []string{versionTag}}
 	)
 	JustBeforeEach(func() {
 		var err error
 		dstPath, err = ioutil.TempDir("", "")
 		Expect(err).NotTo(HaveOccurred())
+		reportsDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		scanReport := filepath.Join(reportsDir, "results-arf.xml")
+		Expect(ioutil.WriteFile(scanReport, dummyScanReport, 0644)).To(Succeed())
+		htmlScanReport := filepath.Join(reportsDir, "results.html")
+		Expect(ioutil.WriteFile(htmlScanReport, dummyHTMLScanReport, 0644)).To(Succeed())
+		xccdfResults := filepath.Join(reportsDir, "results-xccdf.xml")
+		Expect(ioutil.WriteFile(xccdfResults, dummyXCCDFResults, 0644)).To(Succeed())
 		options = ImageServerOptions{
 			HealthzURL:        healthzPath,
+			ReadyzURL:         readyzPath,
 			APIURL:            apiPrefix,
+			ResultAPIUrlPath:  resultsPath,
+			PartialResults:    partialResults,
+			PartialMetadata:   partialMetadata,
 			APIVersions:       apiVersions,
 			MetadataURL:       metadataPath,
+			VersionedMetaURL:  versionedMetadataPath,
 			ContentURL:        contentPath,
 			ScanType:          scanType,
 			ScanReportURL:     openscapReportPath,
 			HTMLScanReport:    true,
 			HTMLScanReportURL: openScapHTMLReportPath,
-			AuthToken:         authToken,
+			ResultsHTMLURL:    resultsHTMLPath,
+			XCCDFResultsURL:   openScapXCCDFPath,
+			JUnitReportURL:    openScapJUnitPath,
+			AuthTokens:        []string{authToken, rotatedAuthToken},
 			Chroot:            false,
+			AllowedMethods:    allowedMethods,
+			AllowWrite:        allowWrite,
+			DisableGzip:       disableGzip,
+			DirectoryIndex:    directoryIndex,
 		}
-		handler, err := NewWebdavImageServer(options).(*webdavImageServer).GetHandler(dummyMetadata, dstPath, dummyScanResults, dummyScanReport, dummyHTMLScanReport)
+		handler, err := NewWebdavImageServer(options).(*webdavImageServer).GetHandler(dummyMetadata, dstPath, dummyScanResults, scanReport, htmlScanReport, xccdfResults, "")
 		Expect(err).NotTo(HaveOccurred())
 		server = httptest.NewServer(handler)
 	})
 	AfterEach(func() {
 		server.Close()
 		os.RemoveAll(dstPath)
+		os.RemoveAll(reportsDir)
+		allowedMethods = nil
+		directoryIndex = false
+		allowWrite = false
+		disableGzip = false
+		partialResults = nil
+		partialMetadata = nil
 	})
 	Describe("Endpoints:", func() {
 		var u *url.URL
@@ -92,6 +149,14 @@ var _ = Describe("Webdav", func() {
 					Expect(body).To(Equal([]byte("ok\n")))
 				})
 			})
+			Context("another valid auth token", func() {
+				It("returns 200", func() {
+					status, body, err := getWithAuth(u, rotatedAuthToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal([]byte("ok\n")))
+				})
+			})
 			Context("invalid auth token", func() {
 				It("returns 401", func() {
 					status, _, err := getWithAuth(u, "asdf")
@@ -99,6 +164,39 @@ var _ = Describe("Webdav", func() {
 					Expect(status).To(Equal(http.StatusUnauthorized))
 				})
 			})
+			Context("with partial results", func() {
+				BeforeEach(func() {
+					partialResults = &api.PartialResults{}
+					partialResults.Set(api.ScanResult{APIVersion: api.DefaultResultsAPIVersion})
+				})
+				It("returns 503 until the scan is complete", func() {
+					status, _, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusServiceUnavailable))
+					partialResults.Set(api.ScanResult{APIVersion: api.DefaultResultsAPIVersion, Complete: true})
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal([]byte("ok\n")))
+				})
+			})
+		})
+		Describe("Readyz", func() {
+			JustBeforeEach(func() {
+				u.Path = readyzPath
+			})
+			Context("with partial results", func() {
+				BeforeEach(func() {
+					partialResults = &api.PartialResults{}
+					partialResults.Set(api.ScanResult{APIVersion: api.DefaultResultsAPIVersion})
+				})
+				It("returns 200 while the image is still being scanned", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal([]byte("ok\n")))
+				})
+			})
 		})
 		Describe(apiPrefix, func() {
 			JustBeforeEach(func() {
@@ -127,6 +225,96 @@ var _ = Describe("Webdav", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(metadata.ID).To(Equal(dummyMetadata.ID))
 				Expect(metadata.OpenSCAP.Status).To(Equal(dummyMetadata.OpenSCAP.Status))
+				Expect(metadata.Scanners).To(Equal(dummyMetadata.Scanners))
+			})
+			Context("with partial metadata", func() {
+				BeforeEach(func() {
+					partialMetadata = &api.PartialMetadata{}
+					partialMetadata.Set(api.InspectorMetadata{Image: docker.Image{ID: "partial"}})
+				})
+				It("returns the latest partial metadata", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					var metadata api.InspectorMetadata
+					Expect(json.Unmarshal(body, &metadata)).To(Succeed())
+					Expect(metadata.ID).To(Equal("partial"))
+					Expect(metadata.Scanners).To(BeEmpty())
+
+					scanned := api.InspectorMetadata{Image: docker.Image{ID: "partial"}}
+					scanned.Scanners = []api.ScannerMetadata{{Name: "unowned", Status: api.StatusSuccess}}
+					partialMetadata.Set(scanned)
+					// the metadata that were set aren't changed by the inspection
+					scanned.Scanners[0].Status = api.StatusError
+					status, body, err = getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(json.Unmarshal(body, &metadata)).To(Succeed())
+					Expect(metadata.Scanners).To(Equal([]api.ScannerMetadata{{Name: "unowned", Status: api.StatusSuccess}}))
+				})
+			})
+		})
+		Describe(versionedMetadataPath, func() {
+			JustBeforeEach(func() {
+				u.Path = versionedMetadataPath
+			})
+			It("returns the metadata in a versioned envelope", func() {
+				status, body, err := getWithAuth(u, authToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status).To(Equal(http.StatusOK))
+				var envelope map[string]json.RawMessage
+				Expect(json.Unmarshal(body, &envelope)).To(Succeed())
+				Expect(string(envelope["metadataVersion"])).To(Equal(`"` + api.MetadataVersion + `"`))
+				Expect(envelope).To(HaveKey("image"))
+				Expect(envelope).To(HaveKey("inspection"))
+				Expect(envelope).NotTo(HaveKey("Id"))
+				var metadata api.VersionedInspectorMetadata
+				Expect(json.Unmarshal(body, &metadata)).To(Succeed())
+				Expect(metadata.Image).NotTo(BeNil())
+				Expect(metadata.Image.ID).To(Equal(dummyMetadata.ID))
+				Expect(metadata.Inspection.OpenSCAP.Status).To(Equal(dummyMetadata.OpenSCAP.Status))
+				Expect(metadata.Inspection.Scanners).To(Equal(dummyMetadata.Scanners))
+			})
+		})
+
+		Describe(resultsPath, func() {
+			var results func() api.ScanResult
+			JustBeforeEach(func() {
+				u.Path = resultsPath
+				results = func() api.ScanResult {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					var scanResults api.ScanResult
+					Expect(json.Unmarshal(body, &scanResults)).To(Succeed())
+					return scanResults
+				}
+			})
+			It("returns the results the server was initialized with", func() {
+				scanResults := results()
+				Expect(scanResults.APIVersion).To(Equal(dummyScanResults.APIVersion))
+				Expect(scanResults.Complete).To(BeTrue())
+			})
+			Context("with partial results", func() {
+				finding := api.Result{Name: "unowned", Reference: "file:///usr/bin/unowned"}
+				BeforeEach(func() {
+					partialResults = &api.PartialResults{}
+					partialResults.Set(api.ScanResult{APIVersion: api.DefaultResultsAPIVersion})
+				})
+				It("returns the results found so far until the scan is complete", func() {
+					Expect(results().Complete).To(BeFalse())
+					Expect(results().Results).To(BeEmpty())
+					partialResults.Add(finding)
+					Expect(results().Complete).To(BeFalse())
+					Expect(results().Results).To(Equal([]api.Result{finding}))
+					partialResults.Set(api.ScanResult{
+						APIVersion: api.DefaultResultsAPIVersion,
+						Results:    []api.Result{finding},
+						Complete:   true,
+					})
+					Expect(results().Complete).To(BeTrue())
+					Expect(results().Results).To(Equal([]api.Result{finding}))
+				})
 			})
 		})
 
@@ -144,6 +332,28 @@ var _ = Describe("Webdav", func() {
 					Expect(status).To(Equal(http.StatusOK))
 					Expect(body).To(Equal(dummyScanReport))
 				})
+				It("should stream the scan report from disk", func() {
+					// the report is not held in memory, its changes are served
+					updated := []byte("this is an updated scan report")
+					Expect(ioutil.WriteFile(filepath.Join(reportsDir, "results-arf.xml"), updated, 0644)).To(Succeed())
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal(updated))
+				})
+				It("should serve ranges of the scan report", func() {
+					req, err := http.NewRequest("GET", u.String(), nil)
+					Expect(err).NotTo(HaveOccurred())
+					req.Header.Set(authTokenHeader, authToken)
+					req.Header.Set("Range", "bytes=10-14")
+					resp, err := http.DefaultClient.Do(req)
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+					Expect(body).To(Equal(dummyScanReport[10:15]))
+				})
 			})
 			Context("OpenSCAP scan errored", func() {
 				BeforeEach(func() {
@@ -187,6 +397,102 @@ var _ = Describe("Webdav", func() {
 				})
 			})
 		})
+		Describe(openScapXCCDFPath, func() {
+			JustBeforeEach(func() {
+				u.Path = openScapXCCDFPath
+			})
+			Context("OpenSCAP scan succeeded", func() {
+				BeforeEach(func() {
+					dummyMetadata.OpenSCAP.Status = api.StatusSuccess
+				})
+				It("should return 200 with the XCCDF results", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal(dummyXCCDFResults))
+				})
+			})
+			Context("OpenSCAP scan errored", func() {
+				BeforeEach(func() {
+					dummyMetadata.OpenSCAP.Status = api.StatusError
+					dummyMetadata.OpenSCAP.ErrorMessage = "dummy error message"
+				})
+				It("should return 500 with the scan error message", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusInternalServerError))
+					Expect(string(body)).To(ContainSubstring(dummyMetadata.OpenSCAP.ErrorMessage))
+				})
+			})
+		})
+
+		Describe(openScapJUnitPath, func() {
+			JustBeforeEach(func() {
+				u.Path = openScapJUnitPath
+			})
+			BeforeEach(func() {
+				dummyMetadata.OpenSCAP.Status = api.StatusSuccess
+			})
+			It("should return 404 when the JUnit report was not requested", func() {
+				status, _, err := getWithAuth(u, authToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Describe(resultsHTMLPath, func() {
+			JustBeforeEach(func() {
+				u.Path = resultsHTMLPath
+			})
+			Context("after a clamav scan", func() {
+				finding := api.Result{
+					Name:        "clamav",
+					Reference:   "file:///usr/bin/infected",
+					Description: "Eicar-Test-Signature",
+					Summary:     []api.Summary{{Label: api.SeverityImportant}},
+				}
+				BeforeEach(func() {
+					dummyMetadata.OpenSCAP.Status = api.StatusNotRequested
+					partialResults = &api.PartialResults{}
+					partialResults.Set(api.ScanResult{
+						APIVersion: api.DefaultResultsAPIVersion,
+						Results:    []api.Result{finding},
+						Complete:   true,
+					})
+				})
+				It("should return 200 with the findings in an HTML table", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(string(body)).To(ContainSubstring("<td>clamav</td><td>important</td><td>file:///usr/bin/infected</td><td>Eicar-Test-Signature</td>"))
+					Expect(string(body)).To(ContainSubstring("1 problems found"))
+				})
+			})
+			Context("OpenSCAP scan succeeded", func() {
+				BeforeEach(func() {
+					dummyMetadata.OpenSCAP.Status = api.StatusSuccess
+				})
+				It("should return 200 with the HTML report of OpenSCAP", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(body).To(Equal(dummyHTMLScanReport))
+				})
+			})
+			Context("OpenSCAP scan errored", func() {
+				BeforeEach(func() {
+					dummyMetadata.OpenSCAP.Status = api.StatusError
+					dummyMetadata.OpenSCAP.ErrorMessage = "dummy error message"
+				})
+				It("should return 500 with the scan error message", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusInternalServerError))
+					Expect(string(body)).To(ContainSubstring(dummyMetadata.OpenSCAP.ErrorMessage))
+				})
+			})
+		})
+
 		Describe("an HTTP GET of an expected file from "+contentPath, func() {
 			fileContents := "have a nice day"
 			JustBeforeEach(func() {
@@ -204,11 +510,248 @@ var _ = Describe("Webdav", func() {
 				Expect(string(body)).To(Equal(fileContents))
 			})
 		})
+		Describe("an HTTP GET of a directory from "+contentPath, func() {
+			JustBeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(dstPath, "etc", "yum.repos.d"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dstPath, "etc", "os-release"), []byte("rhel"), 0644)).To(Succeed())
+				u.Path = contentPath + "etc/"
+			})
+			Context("with the directory index", func() {
+				BeforeEach(func() {
+					directoryIndex = true
+				})
+				It("should return status 200 and list the directory entries", func() {
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(string(body)).To(ContainSubstring(`<a href="../">../</a>`))
+					Expect(string(body)).To(ContainSubstring(`<a href="./os-release">os-release</a>`))
+					Expect(string(body)).To(ContainSubstring(`<a href="./yum.repos.d/">yum.repos.d/</a>`))
+				})
+				It("should return 401 without a valid auth token", func() {
+					status, body, err := getWithAuth(u, "asdf")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusUnauthorized))
+					Expect(string(body)).NotTo(ContainSubstring("os-release"))
+				})
+				It("should still serve the files", func() {
+					u.Path = contentPath + "etc/os-release"
+					status, body, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusOK))
+					Expect(string(body)).To(Equal("rhel"))
+				})
+			})
+			Context("without the directory index", func() {
+				It("should return status 405", func() {
+					status, _, err := getWithAuth(u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusMethodNotAllowed))
+				})
+			})
+		})
+		Describe("requests accepting gzip", func() {
+			// the transport doesn't decompress the responses to the requests setting Accept-Encoding
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			get := func(path string, acceptGzip bool) (*http.Response, []byte) {
+				u.Path = path
+				req, err := http.NewRequest("GET", u.String(), nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set(authTokenHeader, authToken)
+				if acceptGzip {
+					req.Header.Set("Accept-Encoding", "gzip")
+				}
+				resp, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				return resp, body
+			}
+			gunzip := func(body []byte) []byte {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				decoded, err := ioutil.ReadAll(gz)
+				Expect(err).NotTo(HaveOccurred())
+				return decoded
+			}
+			BeforeEach(func() {
+				dummyMetadata.OpenSCAP.Status = api.StatusSuccess
+			})
+			It("should compress the metadata", func() {
+				resp, body := get(metadataPath, true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+				var metadata api.InspectorMetadata
+				Expect(json.Unmarshal(gunzip(body), &metadata)).To(Succeed())
+				Expect(metadata.ID).To(Equal(dummyMetadata.ID))
+			})
+			It("should compress the scan report and the image files", func() {
+				resp, body := get(openscapReportPath, true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+				Expect(gunzip(body)).To(Equal(dummyScanReport))
+				Expect(ioutil.WriteFile(filepath.Join(dstPath, "motd"), []byte("have a nice day"), 0644)).To(Succeed())
+				resp, body = get(contentPath+"motd", true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+				Expect(string(gunzip(body))).To(Equal("have a nice day"))
+			})
+			It("should not compress for the clients not accepting gzip", func() {
+				resp, body := get(openscapReportPath, false)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+				Expect(body).To(Equal(dummyScanReport))
+			})
+			Context("with the compression disabled", func() {
+				BeforeEach(func() {
+					disableGzip = true
+				})
+				It("should not compress", func() {
+					resp, body := get(openscapReportPath, true)
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+					Expect(body).To(Equal(dummyScanReport))
+				})
+			})
+		})
+		Describe("requests to "+contentPath+" by default", func() {
+			JustBeforeEach(func() {
+				u.Path = contentPath
+			})
+			It("should pass the read-only methods through to webdav", func() {
+				status, _, err := requestWithAuth("PROPFIND", u, authToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status).To(Equal(http.StatusMultiStatus))
+				status, _, err = requestWithAuth("OPTIONS", u, authToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status).To(Equal(http.StatusOK))
+			})
+			It("should return status 405 for the methods modifying the image", func() {
+				u.Path = contentPath + "created"
+				for _, method := range []string{"PUT", "DELETE", "MKCOL", "MOVE", "PROPPATCH"} {
+					status, _, err := requestWithAuth(method, u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusMethodNotAllowed))
+				}
+				_, err := os.Stat(filepath.Join(dstPath, "created"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+			Context("with allow write", func() {
+				BeforeEach(func() {
+					allowWrite = true
+				})
+				It("should let the clients modify the image", func() {
+					u.Path = contentPath + "created"
+					status, _, err := requestWithAuth("PUT", u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusCreated))
+					_, err = os.Stat(filepath.Join(dstPath, "created"))
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+		Describe("requests to "+contentPath+" with allowed methods", func() {
+			BeforeEach(func() {
+				allowedMethods = []string{"GET", "HEAD", "PROPFIND"}
+			})
+			JustBeforeEach(func() {
+				u.Path = contentPath
+			})
+			It("should pass the allowed methods through to webdav", func() {
+				status, _, err := requestWithAuth("PROPFIND", u, authToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(status).To(Equal(http.StatusMultiStatus))
+			})
+			It("should return status 405 for the other methods", func() {
+				u.Path = contentPath + "created"
+				for _, method := range []string{"PUT", "DELETE", "MKCOL"} {
+					status, _, err := requestWithAuth(method, u, authToken)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(status).To(Equal(http.StatusMethodNotAllowed))
+				}
+				_, err := os.Stat(filepath.Join(dstPath, "created"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+	Describe("serving the image", func() {
+		It("returns an actionable error when the address is already in use", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			options.ServePath = listener.Addr().String()
+			err = NewWebdavImageServer(options).ServeImage(dummyMetadata, dstPath, dummyScanResults, "", "", "", "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already in use"))
+			Expect(err.Error()).To(ContainSubstring("127.0.0.1:0"))
+		})
+		It("reports the port picked by the system for port 0", func() {
+			reader, writer := io.Pipe()
+			log.SetOutput(writer)
+			defer log.SetOutput(os.Stderr)
+			options.ServePath = "127.0.0.1:0"
+			go NewWebdavImageServer(options).ServeImage(dummyMetadata, dstPath, dummyScanResults, "", "", "", "")
+			// the warnings about the chroot are logged before the served address
+			var served []string
+			lines := bufio.NewReader(reader)
+			for served == nil {
+				line, err := lines.ReadString('\n')
+				Expect(err).NotTo(HaveOccurred())
+				served = regexp.MustCompile(`Serving image content on webdav://(127\.0\.0\.1:[1-9][0-9]*)/`).FindStringSubmatch(line)
+			}
+			log.SetOutput(os.Stderr)
+			status, body, err := getWithAuth(&url.URL{Scheme: "http", Host: served[1], Path: healthzPath}, authToken)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal([]byte("ok\n")))
+		})
+		It("exposes the port picked by the system for port 0", func() {
+			options.ServePath = "127.0.0.1:0"
+			imageServer := NewWebdavImageServer(options)
+			Expect(imageServer.Addr()).To(BeEmpty())
+			go imageServer.ServeImage(dummyMetadata, dstPath, dummyScanResults, "", "", "", "")
+			started := time.Now()
+			for len(imageServer.Addr()) == 0 && time.Since(started) < 5*time.Second {
+				time.Sleep(10 * time.Millisecond)
+			}
+			host, port, err := net.SplitHostPort(imageServer.Addr())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(host).To(Equal("127.0.0.1"))
+			Expect(port).NotTo(Equal("0"))
+			status, body, err := getWithAuth(&url.URL{Scheme: "http", Host: imageServer.Addr(), Path: healthzPath}, authToken)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal([]byte("ok\n")))
+		})
+		It("cuts off a client sending its request slowly after the read timeout", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			options.ReadTimeout = 100 * time.Millisecond
+			server := NewWebdavImageServer(options).(*webdavImageServer).newServer(http.NotFoundHandler())
+			go server.Serve(listener)
+			defer server.Close()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			// the headers are never terminated
+			_, err = conn.Write([]byte("GET " + healthzPath + " HTTP/1.1\r\nHost: localhost\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			started := time.Now()
+			conn.SetReadDeadline(started.Add(10 * time.Second))
+			_, err = ioutil.ReadAll(conn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(started) < 5*time.Second).To(BeTrue())
+		})
 	})
 })
 
 func getWithAuth(u *url.URL, token string) (int, []byte, error) {
-	req, err := http.NewRequest("GET", u.String(), nil)
+	return requestWithAuth("GET", u, token)
+}
+
+func requestWithAuth(method string, u *url.URL, token string) (int, []byte, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -0,0 +1,60 @@
+package inspector
+
+import (
+	"log"
+	"runtime"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// hostArch is the architecture of the host, injectable for testing.
+var hostArch = runtime.GOARCH
+
+// archAliases maps the architecture names found in image metadata to the GOARCH names
+// used by docker.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"armhf":   "arm",
+	"armel":   "arm",
+	"armv7l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"ppc64el": "ppc64le",
+}
+
+// normalizeArch returns the GOARCH name of the architecture arch.
+func normalizeArch(arch string) string {
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// checkArchitecture compares the architecture of the inspected image with the host's,
+// records the decision taken for a mismatch in the metadata and returns true when the
+// architecture sensitive scanners must be skipped. Images without an architecture are
+// assumed to match the host.
+func (i *defaultImageInspector) checkArchitecture() bool {
+	imageArch := i.meta.Image.Architecture
+	if len(imageArch) == 0 || normalizeArch(imageArch) == normalizeArch(hostArch) {
+		return false
+	}
+
+	skip := i.opts.ArchMismatchPolicy == iiapi.ArchMismatchSkip
+	i.meta.ArchMismatch = &iiapi.ArchMismatchMetadata{
+		ImageArchitecture: imageArch,
+		HostArchitecture:  hostArch,
+		Policy:            i.opts.ArchMismatchPolicy,
+		Skipped:           skip,
+	}
+	if skip {
+		log.Printf("WARNING: Image architecture %s differs from the host architecture %s, skipping the architecture sensitive scanners",
+			imageArch, hostArch)
+	} else {
+		log.Printf("WARNING: Image architecture %s differs from the host architecture %s, the scan results may be inaccurate",
+			imageArch, hostArch)
+	}
+	return skip
+}
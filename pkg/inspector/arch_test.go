@@ -0,0 +1,93 @@
+package inspector
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestCheckArchitecture(t *testing.T) {
+	oldHostArch := hostArch
+	defer func() { hostArch = oldHostArch }()
+	hostArch = "amd64"
+
+	for k, v := range map[string]struct {
+		imageArch        string
+		policy           string
+		expectedSkip     bool
+		expectedMismatch bool
+	}{
+		"no image architecture":         {imageArch: "", policy: iiapi.ArchMismatchSkip},
+		"same architecture":             {imageArch: "amd64", policy: iiapi.ArchMismatchSkip},
+		"same architecture alias":       {imageArch: "x86_64", policy: iiapi.ArchMismatchSkip},
+		"mismatching architecture warn": {imageArch: "arm64", policy: iiapi.ArchMismatchWarn, expectedMismatch: true},
+		"mismatching architecture skip": {imageArch: "aarch64", policy: iiapi.ArchMismatchSkip, expectedSkip: true, expectedMismatch: true},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.ArchMismatchPolicy = v.policy
+		ii := &defaultImageInspector{opts: *opts}
+		ii.meta.Image.Architecture = v.imageArch
+
+		if skip := ii.checkArchitecture(); skip != v.expectedSkip {
+			t.Errorf("%s: expected skip to be %t, got %t", k, v.expectedSkip, skip)
+		}
+		if !v.expectedMismatch {
+			if ii.meta.ArchMismatch != nil {
+				t.Errorf("%s: expected no architecture mismatch, got %v", k, ii.meta.ArchMismatch)
+			}
+			continue
+		}
+		expected := iiapi.ArchMismatchMetadata{
+			ImageArchitecture: v.imageArch,
+			HostArchitecture:  "amd64",
+			Policy:            v.policy,
+			Skipped:           v.expectedSkip,
+		}
+		if ii.meta.ArchMismatch == nil || *ii.meta.ArchMismatch != expected {
+			t.Errorf("%s: expected the architecture mismatch %v, got %v", k, expected, ii.meta.ArchMismatch)
+		}
+	}
+}
+
+func TestInspectSkipsOpenSCAPOnArchMismatch(t *testing.T) {
+	oldHostArch := hostArch
+	defer func() { hostArch = oldHostArch }()
+	hostArch = "amd64"
+
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	resultsDir, err := ioutil.TempDir("", "results-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(resultsDir)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "openscap"
+	opts.ScanResultsDir = resultsDir
+	opts.ArchMismatchPolicy = iiapi.ArchMismatchSkip
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	ii.meta.Image.Architecture = "arm64"
+
+	// oscap is never run, the inspection would fail otherwise
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("the inspection failed: %v", err)
+	}
+	if ii.meta.OpenSCAP.Status != iiapi.StatusSkipped {
+		t.Errorf("expected the OpenSCAP scan to be skipped, got status %s", ii.meta.OpenSCAP.Status)
+	}
+	if ii.meta.ArchMismatch == nil || !ii.meta.ArchMismatch.Skipped {
+		t.Errorf("expected the skip decision to be recorded, got %v", ii.meta.ArchMismatch)
+	}
+	if len(ii.meta.Scanners) != 1 || ii.meta.Scanners[0].Name != "openscap" || ii.meta.Scanners[0].Status != iiapi.StatusSkipped {
+		t.Errorf("expected the OpenSCAP scanner to be recorded as skipped, got %v", ii.meta.Scanners)
+	}
+}
@@ -0,0 +1,130 @@
+package inspector
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// newAttestationStatement returns the in-toto statement attesting scanResults about image,
+// identified by its repository digests or by its ID when it has none.
+func newAttestationStatement(image *docker.Image, scanResults iiapi.ScanResult) iiapi.AttestationStatement {
+	digests := []string{}
+	for _, repoDigest := range image.RepoDigests {
+		if i := strings.Index(repoDigest, "@"); i >= 0 {
+			digests = append(digests, repoDigest[i+1:])
+		}
+	}
+	if len(digests) == 0 {
+		digests = append(digests, image.ID)
+	}
+
+	statement := iiapi.AttestationStatement{
+		Type:          iiapi.InTotoStatementType,
+		Subject:       []iiapi.AttestationSubject{},
+		PredicateType: iiapi.ScanResultPredicateType,
+		Predicate:     scanResults,
+	}
+	seen := map[string]bool{}
+	for _, digest := range digests {
+		parts := strings.SplitN(digest, ":", 2)
+		if len(parts) != 2 || seen[digest] {
+			continue
+		}
+		seen[digest] = true
+		statement.Subject = append(statement.Subject, iiapi.AttestationSubject{
+			Name:   scanResults.ImageName,
+			Digest: map[string]string{parts[0]: parts[1]},
+		})
+	}
+	return statement
+}
+
+// dssePAE returns the pre-authentication encoding of payload that DSSE envelopes sign.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// loadSigningKey reads the PEM encoded PKCS#8, EC or PKCS#1 private key of file.
+func loadSigningKey(file string) (crypto.Signer, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the attestation key: %v\n", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("Unable to decode the attestation key %s: no PEM data found\n", file)
+	}
+	var key interface{}
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("Unable to parse the attestation key %s: unsupported key type\n", file)
+			}
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse the attestation key %s: unsupported key type\n", file)
+	}
+	return signer, nil
+}
+
+// signAttestation returns the DSSE envelope of the statement payload signed with key.
+func signAttestation(payload []byte, key crypto.Signer) (*iiapi.AttestationEnvelope, error) {
+	pae := dssePAE(iiapi.InTotoPayloadType, payload)
+	// ed25519 signs the message itself, the other keys its digest
+	message, opts := pae, crypto.SignerOpts(crypto.Hash(0))
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		digest := sha256.Sum256(pae)
+		message, opts = digest[:], crypto.SHA256
+	}
+	sig, err := key.Sign(rand.Reader, message, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to sign the attestation: %v\n", err)
+	}
+	return &iiapi.AttestationEnvelope{
+		PayloadType: iiapi.InTotoPayloadType,
+		Payload:     payload,
+		Signatures:  []iiapi.AttestationSignature{{Sig: sig}},
+	}, nil
+}
+
+// writeAttestation writes to the file name the in-toto statement attesting scanResults about
+// image, in a DSSE envelope signed with the key of keyFile if given.
+func writeAttestation(name, keyFile string, image *docker.Image, scanResults iiapi.ScanResult) error {
+	content, err := json.Marshal(newAttestationStatement(image, scanResults))
+	if err != nil {
+		return fmt.Errorf("Unable to serialize the attestation: %v\n", err)
+	}
+	if len(keyFile) > 0 {
+		key, err := loadSigningKey(keyFile)
+		if err != nil {
+			return err
+		}
+		envelope, err := signAttestation(content, key)
+		if err != nil {
+			return err
+		}
+		if content, err = json.Marshal(envelope); err != nil {
+			return fmt.Errorf("Unable to serialize the attestation: %v\n", err)
+		}
+	}
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		return fmt.Errorf("Unable to write the attestation file: %v\n", err)
+	}
+	log.Printf("Attestation written to %s", name)
+	return nil
+}
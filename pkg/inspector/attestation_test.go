@@ -0,0 +1,177 @@
+package inspector
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+func TestNewAttestationStatement(t *testing.T) {
+	scanResults := iiapi.ScanResult{
+		APIVersion: iiapi.DefaultResultsAPIVersion,
+		ImageName:  "docker.io/library/fedora:latest",
+		ImageID:    "sha256:1234",
+		Results:    []iiapi.Result{{Name: "openscap", Reference: "CVE-1"}},
+	}
+
+	for k, v := range map[string]struct {
+		image    *docker.Image
+		expected []iiapi.AttestationSubject
+	}{
+		"repo digests": {
+			image: &docker.Image{ID: "sha256:1234", RepoDigests: []string{
+				"docker.io/library/fedora@sha256:5678",
+				"registry.example.com/fedora@sha256:5678",
+				"registry.example.com/fedora@sha256:9abc",
+			}},
+			expected: []iiapi.AttestationSubject{
+				{Name: "docker.io/library/fedora:latest", Digest: map[string]string{"sha256": "5678"}},
+				{Name: "docker.io/library/fedora:latest", Digest: map[string]string{"sha256": "9abc"}},
+			},
+		},
+		"image id": {
+			image: &docker.Image{ID: "sha256:1234"},
+			expected: []iiapi.AttestationSubject{
+				{Name: "docker.io/library/fedora:latest", Digest: map[string]string{"sha256": "1234"}},
+			},
+		},
+	} {
+		statement := newAttestationStatement(v.image, scanResults)
+		if statement.Type != iiapi.InTotoStatementType || statement.PredicateType != iiapi.ScanResultPredicateType {
+			t.Errorf("%s: unexpected statement type %q and predicate type %q", k, statement.Type, statement.PredicateType)
+		}
+		if !reflect.DeepEqual(statement.Subject, v.expected) {
+			t.Errorf("%s: expected the subjects %v, got %v", k, v.expected, statement.Subject)
+		}
+		if !reflect.DeepEqual(statement.Predicate, scanResults) {
+			t.Errorf("%s: expected the predicate to be the scan results, got %+v", k, statement.Predicate)
+		}
+	}
+}
+
+func TestWriteAttestation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attestation-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate the ecdsa key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate the rsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate the ed25519 key: %v", err)
+	}
+	writeKey := func(name, blockType string, der []byte) string {
+		keyFile := path.Join(dir, name)
+		if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatalf("unable to write the key %s: %v", name, err)
+		}
+		return keyFile
+	}
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	if err := ioutil.WriteFile(path.Join(dir, "key.txt"), []byte("not a key"), 0600); err != nil {
+		t.Fatalf("unable to write the key: %v", err)
+	}
+
+	for k, v := range map[string]struct {
+		keyFile string
+		// verify checks the signature of the DSSE encoded payload with the key
+		verify        func(pae, sig []byte) bool
+		expectedError string
+	}{
+		"unsigned": {},
+		"ecdsa key": {
+			keyFile: writeKey("ec.pem", "EC PRIVATE KEY", ecDER),
+			verify: func(pae, sig []byte) bool {
+				digest := sha256.Sum256(pae)
+				return ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig)
+			},
+		},
+		"rsa key": {
+			keyFile: writeKey("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			verify: func(pae, sig []byte) bool {
+				digest := sha256.Sum256(pae)
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig) == nil
+			},
+		},
+		"ed25519 key": {
+			keyFile: writeKey("ed25519.pem", "PRIVATE KEY", edDER),
+			verify: func(pae, sig []byte) bool {
+				return ed25519.Verify(edKey.Public().(ed25519.PublicKey), pae, sig)
+			},
+		},
+		"missing key": {keyFile: path.Join(dir, "nosuchfile"), expectedError: "Unable to read the attestation key"},
+		"invalid key": {keyFile: writeKey("invalid.pem", "PRIVATE KEY", []byte("invalid")), expectedError: "unsupported key type"},
+		"not a pem":   {keyFile: path.Join(dir, "key.txt"), expectedError: "no PEM data found"},
+	} {
+		scanResults := iiapi.ScanResult{ImageName: "docker.io/library/fedora:latest", ImageID: "sha256:1234"}
+		name := path.Join(dir, "statement.json")
+		err := writeAttestation(name, v.keyFile, &docker.Image{ID: "sha256:1234"}, scanResults)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Errorf("%s: expected the attestation to be written: %v", k, err)
+			continue
+		}
+
+		payload := content
+		if v.verify != nil {
+			var envelope iiapi.AttestationEnvelope
+			if err := json.Unmarshal(content, &envelope); err != nil {
+				t.Errorf("%s: expected a DSSE envelope, got %q: %v", k, content, err)
+				continue
+			}
+			if envelope.PayloadType != iiapi.InTotoPayloadType || len(envelope.Signatures) != 1 {
+				t.Errorf("%s: unexpected envelope %+v", k, envelope)
+				continue
+			}
+			if !v.verify(dssePAE(envelope.PayloadType, envelope.Payload), envelope.Signatures[0].Sig) {
+				t.Errorf("%s: expected the signature of the envelope to be valid", k)
+			}
+			payload = envelope.Payload
+		}
+		var statement iiapi.AttestationStatement
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			t.Errorf("%s: expected an attestation statement, got %q: %v", k, payload, err)
+			continue
+		}
+		if statement.Type != iiapi.InTotoStatementType || statement.PredicateType != iiapi.ScanResultPredicateType ||
+			statement.Predicate.ImageID != scanResults.ImageID {
+			t.Errorf("%s: unexpected statement %+v", k, statement)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package inspector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// resultKey identifies the same finding across scans.
+type resultKey struct {
+	name      string
+	reference string
+}
+
+func keyOf(r iiapi.Result) resultKey {
+	return resultKey{name: r.Name, reference: r.Reference}
+}
+
+// loadBaselineResult reads the results of a previous scan from file.
+func loadBaselineResult(file string) (*iiapi.ScanResult, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the baseline result: %v\n", err)
+	}
+	var baseline iiapi.ScanResult
+	if err := json.Unmarshal(content, &baseline); err != nil {
+		return nil, fmt.Errorf("Unable to parse the baseline result %s: %v\n", file, err)
+	}
+	return &baseline, nil
+}
+
+// diffResults returns the changes of results since the baseline scan.
+func diffResults(baseline *iiapi.ScanResult, results []iiapi.Result) *iiapi.ResultsDelta {
+	delta := &iiapi.ResultsDelta{
+		Baseline:  baseline.ImageName,
+		Added:     []iiapi.Result{},
+		Removed:   []iiapi.Result{},
+		Unchanged: []iiapi.Result{},
+	}
+	previous := map[resultKey]struct{}{}
+	for _, r := range baseline.Results {
+		previous[keyOf(r)] = struct{}{}
+	}
+	current := map[resultKey]struct{}{}
+	for _, r := range results {
+		current[keyOf(r)] = struct{}{}
+		if _, ok := previous[keyOf(r)]; ok {
+			delta.Unchanged = append(delta.Unchanged, r)
+		} else {
+			delta.Added = append(delta.Added, r)
+		}
+	}
+	for _, r := range baseline.Results {
+		if _, ok := current[keyOf(r)]; !ok {
+			delta.Removed = append(delta.Removed, r)
+		}
+	}
+	return delta
+}
@@ -0,0 +1,140 @@
+package inspector
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+// resultsMockScanner is a scanner finding the given results.
+type resultsMockScanner struct {
+	results []iiapi.Result
+}
+
+func (s *resultsMockScanner) Scan(context.Context, string, *docker.Image, iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	return s.results, nil, nil
+}
+
+func (s *resultsMockScanner) Name() string {
+	return "MockScanner"
+}
+
+func finding(name, reference string) iiapi.Result {
+	return iiapi.Result{Name: name, Reference: reference, Description: reference + " found by " + name}
+}
+
+func TestDiffResults(t *testing.T) {
+	cve1, cve2, cve3 := finding("openscap", "CVE-1"), finding("openscap", "CVE-2"), finding("openscap", "CVE-3")
+	// the same reference found by another scanner is a different finding
+	clamCVE1 := finding("clamav", "CVE-1")
+
+	for k, v := range map[string]struct {
+		baseline []iiapi.Result
+		current  []iiapi.Result
+		expected iiapi.ResultsDelta
+	}{
+		"no changes": {
+			baseline: []iiapi.Result{cve1, cve2},
+			current:  []iiapi.Result{cve2, cve1},
+			expected: iiapi.ResultsDelta{Added: []iiapi.Result{}, Removed: []iiapi.Result{}, Unchanged: []iiapi.Result{cve2, cve1}},
+		},
+		"added and removed": {
+			baseline: []iiapi.Result{cve1, cve2},
+			current:  []iiapi.Result{cve2, cve3, clamCVE1},
+			expected: iiapi.ResultsDelta{Added: []iiapi.Result{cve3, clamCVE1}, Removed: []iiapi.Result{cve1}, Unchanged: []iiapi.Result{cve2}},
+		},
+		"empty baseline": {
+			current:  []iiapi.Result{cve1},
+			expected: iiapi.ResultsDelta{Added: []iiapi.Result{cve1}, Removed: []iiapi.Result{}, Unchanged: []iiapi.Result{}},
+		},
+		"all fixed": {
+			baseline: []iiapi.Result{cve1, cve3},
+			expected: iiapi.ResultsDelta{Added: []iiapi.Result{}, Removed: []iiapi.Result{cve1, cve3}, Unchanged: []iiapi.Result{}},
+		},
+	} {
+		v.expected.Baseline = "docker.io/library/image:1.0"
+		baseline := &iiapi.ScanResult{ImageName: "docker.io/library/image:1.0", Results: v.baseline}
+		if delta := diffResults(baseline, v.current); !reflect.DeepEqual(*delta, v.expected) {
+			t.Errorf("%s: expected the delta %+v, got %+v", k, v.expected, *delta)
+		}
+	}
+}
+
+func TestInspectBaselineResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baseline-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	baseline, err := json.Marshal(iiapi.ScanResult{
+		ImageName: "docker.io/library/image:1.0",
+		Results:   []iiapi.Result{finding("MockScanner", "CVE-1"), finding("MockScanner", "CVE-2")},
+	})
+	if err != nil {
+		t.Fatalf("unable to serialize the baseline: %v", err)
+	}
+	baselineFile := path.Join(dir, "baseline.json")
+	if err := ioutil.WriteFile(baselineFile, baseline, 0644); err != nil {
+		t.Fatalf("unable to write the baseline: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "invalid.json"), []byte("<xml/>"), 0644); err != nil {
+		t.Fatalf("unable to write the invalid baseline: %v", err)
+	}
+
+	for k, v := range map[string]struct {
+		baselineResult string
+		expectedError  string
+	}{
+		"baseline":         {baselineResult: baselineFile},
+		"no baseline":      {},
+		"missing baseline": {baselineResult: path.Join(dir, "nosuchfile"), expectedError: "Unable to read the baseline result"},
+		"invalid baseline": {baselineResult: path.Join(dir, "invalid.json"), expectedError: "Unable to parse the baseline result"},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = dir
+		opts.ScanType = "unowned"
+		opts.BaselineResult = v.baselineResult
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &resultsMockScanner{results: []iiapi.Result{finding("MockScanner", "CVE-2"), finding("MockScanner", "CVE-3")}}, nil
+		}
+		server := &mockImageServer{}
+		ii.imageServer = server
+
+		err := ii.Inspect()
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		delta := server.results.Delta
+		if len(v.baselineResult) == 0 {
+			if delta != nil {
+				t.Errorf("%s: expected no delta without a baseline, got %+v", k, delta)
+			}
+			continue
+		}
+		if delta == nil || delta.Baseline != "docker.io/library/image:1.0" ||
+			len(delta.Added) != 1 || delta.Added[0].Reference != "CVE-3" ||
+			len(delta.Removed) != 1 || delta.Removed[0].Reference != "CVE-1" ||
+			len(delta.Unchanged) != 1 || delta.Unchanged[0].Reference != "CVE-2" {
+			t.Errorf("%s: unexpected delta %+v", k, delta)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package inspector
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerTLSFiles are the paths of the client certificate, its key and the CA certificate of
+// a TLS connection to the docker daemon, all empty for a plain connection.
+type dockerTLSFiles struct {
+	cert string
+	key  string
+	ca   string
+}
+
+// dockerClientKey identifies the docker clients that can be shared between inspections.
+type dockerClientKey struct {
+	endpoint    string
+	tls         dockerTLSFiles
+	dialTimeout time.Duration
+	keepAlive   time.Duration
+}
+
+var (
+	// dockerNewClient provides an injectable way to create the docker clients for testing.
+	dockerNewClient = docker.NewClient
+	// dockerNewTLSClient provides an injectable way to create the TLS docker clients for testing.
+	dockerNewTLSClient = docker.NewTLSClient
+
+	// dockerClients are the clients shared by the inspections of the process, so that
+	// inspecting several images reuses the daemon connections.
+	dockerClients     = map[dockerClientKey]*docker.Client{}
+	dockerClientsLock sync.Mutex
+)
+
+// sharedDockerClient returns the client of the docker daemon at endpoint, creating it on
+// first use. The connections to the daemon use TLS when the tlsFiles are given, they are
+// established within dialTimeout, no limit when 0, and kept alive with keepAlive probes,
+// disabled when 0, while idle.
+func sharedDockerClient(endpoint string, tlsFiles dockerTLSFiles, dialTimeout, keepAlive time.Duration) (*docker.Client, error) {
+	dockerClientsLock.Lock()
+	defer dockerClientsLock.Unlock()
+
+	key := dockerClientKey{endpoint: endpoint, tls: tlsFiles, dialTimeout: dialTimeout, keepAlive: keepAlive}
+	if client, ok := dockerClients[key]; ok {
+		return client, nil
+	}
+	var client *docker.Client
+	var err error
+	if tlsFiles != (dockerTLSFiles{}) {
+		client, err = dockerNewTLSClient(endpoint, tlsFiles.cert, tlsFiles.key, tlsFiles.ca)
+	} else {
+		client, err = dockerNewClient(endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the unix socket connections are established with the client dialer, the tcp ones with
+	// the transport of the http client
+	client.Dialer = &net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+	client.HTTPClient = &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		Dial:                client.Dialer.Dial,
+		TLSClientConfig:     client.TLSConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}}
+	dockerClients[key] = client
+	return client, nil
+}
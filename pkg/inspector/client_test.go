@@ -0,0 +1,123 @@
+package inspector
+
+import (
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestSharedDockerClient(t *testing.T) {
+	oldNewClient, oldClients := dockerNewClient, dockerClients
+	defer func() { dockerNewClient, dockerClients = oldNewClient, oldClients }()
+
+	created := []string{}
+	dockerNewClient = func(endpoint string) (*docker.Client, error) {
+		created = append(created, endpoint)
+		return docker.NewClient(endpoint)
+	}
+	dockerClients = map[dockerClientKey]*docker.Client{}
+
+	// the inspections of a batch of images, none of them is available
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = "unix:///nosuchdir/docker.sock"
+	opts.PullPolicy = iiapi.PullNever
+	opts.DockerDialTimeout = 5 * time.Second
+	opts.DockerKeepAlive = time.Minute
+	for _, image := range []string{"image1", "image2", "image3"} {
+		opts.Image = image
+		err := NewDefaultImageInspector(*opts).Inspect()
+		if err == nil || !strings.Contains(err.Error(), "is not available") {
+			t.Errorf("expected the inspection of %s to fail with the image not available, got %v", image, err)
+		}
+	}
+	if len(created) != 1 {
+		t.Errorf("expected a single client to be created for the inspections, got %v", created)
+	}
+
+	client, err := sharedDockerClient(opts.URI, dockerTLSFiles{}, opts.DockerDialTimeout, opts.DockerKeepAlive)
+	if err != nil {
+		t.Fatalf("expected the shared client, got %v", err)
+	}
+	if client.Dialer.Timeout != 5*time.Second || client.Dialer.KeepAlive != time.Minute {
+		t.Errorf("expected the dialer to be configured with the options, got %+v", client.Dialer)
+	}
+
+	// the clients of other endpoints or tunables aren't shared
+	other, err := sharedDockerClient("tcp://127.0.0.1:2375", dockerTLSFiles{}, opts.DockerDialTimeout, opts.DockerKeepAlive)
+	if err != nil {
+		t.Fatalf("expected a new client, got %v", err)
+	}
+	if other == client {
+		t.Errorf("expected the clients of different endpoints not to be shared")
+	}
+	if _, err := sharedDockerClient(opts.URI, dockerTLSFiles{}, 0, 0); err != nil {
+		t.Fatalf("expected a new client, got %v", err)
+	}
+	if len(created) != 3 {
+		t.Errorf("expected a client per endpoint and tunables, got %v", created)
+	}
+
+	if _, err := sharedDockerClient("nosuchscheme://docker", dockerTLSFiles{}, 0, 0); err == nil {
+		t.Errorf("expected an invalid endpoint to fail")
+	}
+}
+
+func TestSharedDockerClientTLS(t *testing.T) {
+	oldNewClient, oldNewTLSClient, oldClients := dockerNewClient, dockerNewTLSClient, dockerClients
+	defer func() { dockerNewClient, dockerNewTLSClient, dockerClients = oldNewClient, oldNewTLSClient, oldClients }()
+
+	var created []string
+	dockerNewClient = func(endpoint string) (*docker.Client, error) {
+		created = append(created, "plain "+endpoint)
+		return docker.NewClient(endpoint)
+	}
+	tlsConfig := &tls.Config{ServerName: "docker.example.com"}
+	dockerNewTLSClient = func(endpoint string, cert, key, ca string) (*docker.Client, error) {
+		created = append(created, strings.Join([]string{"tls", endpoint, cert, key, ca}, " "))
+		client, err := docker.NewClient(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		client.TLSConfig = tlsConfig
+		return client, nil
+	}
+
+	for k, v := range map[string]struct {
+		uri, cert, key, ca string
+		expected           string
+	}{
+		"unix socket":     {uri: "unix:///var/run/docker.sock", expected: "plain unix:///var/run/docker.sock"},
+		"tcp without tls": {uri: "tcp://docker.example.com:2375", expected: "plain tcp://docker.example.com:2375"},
+		"tcp with tls": {uri: "tcp://docker.example.com:2376", cert: "/certs/cert.pem", key: "/certs/key.pem", ca: "/certs/ca.pem",
+			expected: "tls tcp://docker.example.com:2376 /certs/cert.pem /certs/key.pem /certs/ca.pem"},
+	} {
+		created = nil
+		dockerClients = map[dockerClientKey]*docker.Client{}
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI, opts.DockerTLSCert, opts.DockerTLSKey, opts.DockerTLSCA = v.uri, v.cert, v.key, v.ca
+		client, err := newDockerClient(*opts)
+		if err != nil {
+			t.Errorf("%s: expected the client to be created, got %v", k, err)
+			continue
+		}
+		if !reflect.DeepEqual(created, []string{v.expected}) {
+			t.Errorf("%s: expected the client %q to be created, got %v", k, v.expected, created)
+		}
+		// the TLS configuration is kept by the transport with the configured dialer
+		transport := client.(*docker.Client).HTTPClient.Transport.(*http.Transport)
+		if len(v.cert) > 0 && transport.TLSClientConfig != tlsConfig {
+			t.Errorf("%s: expected the transport to use the TLS configuration, got %v", k, transport.TLSClientConfig)
+		}
+		if len(v.cert) == 0 && transport.TLSClientConfig != nil {
+			t.Errorf("%s: expected the transport not to use TLS, got %v", k, transport.TLSClientConfig)
+		}
+	}
+}
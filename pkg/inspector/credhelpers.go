@@ -0,0 +1,106 @@
+package inspector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// credentialHelperPrefix is the prefix of the names of the credential helper binaries,
+	// ex. docker-credential-ecr-login
+	credentialHelperPrefix = "docker-credential-"
+	// dockerHubServerURL is the server url of defaultRegistry in the docker configs
+	dockerHubServerURL = "https://index.docker.io/v1/"
+	// identityTokenUsername is the username of the credentials holding an identity token
+	identityTokenUsername = "<token>"
+)
+
+// dockerConfigHelpers are the credential helpers settings of a docker config file.
+type dockerConfigHelpers struct {
+	// CredHelpers are the credential helpers keyed by registry
+	CredHelpers map[string]string `json:"credHelpers"`
+	// CredsStore is the credential helper of the registries without a CredHelpers entry
+	CredsStore string `json:"credsStore"`
+}
+
+// helperCredentials are the credentials printed by the get command of a credential helper.
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// registryServerURLs returns the keys that may identify registry in a docker config.
+func registryServerURLs(registry string) []string {
+	if registry == defaultRegistry {
+		return []string{dockerHubServerURL, registry}
+	}
+	return []string{registry, "https://" + registry}
+}
+
+// appendCredentialHelperConfigs appends to cfgs the credentials of registry obtained from the
+// credential helper configured in the dockercfg file, if any. It returns whether a helper is
+// configured for registry.
+func appendCredentialHelperConfigs(dockercfg, registry string, cfgs *docker.AuthConfigurations) (bool, error) {
+	var helpers dockerConfigHelpers
+	content, err := ioutil.ReadFile(dockercfg)
+	if err != nil || json.Unmarshal(content, &helpers) != nil {
+		// the unreadable files are reported when reading their static auths
+		return false, nil
+	}
+
+	helper, serverURL := helpers.CredsStore, registryServerURLs(registry)[0]
+	for _, url := range registryServerURLs(registry) {
+		if h, ok := helpers.CredHelpers[url]; ok {
+			helper, serverURL = h, url
+			break
+		}
+	}
+	if len(helper) == 0 {
+		return false, nil
+	}
+
+	creds, err := credentialHelperGet(credentialHelperPrefix+helper, serverURL)
+	if err != nil {
+		return true, fmt.Errorf("Unable to get the credentials of %s from %s%s: %v\n",
+			serverURL, credentialHelperPrefix, helper, err)
+	}
+	if creds.Username == identityTokenUsername {
+		return true, fmt.Errorf("The credentials of %s from %s%s are an identity token, which is not supported\n",
+			serverURL, credentialHelperPrefix, helper)
+	}
+	cfgs.Configs[fmt.Sprintf("%s/%s%s", dockercfg, credentialHelperPrefix, helper)] = docker.AuthConfiguration{
+		Username:      creds.Username,
+		Password:      creds.Secret,
+		ServerAddress: serverURL,
+	}
+	return true, nil
+}
+
+// credentialHelperGet runs the get command of the credential helper binary, found in PATH,
+// returning the credentials of serverURL.
+func credentialHelperGet(binary, serverURL string) (*helperCredentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// the helpers report the missing credentials on the standard output
+		if msg := strings.TrimSpace(stdout.String() + stderr.String()); len(msg) > 0 {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("unable to parse the credentials: %v", err)
+	}
+	return &creds, nil
+}
@@ -0,0 +1,128 @@
+package inspector
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+// fakeCredentialHelper prints the credentials of registry.example.com and of docker.io,
+// failing as the real helpers do for the other registries.
+const fakeCredentialHelper = `#!/bin/sh
+[ "$1" = "get" ] || exit 2
+read server
+case "$server" in
+registry.example.com)
+	echo '{"ServerURL":"registry.example.com","Username":"helper","Secret":"s3cret"}' ;;
+https://index.docker.io/v1/)
+	echo '{"ServerURL":"https://index.docker.io/v1/","Username":"hub","Secret":"hubs3cret"}' ;;
+token.example.com)
+	echo '{"ServerURL":"token.example.com","Username":"<token>","Secret":"identity"}' ;;
+*)
+	echo "credentials not found in native keychain"
+	exit 1 ;;
+esac
+`
+
+func TestGetAuthConfigsCredentialHelpers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credhelpers-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "docker-credential-fake"), []byte(fakeCredentialHelper), 0755); err != nil {
+		t.Fatalf("unable to write the fake credential helper: %v", err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for k, v := range map[string]struct {
+		image    string
+		config   string
+		expected map[string]docker.AuthConfiguration
+	}{
+		"registry helper": {
+			image:  "registry.example.com/app:1.0",
+			config: `{"auths": {"registry.example.com": {}}, "credHelpers": {"registry.example.com": "fake", "quay.io": "missing"}}`,
+			expected: map[string]docker.AuthConfiguration{
+				"config.json/docker-credential-fake": {Username: "helper", Password: "s3cret", ServerAddress: "registry.example.com"},
+			},
+		},
+		"credentials store": {
+			image:  "registry.example.com/app@sha256:0123",
+			config: `{"credsStore": "fake"}`,
+			expected: map[string]docker.AuthConfiguration{
+				"config.json/docker-credential-fake": {Username: "helper", Password: "s3cret", ServerAddress: "registry.example.com"},
+			},
+		},
+		"docker hub store": {
+			image:  "centos:7",
+			config: `{"credsStore": "fake"}`,
+			expected: map[string]docker.AuthConfiguration{
+				"config.json/docker-credential-fake": {Username: "hub", Password: "hubs3cret", ServerAddress: "https://index.docker.io/v1/"},
+			},
+		},
+		"registry helper over store": {
+			image:  "registry.example.com/app",
+			config: `{"credsStore": "missing", "credHelpers": {"registry.example.com": "fake"}}`,
+			expected: map[string]docker.AuthConfiguration{
+				"config.json/docker-credential-fake": {Username: "helper", Password: "s3cret", ServerAddress: "registry.example.com"},
+			},
+		},
+		"no helper for the registry": {
+			image:    "registry.example.com/app",
+			config:   `{"credHelpers": {"quay.io": "fake"}}`,
+			expected: map[string]docker.AuthConfiguration{},
+		},
+		"static auths without helper": {
+			image:  "registry.example.com/app",
+			config: `{"auths": {"registry.example.com": {"auth": "dXNlcjpwYXNz"}}}`,
+			expected: map[string]docker.AuthConfiguration{
+				"config.json/registry.example.com": {Username: "user", Password: "pass", ServerAddress: "registry.example.com"},
+			},
+		},
+		"missing helper binary": {
+			image:    "registry.example.com/app",
+			config:   `{"credsStore": "missing"}`,
+			expected: map[string]docker.AuthConfiguration{},
+		},
+		"helper without credentials": {
+			image:    "quay.io/app",
+			config:   `{"credsStore": "fake"}`,
+			expected: map[string]docker.AuthConfiguration{},
+		},
+		"identity token": {
+			image:    "token.example.com/app",
+			config:   `{"credsStore": "fake"}`,
+			expected: map[string]docker.AuthConfiguration{},
+		},
+	} {
+		config := path.Join(dir, "config.json")
+		if err := ioutil.WriteFile(config, []byte(v.config), 0644); err != nil {
+			t.Fatalf("unable to write the docker config: %v", err)
+		}
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = v.image
+		opts.DockerCfg.Values = []string{config}
+		ii := &defaultImageInspector{opts: *opts}
+
+		auths, err := ii.getAuthConfigs()
+		if err != nil {
+			t.Errorf("%s: expected to succeed but received %v", k, err)
+			continue
+		}
+		expected := map[string]docker.AuthConfiguration{}
+		for name, auth := range v.expected {
+			expected[path.Join(dir, name)] = auth
+		}
+		if !reflect.DeepEqual(auths.Configs, expected) {
+			t.Errorf("%s: expected the auths %v, got %v", k, expected, auths.Configs)
+		}
+	}
+}
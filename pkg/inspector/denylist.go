@@ -0,0 +1,64 @@
+package inspector
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// loadDeniedDigests reads the digests listed in file, one per line. Empty lines and lines
+// starting with # are ignored.
+func loadDeniedDigests(file string) (map[string]struct{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the denied digests file: %v\n", err)
+	}
+	defer f.Close()
+
+	digests := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digests[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the denied digests file: %v\n", err)
+	}
+	return digests, nil
+}
+
+// imageDigests returns the digests identifying image: its ID, the digests of its repository
+// pull specs and its parent.
+func imageDigests(image *docker.Image) []string {
+	digests := []string{image.ID}
+	for _, repoDigest := range image.RepoDigests {
+		if i := strings.Index(repoDigest, "@"); i >= 0 {
+			digests = append(digests, repoDigest[i+1:])
+		}
+	}
+	if len(image.Parent) > 0 {
+		digests = append(digests, image.Parent)
+	}
+	return digests
+}
+
+// checkDeniedDigests returns an error, recording the denied digest in the metadata, if any
+// of digests is on the option's denylist.
+func (i *defaultImageInspector) checkDeniedDigests(digests []string) error {
+	for _, digest := range digests {
+		if _, denied := i.deniedDigests[digest]; !denied {
+			continue
+		}
+		i.meta.DeniedDigest = digest
+		log.Printf("ERROR: Image %s matches the denied digest %s", i.opts.Image, digest)
+		return fmt.Errorf("image %s is on the denylist: digest %s is denied", i.opts.Image, digest)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package inspector
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+const deniedDigest = "sha256:9ad1c1e3a7b7f6b2b0e9a4e5d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4"
+
+func writeDeniedDigests(t *testing.T, dir string, digests ...string) string {
+	file := path.Join(dir, "denied-digests")
+	content := "# known compromised images\n\n" + strings.Join(digests, "\n") + "\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write the denied digests: %v", err)
+	}
+	return file
+}
+
+func TestInspectDeniedDigests(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+
+	dir, err := ioutil.TempDir("", "denylist-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for k, v := range map[string]struct {
+		image  *docker.Image
+		denied bool
+	}{
+		"image id denied":       {image: &docker.Image{ID: deniedDigest}, denied: true},
+		"repo digest denied":    {image: &docker.Image{ID: "sha256:1234", RepoDigests: []string{"docker.io/library/fedora@" + deniedDigest}}, denied: true},
+		"parent denied":         {image: &docker.Image{ID: "sha256:1234", Parent: deniedDigest}, denied: true},
+		"image not on denylist": {image: &docker.Image{ID: "sha256:1234", RepoDigests: []string{"docker.io/library/fedora@sha256:5678"}}},
+	} {
+		// the inspection of images that are not denied stops at the container creation
+		client := &mockDockerRuntimeClient{
+			images:    map[string]*docker.Image{"fedora": v.image},
+			createErr: &docker.Error{Status: 500, Message: "container not created"},
+		}
+		newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "fedora"
+		opts.PullPolicy = iiapi.PullNever
+		opts.ScanType = "unowned"
+		opts.DeniedDigestsFile = writeDeniedDigests(t, dir, "sha256:0000", deniedDigest)
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+
+		err := ii.Inspect()
+		if err == nil {
+			t.Errorf("%s: expected the inspection to fail", k)
+			continue
+		}
+		if denied := strings.Contains(err.Error(), "is on the denylist"); denied != v.denied {
+			t.Errorf("%s: expected denied to be %t, got %v", k, v.denied, err)
+		}
+		if v.denied && ii.meta.DeniedDigest != deniedDigest {
+			t.Errorf("%s: expected the denied digest to be recorded, got %q", k, ii.meta.DeniedDigest)
+		}
+		if !v.denied && len(ii.meta.DeniedDigest) > 0 {
+			t.Errorf("%s: unexpected denied digest %q", k, ii.meta.DeniedDigest)
+		}
+	}
+}
+
+func TestExportAndExtractImageDeniedLayer(t *testing.T) {
+	layer := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, content: "ID=rhel"},
+	)
+	sum := sha256.Sum256(layer)
+	layerDigest := "sha256:" + hex.EncodeToString(sum[:])
+
+	tmpDir, err := ioutil.TempDir("", "denylist-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for k, v := range map[string]struct {
+		denylist []string
+		denied   bool
+	}{
+		"layer denied":          {denylist: []string{layerDigest}, denied: true},
+		"layer not on denylist": {denylist: []string{deniedDigest}},
+	} {
+		client := &mockDockerRuntimeClient{
+			images: map[string]*docker.Image{"image": {ID: "image"}},
+			exports: map[string][]byte{
+				"image": newImageTarball(t, map[string][]byte{"base/layer.tar": layer}, []string{"base/layer.tar"}),
+			},
+		}
+		deniedDigests, err := loadDeniedDigests(writeDeniedDigests(t, tmpDir, v.denylist...))
+		if err != nil {
+			t.Fatalf("%s: unable to load the denied digests: %v", k, err)
+		}
+		cacheDir, err := ioutil.TempDir(tmpDir, "cache-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = path.Join(cacheDir, "rootfs")
+		ii := &defaultImageInspector{opts: *opts, deniedDigests: deniedDigests}
+		_, err = ii.exportAndExtractImage(context.Background(), client, &layerCache{dir: cacheDir})
+		if denied := err != nil && strings.Contains(err.Error(), "is on the denylist"); denied != v.denied {
+			t.Errorf("%s: expected denied to be %t, got %v", k, v.denied, err)
+		}
+		if !v.denied && err != nil {
+			t.Errorf("%s: unexpected error %v", k, err)
+		}
+		if _, err := os.Stat(path.Join(opts.DstPath, "etc/os-release")); v.denied != os.IsNotExist(err) {
+			t.Errorf("%s: expected the image to be extracted only when not denied: %v", k, err)
+		}
+	}
+}
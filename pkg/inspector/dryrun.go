@@ -0,0 +1,118 @@
+package inspector
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// dryRunOutput is where the plan of a dry run is written
+var dryRunOutput io.Writer = os.Stdout
+
+// dryRun checks that the inspection can be done, reading the denied digests and the baseline,
+// reaching the docker daemon, resolving the registry auths and creating the scanner, then
+// writes the plan of the inspection to dryRunOutput. Nothing is pulled, extracted, scanned
+// or written, and nothing is cleaned up.
+func (i *defaultImageInspector) dryRun() error {
+	if len(i.opts.DeniedDigestsFile) > 0 {
+		if _, err := loadDeniedDigests(i.opts.DeniedDigestsFile); err != nil {
+			return err
+		}
+	}
+	if len(i.opts.BaselineResult) > 0 {
+		if _, err := loadBaselineResult(i.opts.BaselineResult); err != nil {
+			return err
+		}
+	}
+	var client DockerRuntimeClient
+	if len(i.opts.RootfsPath) == 0 && len(i.opts.ImageTar) == 0 {
+		var err error
+		if client, err = newDockerClient(i.opts); err != nil {
+			return fmt.Errorf("Unable to connect to docker daemon: %v\n", err)
+		}
+	}
+
+	plan := [][2]string{}
+	add := func(key, format string, args ...interface{}) {
+		plan = append(plan, [2]string{key, fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case len(i.opts.RootfsPath) > 0:
+		add("rootfs path", "%s, scanned as it is", i.opts.RootfsPath)
+	case len(i.opts.ImageTar) > 0:
+		add("image tarball", "%s, extracted to %s", i.opts.ImageTar, extractionPath(i.opts.DstPath))
+	case len(i.opts.Container) > 0:
+		container, err := client.InspectContainer(i.opts.Container)
+		if err != nil {
+			return fmt.Errorf("Unable to get docker container information: %v\n", err)
+		}
+		add("container", "%s, its filesystem is scanned in place", container.ID)
+	default:
+		image, err := client.InspectImage(i.opts.Image)
+		if err != nil && err != docker.ErrNoSuchImage {
+			return fmt.Errorf("Unable to connect to docker daemon: %v\n", err)
+		}
+		switch {
+		case err != nil && i.opts.PullPolicy == iiapi.PullNever:
+			return fmt.Errorf("Image %s is not available and pull-policy %s doesn't allow pulling",
+				i.opts.Image, i.opts.PullPolicy)
+		case err != nil || i.opts.PullPolicy == iiapi.PullAlways:
+			auths, err := i.getAuthConfigs()
+			if err != nil {
+				return err
+			}
+			names := []string{}
+			for _, pa := range i.pullAuths(auths) {
+				names = append(names, pa.name)
+			}
+			add("image", "%s, pulled with pull-policy %s", i.opts.Image, i.opts.PullPolicy)
+			add("registry auths", "%s", strings.Join(names, ", "))
+		default:
+			add("image", "%s (%s), already available", i.opts.Image, image.ID)
+		}
+		if i.opts.MetadataOnly {
+			add("metadata only", "the layers are read from the image history, the image is not extracted")
+		} else {
+			add("extraction", "%s", extractionPath(i.opts.DstPath))
+		}
+	}
+
+	if len(i.opts.ScanType) > 0 {
+		if _, err := i.newScanner(i.opts); err != nil {
+			return fmt.Errorf("failed to initialize %s scanner: %v", i.opts.ScanType, err)
+		}
+		add("scan type", "%s", i.opts.ScanType)
+	}
+	for _, output := range []struct{ key, value string }{
+		{"scan results dir", i.opts.ScanResultsDir},
+		{"output file", i.opts.OutputFile},
+		{"split results", i.opts.SplitResults},
+		{"attestation file", i.opts.AttestationFile},
+		{"post results url", i.opts.PostResultURL},
+		{"serve", i.opts.Serve},
+	} {
+		if len(output.value) > 0 {
+			add(output.key, "%s", output.value)
+		}
+	}
+
+	fmt.Fprintf(dryRunOutput, "Dry run, the inspection would be done with:\n")
+	for _, p := range plan {
+		fmt.Fprintf(dryRunOutput, "  %s: %s\n", p[0], p[1])
+	}
+	return nil
+}
+
+// extractionPath describes where the image is extracted to, given the destination path.
+func extractionPath(dstPath string) string {
+	if len(dstPath) == 0 {
+		return "a temporary directory"
+	}
+	return dstPath
+}
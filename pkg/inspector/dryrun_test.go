@@ -0,0 +1,118 @@
+package inspector
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestInspectDryRun(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	oldDryRunOutput := dryRunOutput
+	defer func() { dryRunOutput = oldDryRunOutput }()
+
+	for k, v := range map[string]struct {
+		pullPolicy    string
+		scannerErr    error
+		expectedPlan  []string
+		expectedError string
+	}{
+		"available image": {
+			pullPolicy:   iiapi.PullIfNotPresent,
+			expectedPlan: []string{"  image: image (image-id), already available\n", "  scan type: clamav\n", "  output file: results.json\n", "  serve: 0.0.0.0:8080\n"},
+		},
+		"pull always": {
+			pullPolicy:   iiapi.PullAlways,
+			expectedPlan: []string{"  image: image, pulled with pull-policy always\n", "  registry auths: anonymous\n", "  extraction: a temporary directory\n"},
+		},
+		"scanner not ready": {
+			pullPolicy:    iiapi.PullIfNotPresent,
+			scannerErr:    fmt.Errorf("clamd is not reachable"),
+			expectedError: "failed to initialize clamav scanner: clamd is not reachable",
+		},
+	} {
+		// the client fails the inspection if the image is pulled, created or downloaded
+		client := &mockDockerRuntimeClient{
+			images:     map[string]*docker.Image{"image": {ID: "image-id"}},
+			pullErrors: []error{fmt.Errorf("pulled in a dry run")},
+			createErr:  fmt.Errorf("created in a dry run"),
+		}
+		newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+		out := &bytes.Buffer{}
+		dryRunOutput = out
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.PullPolicy = v.pullPolicy
+		opts.ScanType = "clamav"
+		opts.ClamSocket = "clamd.sock"
+		opts.OutputFile = "results.json"
+		opts.Serve = "0.0.0.0:8080"
+		opts.Cleanup = true
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.opts.DryRun = true
+		scanners := 0
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			scanners++
+			return &SuccMockScanner{}, v.scannerErr
+		}
+
+		err := ii.Inspect()
+		if len(v.expectedError) == 0 && err != nil {
+			t.Errorf("%s: expected the dry run to succeed, got %v", k, err)
+		}
+		if len(v.expectedError) > 0 && (err == nil || err.Error() != v.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+		}
+		if len(client.pulls) > 0 || len(client.downloaded) > 0 {
+			t.Errorf("%s: expected no pull and no extraction, got the pulls %v and the downloads %v", k, client.pulls, client.downloaded)
+		}
+		if scanners != 1 {
+			t.Errorf("%s: expected the scanner to be created once, got %d", k, scanners)
+		}
+		for _, line := range v.expectedPlan {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("%s: expected the plan to contain %q, got %q", k, line, out.String())
+			}
+		}
+	}
+}
+
+func TestInspectDryRunKeepsPath(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	client := &mockDockerRuntimeClient{images: map[string]*docker.Image{"image": {ID: "image-id"}}}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+	oldDryRunOutput := dryRunOutput
+	defer func() { dryRunOutput = oldDryRunOutput }()
+	dryRunOutput = &bytes.Buffer{}
+
+	dstPath, err := ioutil.TempDir("", "dst-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dstPath)
+
+	// the existing extraction path isn't cleaned up since nothing was extracted to it
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.DstPath = dstPath
+	opts.ScanType = "unowned"
+	opts.Cleanup = true
+	opts.DryRun = true
+	if err := NewDefaultImageInspector(*opts).Inspect(); err != nil {
+		t.Fatalf("expected the dry run to succeed, got %v", err)
+	}
+	if _, err := os.Stat(dstPath); err != nil {
+		t.Errorf("expected %s to be kept, got %v", dstPath, err)
+	}
+}
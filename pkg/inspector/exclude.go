@@ -0,0 +1,68 @@
+package inspector
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// excludeFilter returns a filter rejecting the paths of the image extracted in root that
+// match one of globs, the absolute paths in the image are matched or the file names when the
+// glob has no slash. The other paths are passed to next, when set.
+func excludeFilter(root string, globs []string, next iiapi.FilesFilter) iiapi.FilesFilter {
+	root = path.Clean(root)
+	return func(p string, info os.FileInfo) bool {
+		name := path.Join("/", strings.TrimPrefix(path.Clean(p), root))
+		for _, glob := range globs {
+			target := name
+			if !strings.Contains(glob, "/") {
+				target = path.Base(name)
+			}
+			if ok, _ := path.Match(glob, target); ok {
+				return false
+			}
+		}
+		return next == nil || next(p, info)
+	}
+}
+
+// fileLimit stops the scan after a maximum number of files, recording whether the scan was
+// truncated.
+type fileLimit struct {
+	max int
+	// lock protects count and truncated, the scanners may check the files concurrently
+	lock      sync.Mutex
+	count     int
+	truncated bool
+}
+
+// filter returns a filter passing the paths accepted by next, when set, until max files were
+// passed. All the paths are rejected after them, so that the directories are pruned from
+// the walks.
+func (l *fileLimit) filter(next iiapi.FilesFilter) iiapi.FilesFilter {
+	return func(p string, info os.FileInfo) bool {
+		if next != nil && !next(p, info) {
+			return false
+		}
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.count >= l.max {
+			l.truncated = true
+			return false
+		}
+		if info == nil || !info.IsDir() {
+			l.count++
+		}
+		return true
+	}
+}
+
+// wasTruncated returns whether files were left out of the scan by the limit.
+func (l *fileLimit) wasTruncated() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.truncated
+}
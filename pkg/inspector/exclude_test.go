@@ -0,0 +1,160 @@
+package inspector
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestExcludeFilter(t *testing.T) {
+	root := "/var/tmp/image-inspector-123/"
+	next := func(p string, info os.FileInfo) bool {
+		return !strings.HasSuffix(p, "/etc/shadow")
+	}
+	filter := excludeFilter(root, []string{"/var/cache", "/proc/*", "*.log"}, next)
+
+	for p, expected := range map[string]bool{
+		"/var/tmp/image-inspector-123":                   true,
+		"/var/tmp/image-inspector-123/var":               true,
+		"/var/tmp/image-inspector-123/var/cache":         false,
+		"/var/tmp/image-inspector-123/var/cache2":        true,
+		"/var/tmp/image-inspector-123/proc/1":            false,
+		"/var/tmp/image-inspector-123/proc":              true,
+		"/var/tmp/image-inspector-123/var/log/dnf.log":   false,
+		"/var/tmp/image-inspector-123/var/log/dnf.log.1": true,
+		"/var/tmp/image-inspector-123/etc/shadow":        false,
+		"/var/tmp/image-inspector-123/etc/passwd":        true,
+	} {
+		if filter(p, nil) != expected {
+			t.Errorf("%s: expected the filter to return %v", p, expected)
+		}
+	}
+}
+
+// walkMockScanner walks the filesystem like the scanners checking the files, recording the
+// files that pass the filter.
+type walkMockScanner struct {
+	SuccMockScanner
+	walked []string
+}
+
+func (s *walkMockScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	err := filepath.Walk(mountPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(p, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			s.walked = append(s.walked, strings.TrimPrefix(p, mountPath))
+		}
+		return nil
+	})
+	return []iiapi.Result{}, nil, err
+}
+
+func TestInspectExcludePaths(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	for _, name := range []string{"usr/bin/ls", "var/cache/dnf/repo.xml", "var/log/dnf.log", "var/log/messages"} {
+		p := path.Join(rootfs, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("unable to create directory for %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", name, err)
+		}
+	}
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.RootfsPath = rootfs
+	opts.ScanType = "clamav"
+	opts.ClamSocket = "clamav"
+	opts.ExcludePaths.Values = []string{"/var/cache", "*.log"}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("expected the options to validate, got %v", err)
+	}
+	scanner := &walkMockScanner{}
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return scanner, nil
+	}
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed, got %v", err)
+	}
+	if expected := []string{"/usr/bin/ls", "/var/log/messages"}; !reflect.DeepEqual(scanner.walked, expected) {
+		t.Errorf("expected only %v to be scanned, got %v", expected, scanner.walked)
+	}
+}
+
+func TestInspectMaxFiles(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	for _, name := range []string{"etc/hosts", "etc/passwd", "usr/bin/cat", "usr/bin/ls", "var/log/messages"} {
+		p := path.Join(rootfs, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("unable to create directory for %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", name, err)
+		}
+	}
+
+	for k, v := range map[string]struct {
+		maxFiles  int
+		scanned   int
+		truncated bool
+	}{
+		"no limit":          {maxFiles: 0, scanned: 5},
+		"limit not reached": {maxFiles: 5, scanned: 5},
+		"limit reached":     {maxFiles: 3, scanned: 3, truncated: true},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.RootfsPath = rootfs
+		opts.ScanType = "hygiene"
+		opts.MaxFiles = v.maxFiles
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("%s: expected the options to validate, got %v", k, err)
+		}
+		scanner := &walkMockScanner{}
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return scanner, nil
+		}
+		if err := ii.Inspect(); err != nil {
+			t.Errorf("%s: expected the inspection to succeed, got %v", k, err)
+			continue
+		}
+		if len(scanner.walked) != v.scanned {
+			t.Errorf("%s: expected %d files to be scanned, got %v", k, v.scanned, scanner.walked)
+		}
+		if len(ii.meta.Scanners) != 1 {
+			t.Errorf("%s: expected one scanner in the metadata, got %v", k, ii.meta.Scanners)
+			continue
+		}
+		skipped := ii.meta.Scanners[0].Skipped
+		if truncated := len(skipped) == 1 && strings.Contains(skipped[0], "truncated after 3 files"); truncated != v.truncated {
+			t.Errorf("%s: expected the scan to be recorded as truncated %v, got %v", k, v.truncated, skipped)
+		}
+	}
+}
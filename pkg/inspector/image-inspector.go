@@ -2,6 +2,7 @@ package inspector
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,17 +11,33 @@ import (
 	"log"
 	"math"
 	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"archive/tar"
 	"crypto/rand"
 
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/openshift/image-inspector/pkg/apk"
+	"github.com/openshift/image-inspector/pkg/capabilities"
+	"github.com/openshift/image-inspector/pkg/hygiene"
+	"github.com/openshift/image-inspector/pkg/kmod"
+	"github.com/openshift/image-inspector/pkg/logging"
 	"github.com/openshift/image-inspector/pkg/openscap"
+	"github.com/openshift/image-inspector/pkg/permissions"
+	"github.com/openshift/image-inspector/pkg/shadowed"
+	"github.com/openshift/image-inspector/pkg/unowned"
+	"github.com/openshift/image-inspector/pkg/util"
 
 	iicmd "github.com/openshift/image-inspector/pkg/cmd"
 
@@ -35,19 +52,52 @@ const (
 	DOCKER_TAR_PREFIX        = "rootfs/"
 	OWNER_PERM_RW            = 0600
 	HEALTHZ_URL_PATH         = "/healthz"
+	READYZ_URL_PATH          = "/readyz"
 	API_URL_PREFIX           = "/api"
-	RESULT_API_URL_PATH      = "/results"
+	RESULT_API_URL_PATH      = API_URL_PREFIX + "/" + VERSION_TAG + "/results"
 	CONTENT_URL_PREFIX       = API_URL_PREFIX + "/" + VERSION_TAG + "/content/"
 	METADATA_URL_PATH        = API_URL_PREFIX + "/" + VERSION_TAG + "/metadata"
+	VERSIONED_METADATA_PATH  = METADATA_URL_PATH + "/versioned"
 	OPENSCAP_URL_PATH        = API_URL_PREFIX + "/" + VERSION_TAG + "/openscap"
 	OPENSCAP_REPORT_URL_PATH = API_URL_PREFIX + "/" + VERSION_TAG + "/openscap-report"
+	OPENSCAP_XCCDF_URL_PATH  = API_URL_PREFIX + "/" + VERSION_TAG + "/openscap-xccdf-results"
+	RESULTS_HTML_URL_PATH    = API_URL_PREFIX + "/" + VERSION_TAG + "/report"
+	OPENSCAP_JUNIT_URL_PATH  = API_URL_PREFIX + "/" + VERSION_TAG + "/openscap-junit-report"
 	CHROOT_SERVE_PATH        = "/"
 	OSCAP_CVE_DIR            = "/tmp"
 	PULL_LOG_INTERVAL_SEC    = 10
+
+	// the form fields of the results posted with multipart
+	MULTIPART_RESULTS_FIELD     = "results"
+	MULTIPART_ARF_REPORT_FIELD  = "arf-report"
+	MULTIPART_HTML_REPORT_FIELD = "html-report"
+
+	// POST_ERROR_BODY_LIMIT is the size of the response body reported when posting the
+	// results fails
+	POST_ERROR_BODY_LIMIT = 1024
+	// POST_RETRY_INTERVAL is the wait before retrying to post the results, doubled after
+	// each retry
+	POST_RETRY_INTERVAL = 2 * time.Second
+
+	// ANONYMOUS_PULL_AUTH is the name logged for the pulls without authentication
+	ANONYMOUS_PULL_AUTH = "anonymous"
 )
 
 var osMkdir = os.Mkdir
 var ioutilTempDir = ioutil.TempDir
+var timeAfter = time.After
+var syscallMknod = syscall.Mknod
+var syscallMkfifo = syscall.Mkfifo
+var syscallSetxattr = syscall.Setxattr
+
+// imageTarStdin is where the image tarball is read from when it's "-"
+var imageTarStdin io.Reader = os.Stdin
+
+// newDockerClient provides an injectable way to connect to the docker daemon for testing.
+var newDockerClient = func(opts iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) {
+	tlsFiles := dockerTLSFiles{cert: opts.DockerTLSCert, key: opts.DockerTLSKey, ca: opts.DockerTLSCA}
+	return sharedDockerClient(opts.URI, tlsFiles, opts.DockerDialTimeout, opts.DockerKeepAlive)
+}
 
 type containerMeta struct {
 	Container *docker.Container
@@ -58,6 +108,9 @@ type containerMeta struct {
 type ImageInspector interface {
 	// Inspect inspects and serves the image based on the ImageInspectorOptions.
 	Inspect() error
+	// InspectContext is like Inspect but the pull, extraction and scan of the image are
+	// aborted when the context is cancelled.
+	InspectContext(ctx context.Context) error
 }
 
 // defaultImageInspector is the default implementation of ImageInspector.
@@ -66,26 +119,172 @@ type defaultImageInspector struct {
 	meta iiapi.InspectorMetadata
 	// an optional image server that will server content for inspection.
 	imageServer apiserver.ImageServer
+	// timestamps controls how the timestamps of the results and metadata are emitted
+	timestamps iiapi.Timestamps
+	// newScanner creates the scanner of the scan type
+	newScanner scannerFunc
+	// deniedDigests are the digests of the images and layers that are never inspected
+	deniedDigests map[string]struct{}
+	// partialResults are the results served while scanning, nil when they aren't served
+	partialResults *iiapi.PartialResults
+	// partialMeta are the metadata served while scanning, set along with partialResults
+	partialMeta *iiapi.PartialMetadata
+}
+
+// scannerFunc provides an injectable way to create the scanner of the option's scan type
+// for testing.
+type scannerFunc func(opts iicmd.ImageInspectorOptions) (iiapi.Scanner, error)
+
+// newDefaultScanner returns the scanner of the option's scan type.
+func newDefaultScanner(opts iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+	switch opts.ScanType {
+	case openscap.OpenSCAP:
+		if err := openscap.CheckBinary(); err != nil {
+			return nil, err
+		}
+		return openscap.NewDefaultScanner(OSCAP_CVE_DIR, opts.ScanResultsDir, opts.OpenSCAP), nil
+	case clamav.ScannerName:
+		if len(opts.ClamSocket) == 0 {
+			return nil, fmt.Errorf("clam-socket must be set to use clamav scan type")
+		}
+		return clamav.NewScanner(opts.ClamSocket, opts.ClamMaxFileSize)
+	case unowned.ScannerName:
+		return unowned.NewScanner(), nil
+	case apk.ScannerName:
+		return apk.NewScanner(opts.ApkSecDB.Values), nil
+	case capabilities.ScannerName:
+		return capabilities.NewScanner(), nil
+	case hygiene.ScannerName:
+		return hygiene.NewScanner(opts.HygienePatterns.Values), nil
+	case kmod.ScannerName:
+		return kmod.NewScanner(opts.KmodInitPaths.Values), nil
+	case permissions.ScannerName:
+		return permissions.NewScanner(), nil
+	case shadowed.ScannerName:
+		return shadowed.NewScanner(), nil
+	}
+	return nil, fmt.Errorf("unsupported scan type: %s", opts.ScanType)
+}
+
+// scannerPanicError is the error of a scanner that panicked.
+type scannerPanicError struct {
+	scanner string
+	value   interface{}
+}
+
+func (e *scannerPanicError) Error() string {
+	return fmt.Sprintf("scanner %s panicked: %v", e.scanner, e.value)
+}
+
+// scanTimeoutError is the error of a scanner that didn't complete within the scan timeout,
+// the results it found until then are kept.
+type scanTimeoutError struct {
+	scanner string
+	err     error
+}
+
+func (e *scanTimeoutError) Error() string {
+	return fmt.Sprintf("scanner %s timed out: %v", e.scanner, e.err)
+}
+
+// safeScan runs the scanner converting a panic into a scan error, so that a buggy scanner
+// doesn't crash the whole inspection. The errors of the scans interrupted by the deadline of
+// ctx are returned as scanTimeoutError.
+func safeScan(ctx context.Context, scanner iiapi.Scanner, path string, image *docker.Image, filter iiapi.FilesFilter) (results []iiapi.Result, report interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: Scanner %s panicked: %v\n%s", scanner.Name(), r, debug.Stack())
+			results, report = nil, nil
+			err = &scannerPanicError{scanner: scanner.Name(), value: r}
+		}
+	}()
+	results, report, err = scanner.Scan(ctx, path, image, filter)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		log.Printf("WARNING: Scanner %s timed out with %d results found", scanner.Name(), len(results))
+		err = &scanTimeoutError{scanner: scanner.Name(), err: err}
+	}
+	return results, report, err
+}
+
+// scanFailed tolerates the scanner panics and timeouts, already recorded in the metadata
+// by recordScan, so that the inspection completes, and returns the errors of the other
+// scanners.
+func (i *defaultImageInspector) scanFailed(err error) error {
+	switch err.(type) {
+	case *scannerPanicError, *scanTimeoutError:
+		return nil
+	}
+	return err
+}
+
+// recordScan records the outcome of the scan of the scanner name in the metadata, a nil
+// err is recorded as a success.
+func (i *defaultImageInspector) recordScan(name string, err error) {
+	scan := iiapi.ScannerMetadata{
+		Name:             name,
+		Status:           iiapi.StatusSuccess,
+		ContentTimeStamp: i.timestamps.Format(time.Now()),
+	}
+	if err != nil {
+		scan.SetError(err, i.timestamps)
+	}
+	i.meta.Scanners = append(i.meta.Scanners, scan)
+}
+
+// skipOpenSCAP records the OpenSCAP scan as not run with the given status and reason.
+func (i *defaultImageInspector) skipOpenSCAP(status iiapi.OpenSCAPStatus, message string) {
+	i.meta.OpenSCAP.Status = status
+	i.meta.OpenSCAP.ErrorMessage = message
+	i.meta.Scanners = append(i.meta.Scanners, iiapi.ScannerMetadata{
+		Name:             openscap.OpenSCAP,
+		Status:           status,
+		ErrorMessage:     message,
+		ContentTimeStamp: i.timestamps.Format(time.Now()),
+	})
+}
+
+// skipScan records the scan of the scan type as not run for the given reason.
+func (i *defaultImageInspector) skipScan(reason string) {
+	if i.opts.ScanType == "openscap" {
+		i.skipOpenSCAP(iiapi.StatusSkipped, reason)
+		return
+	}
+	i.meta.Scanners = append(i.meta.Scanners, iiapi.ScannerMetadata{
+		Name:             i.opts.ScanType,
+		Status:           iiapi.StatusSkipped,
+		Skipped:          []string{reason},
+		ContentTimeStamp: i.timestamps.Format(time.Now()),
+	})
 }
 
 // NewInspectorMetadata returns a new InspectorMetadata out of *docker.Image
-// The OpenSCAP status will be NotRequested
-func NewInspectorMetadata(imageMetadata *docker.Image) iiapi.InspectorMetadata {
+// The OpenSCAP status will be NotRequested, its timestamp is formatted by ts
+func NewInspectorMetadata(imageMetadata *docker.Image, ts iiapi.Timestamps) iiapi.InspectorMetadata {
 	return iiapi.InspectorMetadata{
 		Image: *imageMetadata,
-		OpenSCAP: &iiapi.OpenSCAPMetadata{
-			Status:           iiapi.StatusNotRequested,
-			ErrorMessage:     "",
-			ContentTimeStamp: string(time.Now().Format(time.RFC850)),
+		InspectionMetadata: iiapi.InspectionMetadata{
+			OpenSCAP: &iiapi.OpenSCAPMetadata{
+				Status:           iiapi.StatusNotRequested,
+				ErrorMessage:     "",
+				ContentTimeStamp: ts.Format(time.Now()),
+			},
 		},
 	}
 }
 
 // NewDefaultImageInspector provides a new default inspector.
 func NewDefaultImageInspector(opts iicmd.ImageInspectorOptions) ImageInspector {
+	timestamps := iiapi.Timestamps{UTC: opts.UTCTimestamps}
 	inspector := &defaultImageInspector{
-		opts: opts,
-		meta: NewInspectorMetadata(&docker.Image{}),
+		opts:       opts,
+		meta:       NewInspectorMetadata(&docker.Image{}, timestamps),
+		timestamps: timestamps,
+		newScanner: newDefaultScanner,
+	}
+
+	if opts.ServePartialResults {
+		inspector.partialResults = &iiapi.PartialResults{}
+		inspector.partialMeta = &iiapi.PartialMetadata{}
 	}
 
 	// if serving then set up an image server
@@ -93,17 +292,31 @@ func NewDefaultImageInspector(opts iicmd.ImageInspectorOptions) ImageInspector {
 		imageServerOpts := apiserver.ImageServerOptions{
 			ServePath:         opts.Serve,
 			HealthzURL:        HEALTHZ_URL_PATH,
+			ReadyzURL:         READYZ_URL_PATH,
 			APIURL:            API_URL_PREFIX,
 			ResultAPIUrlPath:  RESULT_API_URL_PATH,
+			PartialResults:    inspector.partialResults,
+			PartialMetadata:   inspector.partialMeta,
 			APIVersions:       iiapi.APIVersions{Versions: []string{VERSION_TAG}},
 			MetadataURL:       METADATA_URL_PATH,
+			VersionedMetaURL:  VERSIONED_METADATA_PATH,
 			ContentURL:        CONTENT_URL_PREFIX,
 			ScanType:          opts.ScanType,
 			ScanReportURL:     OPENSCAP_URL_PATH,
-			HTMLScanReport:    opts.OpenScapHTML,
+			HTMLScanReport:    opts.OpenSCAP.HTML,
 			HTMLScanReportURL: OPENSCAP_REPORT_URL_PATH,
-			AuthToken:         opts.AuthToken,
+			ResultsHTMLURL:    RESULTS_HTML_URL_PATH,
+			XCCDFResultsURL:   OPENSCAP_XCCDF_URL_PATH,
+			JUnitReportURL:    OPENSCAP_JUNIT_URL_PATH,
+			AuthTokens:        util.SplitList(opts.AuthToken, ","),
+			AllowedMethods:    util.SplitList(strings.ToUpper(opts.AllowedMethods), ","),
+			AllowWrite:        opts.AllowWrite,
 			Chroot:            opts.Chroot,
+			DirectoryIndex:    opts.WebdavIndex,
+			DisableGzip:       opts.DisableCompression,
+			ReadTimeout:       opts.ReadTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
 		}
 		inspector.imageServer = apiserver.NewWebdavImageServer(imageServerOpts)
 	}
@@ -112,28 +325,60 @@ func NewDefaultImageInspector(opts iicmd.ImageInspectorOptions) ImageInspector {
 
 // Inspect inspects and serves the image based on the ImageInspectorOptions.
 func (i *defaultImageInspector) Inspect() error {
+	return i.InspectContext(context.Background())
+}
+
+// InspectContext inspects and serves the image based on the ImageInspectorOptions, aborting
+// when ctx is cancelled.
+func (i *defaultImageInspector) InspectContext(ctx context.Context) error {
 	var (
-		scanner iiapi.Scanner
-		err     error
+		err error
 
-		scanReport, htmlScanReport []byte
+		scanReport, htmlScanReport string
+		xccdfResults, junitReport  string
 		filterFn                   iiapi.FilesFilter
 	)
 
-	scanResults := iiapi.ScanResult{
-		APIVersion: iiapi.DefaultResultsAPIVersion,
-		ImageName:  i.opts.Image,
-		Results:    []iiapi.Result{},
+	if i.opts.DryRun {
+		return i.dryRun()
 	}
 
-	client, err := docker.NewClient(i.opts.URI)
-	if err != nil {
-		return fmt.Errorf("Unable to connect to docker daemon: %v\n", err)
+	scanResults := i.newScanResult()
+	defer i.cleanupOutputDirs()
+
+	if len(i.opts.DeniedDigestsFile) > 0 {
+		if i.deniedDigests, err = loadDeniedDigests(i.opts.DeniedDigestsFile); err != nil {
+			return err
+		}
 	}
 
-	ctx := context.Background()
+	var baseline *iiapi.ScanResult
+	if len(i.opts.BaselineResult) > 0 {
+		if baseline, err = loadBaselineResult(i.opts.BaselineResult); err != nil {
+			return err
+		}
+	}
 
-	if len(i.opts.Container) == 0 {
+	var client DockerRuntimeClient
+	if len(i.opts.RootfsPath) == 0 && len(i.opts.ImageTar) == 0 {
+		if client, err = newDockerClient(i.opts); err != nil {
+			return fmt.Errorf("Unable to connect to docker daemon: %v\n", err)
+		}
+	}
+
+	if len(i.opts.RootfsPath) > 0 {
+		// no docker image is involved, the directory is scanned as it is
+		log.Printf("Inspecting the root filesystem %s", i.opts.RootfsPath)
+		i.opts.DstPath = i.opts.RootfsPath
+		i.meta.RootfsPath = i.opts.RootfsPath
+	} else if len(i.opts.ImageTar) > 0 {
+		imageMetadata, err := i.extractImageTar(ctx)
+		if err != nil {
+			return err
+		}
+		i.meta.Image = *imageMetadata
+		scanResults.ImageID = i.meta.Image.ID
+	} else if len(i.opts.Container) == 0 {
 		imageMetaBefore, inspectErrBefore := client.InspectImage(i.opts.Image)
 		if i.opts.PullPolicy == iiapi.PullNever && inspectErrBefore != nil {
 			return fmt.Errorf("Image %s is not available and pull-policy %s doesn't allow pulling",
@@ -142,7 +387,7 @@ func (i *defaultImageInspector) Inspect() error {
 
 		if i.opts.PullPolicy == iiapi.PullAlways ||
 			(i.opts.PullPolicy == iiapi.PullIfNotPresent && inspectErrBefore != nil) {
-			if err = i.pullImage(client); err != nil {
+			if err = i.pullImage(ctx, client); err != nil {
 				return err
 			}
 		}
@@ -152,13 +397,31 @@ func (i *defaultImageInspector) Inspect() error {
 			imageMetaBefore.ID == imageMetaAfter.ID {
 			log.Printf("Image %s was already available", i.opts.Image)
 		}
-
-		randomName, err := generateRandomName()
-		if err != nil {
-			return err
+		if inspectErrAfter == nil {
+			if err = i.checkDeniedDigests(imageDigests(imageMetaAfter)); err != nil {
+				return err
+			}
+		}
+		if i.opts.MetadataOnly {
+			if inspectErrAfter != nil {
+				return fmt.Errorf("Unable to inspect image %s: %v\n", i.opts.Image, inspectErrAfter)
+			}
+			return i.inspectMetadata(ctx, client, imageMetaAfter, scanResults)
 		}
 
-		imageMetadata, err := i.createAndExtractImage(client, randomName)
+		var imageMetadata *docker.Image
+		if len(i.opts.LayerCacheDir) > 0 {
+			if i.opts.LayerCacheDir, err = createOutputDir(i.opts.LayerCacheDir, "image-inspector-layers-", i.opts.OutputDirMode.Mode); err != nil {
+				return err
+			}
+			imageMetadata, err = i.exportAndExtractImage(ctx, client, &layerCache{dir: i.opts.LayerCacheDir})
+		} else {
+			var randomName string
+			if randomName, err = generateRandomName(); err != nil {
+				return err
+			}
+			imageMetadata, err = i.createAndExtractImage(ctx, client, randomName)
+		}
 		if err != nil {
 			return err
 		}
@@ -173,6 +436,9 @@ func (i *defaultImageInspector) Inspect() error {
 		i.meta.Image = *meta.Image
 		scanResults.ImageID = meta.Image.ID
 		scanResults.ContainerID = meta.Container.ID
+		if err = i.checkDeniedDigests(imageDigests(meta.Image)); err != nil {
+			return err
+		}
 
 		var filterInclude map[string]struct{}
 
@@ -204,58 +470,342 @@ func (i *defaultImageInspector) Inspect() error {
 		}
 	}
 
-	switch i.opts.ScanType {
-	case "openscap":
-		if i.opts.ScanResultsDir, err = createOutputDir(i.opts.ScanResultsDir, "image-inspector-scan-results-"); err != nil {
-			return err
-		}
-		var (
-			results   []iiapi.Result
-			reportObj interface{}
-		)
-		scanner = openscap.NewDefaultScanner(OSCAP_CVE_DIR, i.opts.ScanResultsDir, i.opts.CVEUrlPath, i.opts.OpenScapHTML)
-		results, reportObj, err = scanner.Scan(ctx, i.opts.DstPath, &i.meta.Image, filterFn)
-		if err != nil {
-			i.meta.OpenSCAP.SetError(err)
-			log.Printf("DEBUG: Unable to scan image %q with OpenSCAP: %v", i.opts.Image, err)
+	if len(i.opts.ExcludePaths.Values) > 0 {
+		filterFn = excludeFilter(i.opts.DstPath, i.opts.ExcludePaths.Values, filterFn)
+	}
+
+	skipArchSensitive := i.checkArchitecture()
+
+	if i.opts.DetectOS {
+		i.meta.OSFamily, i.meta.PackageManager = detectOS(i.opts.DstPath)
+		scanResults.OSFamily, scanResults.PackageManager = i.meta.OSFamily, i.meta.PackageManager
+		log.Printf("Detected the OS family %q and the package manager %q", i.meta.OSFamily, i.meta.PackageManager)
+	}
+
+	// the filesystem of a running container is not extracted
+	if len(i.opts.Container) == 0 {
+		if scanResults.ExtractedBytes, scanResults.ExtractedFileCount, err = extractedSize(i.opts.DstPath); err != nil {
+			log.Printf("WARNING: Unable to measure the extracted filesystem %s: %v", i.opts.DstPath, err)
 		} else {
-			i.meta.OpenSCAP.Status = iiapi.StatusSuccess
-			report := reportObj.(openscap.OpenSCAPReport)
-			scanReport = report.ArfBytes
-			htmlScanReport = report.HTMLBytes
-			scanResults.Results = append(scanResults.Results, results...)
+			logging.Info("Extracted image", logging.Fields{"image": i.opts.Image, "phase": "extract", "path": i.opts.DstPath,
+				"bytes": scanResults.ExtractedBytes, "files": scanResults.ExtractedFileCount})
 		}
+	}
 
-	case "clamav":
-		scanner, err = clamav.NewScanner(i.opts.ClamSocket)
+	// with partial results the image is served while scanning, the results found so far
+	// are added to partialResults as the scanner finds them
+	var served chan error
+	if i.partialResults != nil && i.imageServer != nil {
+		i.partialMeta.Set(i.meta)
+		i.partialResults.Set(scanResults)
+		served = make(chan error, 1)
+		go func(results iiapi.ScanResult) {
+			served <- i.imageServer.ServeImage(&i.meta, i.opts.DstPath, results, "", "", "", "")
+		}(scanResults)
+	}
+
+	scanCtx := ctx
+	if i.opts.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, i.opts.ScanTimeout)
+		defer cancel()
+	}
+
+	var limit *fileLimit
+	if i.opts.MaxFiles > 0 {
+		limit = &fileLimit{max: i.opts.MaxFiles}
+		filterFn = limit.filter(filterFn)
+	}
+
+	scanStarted := time.Now()
+	run, err := i.beforeScan(skipArchSensitive)
+	if err != nil {
+		return err
+	}
+	if run {
+		results, reportObj, err := i.scan(scanCtx, filterFn)
 		if err != nil {
-			return fmt.Errorf("failed to initialize clamav scanner: %v", err)
+			return err
 		}
-		results, _, err := scanner.Scan(ctx, i.opts.DstPath, &i.meta.Image, filterFn)
+		if report, ok := reportObj.(openscap.OpenSCAPReport); ok {
+			scanReport = report.ArfPath
+			htmlScanReport = report.HTMLPath
+			xccdfResults = report.XCCDFPath
+			junitReport = report.JUnitPath
+		}
+		scanResults.Results = append(scanResults.Results, results...)
+	}
+
+	scanResults.ScanDuration = time.Since(scanStarted)
+	scanResults.Complete = true
+	scanResults.Status = scanStatus(scanResults.Results, i.meta.Scanners)
+
+	if limit != nil && limit.wasTruncated() && len(i.meta.Scanners) > 0 {
+		log.Printf("WARNING: The scan stopped after %d files, the rest of the image was not scanned", limit.max)
+		scan := &i.meta.Scanners[len(i.meta.Scanners)-1]
+		scan.Skipped = append(scan.Skipped, fmt.Sprintf("the scan was truncated after %d files, max-files was reached", limit.max))
+	}
+
+	for n := range scanResults.Results {
+		scanResults.Results[n].Timestamp = i.timestamps.Time(scanResults.Results[n].Timestamp)
+	}
+	if baseline != nil {
+		scanResults.Delta = diffResults(baseline, scanResults.Results)
+		log.Printf("Compared to the baseline %s: %d results added, %d removed and %d unchanged", scanResults.Delta.Baseline,
+			len(scanResults.Delta.Added), len(scanResults.Delta.Removed), len(scanResults.Delta.Unchanged))
+	}
+
+	// the metadata are updated first, the scan is done once the results are complete
+	if i.partialResults != nil {
+		i.partialMeta.Set(i.meta)
+		i.partialResults.Set(scanResults)
+	}
+
+	if len(i.opts.OutputFile) > 0 {
+		if err := writeResults(i.opts.OutputFile, i.opts.OutputGzip, scanResults); err != nil {
+			return err
+		}
+	}
+
+	if len(i.opts.SplitResults) > 0 {
+		dir, err := createOutputDir(i.opts.SplitResults, "", i.opts.OutputDirMode.Mode)
 		if err != nil {
-			log.Printf("DEBUG: Unable to scan image %q with ClamAV: %v", i.opts.Image, err)
 			return err
 		}
-		scanResults.Results = append(scanResults.Results, results...)
+		if err := writeSplitResults(dir, scanResults, i.meta.Scanners); err != nil {
+			return err
+		}
+	}
 
-	default:
-		return fmt.Errorf("unsupported scan type: %s", i.opts.ScanType)
+	if len(i.opts.AttestationFile) > 0 {
+		if err := writeAttestation(i.opts.AttestationFile, i.opts.AttestationKeyFile, &i.meta.Image, scanResults); err != nil {
+			return err
+		}
 	}
 
 	if len(i.opts.PostResultURL) > 0 {
-		if err := i.postResults(scanResults); err != nil {
+		if err := i.postResults(ctx, scanResults, scanReport, htmlScanReport); err != nil {
 			log.Printf("Error posting results: %v", err)
-			return nil
+			if !i.opts.IgnorePostErrors {
+				return err
+			}
+		}
+	}
+
+	if len(i.opts.FailOnSeverity) > 0 {
+		if severe := severeResults(scanResults.Results, iiapi.Severity(i.opts.FailOnSeverity)); len(severe) > 0 {
+			return fmt.Errorf("Found %d results of severity %s or higher, the first is %s by %s\n",
+				len(severe), i.opts.FailOnSeverity, severe[0].Reference, severe[0].Name)
+		}
+	}
+
+	if i.imageServer == nil && i.opts.FailOnScanError {
+		if scan := failedScan(i.meta.Scanners); scan != nil {
+			return fmt.Errorf("Unable to scan the image with %s: %s\n", scan.Name, scan.ErrorMessage)
 		}
 	}
 
 	if i.imageServer != nil {
-		return i.imageServer.ServeImage(&i.meta, i.opts.DstPath, scanResults, scanReport, htmlScanReport)
+		if scan := failedScan(i.meta.Scanners); scan != nil && !i.opts.ServeOnScanError {
+			return fmt.Errorf("Unable to scan the image with %s, not serving it: %s\n", scan.Name, scan.ErrorMessage)
+		}
+		if served != nil {
+			return <-served
+		}
+		return i.imageServer.ServeImage(&i.meta, i.opts.DstPath, scanResults, scanReport, htmlScanReport, xccdfResults, junitReport)
 	}
 
 	return nil
 }
 
+// cleanupOutputDirs removes the extracted image and the scan results directories with
+// Cleanup, otherwise it logs where they are kept. The filesystems that weren't extracted are
+// left alone.
+func (i *defaultImageInspector) cleanupOutputDirs() {
+	if len(i.opts.RootfsPath) > 0 || len(i.opts.Container) > 0 {
+		return
+	}
+	for _, dir := range []string{i.opts.DstPath, i.opts.ScanResultsDir} {
+		if _, err := os.Stat(dir); len(dir) == 0 || err != nil {
+			continue
+		}
+		if !i.opts.Cleanup {
+			log.Printf("Keeping %s, pass cleanup to remove it once the inspection is done", dir)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("WARNING: Unable to remove %s: %v", dir, err)
+			continue
+		}
+		log.Printf("Removed %s", dir)
+	}
+}
+
+// scanDescriptions describe what each scan type looks for in the debug logs.
+var scanDescriptions = map[string]string{
+	"openscap":     "with OpenSCAP",
+	"clamav":       "with ClamAV",
+	"unowned":      "for unowned files",
+	"apk":          "for apk vulnerabilities",
+	"capabilities": "for file capabilities",
+	"hygiene":      "for leftover files",
+	"kmod":         "for kernel modules",
+	"permissions":  "for sensitive files permissions",
+	"shadowed":     "for shadowed binaries",
+}
+
+// beforeScan prepares the scan of the scan type, it returns false when the scan must not
+// run, in which case the reason is recorded in the metadata.
+func (i *defaultImageInspector) beforeScan(skipArchSensitive bool) (bool, error) {
+	if _, ok := scanDescriptions[i.opts.ScanType]; !ok {
+		return false, fmt.Errorf("unsupported scan type: %s", i.opts.ScanType)
+	}
+	if i.opts.ScanVolumesOnly && len(imageVolumes(&i.meta.Image)) == 0 {
+		log.Printf("Image %s declares no volumes, skipping the scan", i.opts.Image)
+		i.skipScan("the image declares no volumes to scan")
+		return false, nil
+	}
+
+	var err error
+	switch i.opts.ScanType {
+	case "openscap":
+		if skipArchSensitive {
+			i.skipOpenSCAP(iiapi.StatusSkipped, fmt.Sprintf("image architecture %s differs from the host architecture %s",
+				i.meta.ArchMismatch.ImageArchitecture, i.meta.ArchMismatch.HostArchitecture))
+			return false, nil
+		}
+		if i.checkOSRelease() {
+			i.skipOpenSCAP(iiapi.StatusUnknownOS, "no os-release or redhat-release file was found in the image")
+			return false, nil
+		}
+		if i.opts.ScanResultsDir, err = createOutputDir(i.opts.ScanResultsDir, "image-inspector-scan-results-", i.opts.OutputDirMode.Mode); err != nil {
+			return false, err
+		}
+		if err = removeStaleReports(i.opts.ScanResultsDir, i.opts.Strict); err != nil {
+			return false, err
+		}
+
+	case "clamav":
+		if i.opts.ClamDebug || i.opts.Verbose {
+			if err := clamav.EnableProtocolLog(); err != nil {
+				log.Printf("WARNING: Unable to enable the clamd protocol log: %v", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// scan runs the scanner of the scan type and records its outcome in the metadata. It
+// returns the results and the report of the scanner, the error is returned only when it
+// must make the inspection fail.
+func (i *defaultImageInspector) scan(ctx context.Context, filterFn iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanner, err := i.createScanner()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize %s scanner: %v", i.opts.ScanType, err)
+	}
+	results, reportObj, err := safeScan(ctx, scanner, i.opts.DstPath, &i.meta.Image, filterFn)
+	i.recordScan(scanner.Name(), err)
+	if report, ok := reportObj.(clamav.ClamScanReport); ok {
+		i.meta.Scanners[len(i.meta.Scanners)-1].Errors = report.Errors
+		i.meta.Scanners[len(i.meta.Scanners)-1].Skipped = report.Skipped
+	}
+	if err != nil {
+		log.Printf("DEBUG: Unable to scan image %q %s: %v", i.opts.Image, scanDescriptions[i.opts.ScanType], err)
+	}
+
+	// the OpenSCAP failures are reported in its own metadata and never fail the inspection
+	if i.opts.ScanType == "openscap" {
+		if err != nil {
+			i.meta.OpenSCAP.SetError(err, i.timestamps)
+			return nil, nil, nil
+		}
+		i.meta.OpenSCAP.Status = iiapi.StatusSuccess
+		return results, reportObj, nil
+	}
+	if err != nil {
+		if err = i.scanFailed(err); err != nil {
+			return nil, nil, err
+		}
+	}
+	return results, reportObj, nil
+}
+
+// createScanner creates the scanner of the scan type, making it report its results to
+// partialResults when they are served and the scanner can report them while scanning.
+func (i *defaultImageInspector) createScanner() (iiapi.Scanner, error) {
+	scanner, err := i.newScanner(i.opts)
+	if err != nil {
+		return nil, err
+	}
+	if incremental, ok := scanner.(iiapi.IncrementalScanner); ok && i.partialResults != nil {
+		incremental.SetResultsSink(func(r iiapi.Result) {
+			i.partialResults.Add(r)
+		})
+	}
+	return scanner, nil
+}
+
+// failedScan returns the first of the scans that failed, nil when none did.
+func failedScan(scans []iiapi.ScannerMetadata) *iiapi.ScannerMetadata {
+	for n := range scans {
+		if scans[n].Status == iiapi.StatusError {
+			return &scans[n]
+		}
+	}
+	return nil
+}
+
+// scanStatus returns the outcome of the scans that found results.
+func scanStatus(results []iiapi.Result, scans []iiapi.ScannerMetadata) iiapi.ScanStatus {
+	switch {
+	case failedScan(scans) != nil:
+		return iiapi.ScanStatusError
+	case len(results) > 0:
+		return iiapi.ScanStatusFindings
+	}
+	return iiapi.ScanStatusClean
+}
+
+// severeResults returns the results with a severity that is at least threshold.
+func severeResults(results []iiapi.Result, threshold iiapi.Severity) []iiapi.Result {
+	severe := []iiapi.Result{}
+	for _, r := range results {
+		for _, s := range r.Summary {
+			if s.Label.AtLeast(threshold) {
+				severe = append(severe, r)
+				break
+			}
+		}
+	}
+	return severe
+}
+
+// writeResults writes the scan results in JSON to the file name, gzip-compressed when
+// compress is set or name has the .gz extension.
+func writeResults(name string, compress bool, scanResults iiapi.ScanResult) error {
+	resultJSON, err := json.Marshal(scanResults)
+	if err != nil {
+		return fmt.Errorf("Unable to serialize the results: %v\n", err)
+	}
+	if compress || strings.HasSuffix(name, ".gz") {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(resultJSON); err != nil {
+			return fmt.Errorf("Unable to compress the results: %v\n", err)
+		}
+		// the compressed stream is flushed and terminated only when closed
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("Unable to compress the results: %v\n", err)
+		}
+		resultJSON = buf.Bytes()
+	}
+	if err := ioutil.WriteFile(name, resultJSON, 0644); err != nil {
+		return fmt.Errorf("Unable to write the results file: %v\n", err)
+	}
+	log.Printf("Results written to %s", name)
+	return nil
+}
+
 func (i *defaultImageInspector) postTokenContent() string {
 	if len(i.opts.PostResultTokenFile) == 0 {
 		return ""
@@ -268,65 +818,146 @@ func (i *defaultImageInspector) postTokenContent() string {
 	return fmt.Sprintf("?token=%s", strings.TrimSpace(string(token)))
 }
 
-func (i *defaultImageInspector) postResults(scanResults iiapi.ScanResult) error {
+// postResults posts the results, and the reports with PostMultipart, to PostResultURL.
+// Failures that are likely to be transient are retried up to PostResultRetries times,
+// doubling the POST_RETRY_INTERVAL wait after each attempt. The last error is returned.
+func (i *defaultImageInspector) postResults(ctx context.Context, scanResults iiapi.ScanResult, scanReport, htmlScanReport string) error {
 	url := i.opts.PostResultURL + i.postTokenContent()
 	log.Printf("Posting results to %q ...", url)
 	resultJSON, err := json.Marshal(scanResults)
 	if err != nil {
 		return err
 	}
-	client := http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewReader(resultJSON))
+	interval := POST_RETRY_INTERVAL
+	for attempt := 1; ; attempt++ {
+		retryable, err := i.postResultsOnce(ctx, url, resultJSON, scanReport, htmlScanReport)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt > i.opts.PostResultRetries || !retryable {
+			return err
+		}
+		log.Printf("Posting results failed (attempt %d of %d): %v. Retrying in %v",
+			attempt, i.opts.PostResultRetries+1, err, interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeAfter(interval):
+		}
+		interval *= 2
+	}
+}
+
+// postResultsOnce posts the results once and returns whether its failure may be transient,
+// that is a network failure or a server error of the result sink.
+func (i *defaultImageInspector) postResultsOnce(ctx context.Context, url string, resultJSON []byte, scanReport, htmlScanReport string) (bool, error) {
+	var err error
+	body, contentType := ioutil.NopCloser(bytes.NewReader(resultJSON)), ""
+	if i.opts.PostMultipart {
+		if body, contentType, err = multipartResults(resultJSON, scanReport, htmlScanReport); err != nil {
+			return false, err
+		}
+	}
+	client := http.Client{Timeout: i.opts.PostTimeout}
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
-		return err
+		// the reports are streamed until the body is closed
+		body.Close()
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, POST_ERROR_BODY_LIMIT))
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("Unable to post the results, the server replied %s: %s\n",
+				resp.Status, strings.TrimSpace(string(respBody)))
 	}
 	log.Printf("DEBUG: Success: %v", resp)
-	return nil
+	return false, nil
 }
 
-// aggregateBytesAndReport sums the numbers recieved from its input channel
-// bytesChan and prints them to the log every PULL_LOG_INTERVAL_SEC seconds.
-// It will exit after bytesChan is closed.
-func aggregateBytesAndReport(bytesChan chan int) {
-	var bytesDownloaded int = 0
-	ticker := time.NewTicker(PULL_LOG_INTERVAL_SEC * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case bytes, open := <-bytesChan:
-			if !open {
-				log.Printf("Finished Downloading Image (%dKb downloaded)", bytesDownloaded/1024)
-				return
+// multipartResults returns a multipart/form-data body, and its content type, made of the
+// results JSON and of the available report files as file parts. The report files are
+// streamed from disk while the body is read.
+func multipartResults(resultJSON []byte, scanReport, htmlScanReport string) (io.ReadCloser, string, error) {
+	type part struct {
+		field, filename, contentType string
+		content                      io.ReadCloser
+	}
+	parts := []part{{MULTIPART_RESULTS_FIELD, "results.json", "application/json", ioutil.NopCloser(bytes.NewReader(resultJSON))}}
+	for _, report := range []struct {
+		part
+		name string
+	}{
+		{part{MULTIPART_ARF_REPORT_FIELD, openscap.ArfResultFile, "application/xml", nil}, scanReport},
+		{part{MULTIPART_HTML_REPORT_FIELD, openscap.HTMLResultFile, "text/html", nil}, htmlScanReport},
+	} {
+		if len(report.name) == 0 {
+			continue
+		}
+		f, err := os.Open(report.name)
+		if err != nil {
+			for _, p := range parts {
+				p.content.Close()
 			}
-			bytesDownloaded += bytes
-		case <-ticker.C:
-			log.Printf("Downloading Image (%dKb downloaded)", bytesDownloaded/1024)
+			return nil, "", fmt.Errorf("Unable to open the %s part: %v\n", report.field, err)
 		}
+		report.content = f
+		parts = append(parts, report.part)
 	}
+
+	body, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		defer func() {
+			for _, p := range parts {
+				p.content.Close()
+			}
+		}()
+		for _, part := range parts {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, part.field, part.filename))
+			header.Set("Content-Type", part.contentType)
+			w, err := mw.CreatePart(header)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("Unable to create the %s part: %v\n", part.field, err))
+				return
+			}
+			if _, err := io.Copy(w, part.content); err != nil {
+				pw.CloseWithError(fmt.Errorf("Unable to write the %s part: %v\n", part.field, err))
+				return
+			}
+		}
+		if err := mw.Close(); err != nil {
+			pw.CloseWithError(fmt.Errorf("Unable to close the multipart body: %v\n", err))
+			return
+		}
+		pw.Close()
+	}()
+	return body, mw.FormDataContentType(), nil
 }
 
 // decodeDockerResponse will parse the docker pull messages received
-// from reader. It will start aggregateBytesAndReport with bytesChan
-// and will push the difference of bytes downloaded to bytesChan.
+// from reader. It will start reportPullProgress once the layers are
+// downloaded and will update the progress of the pull from the messages.
 // Errors encountered during parsing are reported to parsedErrors channel.
-// After reader is closed it will send nil on parsedErrors, close bytesChan and exit.
+// After reader is closed it will send nil on parsedErrors, stop the report and exit.
 func decodeDockerResponse(parsedErrors chan error, reader io.Reader) {
-	type progressDetailType struct {
-		Current, Total int
-	}
-	type pullMessage struct {
-		Status, Id     string
-		ProgressDetail progressDetailType
-		Error          string
-	}
-	bytesChan := make(chan int, 100)
-	defer func() { close(bytesChan) }()           // Closing the channel to end the other routine
-	layersBytesDownloaded := make(map[string]int) // bytes downloaded per layer
-	dec := json.NewDecoder(reader)                // decoder for the json messages
+	progress := newPullProgress()
+	done := make(chan struct{})
+	defer func() { close(done) }() // Closing the channel to end the other routine
+	dec := json.NewDecoder(reader) // decoder for the json messages
 
 	var startedDownloading = false
 	for {
@@ -342,26 +973,18 @@ func decodeDockerResponse(parsedErrors chan error, reader io.Reader) {
 		}
 		// decoding
 		if v.Error != "" {
-			parsedErrors <- fmt.Errorf(v.Error)
+			parsedErrors <- fmt.Errorf("%s", v.Error)
 			break
 		}
-		if v.Status == "Downloading" {
-			if !startedDownloading {
-				go aggregateBytesAndReport(bytesChan)
-				startedDownloading = true
-			}
-			bytes := v.ProgressDetail.Current
-			last, existed := layersBytesDownloaded[v.Id]
-			if !existed {
-				last = 0
-			}
-			layersBytesDownloaded[v.Id] = bytes
-			bytesChan <- (bytes - last)
+		progress.update(v)
+		if (v.Status == "Downloading" || v.Status == "Extracting") && !startedDownloading {
+			go reportPullProgress(progress, done)
+			startedDownloading = true
 		}
 	}
 }
 
-func (i *defaultImageInspector) getContainerMeta(client *docker.Client) (*containerMeta, error) {
+func (i *defaultImageInspector) getContainerMeta(client DockerRuntimeClient) (*containerMeta, error) {
 	var err error
 	result := &containerMeta{}
 
@@ -372,13 +995,13 @@ func (i *defaultImageInspector) getContainerMeta(client *docker.Client) (*contai
 
 	result.Image, err = client.InspectImage(result.Container.Image)
 	if err != nil {
-		return nil,fmt.Errorf("Unable to get docker image information: %v", err)
+		return nil, fmt.Errorf("Unable to get docker image information: %v", err)
 	}
 
 	return result, nil
 }
 
-func (i *defaultImageInspector) getContainerChanges(client *docker.Client, meta *containerMeta) (map[string]struct{}, error) {
+func (i *defaultImageInspector) getContainerChanges(client DockerRuntimeClient, meta *containerMeta) (map[string]struct{}, error) {
 	rootPath := i.opts.DstPath
 
 	containerChanges, err := client.ContainerChanges(i.opts.Container)
@@ -399,11 +1022,31 @@ func (i *defaultImageInspector) getContainerChanges(client *docker.Client, meta
 	return filter, nil
 }
 
-// pullImage pulls the inspected image using the given client.
+// pullImage pulls the inspected image using the given client, from the registry mirrors in
+// order and then from its registry. The image pulled from a mirror is inspected with its
+// mirrored name, the one it's known by the docker daemon.
+func (i *defaultImageInspector) pullImage(ctx context.Context, client DockerRuntimeClient) error {
+	for _, mirror := range i.opts.RegistryMirrors.Values {
+		image := mirrorImageName(i.opts.Image, mirror)
+		err := i.pullImageFrom(ctx, client, image)
+		if err == nil {
+			i.opts.Image = image
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("WARNING: Unable to pull image %s from the registry mirror %s: %v", i.opts.Image, mirror, err)
+	}
+	return i.pullImageFrom(ctx, client, i.opts.Image)
+}
+
+// pullImageFrom pulls image using the given client.
 // It will try to use all the given authentication methods and will fail
 // only if all of them failed.
-func (i *defaultImageInspector) pullImage(client *docker.Client) error {
-	log.Printf("Pulling image %s", i.opts.Image)
+func (i *defaultImageInspector) pullImageFrom(ctx context.Context, client DockerRuntimeClient, image string) error {
+	logging.Info("Pulling image "+image, logging.Fields{"image": image, "phase": "pull"})
+	started := time.Now()
 
 	var imagePullAuths *docker.AuthConfigurations
 	var authCfgErr error
@@ -411,35 +1054,167 @@ func (i *defaultImageInspector) pullImage(client *docker.Client) error {
 		return authCfgErr
 	}
 
-	// Try all the possible auth's from the config file
-	var err error
-	for name, auth := range imagePullAuths.Configs {
-		parsedErrors := make(chan error, 100)
-		defer func() { close(parsedErrors) }()
-
-		go func() {
-			reader, writer := io.Pipe()
-			defer writer.Close()
-			defer reader.Close()
-			imagePullOption := docker.PullImageOptions{
-				Repository:    i.opts.Image,
-				OutputStream:  writer,
-				RawJSONStream: true,
+	authErrors := []string{}
+	for _, pa := range i.pullAuths(imagePullAuths) {
+		if err := i.pullImageWithRetries(ctx, client, image, pa); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			go decodeDockerResponse(parsedErrors, reader)
+			logging.Warning(fmt.Sprintf("Pulling image %s %s failed: %v", image, pa.label(), err),
+				logging.Fields{"image": image, "phase": "pull", "auth": pa.name})
+			authErrors = append(authErrors, fmt.Sprintf("%s: %v", pa.name, err))
+		} else {
+			logging.Info(fmt.Sprintf("Pulled image %s %s", image, pa.label()),
+				logging.Fields{"image": image, "phase": "pull", "auth": pa.name, "duration": time.Since(started)})
+			return nil
+		}
+	}
+	return fmt.Errorf("Unable to pull docker image: %s\n", strings.Join(authErrors, "; "))
+}
 
-			if err = client.PullImage(imagePullOption, auth); err != nil {
-				parsedErrors <- err
-			}
-		}()
+// pullAuth is one of the authentications the image is pulled with.
+type pullAuth struct {
+	// name is the name of the auth in the logs and the errors
+	name string
+	auth docker.AuthConfiguration
+	// anonymous marks the pull without authentication, whatever the name of the auth
+	anonymous bool
+}
 
-		if parsedError := <-parsedErrors; parsedError != nil {
-			log.Printf("Authentication with %s failed: %v", name, parsedError)
-		} else {
+// pullAuths returns the authentications the image is pulled with, in order: the given
+// auths sorted by name, then the anonymous pull when there are none or AnonymousFallback
+// is set.
+func (i *defaultImageInspector) pullAuths(imagePullAuths *docker.AuthConfigurations) []pullAuth {
+	names := make([]string, 0, len(imagePullAuths.Configs))
+	for name := range imagePullAuths.Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	auths := make([]pullAuth, 0, len(names)+1)
+	for _, name := range names {
+		auths = append(auths, pullAuth{name: name, auth: imagePullAuths.Configs[name]})
+	}
+	if i.opts.AnonymousFallback || len(auths) == 0 {
+		auths = append(auths, pullAuth{name: ANONYMOUS_PULL_AUTH, anonymous: true})
+	}
+	return auths
+}
+
+// label describes the pulls with the auth in the logs.
+func (pa pullAuth) label() string {
+	if pa.anonymous {
+		return "anonymously"
+	}
+	return "with " + pa.name
+}
+
+// pullImageWithRetries pulls image with the given authentication. Failures
+// that are likely to be transient are retried up to PullRetryCount times, doubling the
+// PullRetryInterval wait after each attempt. The last error is returned.
+func (i *defaultImageInspector) pullImageWithRetries(ctx context.Context, client DockerRuntimeClient, image string, pa pullAuth) error {
+	interval := i.opts.PullRetryInterval
+	for attempt := 1; ; attempt++ {
+		err := pullImageOnce(ctx, client, image, pa.auth)
+		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt > i.opts.PullRetryCount || !isRetryablePullError(err) {
+			return err
+		}
+		logging.Info(fmt.Sprintf("Pulling image %s %s failed (attempt %d of %d): %v. Retrying in %v",
+			image, pa.label(), attempt, i.opts.PullRetryCount+1, err, interval),
+			logging.Fields{"image": image, "phase": "pull", "auth": pa.name, "attempt": attempt})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeAfter(interval):
+		}
+		interval *= 2
+	}
+}
+
+// pullImageOnce pulls image using the given authentication and returns the first error
+// reported either by the client or by the pull messages. When ctx is cancelled the pull
+// stream is closed, which aborts the pull, and the context error is returned.
+func pullImageOnce(ctx context.Context, client DockerRuntimeClient, image string, auth docker.AuthConfiguration) error {
+	parsedErrors := make(chan error, 100)
+	reader, writer := io.Pipe()
+
+	go func() {
+		defer writer.Close()
+		defer reader.Close()
+		imagePullOption := docker.PullImageOptions{
+			Repository:    image,
+			OutputStream:  writer,
+			RawJSONStream: true,
+		}
+		go decodeDockerResponse(parsedErrors, reader)
+
+		if err := client.PullImage(imagePullOption, auth); err != nil {
+			parsedErrors <- err
+		}
+	}()
+
+	select {
+	case err := <-parsedErrors:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	case <-ctx.Done():
+		// aborts the pull on the next write of the client
+		reader.CloseWithError(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// closeOnCancel closes reader with the context error when ctx is cancelled, unblocking both
+// ends of the pipe. The returned function stops watching ctx.
+func closeOnCancel(ctx context.Context, reader *io.PipeReader) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			reader.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// isRetryablePullError returns true if err is a network or server side (5xx or rate
+// limiting) error that may go away when retrying the pull. Authentication and authorization
+// errors are never retried.
+func isRetryablePullError(err error) bool {
+	// the daemon reports the registry authentication failures as server errors
+	msg := strings.ToLower(err.Error())
+	for _, authMsg := range []string{"unauthorized", "authentication required", "denied"} {
+		if strings.Contains(msg, authMsg) {
+			return false
+		}
+	}
+
+	if dockerErr, ok := err.(*docker.Error); ok {
+		return dockerErr.Status >= http.StatusInternalServerError ||
+			dockerErr.Status == http.StatusTooManyRequests
 	}
-	return fmt.Errorf("Unable to pull docker image: %v\n", err)
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	for _, transientMsg := range []string{
+		"timeout", "connection refused", "connection reset", "unexpected eof",
+		"toomanyrequests", "too many requests", "internal server error",
+		"bad gateway", "service unavailable", "gateway timeout",
+	} {
+		if strings.Contains(msg, transientMsg) {
+			return true
+		}
+	}
+	return false
 }
 
 // createAndExtractImage creates a docker container based on the option's image with containerName.
@@ -447,7 +1222,7 @@ func (i *defaultImageInspector) pullImage(client *docker.Client) error {
 // option's destination path.  If the destination path is empty it will write to a temp directory
 // and update the option's destination path with a /var/tmp directory.  /var/tmp is used to
 // try and ensure it is a non-in-memory tmpfs.
-func (i *defaultImageInspector) createAndExtractImage(client *docker.Client, containerName string) (*docker.Image, error) {
+func (i *defaultImageInspector) createAndExtractImage(ctx context.Context, client DockerRuntimeClient, containerName string) (*docker.Image, error) {
 	container, err := client.CreateContainer(docker.CreateContainerOptions{
 		Name: containerName,
 		Config: &docker.Config{
@@ -478,56 +1253,182 @@ func (i *defaultImageInspector) createAndExtractImage(client *docker.Client, con
 		return imageMetadata, fmt.Errorf("Unable to get docker image information: %v\n", err)
 	}
 
-	if i.opts.DstPath, err = createOutputDir(i.opts.DstPath, "image-inspector-"); err != nil {
+	if i.opts.DstPath, err = createOutputDir(i.opts.DstPath, "image-inspector-", i.opts.OutputDirMode.Mode); err != nil {
 		return imageMetadata, err
 	}
 
+	logging.Info(fmt.Sprintf("Extracting image %s to %s", i.opts.Image, i.opts.DstPath),
+		logging.Fields{"image": i.opts.Image, "phase": "extract", "path": i.opts.DstPath})
+
+	extractPaths := i.opts.ExtractPaths.Values
+	if i.opts.ScanVolumesOnly {
+		if extractPaths = imageVolumes(imageMetadata); len(extractPaths) == 0 {
+			log.Printf("Image %s declares no volumes, nothing is extracted", i.opts.Image)
+			return imageMetadata, nil
+		}
+		log.Printf("Extracting the volumes %v of image %s", extractPaths, i.opts.Image)
+	}
+	if len(extractPaths) == 0 {
+		return imageMetadata, i.downloadFromContainer(ctx, client, container.ID, "/")
+	}
+	for _, p := range extractPaths {
+		if err := i.downloadFromContainer(ctx, client, container.ID, p); err != nil {
+			return imageMetadata, err
+		}
+	}
+	return imageMetadata, nil
+}
+
+// imageVolumes returns the sorted paths of the volumes declared in the config of image.
+func imageVolumes(image *docker.Image) []string {
+	volumes := []string{}
+	if image == nil || image.Config == nil {
+		return volumes
+	}
+	for volume := range image.Config.Volumes {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	return volumes
+}
+
+// downloadFromContainer extracts the content of srcPath in the container to the same path
+// under the option's destination path.
+func (i *defaultImageInspector) downloadFromContainer(ctx context.Context, client DockerRuntimeClient, containerID, srcPath string) error {
+	srcPath = path.Clean(srcPath)
+	extractOpts := tarExtractOptions{
+		prefix:              DOCKER_TAR_PREFIX,
+		symlinkPolicy:       i.opts.SymlinkPolicy,
+		extractSpecialFiles: i.opts.ExtractSpecialFiles,
+		preserveXattrs:      i.opts.PreserveXattrs,
+	}
+	if srcPath != "/" {
+		// the entries of the archive of a path are relative to the parent of the path, they
+		// are extracted there but the symlinks are still resolved from the image root
+		extractOpts.prefix = ""
+		extractOpts.dir = strings.TrimPrefix(path.Dir(srcPath), "/")
+		if err := os.MkdirAll(path.Join(i.opts.DstPath, extractOpts.dir), 0755); err != nil {
+			return fmt.Errorf("Unable to create destination path: %v\n", err)
+		}
+	}
+
 	reader, writer := io.Pipe()
 	// handle closing the reader/writer in the method that creates them
 	defer writer.Close()
 	defer reader.Close()
-
-	log.Printf("Extracting image %s to %s", i.opts.Image, i.opts.DstPath)
+	// cancelling the context interrupts both the download and the extraction
+	defer closeOnCancel(ctx, reader)()
 
 	// start the copy function first which will block after the first write while waiting for
 	// the reader to read.
 	errorChannel := make(chan error)
 	go func() {
-		errorChannel <- client.DownloadFromContainer(
-			container.ID,
+		err := client.DownloadFromContainer(
+			containerID,
 			docker.DownloadFromContainerOptions{
 				OutputStream: writer,
-				Path:         "/",
+				Path:         srcPath,
 			})
+		// unblocks the extraction when the download ends
+		writer.CloseWithError(err)
+		errorChannel <- err
 	}()
 
+	// the extraction of a large image may take minutes, its progress is logged meanwhile
+	progress := &extractProgress{}
+	extractOpts.progress = progress.add
+	done := make(chan struct{})
+	go reportExtractProgress(i.opts.Image, srcPath, progress, done)
+
 	// block on handling the reads here so we ensure both the write and the reader are finished
 	// (read waits until an EOF or error occurs).
-	handleTarStream(reader, i.opts.DstPath)
+	extractErr := handleTarStream(reader, i.opts.DstPath, extractOpts)
+	close(done)
 
 	// capture any error from the copy, ensures both the handleTarStream and DownloadFromContainer
 	// are done.
-	err = <-errorChannel
+	err := <-errorChannel
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if extractErr != nil {
+		return fmt.Errorf("Unable to extract container path %s: %v\n", srcPath, extractErr)
+	}
 	if err != nil {
-		return imageMetadata, fmt.Errorf("Unable to extract container: %v\n", err)
+		return fmt.Errorf("Unable to extract container path %s: %v\n", srcPath, err)
 	}
+	return nil
+}
 
-	return imageMetadata, nil
+// tarExtractOptions controls how processTarStream extracts a tar stream.
+type tarExtractOptions struct {
+	// prefix is trimmed from the name of each entry
+	prefix string
+	// dir is the directory, relative to the extraction root, where the entries are extracted
+	dir string
+	// symlinkPolicy controls how symlinks with absolute or escaping targets are extracted
+	symlinkPolicy string
+	// extractSpecialFiles controls whether device nodes and FIFOs are recreated
+	extractSpecialFiles bool
+	// preserveXattrs controls whether the extended attributes of the entries are restored
+	preserveXattrs bool
+	// progress, when set, is passed each extracted entry with the bytes written for it
+	progress func(files int, bytes int64)
 }
 
-func handleTarStream(reader io.ReadCloser, destination string) {
-	tr := tar.NewReader(reader)
-	if tr != nil {
-		err := processTarStream(tr, destination)
-		if err != nil {
-			log.Print(err)
+// handleTarStream extracts the tar stream of reader to destination, consuming the whole
+// stream so that its writer never blocks: what follows the end of the archive is discarded
+// and, when the extraction fails, the reader is closed with the error of the extraction.
+func handleTarStream(reader *io.PipeReader, destination string, opts tarExtractOptions) error {
+	if err := processTarStream(tar.NewReader(reader), destination, opts); err != nil {
+		reader.CloseWithError(err)
+		return err
+	}
+	// the errors of the download are reported by its writer
+	io.Copy(ioutil.Discard, reader)
+	return nil
+}
+
+// symlinkTarget returns the target that should be used for a symlink named name (relative to
+// the extraction root) pointing to linkname, according to symlinkPolicy. If the symlink should
+// not be created at all an empty string is returned. Targets that are relative and resolve
+// inside the extraction root are never modified.
+func symlinkTarget(destination, name, linkname, symlinkPolicy string) string {
+	linkDir := path.Dir(path.Join("/", name))
+	if !path.IsAbs(linkname) {
+		rel := path.Join(strings.TrimPrefix(linkDir, "/"), linkname)
+		if rel != ".." && !strings.HasPrefix(rel, "../") {
+			return linkname
 		}
+	}
+
+	switch symlinkPolicy {
+	case iiapi.SymlinkKeep:
+		return linkname
+	case iiapi.SymlinkSkip:
+		return ""
+	}
+
+	// resolving from the root of the image confines the target inside the extraction root
+	var resolved string
+	if path.IsAbs(linkname) {
+		resolved = path.Clean(linkname)
 	} else {
-		log.Printf("Unable to create image tar reader")
+		resolved = path.Join(linkDir, linkname)
 	}
+
+	if symlinkPolicy == iiapi.SymlinkClamp {
+		return path.Join(destination, resolved)
+	}
+
+	rel, err := filepath.Rel(linkDir, resolved)
+	if err != nil {
+		return ""
+	}
+	return rel
 }
 
-func processTarStream(tr *tar.Reader, destination string) error {
+func processTarStream(tr *tar.Reader, destination string, opts tarExtractOptions) error {
 	for {
 		hdr, err := tr.Next()
 		if err != nil {
@@ -539,9 +1440,25 @@ func processTarStream(tr *tar.Reader, destination string) error {
 
 		hdrInfo := hdr.FileInfo()
 
-		dstpath := path.Join(destination, strings.TrimPrefix(hdr.Name, DOCKER_TAR_PREFIX))
+		name := strings.TrimPrefix(hdr.Name, opts.prefix)
+		if len(opts.dir) > 0 {
+			name = path.Join(opts.dir, name)
+		}
+		dstpath := path.Join(destination, name)
+		if !insideDestination(destination, dstpath) {
+			log.Printf("Skipping %s which is outside of the extraction root", hdr.Name)
+			continue
+		}
+		// the entries with a path extracted earlier replace it, which also prevents writing
+		// through a symlink that may point outside of the extraction root
+		if extractedType(hdr.Typeflag, opts) {
+			if err := removeReplacedEntry(dstpath, hdr.Typeflag == tar.TypeDir); err != nil {
+				return err
+			}
+		}
 		// Overriding permissions to allow writing content
 		mode := hdrInfo.Mode() | OWNER_PERM_RW
+		var written int64
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
@@ -559,28 +1476,180 @@ func processTarStream(tr *tar.Reader, destination string) error {
 			if err != nil {
 				return fmt.Errorf("Unable to create file: %v", err)
 			}
-			if _, err := io.Copy(file, tr); err != nil {
+			if written, err = io.Copy(file, tr); err != nil {
 				file.Close()
 				return fmt.Errorf("Unable to write into file: %v", err)
 			}
 			file.Close()
+			// the umask must not hide the group and other permissions of the image files
+			if err := os.Chmod(dstpath, mode); err != nil {
+				return fmt.Errorf("Unable to update file mode: %v", err)
+			}
 		case tar.TypeSymlink:
-			if err := os.Symlink(hdr.Linkname, dstpath); err != nil {
+			target := symlinkTarget(destination, name, hdr.Linkname, opts.symlinkPolicy)
+			if len(target) == 0 {
+				log.Printf("Skipping symlink %s with target %s outside the image", name, hdr.Linkname)
+				continue
+			}
+			if err := os.Symlink(target, dstpath); err != nil {
 				return fmt.Errorf("Unable to create symlink: %v\n", err)
 			}
 		case tar.TypeLink:
-			target := path.Join(destination, strings.TrimPrefix(hdr.Linkname, DOCKER_TAR_PREFIX))
+			target := path.Join(destination, opts.dir, strings.TrimPrefix(hdr.Linkname, opts.prefix))
+			if !insideDestination(destination, target) {
+				log.Printf("Skipping link %s with target %s outside of the extraction root", name, hdr.Linkname)
+				continue
+			}
 			if err := os.Link(target, dstpath); err != nil {
 				return fmt.Errorf("Unable to create link: %v\n", err)
 			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if !opts.extractSpecialFiles {
+				continue
+			}
+			if extracted, err := createSpecialFile(hdr, dstpath); err != nil {
+				return err
+			} else if !extracted {
+				continue
+			}
 		default:
-			// For now we're skipping anything else. Special device files and
-			// symlinks are not needed or anyway probably incorrect.
+			// For now we're skipping anything else.
+		}
+
+		// symlinks are excluded since setxattr would follow them and hard links share the
+		// attributes of their target
+		if opts.preserveXattrs && hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink {
+			setXattrs(dstpath, tarXattrs(hdr))
+		}
+
+		// maintaining access and modification time in best effort fashion (symlinks
+		// excluded since Chtimes would follow them)
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(dstpath, hdr.AccessTime, hdr.ModTime)
+		}
+
+		if opts.progress != nil {
+			opts.progress(1, written)
+		}
+	}
+}
+
+// paxSchilyXattr is the prefix of the PAX records holding extended attributes
+const paxSchilyXattr = "SCHILY.xattr."
+
+// tarXattrs returns the extended attributes of a tar entry, found either in the Xattrs of
+// the header or in the SCHILY.xattr PAX records.
+func tarXattrs(hdr *tar.Header) map[string]string {
+	xattrs := map[string]string{}
+	for k, v := range hdr.Xattrs {
+		xattrs[k] = v
+	}
+	for k, v := range hdr.PAXRecords {
+		if strings.HasPrefix(k, paxSchilyXattr) {
+			xattrs[strings.TrimPrefix(k, paxSchilyXattr)] = v
 		}
+	}
+	return xattrs
+}
+
+// setXattrs sets the extended attributes of dstpath in best effort fashion, the attributes
+// that can't be set (e.g. because of missing privileges or filesystem support, or labels
+// rejected by the host) are skipped.
+func setXattrs(dstpath string, xattrs map[string]string) {
+	for k, v := range xattrs {
+		if err := syscallSetxattr(dstpath, k, []byte(v), 0); err != nil {
+			log.Printf("Skipping extended attribute %s of %s: %v", k, dstpath, err)
+		}
+	}
+}
 
-		// maintaining access and modification time in best effort fashion
-		os.Chtimes(dstpath, hdr.AccessTime, hdr.ModTime)
+// extractedType returns true if processTarStream extracts the entries of type typeflag.
+func extractedType(typeflag byte, opts tarExtractOptions) bool {
+	switch typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink:
+		return true
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return opts.extractSpecialFiles
 	}
+	return false
+}
+
+// removeReplacedEntry removes the entry extracted earlier at dstpath so that the last entry
+// with a given path wins even when its type changes. Only a directory replaced by another
+// directory is kept, their contents are merged as in the image layers.
+func removeReplacedEntry(dstpath string, isDir bool) error {
+	fi, err := os.Lstat(dstpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Unable to inspect %s: %v\n", dstpath, err)
+	}
+	if fi.IsDir() && isDir {
+		return nil
+	}
+	if err := os.RemoveAll(dstpath); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v\n", dstpath, err)
+	}
+	return nil
+}
+
+// createSpecialFile creates the device node or FIFO described by hdr at dstpath. Device
+// nodes require privileges, without them the file is skipped and false is returned.
+func createSpecialFile(hdr *tar.Header, dstpath string) (bool, error) {
+	if err := os.Remove(dstpath); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("Unable to replace %s: %v\n", dstpath, err)
+	}
+	perm := uint32(hdr.FileInfo().Mode().Perm())
+	var err error
+	switch hdr.Typeflag {
+	case tar.TypeFifo:
+		err = syscallMkfifo(dstpath, perm)
+	case tar.TypeChar:
+		err = syscallMknod(dstpath, syscall.S_IFCHR|perm, mkdev(hdr.Devmajor, hdr.Devminor))
+	case tar.TypeBlock:
+		err = syscallMknod(dstpath, syscall.S_IFBLK|perm, mkdev(hdr.Devmajor, hdr.Devminor))
+	}
+	if err != nil {
+		if os.IsPermission(err) {
+			log.Printf("Skipping special file %s, not enough privileges to create it", hdr.Name)
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to create special file: %v\n", err)
+	}
+	return true, nil
+}
+
+// mkdev returns the device number of the given major and minor numbers as encoded on linux.
+func mkdev(major, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
+
+// insideDestination returns true if dstpath is located under destination once the symlinks
+// in its parent directories are resolved.
+func insideDestination(destination, dstpath string) bool {
+	if !isSubPath(destination, dstpath) {
+		return false
+	}
+	if path.Clean(dstpath) == path.Clean(destination) {
+		return true
+	}
+	root, err := filepath.EvalSymlinks(destination)
+	if err != nil {
+		return false
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(dstpath))
+	if err != nil {
+		// nothing can be created under a missing parent
+		return true
+	}
+	return isSubPath(root, filepath.Join(parent, filepath.Base(dstpath)))
+}
+
+// isSubPath returns true if p is dir or a path under dir.
+func isSubPath(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
 }
 
 func generateRandomName() (string, error) {
@@ -605,39 +1674,102 @@ func appendDockerCfgConfigs(dockercfg string, cfgs *docker.AuthConfigurations) e
 		return fmt.Errorf("No auths were found in the given dockercfg file\n")
 	}
 	for name, ac := range imagePullAuths.Configs {
+		// the empty auths would repeat the anonymous pull
+		if len(ac.Username) == 0 && len(ac.Password) == 0 {
+			log.Printf("Skipping the empty auth of %s in %s, the image can be pulled anonymously instead", name, dockercfg)
+			continue
+		}
 		cfgs.Configs[fmt.Sprintf("%s/%s", dockercfg, name)] = ac
 	}
 	return nil
 }
 
 func (i *defaultImageInspector) getAuthConfigs() (*docker.AuthConfigurations, error) {
-	imagePullAuths := &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{"Default Empty Authentication": {}}}
+	imagePullAuths := &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{}}
 	if len(i.opts.DockerCfg.Values) > 0 {
+		registry := imageRegistry(i.opts.Image)
 		for _, dcfgFile := range i.opts.DockerCfg.Values {
-			if err := appendDockerCfgConfigs(dcfgFile, imagePullAuths); err != nil {
+			helper, err := appendCredentialHelperConfigs(dcfgFile, registry, imagePullAuths)
+			if err != nil {
+				log.Printf("WARNING: Unable to use the credential helper of %s. Error: %v", dcfgFile, err)
+			}
+			// the configs using a credential helper don't need to list static auths
+			if err := appendDockerCfgConfigs(dcfgFile, imagePullAuths); err != nil && !helper {
 				log.Printf("WARNING: Unable to read docker configuration from %s. Error: %v", dcfgFile, err)
 			}
 		}
 	}
 
 	if i.opts.Username != "" {
-		token, err := ioutil.ReadFile(i.opts.PasswordFile)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to read password file: %v\n", err)
+		// the password file takes precedence over the environment
+		token := []byte(os.Getenv(iicmd.RegistryPasswordEnv))
+		if len(i.opts.PasswordFile) > 0 {
+			var err error
+			if token, err = ioutil.ReadFile(i.opts.PasswordFile); err != nil {
+				return nil, fmt.Errorf("Unable to read password file: %v\n", err)
+			}
 		}
-		imagePullAuths = &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{"": {Username: i.opts.Username, Password: string(token)}}}
+		imagePullAuths = &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{i.opts.Username: {Username: i.opts.Username, Password: string(token)}}}
 	}
 
 	return imagePullAuths, nil
 }
 
-func createOutputDir(dirName string, tempName string) (string, error) {
+// removeStaleReports removes the reports left in dirName by a previous scan so that they
+// can't be mistaken for the results of the current one. In strict mode finding a previous
+// report is an error instead.
+func removeStaleReports(dirName string, strict bool) error {
+	for _, report := range []string{openscap.ArfResultFile, openscap.HTMLResultFile, openscap.XCCDFResultFile, openscap.JUnitResultFile} {
+		reportPath := path.Join(dirName, report)
+		if _, err := os.Lstat(reportPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("Unable to check for previous report %s: %v\n", reportPath, err)
+		}
+		if strict {
+			return fmt.Errorf("Scan results directory contains the report %s of a previous scan\n", reportPath)
+		}
+		log.Printf("Removing report %s of a previous scan", reportPath)
+		if err := os.Remove(reportPath); err != nil {
+			return fmt.Errorf("Unable to remove previous report %s: %v\n", reportPath, err)
+		}
+	}
+	return nil
+}
+
+// extractedSize returns the size and the number of the regular files under root.
+func extractedSize(root string) (int64, int, error) {
+	var size int64
+	count := 0
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+			count++
+		}
+		return nil
+	})
+	return size, count, err
+}
+
+// createOutputDir creates the directory dirName, or a temporary directory named after
+// tempName when empty, with the permissions mode. The directories are created 0755 and the
+// temporary ones 0700 when mode is 0, an existing dirName is left untouched.
+func createOutputDir(dirName string, tempName string, mode os.FileMode) (string, error) {
 	if len(dirName) > 0 {
-		err := osMkdir(dirName, 0755)
+		perm := mode
+		if perm == 0 {
+			perm = 0755
+		}
+		err := osMkdir(dirName, perm)
 		if err != nil {
 			if !os.IsExist(err) {
 				return "", fmt.Errorf("Unable to create destination path: %v\n", err)
 			}
+			return dirName, nil
 		}
 	} else {
 		// forcing to use /var/tmp because often it's not an in-memory tmpfs
@@ -647,5 +1779,11 @@ func createOutputDir(dirName string, tempName string) (string, error) {
 			return "", fmt.Errorf("Unable to create temporary path: %v\n", err)
 		}
 	}
+	// the permissions given to mkdir are restricted by the umask
+	if mode != 0 {
+		if err := os.Chmod(dirName, mode); err != nil {
+			return "", fmt.Errorf("Unable to set the permissions of %s: %v\n", dirName, err)
+		}
+	}
 	return dirName, nil
 }
@@ -1,16 +1,35 @@
 package inspector
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/clamav"
 	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+	"github.com/openshift/image-inspector/pkg/openscap"
 )
 
 type FailMockScanner struct{}
@@ -23,6 +42,9 @@ type NoResMockScanner struct {
 type SuccWithReportMockScanner struct {
 	SuccMockScanner
 }
+type PanicMockScanner struct {
+	FailMockScanner
+}
 
 func (ms *FailMockScanner) Scan(context.Context, string, *docker.Image, iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
 	return nil, nil, fmt.Errorf("FAIL SCANNER!")
@@ -34,6 +56,11 @@ func (ms *SuccMockScanner) Scan(context.Context, string, *docker.Image, iiapi.Fi
 	return []iiapi.Result{}, nil, nil
 }
 
+func (ms *PanicMockScanner) Scan(context.Context, string, *docker.Image, iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	var results []iiapi.Result
+	return []iiapi.Result{results[1]}, nil, nil
+}
+
 func TestScanImage(t *testing.T) {
 	ctx := context.Background()
 	for k, v := range map[string]struct {
@@ -85,17 +112,17 @@ func TestGetAuthConfigs(t *testing.T) {
 		expectedAuths int
 		shouldFail    bool
 	}{
-		"two dockercfg":               {opts: goodTwoDockerCfg, expectedAuths: 3, shouldFail: false},
+		"two dockercfg":               {opts: goodTwoDockerCfg, expectedAuths: 2, shouldFail: false},
 		"username and passwordFile":   {opts: goodUserAndPass, expectedAuths: 1, shouldFail: false},
-		"two dockercfg, one missing":  {opts: badDockerCfgMissing, expectedAuths: 2, shouldFail: false},
-		"two dockercfg, one wrong":    {opts: badDockerCfgWrong, expectedAuths: 2, shouldFail: false},
-		"two dockercfg, no auth":      {opts: badDockerCfgNoAuth, expectedAuths: 2, shouldFail: false},
+		"two dockercfg, one missing":  {opts: badDockerCfgMissing, expectedAuths: 1, shouldFail: false},
+		"two dockercfg, one wrong":    {opts: badDockerCfgWrong, expectedAuths: 1, shouldFail: false},
+		"two dockercfg, no auth":      {opts: badDockerCfgNoAuth, expectedAuths: 1, shouldFail: false},
 		"password file doens't exist": {opts: badUserAndPass, expectedAuths: 1, shouldFail: true},
-		"no auths, default expected":  {opts: goodNoAuth, expectedAuths: 1, shouldFail: false},
+		"no auths":                    {opts: goodNoAuth, expectedAuths: 0, shouldFail: false},
 	}
 
 	for k, v := range tests {
-		ii := &defaultImageInspector{*v.opts, iiapi.InspectorMetadata{}, nil}
+		ii := &defaultImageInspector{opts: *v.opts}
 		auths, err := ii.getAuthConfigs()
 		if !v.shouldFail {
 			if err != nil {
@@ -117,6 +144,35 @@ func TestGetAuthConfigs(t *testing.T) {
 	}
 }
 
+func TestGetAuthConfigsPassword(t *testing.T) {
+	defer os.Setenv(iicmd.RegistryPasswordEnv, os.Getenv(iicmd.RegistryPasswordEnv))
+	for k, v := range map[string]struct {
+		passwordFile     string
+		passwordEnv      string
+		expectedPassword string
+	}{
+		"password environment":  {passwordEnv: "env_password", expectedPassword: "env_password"},
+		"password file":         {passwordFile: "test/passwordFile1", expectedPassword: "some_password\n"},
+		"password file and env": {passwordFile: "test/passwordFile1", passwordEnv: "env_password", expectedPassword: "some_password\n"},
+	} {
+		os.Setenv(iicmd.RegistryPasswordEnv, v.passwordEnv)
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Username = "erez"
+		opts.PasswordFile = v.passwordFile
+		ii := &defaultImageInspector{opts: *opts}
+
+		auths, err := ii.getAuthConfigs()
+		if err != nil {
+			t.Errorf("%s expected to succeed but received %v", k, err)
+			continue
+		}
+		expected := map[string]docker.AuthConfiguration{"erez": {Username: "erez", Password: v.expectedPassword}}
+		if !reflect.DeepEqual(auths.Configs, expected) {
+			t.Errorf("%s expected the auths %v but got %v", k, expected, auths.Configs)
+		}
+	}
+}
+
 func Test_decodeDockerResponse(t *testing.T) {
 	no_error_input := "{\"Status\": \"fine\"}"
 	one_error := "{\"Status\": \"fine\"}{\"Error\": \"Oops\"}{\"Status\": \"fine\"}"
@@ -201,7 +257,7 @@ func TestCreateOutputDir(t *testing.T) {
 	} {
 		osMkdir = v.newMkdir
 		ioutilTempDir = v.newTempDir
-		_, err := createOutputDir(v.dirName, "temp-name-")
+		_, err := createOutputDir(v.dirName, "temp-name-", 0)
 		if v.shouldFail {
 			if err == nil {
 				t.Errorf("%s should have failed but it didn't!", k)
@@ -213,3 +269,2502 @@ func TestCreateOutputDir(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateOutputDirMode(t *testing.T) {
+	parent, err := ioutil.TempDir("", "output-dir-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(parent)
+	existing := path.Join(parent, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatalf("unable to create the existing directory: %v", err)
+	}
+	// the umask restricts the permissions of mkdir but not the configured ones
+	defer syscall.Umask(syscall.Umask(0077))
+
+	for k, v := range map[string]struct {
+		dirName  string
+		mode     os.FileMode
+		expected os.FileMode
+	}{
+		"default new dir":      {dirName: path.Join(parent, "default"), expected: 0700},
+		"configured new dir":   {dirName: path.Join(parent, "configured"), mode: 0750, expected: 0750},
+		"configured temporary": {mode: 0750, expected: 0750},
+		"default temporary":    {expected: 0700},
+		"existing dir":         {dirName: existing, mode: 0700, expected: 0755},
+	} {
+		dirName, err := createOutputDir(v.dirName, "output-dir-", v.mode)
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		if len(v.dirName) == 0 {
+			defer os.RemoveAll(dirName)
+		}
+		info, err := os.Stat(dirName)
+		if err != nil {
+			t.Errorf("%s: expected the directory to be created: %v", k, err)
+			continue
+		}
+		if info.Mode().Perm() != v.expected {
+			t.Errorf("%s: expected the permissions %#o, got %#o", k, v.expected, info.Mode().Perm())
+		}
+	}
+}
+
+// tarEntry describes an entry of a tar stream created by newTarReader.
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+// newTarball returns the content of a tar stream with the given entries.
+func newTarball(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("unable to write tar header %s: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("unable to write tar content %s: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTarReader(t *testing.T, entries ...tarEntry) *tar.Reader {
+	return tar.NewReader(bytes.NewReader(newTarball(t, entries...)))
+}
+
+func TestProcessTarStreamSymlinkPolicy(t *testing.T) {
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644}, content: "root:x:0:0"},
+		{hdr: tar.Header{Name: "rootfs/usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/usr/passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{hdr: tar.Header{Name: "rootfs/usr/escape", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"}},
+		{hdr: tar.Header{Name: "rootfs/usr/local", Typeflag: tar.TypeSymlink, Linkname: "../etc"}},
+	}
+	// the archive of --extract-path=/usr/bin has the entries relative to /usr
+	extractPathEntries := []tarEntry{
+		{hdr: tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "bin/absolute", Typeflag: tar.TypeSymlink, Linkname: "/etc/alternatives/x"}},
+		{hdr: tar.Header{Name: "bin/inside", Typeflag: tar.TypeSymlink, Linkname: "../../etc/alternatives/x"}},
+		{hdr: tar.Header{Name: "bin/escape", Typeflag: tar.TypeSymlink, Linkname: "../../../etc/alternatives/x"}},
+	}
+
+	for k, v := range map[string]struct {
+		policy string
+		// dir is the directory of the extract path, the image root is extracted when empty
+		dir             string
+		expectedTargets map[string]string
+	}{
+		"relative policy": {
+			policy: iiapi.SymlinkRelative,
+			expectedTargets: map[string]string{
+				"usr/passwd": "../etc/passwd",
+				"usr/escape": "../etc/passwd",
+				"usr/local":  "../etc",
+			},
+		},
+		"skip policy": {
+			policy: iiapi.SymlinkSkip,
+			expectedTargets: map[string]string{
+				"usr/passwd": "",
+				"usr/escape": "",
+				"usr/local":  "../etc",
+			},
+		},
+		"clamp policy": {
+			policy: iiapi.SymlinkClamp,
+			expectedTargets: map[string]string{
+				"usr/passwd": "DEST/etc/passwd",
+				"usr/escape": "DEST/etc/passwd",
+				"usr/local":  "../etc",
+			},
+		},
+		"keep policy": {
+			policy: iiapi.SymlinkKeep,
+			expectedTargets: map[string]string{
+				"usr/passwd": "/etc/passwd",
+				"usr/escape": "../../etc/passwd",
+				"usr/local":  "../etc",
+			},
+		},
+		"relative policy with extract path": {
+			policy: iiapi.SymlinkRelative,
+			dir:    "usr",
+			expectedTargets: map[string]string{
+				"usr/bin/absolute": "../../etc/alternatives/x",
+				"usr/bin/inside":   "../../etc/alternatives/x",
+				"usr/bin/escape":   "../../etc/alternatives/x",
+			},
+		},
+		"skip policy with extract path": {
+			policy: iiapi.SymlinkSkip,
+			dir:    "usr",
+			expectedTargets: map[string]string{
+				"usr/bin/absolute": "",
+				"usr/bin/inside":   "../../etc/alternatives/x",
+				"usr/bin/escape":   "",
+			},
+		},
+		"clamp policy with extract path": {
+			policy: iiapi.SymlinkClamp,
+			dir:    "usr",
+			expectedTargets: map[string]string{
+				"usr/bin/absolute": "DEST/etc/alternatives/x",
+				"usr/bin/inside":   "../../etc/alternatives/x",
+				"usr/bin/escape":   "DEST/etc/alternatives/x",
+			},
+		},
+	} {
+		dst, err := ioutil.TempDir("", "symlink-policy-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dst)
+
+		tr, opts := newTarReader(t, entries...), tarExtractOptions{prefix: DOCKER_TAR_PREFIX, symlinkPolicy: v.policy}
+		if len(v.dir) > 0 {
+			if err := os.MkdirAll(path.Join(dst, v.dir), 0755); err != nil {
+				t.Fatalf("unable to create directory: %v", err)
+			}
+			tr, opts = newTarReader(t, extractPathEntries...), tarExtractOptions{dir: v.dir, symlinkPolicy: v.policy}
+		}
+		if err := processTarStream(tr, dst, opts); err != nil {
+			t.Errorf("%s failed to process the tar stream: %v", k, err)
+			continue
+		}
+
+		for name, expected := range v.expectedTargets {
+			target, err := os.Readlink(path.Join(dst, name))
+			if len(expected) == 0 {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s expected %s to be skipped but got %q (%v)", k, name, target, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s expected %s to be a symlink but got %v", k, name, err)
+				continue
+			}
+			if expected = strings.Replace(expected, "DEST", dst, 1); target != expected {
+				t.Errorf("%s expected %s to point to %q but got %q", k, name, expected, target)
+			}
+		}
+	}
+}
+
+// mockDockerRuntimeClient is a DockerRuntimeClient that returns pullErrors in order from
+// PullImage and records the calls performed on it.
+type mockDockerRuntimeClient struct {
+	pullErrors []error
+	pulls      []docker.PullImageOptions
+	pullAuths  []docker.AuthConfiguration
+	// pullStream, when set, is called to write the pull messages and its result is returned
+	pullStream func(io.Writer) error
+	// images and exports are the metadata and docker save tarballs of the available images
+	images  map[string]*docker.Image
+	exports map[string][]byte
+	// containerImage is the image of the containers created and downloads are the tarballs
+	// of their paths, downloaded records the paths requested
+	containerImage string
+	downloads      map[string][]byte
+	downloaded     []string
+	// createErr, when set, is returned by CreateContainer
+	createErr error
+	// histories are the histories of the available images
+	histories map[string][]docker.ImageHistory
+}
+
+func (c *mockDockerRuntimeClient) InspectImage(name string) (*docker.Image, error) {
+	if image, ok := c.images[name]; ok {
+		return image, nil
+	}
+	return nil, fmt.Errorf("no such image: %s", name)
+}
+func (c *mockDockerRuntimeClient) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	c.pulls = append(c.pulls, opts)
+	c.pullAuths = append(c.pullAuths, auth)
+	if c.pullStream != nil {
+		return c.pullStream(opts.OutputStream)
+	}
+	if len(c.pullErrors) == 0 {
+		return nil
+	}
+	err := c.pullErrors[0]
+	c.pullErrors = c.pullErrors[1:]
+	return err
+}
+func (c *mockDockerRuntimeClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if c.createErr != nil {
+		return nil, c.createErr
+	}
+	if len(c.containerImage) == 0 {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return &docker.Container{ID: opts.Name, Image: c.containerImage}, nil
+}
+func (c *mockDockerRuntimeClient) RemoveContainer(docker.RemoveContainerOptions) error {
+	if len(c.containerImage) == 0 {
+		return fmt.Errorf("not implemented")
+	}
+	return nil
+}
+func (c *mockDockerRuntimeClient) InspectContainer(id string) (*docker.Container, error) {
+	if len(c.containerImage) == 0 {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return &docker.Container{ID: id, Image: c.containerImage}, nil
+}
+func (c *mockDockerRuntimeClient) ContainerChanges(string) ([]docker.Change, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *mockDockerRuntimeClient) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	c.downloaded = append(c.downloaded, opts.Path)
+	download, ok := c.downloads[opts.Path]
+	if !ok {
+		return fmt.Errorf("no such path: %s", opts.Path)
+	}
+	_, err := opts.OutputStream.Write(download)
+	return err
+}
+func (c *mockDockerRuntimeClient) ExportImage(opts docker.ExportImageOptions) error {
+	export, ok := c.exports[opts.Name]
+	if !ok {
+		return fmt.Errorf("no such image: %s", opts.Name)
+	}
+	_, err := opts.OutputStream.Write(export)
+	return err
+}
+
+func (c *mockDockerRuntimeClient) ImageHistory(name string) ([]docker.ImageHistory, error) {
+	history, ok := c.histories[name]
+	if !ok {
+		return nil, fmt.Errorf("no such image: %s", name)
+	}
+	return history, nil
+}
+
+func TestPullImageRetries(t *testing.T) {
+	oldAfter := timeAfter
+	defer func() { timeAfter = oldAfter }()
+
+	serverErr := &docker.Error{Status: 503, Message: "service unavailable"}
+	authErr := fmt.Errorf("unauthorized: authentication required")
+
+	for k, v := range map[string]struct {
+		retries          int
+		pullErrors       []error
+		expectedAttempts int
+		expectedSleeps   []time.Duration
+		shouldFail       bool
+	}{
+		"success at first attempt": {retries: 3, expectedAttempts: 1},
+		"success after retries": {
+			retries:          3,
+			pullErrors:       []error{serverErr, serverErr},
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		"give up after retries": {
+			retries:          2,
+			pullErrors:       []error{serverErr, serverErr, serverErr, serverErr},
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{time.Second, 2 * time.Second},
+			shouldFail:       true,
+		},
+		"no retries on authentication errors": {
+			retries:          3,
+			pullErrors:       []error{authErr},
+			expectedAttempts: 1,
+			shouldFail:       true,
+		},
+		"no retries configured": {
+			retries:          0,
+			pullErrors:       []error{serverErr},
+			expectedAttempts: 1,
+			shouldFail:       true,
+		},
+	} {
+		var sleeps []time.Duration
+		timeAfter = func(d time.Duration) <-chan time.Time {
+			sleeps = append(sleeps, d)
+			elapsed := make(chan time.Time, 1)
+			elapsed <- time.Now()
+			return elapsed
+		}
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.PullRetryCount = v.retries
+		opts.PullRetryInterval = time.Second
+		client := &mockDockerRuntimeClient{pullErrors: v.pullErrors}
+		ii := &defaultImageInspector{opts: *opts}
+
+		err := ii.pullImage(context.Background(), client)
+		if v.shouldFail && err == nil {
+			t.Errorf("%s should have failed but it didn't", k)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+		}
+		if len(client.pulls) != v.expectedAttempts {
+			t.Errorf("%s expected %d pull attempts but got %d", k, v.expectedAttempts, len(client.pulls))
+		}
+		if !reflect.DeepEqual(sleeps, v.expectedSleeps) {
+			t.Errorf("%s expected to wait %v between attempts but waited %v", k, v.expectedSleeps, sleeps)
+		}
+	}
+}
+
+func TestPullImageRegistryMirrors(t *testing.T) {
+	passwordFile, err := ioutil.TempFile("", "password-")
+	if err != nil {
+		t.Fatalf("unable to create the password file: %v", err)
+	}
+	defer os.Remove(passwordFile.Name())
+	passwordFile.WriteString("secret")
+	passwordFile.Close()
+	notFound := fmt.Errorf("manifest unknown")
+
+	for k, v := range map[string]struct {
+		pullErrors    []error
+		expectedPulls []string
+		expectedImage string
+		shouldFail    bool
+	}{
+		"first mirror": {
+			expectedPulls: []string{"mirror.example.com/library/fedora:26"},
+			expectedImage: "mirror.example.com/library/fedora:26",
+		},
+		"second mirror": {
+			pullErrors:    []error{notFound, notFound},
+			expectedPulls: []string{"mirror.example.com/library/fedora:26", "mirror.example.com/library/fedora:26", "registry.example.com/dockerhub/library/fedora:26"},
+			expectedImage: "registry.example.com/dockerhub/library/fedora:26",
+		},
+		"image registry": {
+			pullErrors: []error{notFound, notFound, notFound, notFound},
+			expectedPulls: []string{"mirror.example.com/library/fedora:26", "mirror.example.com/library/fedora:26",
+				"registry.example.com/dockerhub/library/fedora:26", "registry.example.com/dockerhub/library/fedora:26", "fedora:26"},
+			expectedImage: "fedora:26",
+		},
+		"all failing": {
+			pullErrors: []error{notFound, notFound, notFound, notFound, notFound, notFound},
+			expectedPulls: []string{"mirror.example.com/library/fedora:26", "mirror.example.com/library/fedora:26",
+				"registry.example.com/dockerhub/library/fedora:26", "registry.example.com/dockerhub/library/fedora:26", "fedora:26", "fedora:26"},
+			expectedImage: "fedora:26",
+			shouldFail:    true,
+		},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "fedora:26"
+		opts.Username = "user"
+		opts.PasswordFile = passwordFile.Name()
+		opts.AnonymousFallback = true
+		opts.RegistryMirrors.Values = []string{"mirror.example.com", "registry.example.com/dockerhub/"}
+		client := &mockDockerRuntimeClient{pullErrors: v.pullErrors}
+		ii := &defaultImageInspector{opts: *opts}
+
+		err := ii.pullImage(context.Background(), client)
+		if v.shouldFail && err == nil {
+			t.Errorf("%s: should have failed but it didn't", k)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s: should have succeeded but failed with %v", k, err)
+		}
+		pulls := []string{}
+		for n, pull := range client.pulls {
+			pulls = append(pulls, pull.Repository)
+			// the auths of the image registry are tried on the mirrors too
+			if expected := []string{"user", ""}[n%2]; client.pullAuths[n].Username != expected {
+				t.Errorf("%s: expected the pull %d to be authenticated as %q, got %q", k, n, expected, client.pullAuths[n].Username)
+			}
+		}
+		if !reflect.DeepEqual(pulls, v.expectedPulls) {
+			t.Errorf("%s: expected the pulls %v, got %v", k, v.expectedPulls, pulls)
+		}
+		if ii.opts.Image != v.expectedImage {
+			t.Errorf("%s: expected the image to be inspected as %s, got %s", k, v.expectedImage, ii.opts.Image)
+		}
+	}
+}
+
+func TestMirrorImageName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"fedora":                            "mirror.example.com/library/fedora:latest",
+		"quay.io/app/server:1.0":            "mirror.example.com/app/server:1.0",
+		"localhost:5000/app@sha256:0123abc": "mirror.example.com/app@sha256:0123abc",
+	} {
+		if mirrored := mirrorImageName(name, "mirror.example.com/"); mirrored != expected {
+			t.Errorf("%s: expected the mirrored name %s, got %s", name, expected, mirrored)
+		}
+	}
+}
+
+func TestPullImageReportsAuthErrors(t *testing.T) {
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.DockerCfg.Values = []string{"test/dockercfg1"}
+	opts.AnonymousFallback = true
+	client := &mockDockerRuntimeClient{pullErrors: []error{
+		fmt.Errorf("unauthorized: incorrect username or password"),
+		fmt.Errorf("pull access denied for image"),
+	}}
+	ii := &defaultImageInspector{opts: *opts}
+
+	err := ii.pullImage(context.Background(), client)
+	if err == nil {
+		t.Fatalf("expected pulling with two failing authentications to fail")
+	}
+	if len(client.pulls) != 2 {
+		t.Errorf("expected 2 pull attempts but got %d", len(client.pulls))
+	}
+	for _, reason := range []string{"incorrect username or password", "pull access denied", "test/dockercfg1"} {
+		if !strings.Contains(err.Error(), reason) {
+			t.Errorf("expected the error to contain %q but got %q", reason, err)
+		}
+	}
+	if strings.Contains(err.Error(), "<nil>") {
+		t.Errorf("expected the error to contain the failure reasons but got %q", err)
+	}
+}
+
+func TestPullImageAnonymousFallback(t *testing.T) {
+	authErr := fmt.Errorf("unauthorized: incorrect username or password")
+	dockerCfgAuths := []docker.AuthConfiguration{}
+	for _, cfg := range []string{"test/dockercfg1", "test/dockercfg2"} {
+		auths := &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{}}
+		if err := appendDockerCfgConfigs(cfg, auths); err != nil {
+			t.Fatalf("unable to read %s: %v", cfg, err)
+		}
+		names := []string{}
+		for name := range auths.Configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dockerCfgAuths = append(dockerCfgAuths, auths.Configs[name])
+		}
+	}
+	anonymous := docker.AuthConfiguration{}
+
+	for k, v := range map[string]struct {
+		dockerCfg         []string
+		anonymousFallback bool
+		pullErrors        []error
+		expectedAuths     []docker.AuthConfiguration
+		shouldFail        bool
+	}{
+		"anonymous after the failing auths": {
+			dockerCfg:         []string{"test/dockercfg1", "test/dockercfg2"},
+			anonymousFallback: true,
+			pullErrors:        []error{authErr, authErr},
+			expectedAuths:     append(append([]docker.AuthConfiguration{}, dockerCfgAuths...), anonymous),
+		},
+		"no anonymous after a successful auth": {
+			dockerCfg:         []string{"test/dockercfg1", "test/dockercfg2"},
+			anonymousFallback: true,
+			pullErrors:        []error{authErr},
+			expectedAuths:     dockerCfgAuths[:2],
+		},
+		"anonymous fallback disabled": {
+			dockerCfg:     []string{"test/dockercfg1", "test/dockercfg2"},
+			pullErrors:    []error{authErr, authErr},
+			expectedAuths: dockerCfgAuths,
+			shouldFail:    true,
+		},
+		"anonymous fallback failing": {
+			dockerCfg:         []string{"test/dockercfg1"},
+			anonymousFallback: true,
+			pullErrors:        []error{authErr, fmt.Errorf("pull access denied for image")},
+			expectedAuths:     []docker.AuthConfiguration{dockerCfgAuths[0], anonymous},
+			shouldFail:        true,
+		},
+		"anonymous only without auths": {
+			expectedAuths: []docker.AuthConfiguration{anonymous},
+		},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.PullRetryCount = 0
+		opts.DockerCfg.Values = v.dockerCfg
+		opts.AnonymousFallback = v.anonymousFallback
+		client := &mockDockerRuntimeClient{pullErrors: v.pullErrors}
+		ii := &defaultImageInspector{opts: *opts}
+
+		err := ii.pullImage(context.Background(), client)
+		if v.shouldFail && err == nil {
+			t.Errorf("%s should have failed but it didn't", k)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+		}
+		if !reflect.DeepEqual(client.pullAuths, v.expectedAuths) {
+			t.Errorf("%s expected the pull authentications %v but got %v", k, v.expectedAuths, client.pullAuths)
+		}
+		if v.shouldFail && v.anonymousFallback && (err == nil || !strings.Contains(err.Error(), ANONYMOUS_PULL_AUTH+": pull access denied")) {
+			t.Errorf("%s expected the error to report the anonymous pull but got %v", k, err)
+		}
+	}
+}
+
+func TestPullAuths(t *testing.T) {
+	named := docker.AuthConfiguration{Username: "user", Password: "password"}
+	for k, v := range map[string]struct {
+		auths             map[string]docker.AuthConfiguration
+		anonymousFallback bool
+		expected          []pullAuth
+	}{
+		"no auths": {
+			expected: []pullAuth{{name: ANONYMOUS_PULL_AUTH, anonymous: true}},
+		},
+		"auths without fallback": {
+			auths:    map[string]docker.AuthConfiguration{"b": named, "a": named},
+			expected: []pullAuth{{name: "a", auth: named}, {name: "b", auth: named}},
+		},
+		"auth named as the anonymous pull": {
+			auths:             map[string]docker.AuthConfiguration{ANONYMOUS_PULL_AUTH: named},
+			anonymousFallback: true,
+			expected:          []pullAuth{{name: ANONYMOUS_PULL_AUTH, auth: named}, {name: ANONYMOUS_PULL_AUTH, anonymous: true}},
+		},
+	} {
+		ii := &defaultImageInspector{opts: iicmd.ImageInspectorOptions{AnonymousFallback: v.anonymousFallback}}
+		auths := ii.pullAuths(&docker.AuthConfigurations{Configs: v.auths})
+		if !reflect.DeepEqual(auths, v.expected) {
+			t.Errorf("%s: expected the pull auths %v, got %v", k, v.expected, auths)
+		}
+	}
+}
+
+func TestPullImageEmptyAuths(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	authErr := fmt.Errorf("unauthorized: incorrect username or password")
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.PullRetryCount = 0
+	opts.DockerCfg.Values = []string{"test/dockercfg4"}
+	opts.AnonymousFallback = true
+	client := &mockDockerRuntimeClient{pullErrors: []error{authErr, authErr}}
+	ii := &defaultImageInspector{opts: *opts}
+
+	if err := ii.pullImage(context.Background(), client); err == nil {
+		t.Fatalf("expected the pull to fail")
+	}
+	anonymous := 0
+	for _, auth := range client.pullAuths {
+		if auth == (docker.AuthConfiguration{}) {
+			anonymous++
+		}
+	}
+	if len(client.pullAuths) != 2 || anonymous != 1 {
+		t.Errorf("expected the auth of the dockercfg and one anonymous pull, got %v", client.pullAuths)
+	}
+	for _, expected := range []string{
+		"Skipping the empty auth of quay.io in test/dockercfg4",
+		"Skipping the empty auth of registry.example.com:5000 in test/dockercfg4",
+		"Pulling image image with test/dockercfg4/172.30.203.184:5000 failed: " + authErr.Error(),
+		"Pulling image image anonymously failed: " + authErr.Error(),
+	} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("expected the logs to contain %q, got\n%s", expected, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "with "+ANONYMOUS_PULL_AUTH) {
+		t.Errorf("expected the anonymous pull to be labeled as such, got\n%s", logs.String())
+	}
+}
+
+func TestIsRetryablePullError(t *testing.T) {
+	for k, v := range map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"server error":         {err: &docker.Error{Status: 500}, retryable: true},
+		"rate limiting":        {err: &docker.Error{Status: 429}, retryable: true},
+		"server auth error":    {err: &docker.Error{Status: 500, Message: "unauthorized: authentication required"}, retryable: false},
+		"not found":            {err: &docker.Error{Status: 404}, retryable: false},
+		"network error":        {err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, retryable: true},
+		"registry timeout":     {err: fmt.Errorf("Get https://registry/v2/: net/http: TLS handshake timeout"), retryable: true},
+		"registry unavailable": {err: fmt.Errorf("received unexpected HTTP status: 503 Service Unavailable"), retryable: true},
+		"unauthorized":         {err: fmt.Errorf("unauthorized: authentication required"), retryable: false},
+		"access denied":        {err: fmt.Errorf("pull access denied for foo"), retryable: false},
+		"unknown manifest":     {err: fmt.Errorf("manifest for foo:latest not found"), retryable: false},
+	} {
+		if retryable := isRetryablePullError(v.err); retryable != v.retryable {
+			t.Errorf("%s expected retryable to be %v but got %v", k, v.retryable, retryable)
+		}
+	}
+}
+
+func TestInspectCreateContainerFailure(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	client := &mockDockerRuntimeClient{
+		images:    map[string]*docker.Image{"image": {ID: "image-id"}},
+		createErr: &docker.Error{Status: 500, Message: "unable to create the container"},
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	dstPath, err := ioutil.TempDir("", "dst-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dstPath)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.DstPath = dstPath
+	opts.PullPolicy = iiapi.PullNever
+	ii := NewDefaultImageInspector(*opts)
+
+	err = ii.Inspect()
+	if err == nil || !strings.Contains(err.Error(), "unable to create the container") {
+		t.Errorf("expected the container creation error, got %v", err)
+	}
+}
+
+func TestProcessTarStreamPathTraversal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "path-traversal-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outside := path.Join(tmpDir, "outside")
+	dst := path.Join(tmpDir, "a", "b", "root")
+	for _, dir := range []string{outside, dst} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("unable to create %s: %v", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatalf("unable to create secret file: %v", err)
+	}
+
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0644}, content: "pwned"},
+		{hdr: tar.Header{Name: "rootfs/../../../outside/dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/evil", Typeflag: tar.TypeSymlink, Linkname: outside}},
+		{hdr: tar.Header{Name: "rootfs/evil/pwned", Typeflag: tar.TypeReg, Mode: 0644}, content: "pwned"},
+		{hdr: tar.Header{Name: "rootfs/secret", Typeflag: tar.TypeLink, Linkname: "rootfs/../../../outside/secret"}},
+		{hdr: tar.Header{Name: "rootfs/passwd", Typeflag: tar.TypeSymlink, Linkname: path.Join(outside, "secret")}},
+		{hdr: tar.Header{Name: "rootfs/passwd", Typeflag: tar.TypeReg, Mode: 0644}, content: "overwritten"},
+		{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+	}
+
+	// the insecure policy keeps the absolute symlinks that an attacker could write through
+	opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX, symlinkPolicy: iiapi.SymlinkKeep}
+	if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+		t.Fatalf("unable to process the tar stream: %v", err)
+	}
+
+	for _, p := range []string{
+		path.Join(tmpDir, "a", "etc", "passwd"),
+		path.Join(outside, "dir"),
+		path.Join(outside, "pwned"),
+		path.Join(dst, "secret"),
+	} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be created, got %v", p, err)
+		}
+	}
+	if content, err := ioutil.ReadFile(path.Join(outside, "secret")); err != nil || string(content) != "secret" {
+		t.Errorf("expected the file outside of the extraction root to be untouched, got %q (%v)", content, err)
+	}
+	if content, err := ioutil.ReadFile(path.Join(dst, "passwd")); err != nil || string(content) != "overwritten" {
+		t.Errorf("expected passwd to be replaced by a regular file, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(path.Join(dst, "etc", "hosts")); err != nil {
+		t.Errorf("expected the entries inside the extraction root to be extracted: %v", err)
+	}
+}
+
+func TestProcessTarStreamReplacedEntries(t *testing.T) {
+	dst, err := ioutil.TempDir("", "replaced-entries-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/file-to-dir", Typeflag: tar.TypeReg, Mode: 0644}, content: "file"},
+		{hdr: tar.Header{Name: "rootfs/file-to-dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/file-to-dir/nested", Typeflag: tar.TypeReg, Mode: 0644}, content: "nested"},
+		{hdr: tar.Header{Name: "rootfs/dir-to-symlink/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/dir-to-symlink/nested", Typeflag: tar.TypeReg, Mode: 0644}, content: "nested"},
+		{hdr: tar.Header{Name: "rootfs/dir-to-symlink", Typeflag: tar.TypeSymlink, Linkname: "file-to-dir"}},
+		{hdr: tar.Header{Name: "rootfs/dir-to-file/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/dir-to-file", Typeflag: tar.TypeReg, Mode: 0644}, content: "file"},
+		{hdr: tar.Header{Name: "rootfs/symlink-to-symlink", Typeflag: tar.TypeSymlink, Linkname: "dir-to-file"}},
+		{hdr: tar.Header{Name: "rootfs/symlink-to-symlink", Typeflag: tar.TypeSymlink, Linkname: "file-to-dir"}},
+		{hdr: tar.Header{Name: "rootfs/file-to-link", Typeflag: tar.TypeReg, Mode: 0644}, content: "file"},
+		{hdr: tar.Header{Name: "rootfs/file-to-link", Typeflag: tar.TypeLink, Linkname: "rootfs/dir-to-file"}},
+		{hdr: tar.Header{Name: "rootfs/merged/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/merged/first", Typeflag: tar.TypeReg, Mode: 0644}, content: "first"},
+		{hdr: tar.Header{Name: "rootfs/merged/", Typeflag: tar.TypeDir, Mode: 0700}},
+		{hdr: tar.Header{Name: "rootfs/merged/second", Typeflag: tar.TypeReg, Mode: 0644}, content: "second"},
+	}
+	opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX, symlinkPolicy: iiapi.SymlinkKeep}
+	if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+		t.Fatalf("unable to process the tar stream: %v", err)
+	}
+
+	for k, v := range map[string]struct {
+		mode   os.FileMode
+		target string
+	}{
+		"file-to-dir":        {mode: os.ModeDir},
+		"file-to-dir/nested": {},
+		"dir-to-symlink":     {mode: os.ModeSymlink, target: "file-to-dir"},
+		"dir-to-file":        {},
+		"symlink-to-symlink": {mode: os.ModeSymlink, target: "file-to-dir"},
+		"file-to-link":       {},
+		"merged":             {mode: os.ModeDir},
+		"merged/first":       {},
+		"merged/second":      {},
+	} {
+		fi, err := os.Lstat(path.Join(dst, k))
+		if err != nil {
+			t.Errorf("%s: expected to be extracted: %v", k, err)
+			continue
+		}
+		if fi.Mode()&os.ModeType != v.mode {
+			t.Errorf("%s: expected the type %v, got %v", k, v.mode, fi.Mode()&os.ModeType)
+		}
+		if len(v.target) > 0 {
+			if target, err := os.Readlink(path.Join(dst, k)); err != nil || target != v.target {
+				t.Errorf("%s: expected the target %s, got %s (%v)", k, v.target, target, err)
+			}
+		}
+	}
+	if content, err := ioutil.ReadFile(path.Join(dst, "file-to-link")); err != nil || string(content) != "file" {
+		t.Errorf("expected file-to-link to be a link to dir-to-file, got %q (%v)", content, err)
+	}
+}
+
+func TestNewDefaultImageInspectorTimestamps(t *testing.T) {
+	inspectors := map[bool]*defaultImageInspector{}
+	for _, utc := range []bool{false, true} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.UTCTimestamps = utc
+		inspectors[utc] = NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	}
+
+	// the inspectors are created first to make sure that their settings are independent
+	for k, v := range map[string]struct {
+		utc    bool
+		layout string
+	}{
+		"local timestamps": {utc: false, layout: time.RFC850},
+		"utc timestamps":   {utc: true, layout: time.RFC3339},
+	} {
+		ii := inspectors[v.utc]
+		ts, err := time.Parse(v.layout, ii.meta.OpenSCAP.ContentTimeStamp)
+		if err != nil {
+			t.Errorf("%s: unexpected timestamp %q: %v", k, ii.meta.OpenSCAP.ContentTimeStamp, err)
+			continue
+		}
+		if v.utc && (!strings.HasSuffix(ii.meta.OpenSCAP.ContentTimeStamp, "Z") || ts.Location() != time.UTC) {
+			t.Errorf("%s: expected a UTC timestamp, got %q", k, ii.meta.OpenSCAP.ContentTimeStamp)
+		}
+		if meta := NewInspectorMetadata(&docker.Image{}, ii.timestamps); strings.HasSuffix(meta.OpenSCAP.ContentTimeStamp, "Z") != v.utc {
+			t.Errorf("%s: expected the new metadata to honor the timestamps, got %q", k, meta.OpenSCAP.ContentTimeStamp)
+		}
+		if result := ii.timestamps.Time(time.Now()); v.utc != (result.Location() == time.UTC) {
+			t.Errorf("%s: unexpected location %v of the result timestamps", k, result.Location())
+		}
+
+		ii.meta.OpenSCAP.SetError(fmt.Errorf("scan failed"), ii.timestamps)
+		if _, err := time.Parse(v.layout, ii.meta.OpenSCAP.ContentTimeStamp); err != nil {
+			t.Errorf("%s: unexpected error timestamp %q: %v", k, ii.meta.OpenSCAP.ContentTimeStamp, err)
+		}
+	}
+}
+
+func TestInspectRecoversScannerPanics(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	layer := newTarball(t, tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}})
+	client := &mockDockerRuntimeClient{
+		images:  map[string]*docker.Image{"image": {ID: "image-id"}},
+		exports: map[string][]byte{"image": newImageTarball(t, map[string][]byte{"layer.tar": layer}, []string{"layer.tar"})},
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	tmpDir, err := ioutil.TempDir("", "scanner-panics-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for k, v := range map[string]struct {
+		scanType string
+		scanner  iiapi.Scanner
+		// expectedError is the error of Inspect, a panic is never an inspection error
+		expectedError bool
+	}{
+		"openscap panic": {scanType: "openscap", scanner: &PanicMockScanner{}},
+		"clamav panic":   {scanType: "clamav", scanner: &PanicMockScanner{}},
+		"clamav failure": {scanType: "clamav", scanner: &FailMockScanner{}, expectedError: true},
+		"clamav success": {scanType: "clamav", scanner: &SuccMockScanner{}},
+		"unowned panic":  {scanType: "unowned", scanner: &PanicMockScanner{}},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.PullPolicy = iiapi.PullNever
+		opts.DstPath = path.Join(tmpDir, strings.Replace(k, " ", "-", -1))
+		opts.LayerCacheDir = path.Join(tmpDir, "cache")
+		opts.ScanType = v.scanType
+		opts.ScanResultsDir = path.Join(tmpDir, "results")
+		// the inspection completes, it fails only at the end with fail-on-scan-error
+		opts.FailOnScanError = false
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return v.scanner, nil
+		}
+
+		err = ii.Inspect()
+		if v.expectedError != (err != nil) {
+			t.Errorf("%s: expected the inspection error to be %t, got %v", k, v.expectedError, err)
+		}
+		_, isPanic := v.scanner.(*PanicMockScanner)
+		if len(ii.meta.Scanners) != 1 || isPanic != strings.Contains(ii.meta.Scanners[0].ErrorMessage, "MockScanner panicked") {
+			t.Errorf("%s: unexpected scanners metadata %+v", k, ii.meta.Scanners)
+		}
+		if v.scanType == "openscap" && ii.meta.OpenSCAP.Status != iiapi.StatusError {
+			t.Errorf("%s: expected the panicking scanner to be marked failed, status is %s", k, ii.meta.OpenSCAP.Status)
+		}
+	}
+}
+
+func TestInspectScanStatus(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	for k, v := range map[string]struct {
+		scanner         iiapi.Scanner
+		failOnScanError bool
+		expectedStatus  iiapi.ScanStatus
+		expectedError   bool
+	}{
+		"clean":                    {scanner: &SuccMockScanner{}, failOnScanError: true, expectedStatus: iiapi.ScanStatusClean},
+		"findings":                 {scanner: &resultsMockScanner{results: []iiapi.Result{finding("clamav", "file:///virus")}}, failOnScanError: true, expectedStatus: iiapi.ScanStatusFindings},
+		"all scanners failed":      {scanner: &PanicMockScanner{}, failOnScanError: true, expectedStatus: iiapi.ScanStatusError, expectedError: true},
+		"without fail on scan err": {scanner: &PanicMockScanner{}, expectedStatus: iiapi.ScanStatusError},
+	} {
+		dir, err := ioutil.TempDir("", "output-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "clamav"
+		opts.OutputFile = path.Join(dir, "results.json")
+		opts.FailOnScanError = v.failOnScanError
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return v.scanner, nil
+		}
+
+		err = ii.Inspect()
+		if v.expectedError != (err != nil) {
+			t.Errorf("%s: expected the inspection error to be %t, got %v", k, v.expectedError, err)
+		}
+		// the results are written with the status before the inspection fails
+		content, err := ioutil.ReadFile(opts.OutputFile)
+		if err != nil {
+			t.Errorf("%s: expected the results file to be written: %v", k, err)
+			continue
+		}
+		var results iiapi.ScanResult
+		if err := json.Unmarshal(content, &results); err != nil {
+			t.Errorf("%s: expected the results file to hold JSON, got %q: %v", k, content, err)
+			continue
+		}
+		if results.Status != v.expectedStatus {
+			t.Errorf("%s: expected the status %q, got %q with the results %v", k, v.expectedStatus, results.Status, results.Results)
+		}
+	}
+}
+
+func TestInspectRecordsScannerMetadata(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	for k, v := range map[string]struct {
+		scanType       string
+		scanner        iiapi.Scanner
+		expectedStatus iiapi.OpenSCAPStatus
+		expectedError  string
+	}{
+		"clamav panic":    {scanType: "clamav", scanner: &PanicMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "MockScanner panicked"},
+		"unowned failure": {scanType: "unowned", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+		"unowned success": {scanType: "unowned", scanner: &SuccMockScanner{}, expectedStatus: iiapi.StatusSuccess},
+		"hygiene failure": {scanType: "hygiene", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+		"kmod failure":    {scanType: "kmod", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+		"perms failure":   {scanType: "permissions", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+		"shadow failure":  {scanType: "shadowed", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+		"openscap error":  {scanType: "openscap", scanner: &FailMockScanner{}, expectedStatus: iiapi.StatusError, expectedError: "FAIL SCANNER!"},
+	} {
+		resultsDir, err := ioutil.TempDir("", "results-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(resultsDir)
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = v.scanType
+		opts.ScanResultsDir = resultsDir
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return v.scanner, nil
+		}
+		ii.Inspect()
+
+		body, err := json.Marshal(ii.meta)
+		if err != nil {
+			t.Fatalf("%s: unable to serialize the metadata: %v", k, err)
+		}
+		var meta struct {
+			OpenSCAP *iiapi.OpenSCAPMetadata
+			Scanners []map[string]string
+		}
+		if err := json.Unmarshal(body, &meta); err != nil {
+			t.Fatalf("%s: unable to deserialize the metadata: %v", k, err)
+		}
+		if len(meta.Scanners) != 1 {
+			t.Errorf("%s: expected one scanner record, got %v", k, meta.Scanners)
+			continue
+		}
+		scan := meta.Scanners[0]
+		if scan["Name"] != "MockScanner" || scan["Status"] != string(v.expectedStatus) ||
+			!strings.Contains(scan["ErrorMessage"], v.expectedError) || len(scan["ContentTimeStamp"]) == 0 {
+			t.Errorf("%s: unexpected scanner record %v", k, scan)
+		}
+		if len(v.expectedError) == 0 {
+			if _, ok := scan["ErrorMessage"]; ok {
+				t.Errorf("%s: expected no error message, got %v", k, scan)
+			}
+		}
+		// the OpenSCAP status is kept for backward compatibility
+		expectedOpenSCAP := iiapi.StatusNotRequested
+		if v.scanType == "openscap" {
+			expectedOpenSCAP = v.expectedStatus
+		}
+		if meta.OpenSCAP == nil || meta.OpenSCAP.Status != expectedOpenSCAP {
+			t.Errorf("%s: expected the OpenSCAP status %s, got %v", k, expectedOpenSCAP, meta.OpenSCAP)
+		}
+	}
+}
+
+// reportMockScanner is a SuccMockScanner returning a report.
+type reportMockScanner struct {
+	SuccMockScanner
+	report interface{}
+}
+
+func (s *reportMockScanner) Scan(ctx context.Context, path string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	return nil, s.report, nil
+}
+
+func TestInspectRecordsClamAVErrors(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	for k, v := range map[string]struct {
+		report          interface{}
+		expected        []string
+		expectedSkipped []string
+	}{
+		"no report": {},
+		"no errors": {report: clamav.ClamScanReport{}},
+		"errors": {
+			report:   clamav.ClamScanReport{Errors: []string{"file:///usr/bin/locked: permission denied", "clamd is reloading"}},
+			expected: []string{"file:///usr/bin/locked: permission denied", "clamd is reloading"},
+		},
+		"skipped files": {
+			report:          clamav.ClamScanReport{Skipped: []string{"file:///var/lib/db: skipped"}},
+			expectedSkipped: []string{"file:///var/lib/db: skipped"},
+		},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "clamav"
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &reportMockScanner{report: v.report}, nil
+		}
+		if err := ii.Inspect(); err != nil {
+			t.Errorf("%s: expected the inspection to succeed but failed with %v", k, err)
+			continue
+		}
+		if len(ii.meta.Scanners) != 1 || ii.meta.Scanners[0].Status != iiapi.StatusSuccess {
+			t.Errorf("%s: expected a successful scanner record, got %v", k, ii.meta.Scanners)
+			continue
+		}
+		if !reflect.DeepEqual(ii.meta.Scanners[0].Errors, v.expected) {
+			t.Errorf("%s: expected the scanner errors %v, got %v", k, v.expected, ii.meta.Scanners[0].Errors)
+		}
+		if !reflect.DeepEqual(ii.meta.Scanners[0].Skipped, v.expectedSkipped) {
+			t.Errorf("%s: expected the skipped notes %v, got %v", k, v.expectedSkipped, ii.meta.Scanners[0].Skipped)
+		}
+	}
+}
+
+func TestRemoveStaleReports(t *testing.T) {
+	for k, v := range map[string]struct {
+		strict     bool
+		shouldFail bool
+	}{
+		"stale report is removed":           {strict: false, shouldFail: false},
+		"stale report fails in strict mode": {strict: true, shouldFail: true},
+	} {
+		dir, err := ioutil.TempDir("", "image-inspector-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		report := path.Join(dir, openscap.ArfResultFile)
+		if err := ioutil.WriteFile(report, []byte("<stale/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err = removeStaleReports(dir, v.strict)
+		if v.shouldFail && err == nil {
+			t.Errorf("%s should have failed but it didn't!", k)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+		}
+		_, statErr := os.Stat(report)
+		if v.strict && statErr != nil {
+			t.Errorf("%s: the report should have been left in place: %v", k, statErr)
+		}
+		if !v.strict && !os.IsNotExist(statErr) {
+			t.Errorf("%s: the report should have been removed", k)
+		}
+	}
+}
+
+func TestProcessTarStreamSpecialFiles(t *testing.T) {
+	defer func() { syscallMknod = syscall.Mknod }()
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/dev/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+		{hdr: tar.Header{Name: "rootfs/dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Devmajor: 8, Devminor: 0}},
+		{hdr: tar.Header{Name: "rootfs/run/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/run/initctl", Typeflag: tar.TypeFifo, Mode: 0600}},
+	}
+
+	for k, v := range map[string]struct {
+		extract    bool
+		mknodErr   error
+		expectFifo bool
+		expectDevs map[string]int
+	}{
+		"special files not requested": {extract: false},
+		"unprivileged extraction":     {extract: true, mknodErr: syscall.EPERM, expectFifo: true},
+		"privileged extraction": {extract: true, expectFifo: true,
+			expectDevs: map[string]int{"dev/null": mkdev(1, 3), "dev/sda": mkdev(8, 0)}},
+	} {
+		dst, err := ioutil.TempDir("", "special-files-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dst)
+
+		devs := map[string]int{}
+		syscallMknod = func(p string, mode uint32, dev int) error {
+			if v.mknodErr != nil {
+				return v.mknodErr
+			}
+			rel := strings.TrimPrefix(p, dst+"/")
+			devs[rel] = dev
+			return ioutil.WriteFile(p, nil, 0600)
+		}
+
+		opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX, extractSpecialFiles: v.extract}
+		if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+			t.Errorf("%s: unable to process the tar stream: %v", k, err)
+			continue
+		}
+
+		fi, err := os.Lstat(path.Join(dst, "run", "initctl"))
+		if v.expectFifo && (err != nil || fi.Mode()&os.ModeNamedPipe == 0) {
+			t.Errorf("%s: expected a FIFO to be created, got %v (%v)", k, fi, err)
+		}
+		if !v.expectFifo && !os.IsNotExist(err) {
+			t.Errorf("%s: expected the FIFO to be skipped, got %v", k, err)
+		}
+		if len(v.expectDevs) == 0 {
+			v.expectDevs = map[string]int{}
+		}
+		if !reflect.DeepEqual(devs, v.expectDevs) {
+			t.Errorf("%s: expected devices %v, got %v", k, v.expectDevs, devs)
+		}
+	}
+}
+
+func TestProcessTarStreamModes(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(0077))
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/etc/shadow", Typeflag: tar.TypeReg, Mode: 0640}, content: "root:*::"},
+		{hdr: tar.Header{Name: "rootfs/etc/server.key", Typeflag: tar.TypeReg, Mode: 0644}, content: "key"},
+		{hdr: tar.Header{Name: "rootfs/etc/readonly", Typeflag: tar.TypeReg, Mode: 0444}, content: "ro"},
+	}
+	dst, err := ioutil.TempDir("", "modes-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX}
+	if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+		t.Fatalf("unable to process the tar stream: %v", err)
+	}
+	// the owner is always given the permission to write
+	for name, expected := range map[string]os.FileMode{
+		"etc/shadow":     0640,
+		"etc/server.key": 0644,
+		"etc/readonly":   0644,
+	} {
+		info, err := os.Stat(path.Join(dst, name))
+		if err != nil {
+			t.Errorf("expected %s to be extracted: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("expected the permissions of %s to be %#o, got %#o", name, expected, info.Mode().Perm())
+		}
+	}
+}
+
+func TestProcessTarStreamXattrs(t *testing.T) {
+	// cap_net_bind_service+ep
+	capability := "\x01\x00\x00\x02\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/usr/ping", Typeflag: tar.TypeReg, Mode: 0755, Format: tar.FormatPAX,
+			PAXRecords: map[string]string{paxSchilyXattr + "security.capability": capability}}, content: "ping"},
+		{hdr: tar.Header{Name: "rootfs/usr/note", Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatPAX,
+			PAXRecords: map[string]string{paxSchilyXattr + "user.comment": "hello"}}, content: "note"},
+	}
+
+	for k, v := range map[string]struct {
+		preserve bool
+	}{
+		"xattrs not requested": {preserve: false},
+		"xattrs preserved":     {preserve: true},
+	} {
+		dst, err := ioutil.TempDir("", "xattrs-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dst)
+
+		opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX, preserveXattrs: v.preserve}
+		if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+			t.Errorf("%s: unable to process the tar stream: %v", k, err)
+			continue
+		}
+
+		for name, xattr := range map[string]struct{ key, value string }{
+			"usr/ping": {"security.capability", capability},
+			"usr/note": {"user.comment", "hello"},
+		} {
+			buf := make([]byte, 64)
+			n, err := syscall.Getxattr(path.Join(dst, name), xattr.key, buf)
+			if n < 0 {
+				n = 0
+			}
+			if !v.preserve {
+				if err == nil {
+					t.Errorf("%s: expected %s of %s not to be set", k, xattr.key, name)
+				}
+				continue
+			}
+			if err == syscall.ENOTSUP || (err == syscall.ENODATA && os.Geteuid() != 0) {
+				t.Logf("%s: %s not supported on this system: %v", k, xattr.key, err)
+				continue
+			}
+			if err != nil || string(buf[:n]) != xattr.value {
+				t.Errorf("%s: expected %s of %s to be %q, got %q (%v)", k, xattr.key, name, xattr.value, buf[:n], err)
+			}
+		}
+	}
+}
+
+func TestProcessTarStreamRejectedXattrs(t *testing.T) {
+	oldSetxattr := syscallSetxattr
+	defer func() { syscallSetxattr = oldSetxattr }()
+	set := map[string]bool{}
+	syscallSetxattr = func(path string, attr string, data []byte, flags int) error {
+		switch attr {
+		case "security.selinux":
+			return syscall.EINVAL
+		case "user.large":
+			return syscall.E2BIG
+		}
+		set[attr] = true
+		return nil
+	}
+
+	dst, err := ioutil.TempDir("", "xattrs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	err = processTarStream(newTarReader(t,
+		tarEntry{hdr: tar.Header{Name: "rootfs/ping", Typeflag: tar.TypeReg, Mode: 0755, Format: tar.FormatPAX,
+			PAXRecords: map[string]string{
+				paxSchilyXattr + "security.selinux":    "system_u:object_r:unknown_t:s0",
+				paxSchilyXattr + "user.large":          "large",
+				paxSchilyXattr + "security.capability": "capability",
+			}}, content: "ping"},
+		tarEntry{hdr: tar.Header{Name: "rootfs/next", Typeflag: tar.TypeReg, Mode: 0644}, content: "next"},
+	), dst, tarExtractOptions{prefix: DOCKER_TAR_PREFIX, preserveXattrs: true})
+	if err != nil {
+		t.Fatalf("expected the rejected extended attributes to be skipped, got %v", err)
+	}
+	if !set["security.capability"] {
+		t.Errorf("expected the other extended attributes to be set")
+	}
+	if _, err := os.Stat(path.Join(dst, "next")); err != nil {
+		t.Errorf("expected the extraction to continue: %v", err)
+	}
+}
+
+func TestPullImageCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	pullDone := make(chan error, 1)
+	client := &mockDockerRuntimeClient{
+		pullStream: func(w io.Writer) error {
+			// keep sending progress messages until the stream is closed
+			for n := 0; ; n++ {
+				msg := fmt.Sprintf(`{"status":"Downloading","id":"layer","progressDetail":{"current":%d,"total":1000000}}`, n)
+				if _, err := w.Write([]byte(msg)); err != nil {
+					pullDone <- err
+					return err
+				}
+				if n == 0 {
+					close(started)
+				}
+			}
+		},
+	}
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	ii := &defaultImageInspector{opts: *opts}
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- ii.pullImage(ctx, client) }()
+
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the pull was not aborted when cancelling the context")
+	}
+	select {
+	case <-pullDone:
+	case <-time.After(10 * time.Second):
+		t.Errorf("the pull stream was not closed when cancelling the context")
+	}
+	if len(client.pulls) != 1 {
+		t.Errorf("expected a single pull attempt, got %d", len(client.pulls))
+	}
+}
+
+func TestCreateAndExtractImagePaths(t *testing.T) {
+	rootTar := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "rootfs/etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+	)
+	binTar := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "bin/ls", Typeflag: tar.TypeReg, Mode: 0755}, content: "ls"},
+	)
+	etcTar := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+	)
+
+	for k, v := range map[string]struct {
+		extractPaths      []string
+		expectedDownloads []string
+		expectedFiles     []string
+		shouldFail        bool
+	}{
+		"whole filesystem": {
+			expectedDownloads: []string{"/"},
+			expectedFiles:     []string{"etc/hosts"},
+		},
+		"subset of the filesystem": {
+			extractPaths:      []string{"/usr/bin", "/etc/"},
+			expectedDownloads: []string{"/usr/bin", "/etc"},
+			expectedFiles:     []string{"usr/bin/ls", "etc/hosts"},
+		},
+		"missing path": {
+			extractPaths:      []string{"/usr/bin", "/missing"},
+			expectedDownloads: []string{"/usr/bin", "/missing"},
+			shouldFail:        true,
+		},
+	} {
+		dst, err := ioutil.TempDir("", "extract-paths-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dst)
+
+		client := &mockDockerRuntimeClient{
+			images:         map[string]*docker.Image{"image-id": {ID: "image-id"}},
+			containerImage: "image-id",
+			downloads:      map[string][]byte{"/": rootTar, "/usr/bin": binTar, "/etc": etcTar},
+		}
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = dst
+		opts.ExtractPaths.Values = v.extractPaths
+		ii := &defaultImageInspector{opts: *opts}
+
+		_, err = ii.createAndExtractImage(context.Background(), client, "container")
+		if v.shouldFail && err == nil {
+			t.Errorf("%s should have failed but it didn't", k)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+		}
+		if !reflect.DeepEqual(client.downloaded, v.expectedDownloads) {
+			t.Errorf("%s expected the paths %v to be downloaded, got %v", k, v.expectedDownloads, client.downloaded)
+		}
+		for _, f := range v.expectedFiles {
+			if _, err := os.Stat(path.Join(dst, f)); err != nil {
+				t.Errorf("%s expected %s to be extracted: %v", k, f, err)
+			}
+		}
+	}
+}
+
+func TestCreateAndExtractImageAbortedExtraction(t *testing.T) {
+	tarball := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "rootfs/etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+	)
+	// the trailing data is larger than what the tar reader reads ahead, the download blocks
+	// writing it unless the stream is consumed to the end
+	trailing := bytes.Repeat([]byte{0xff}, 1<<20)
+
+	for k, v := range map[string]struct {
+		download      []byte
+		expectedError string
+	}{
+		"corrupted archive": {
+			download:      append(append([]byte{}, trailing...), tarball...),
+			expectedError: "Unable to extract container path /",
+		},
+		"data after the archive": {download: append(append([]byte{}, tarball...), trailing...)},
+	} {
+		dst, err := ioutil.TempDir("", "extract-aborted-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dst)
+
+		client := &mockDockerRuntimeClient{
+			images:         map[string]*docker.Image{"image-id": {ID: "image-id"}},
+			containerImage: "image-id",
+			downloads:      map[string][]byte{"/": v.download},
+		}
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = dst
+		ii := &defaultImageInspector{opts: *opts}
+
+		extracted := make(chan error, 1)
+		go func() {
+			_, err := ii.createAndExtractImage(context.Background(), client, "container")
+			extracted <- err
+		}()
+		select {
+		case err = <-extracted:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: expected the extraction to return, it's still blocked", k)
+		}
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+		}
+		if _, err := os.Stat(path.Join(dst, "etc/hosts")); err != nil {
+			t.Errorf("%s: expected etc/hosts to be extracted: %v", k, err)
+		}
+	}
+}
+
+func TestPostResultsStatus(t *testing.T) {
+	oldAfter := timeAfter
+	defer func() { timeAfter = oldAfter }()
+
+	for k, v := range map[string]struct {
+		statuses         []int
+		body             string
+		retries          int
+		expectedAttempts int
+		expectedSleeps   []time.Duration
+		expectedError    string
+	}{
+		"ok": {
+			statuses:         []int{http.StatusOK},
+			retries:          3,
+			expectedAttempts: 1,
+		},
+		"accepted": {
+			statuses:         []int{http.StatusAccepted},
+			retries:          3,
+			expectedAttempts: 1,
+		},
+		"forbidden is not retried": {
+			statuses:         []int{http.StatusForbidden},
+			body:             "invalid token",
+			retries:          3,
+			expectedAttempts: 1,
+			expectedError:    "403 Forbidden: invalid token",
+		},
+		"sink error then success": {
+			statuses:         []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK},
+			retries:          3,
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{POST_RETRY_INTERVAL, 2 * POST_RETRY_INTERVAL},
+		},
+		"retries exhausted": {
+			statuses:         []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			body:             "database is down",
+			retries:          2,
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{POST_RETRY_INTERVAL, 2 * POST_RETRY_INTERVAL},
+			expectedError:    "500 Internal Server Error: database is down",
+		},
+		"no retries": {
+			statuses:         []int{http.StatusInternalServerError},
+			retries:          0,
+			expectedAttempts: 1,
+			expectedError:    "500 Internal Server Error",
+		},
+	} {
+		var sleeps []time.Duration
+		timeAfter = func(d time.Duration) <-chan time.Time {
+			sleeps = append(sleeps, d)
+			elapsed := make(chan time.Time, 1)
+			elapsed <- time.Now()
+			return elapsed
+		}
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := int(atomic.AddInt32(&attempts, 1))
+			status := v.statuses[len(v.statuses)-1]
+			if attempt <= len(v.statuses) {
+				status = v.statuses[attempt-1]
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(v.body))
+		}))
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.PostResultURL = server.URL
+		opts.PostResultRetries = v.retries
+		ii := &defaultImageInspector{opts: *opts}
+		err := ii.postResults(context.Background(), iiapi.ScanResult{APIVersion: iiapi.DefaultResultsAPIVersion}, "", "")
+		server.Close()
+
+		if int(attempts) != v.expectedAttempts {
+			t.Errorf("%s: expected %d attempts, got %d", k, v.expectedAttempts, attempts)
+		}
+		if !reflect.DeepEqual(sleeps, v.expectedSleeps) {
+			t.Errorf("%s: expected the waits %v, got %v", k, v.expectedSleeps, sleeps)
+		}
+		if len(v.expectedError) == 0 {
+			if err != nil {
+				t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+		}
+	}
+}
+
+func TestPostResultsTimeout(t *testing.T) {
+	oldAfter := timeAfter
+	defer func() { timeAfter = oldAfter }()
+	timeAfter = func(time.Duration) <-chan time.Time {
+		elapsed := make(chan time.Time, 1)
+		elapsed <- time.Now()
+		return elapsed
+	}
+
+	release := make(chan struct{})
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// the first attempt hangs until it times out
+			<-release
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.PostResultURL = server.URL
+	opts.PostResultRetries = 1
+	opts.PostTimeout = 50 * time.Millisecond
+	ii := &defaultImageInspector{opts: *opts}
+	if err := ii.postResults(context.Background(), iiapi.ScanResult{APIVersion: iiapi.DefaultResultsAPIVersion}, "", ""); err != nil {
+		t.Errorf("expected the timed out attempt to be retried, got %v", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestInspectPostResultsFailure(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "unowned"
+	opts.PostResultURL = server.URL
+	for _, ignore := range []bool{false, true} {
+		opts.IgnorePostErrors = ignore
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &SuccMockScanner{}, nil
+		}
+		err := ii.Inspect()
+		if ignore && err != nil {
+			t.Errorf("expected the post failure to be ignored, got %v", err)
+		}
+		// the error is propagated by default
+		if !ignore && (err == nil || !strings.Contains(err.Error(), "403 Forbidden")) {
+			t.Errorf("expected the inspection to fail posting the results, got %v", err)
+		}
+	}
+}
+
+func TestPostResultsMultipart(t *testing.T) {
+	type part struct {
+		filename, contentType, content string
+	}
+	var (
+		contentType string
+		parts       map[string]part
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		parts = map[string]part{}
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "multipart/form-data" {
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			content, _ := ioutil.ReadAll(p)
+			parts[p.FormName()] = part{p.FileName(), p.Header.Get("Content-Type"), string(content)}
+		}
+	}))
+	defer server.Close()
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.PostResultURL = server.URL
+	opts.PostMultipart = true
+	ii := &defaultImageInspector{opts: *opts}
+	results := iiapi.ScanResult{APIVersion: iiapi.DefaultResultsAPIVersion, ImageName: "docker.io/library/fedora:latest"}
+
+	reportsDir, err := ioutil.TempDir("", "reports-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(reportsDir)
+	arfReport := path.Join(reportsDir, openscap.ArfResultFile)
+	htmlReport := path.Join(reportsDir, openscap.HTMLResultFile)
+	if err := ioutil.WriteFile(arfReport, []byte("<arf/>"), 0644); err != nil {
+		t.Fatalf("unable to write the report: %v", err)
+	}
+	if err := ioutil.WriteFile(htmlReport, []byte("<html/>"), 0644); err != nil {
+		t.Fatalf("unable to write the report: %v", err)
+	}
+
+	if err := ii.postResults(context.Background(), results, arfReport, htmlReport); err != nil {
+		t.Fatalf("unable to post the results: %v", err)
+	}
+	if !strings.HasPrefix(contentType, "multipart/form-data; boundary=") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	resultJSON, _ := json.Marshal(results)
+	expected := map[string]part{
+		MULTIPART_RESULTS_FIELD:     {"results.json", "application/json", string(resultJSON)},
+		MULTIPART_ARF_REPORT_FIELD:  {openscap.ArfResultFile, "application/xml", "<arf/>"},
+		MULTIPART_HTML_REPORT_FIELD: {openscap.HTMLResultFile, "text/html", "<html/>"},
+	}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Errorf("expected the parts %v, got %v", expected, parts)
+	}
+
+	// reports that are not available are not attached
+	if err := ii.postResults(context.Background(), results, "", ""); err != nil {
+		t.Fatalf("unable to post the results: %v", err)
+	}
+	if _, ok := parts[MULTIPART_RESULTS_FIELD]; !ok || len(parts) != 1 {
+		t.Errorf("expected only the results part, got %v", parts)
+	}
+}
+
+func TestInspectRootfsPath(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	if err := processTarStream(newTarReader(t,
+		tarEntry{hdr: tar.Header{Name: "var/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "var/lib/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "var/lib/dpkg/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "var/lib/dpkg/info/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "var/lib/dpkg/info/base.list", Typeflag: tar.TypeReg, Mode: 0644}, content: "/bin/sh\n"},
+		tarEntry{hdr: tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "bin/sh", Typeflag: tar.TypeReg, Mode: 0755}, content: "sh"},
+	), rootfs, tarExtractOptions{}); err != nil {
+		t.Fatalf("unable to create the root filesystem: %v", err)
+	}
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	// any attempt to use docker fails
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "unowned"
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("the inspection of the root filesystem failed: %v", err)
+	}
+	if ii.opts.DstPath != rootfs {
+		t.Errorf("expected %s to be scanned, got %s", rootfs, ii.opts.DstPath)
+	}
+	if ii.meta.RootfsPath != rootfs || len(ii.meta.Image.ID) > 0 {
+		t.Errorf("expected the metadata of the root filesystem %s, got %v", rootfs, ii.meta)
+	}
+}
+
+func TestInspectScanVolumesOnly(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	dataTar := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "data/db", Typeflag: tar.TypeReg, Mode: 0644}, content: "db"},
+	)
+	logsTar := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "logs/", Typeflag: tar.TypeDir, Mode: 0755}},
+	)
+
+	for k, v := range map[string]struct {
+		volumes           map[string]struct{}
+		expectedDownloads []string
+		expectedFiles     []string
+		expectedStatus    iiapi.OpenSCAPStatus
+	}{
+		"declared volumes": {
+			volumes:           map[string]struct{}{"/var/lib/data": {}, "/var/log/logs": {}},
+			expectedDownloads: []string{"/var/lib/data", "/var/log/logs"},
+			expectedFiles:     []string{"var/lib/data/db", "var/log/logs"},
+			expectedStatus:    iiapi.StatusSuccess,
+		},
+		"no declared volumes": {expectedStatus: iiapi.StatusSkipped},
+	} {
+		dstPath, err := ioutil.TempDir("", "dst-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dstPath)
+
+		image := &docker.Image{ID: "image-id", Config: &docker.Config{Volumes: v.volumes}}
+		client := &mockDockerRuntimeClient{
+			images:         map[string]*docker.Image{"image": image, "image-id": image},
+			containerImage: "image-id",
+			downloads:      map[string][]byte{"/": newTarball(t), "/var/lib/data": dataTar, "/var/log/logs": logsTar},
+		}
+		newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = dstPath
+		opts.PullPolicy = iiapi.PullNever
+		opts.ScanType = "unowned"
+		opts.ScanVolumesOnly = true
+		opts.OutputFile = path.Join(dstPath, "results.json")
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &SuccMockScanner{}, nil
+		}
+
+		if err := ii.Inspect(); err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+		}
+		if !reflect.DeepEqual(client.downloaded, v.expectedDownloads) {
+			t.Errorf("%s: expected the paths %v to be downloaded, got %v", k, v.expectedDownloads, client.downloaded)
+		}
+		for _, f := range v.expectedFiles {
+			if _, err := os.Stat(path.Join(dstPath, f)); err != nil {
+				t.Errorf("%s: expected %s to be extracted: %v", k, f, err)
+			}
+		}
+		if len(ii.meta.Scanners) != 1 || ii.meta.Scanners[0].Status != v.expectedStatus {
+			t.Errorf("%s: expected a scan with status %s, got %v", k, v.expectedStatus, ii.meta.Scanners)
+		}
+		// the results are written even when the scan is skipped
+		if _, err := os.Stat(opts.OutputFile); err != nil {
+			t.Errorf("%s: expected the results to be written: %v", k, err)
+		}
+	}
+}
+
+// mockImageServer records the images it was asked to serve.
+type mockImageServer struct {
+	served  int
+	results iiapi.ScanResult
+}
+
+func (s *mockImageServer) ServeImage(meta *iiapi.InspectorMetadata, imageServeURL string,
+	results iiapi.ScanResult, scanReport, htmlScanReport, xccdfResults, junitReport string) error {
+	s.served++
+	s.results = results
+	return nil
+}
+
+func (s *mockImageServer) Addr() string {
+	return ""
+}
+
+// slowMockScanner is a SuccMockScanner taking some time to scan.
+type slowMockScanner struct {
+	SuccMockScanner
+}
+
+func (s *slowMockScanner) Scan(ctx context.Context, path string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	time.Sleep(10 * time.Millisecond)
+	return s.SuccMockScanner.Scan(ctx, path, image, filter)
+}
+
+// stuckMockScanner finds a result and then blocks until the context is done.
+type stuckMockScanner struct {
+	SuccMockScanner
+}
+
+func (s *stuckMockScanner) Scan(ctx context.Context, path string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	<-ctx.Done()
+	return []iiapi.Result{{Name: "MockScanner", Reference: "file:///usr/bin/found"}}, nil, ctx.Err()
+}
+
+func TestInspectScanTimeout(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "clamav"
+	opts.ScanTimeout = 50 * time.Millisecond
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return &stuckMockScanner{}, nil
+	}
+	server := &mockImageServer{}
+	ii.imageServer = server
+
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to complete, got %v", err)
+	}
+	if server.served != 1 || len(server.results.Results) != 1 || server.results.Results[0].Reference != "file:///usr/bin/found" {
+		t.Errorf("expected the results found before the timeout to be served, got %+v", server.results)
+	}
+	if len(ii.meta.Scanners) != 1 || ii.meta.Scanners[0].Status != iiapi.StatusError ||
+		!strings.Contains(ii.meta.Scanners[0].ErrorMessage, "timed out") ||
+		!strings.Contains(ii.meta.Scanners[0].ErrorMessage, "deadline exceeded") {
+		t.Errorf("expected the scan to be recorded as timed out, got %+v", ii.meta.Scanners)
+	}
+}
+
+func TestInspectScanStatistics(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	for name, content := range map[string]string{"etc/hosts": "localhost", "usr/bin/ls": "ls", "empty": ""} {
+		if err := os.MkdirAll(path.Dir(path.Join(rootfs, name)), 0755); err != nil {
+			t.Fatalf("unable to create the directory of %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(path.Join(rootfs, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unable to write %s: %v", name, err)
+		}
+	}
+	// the symlinks are not counted
+	if err := os.Symlink("hosts", path.Join(rootfs, "etc/hosts.link")); err != nil {
+		t.Fatalf("unable to create the symlink: %v", err)
+	}
+
+	var posted []byte
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer sink.Close()
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "unowned"
+	opts.PostResultURL = sink.URL
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return &slowMockScanner{}, nil
+	}
+	server := &mockImageServer{}
+	ii.imageServer = server
+
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed, got %v", err)
+	}
+	served := server.results
+	if served.ExtractedFileCount != 3 || served.ExtractedBytes != int64(len("localhost")+len("ls")) {
+		t.Errorf("expected 3 files of 11 bytes, got %d files of %d bytes", served.ExtractedFileCount, served.ExtractedBytes)
+	}
+	if served.ScanDuration < 10*time.Millisecond {
+		t.Errorf("expected the scan duration to be measured, got %v", served.ScanDuration)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(posted, &result); err != nil {
+		t.Fatalf("unable to parse the posted results %q: %v", posted, err)
+	}
+	for field, expected := range map[string]float64{
+		"scanDuration":       float64(served.ScanDuration),
+		"extractedBytes":     11,
+		"extractedFileCount": 3,
+	} {
+		if result[field] != expected {
+			t.Errorf("expected the posted %s to be %v, got %v", field, expected, result[field])
+		}
+	}
+}
+
+func TestInspectServeOnScanError(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	for k, v := range map[string]struct {
+		scanner          iiapi.Scanner
+		serveOnScanError bool
+		expectedServed   bool
+	}{
+		"scan error served":     {scanner: &FailMockScanner{}, serveOnScanError: true, expectedServed: true},
+		"scan error not served": {scanner: &FailMockScanner{}, serveOnScanError: false, expectedServed: false},
+		"scan success served":   {scanner: &SuccMockScanner{}, serveOnScanError: false, expectedServed: true},
+	} {
+		resultsDir, err := ioutil.TempDir("", "results-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(resultsDir)
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "openscap"
+		opts.ScanResultsDir = resultsDir
+		opts.ServeOnScanError = v.serveOnScanError
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return v.scanner, nil
+		}
+		server := &mockImageServer{}
+		ii.imageServer = server
+
+		err = ii.Inspect()
+		if served := server.served > 0; served != v.expectedServed {
+			t.Errorf("%s: expected the image to be served %t, got %t", k, v.expectedServed, served)
+		}
+		if v.expectedServed && err != nil {
+			t.Errorf("%s: expected the inspection to succeed, got %v", k, err)
+		}
+		if !v.expectedServed && (err == nil || !strings.Contains(err.Error(), "FAIL SCANNER!")) {
+			t.Errorf("%s: expected the inspection to fail with the scan error, got %v", k, err)
+		}
+	}
+}
+
+func TestInspectFailOnSeverity(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	severe := func(reference string, severities ...iiapi.Severity) iiapi.Result {
+		r := iiapi.Result{Name: "MockScanner", Reference: reference}
+		for _, s := range severities {
+			r.Summary = append(r.Summary, iiapi.Summary{Label: s})
+		}
+		return r
+	}
+	results := []iiapi.Result{
+		severe("CVE-1", iiapi.SeverityLow),
+		severe("CVE-2", "unknown"),
+		severe("CVE-3"),
+		severe("CVE-4", iiapi.SeverityModerate, iiapi.SeverityImportant),
+	}
+
+	for k, v := range map[string]struct {
+		failOnSeverity string
+		expectedError  string
+	}{
+		"no threshold":       {},
+		"below the results":  {failOnSeverity: "low", expectedError: "Found 2 results of severity low or higher, the first is CVE-1"},
+		"at the results":     {failOnSeverity: "important", expectedError: "Found 1 results of severity important or higher, the first is CVE-4"},
+		"above the results":  {failOnSeverity: "critical"},
+		"moderate threshold": {failOnSeverity: "moderate", expectedError: "the first is CVE-4 by MockScanner"},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "unowned"
+		opts.FailOnSeverity = v.failOnSeverity
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &resultsMockScanner{results: results}, nil
+		}
+		server := &mockImageServer{}
+		ii.imageServer = server
+
+		err := ii.Inspect()
+		if len(v.expectedError) == 0 {
+			if err != nil || server.served != 1 {
+				t.Errorf("%s: expected the inspection to succeed and serve the image, got %v", k, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+		}
+		if server.served > 0 {
+			t.Errorf("%s: expected the image not to be served", k)
+		}
+	}
+}
+
+// incrementalMockScanner reports its results to the sink one at a time, calling scanned
+// after each of them.
+type incrementalMockScanner struct {
+	resultsMockScanner
+	sink    func(iiapi.Result)
+	scanned func(n int)
+}
+
+func (s *incrementalMockScanner) Scan(ctx context.Context, path string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	for n, r := range s.results {
+		if s.sink != nil {
+			s.sink(r)
+		}
+		s.scanned(n + 1)
+	}
+	return s.results, nil, nil
+}
+
+func (s *incrementalMockScanner) SetResultsSink(sink func(iiapi.Result)) {
+	s.sink = sink
+}
+
+func TestInspectServePartialResults(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	results := []iiapi.Result{
+		{Name: "MockScanner", Reference: "file:///usr/bin/first"},
+		{Name: "MockScanner", Reference: "file:///usr/bin/second"},
+	}
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "unowned"
+	opts.Serve = "localhost:0"
+	opts.ServePartialResults = true
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	if ii.partialResults == nil {
+		t.Fatalf("expected the partial results to be set up")
+	}
+	scanner := &incrementalMockScanner{resultsMockScanner: resultsMockScanner{results: results}}
+	scanner.scanned = func(n int) {
+		partial := ii.partialResults.Get()
+		if partial.Complete || !reflect.DeepEqual(partial.Results, results[:n]) {
+			t.Errorf("expected the incomplete results %v after %d results, got %+v", results[:n], n, partial)
+		}
+	}
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return scanner, nil
+	}
+	server := &mockImageServer{}
+	ii.imageServer = server
+
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed but failed with %v", err)
+	}
+	if server.served != 1 || server.results.Complete {
+		t.Errorf("expected the image to be served once before the scan was complete, got %d times with %+v", server.served, server.results)
+	}
+	partial := ii.partialResults.Get()
+	if !partial.Complete || !reflect.DeepEqual(partial.Results, results) {
+		t.Errorf("expected the complete results %v once the scan was done, got %+v", results, partial)
+	}
+}
+
+// handlerServer is the image server providing the handler of the served image.
+type handlerServer interface {
+	GetHandler(meta *iiapi.InspectorMetadata, imageServeURL string, results iiapi.ScanResult,
+		scanReport, htmlScanReport, xccdfResults, junitReport string) (http.Handler, error)
+}
+
+// httptestImageServer serves the handler of the image server with httptest instead of
+// listening on the serve address, the test servers are sent to servers.
+type httptestImageServer struct {
+	handlerServer
+	servers chan *httptest.Server
+}
+
+func (s *httptestImageServer) ServeImage(meta *iiapi.InspectorMetadata, imageServeURL string,
+	results iiapi.ScanResult, scanReport, htmlScanReport, xccdfResults, junitReport string) error {
+	handler, err := s.GetHandler(meta, imageServeURL, results, scanReport, htmlScanReport, xccdfResults, junitReport)
+	if err != nil {
+		return err
+	}
+	s.servers <- httptest.NewServer(handler)
+	return nil
+}
+
+func (s *httptestImageServer) Addr() string {
+	return ""
+}
+
+func TestInspectServePartialMetadata(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	results := []iiapi.Result{
+		{Name: "MockScanner", Reference: "file:///usr/bin/first"},
+		{Name: "MockScanner", Reference: "file:///usr/bin/second"},
+	}
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.URI = ""
+	opts.RootfsPath = rootfs
+	opts.ScanType = "unowned"
+	opts.Serve = "localhost:0"
+	opts.ServePartialResults = true
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	server := &httptestImageServer{handlerServer: ii.imageServer.(handlerServer), servers: make(chan *httptest.Server, 1)}
+	ii.imageServer = server
+
+	get := func(base, urlPath string, v interface{}) error {
+		resp, err := http.Get(base + urlPath)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", urlPath, resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	poll := func(base string) error {
+		var meta iiapi.InspectorMetadata
+		if err := get(base, METADATA_URL_PATH, &meta); err != nil {
+			return err
+		}
+		var versioned iiapi.VersionedInspectorMetadata
+		if err := get(base, VERSIONED_METADATA_PATH, &versioned); err != nil {
+			return err
+		}
+		var partial iiapi.ScanResult
+		return get(base, RESULT_API_URL_PATH, &partial)
+	}
+
+	var httpServer *httptest.Server
+	done := make(chan struct{})
+	polled := make(chan error, 1)
+	scanner := &incrementalMockScanner{resultsMockScanner: resultsMockScanner{results: results}}
+	scanner.scanned = func(n int) {
+		if httpServer == nil {
+			httpServer = <-server.servers
+			// the metadata and the results are requested until the inspection is done
+			first := make(chan struct{})
+			go func(base string) {
+				err := poll(base)
+				close(first)
+				for err == nil {
+					select {
+					case <-done:
+						polled <- nil
+						return
+					default:
+					}
+					err = poll(base)
+				}
+				polled <- err
+			}(httpServer.URL)
+			<-first
+		}
+		var partial iiapi.ScanResult
+		if err := get(httpServer.URL, RESULT_API_URL_PATH, &partial); err != nil || partial.Complete || len(partial.Results) != n {
+			t.Errorf("expected %d incomplete results after %d results, got %+v (%v)", n, n, partial, err)
+		}
+		var meta iiapi.InspectorMetadata
+		if err := get(httpServer.URL, METADATA_URL_PATH, &meta); err != nil || len(meta.Scanners) > 0 {
+			t.Errorf("expected no scan to be recorded while scanning, got %+v (%v)", meta.Scanners, err)
+		}
+	}
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return scanner, nil
+	}
+
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed but failed with %v", err)
+	}
+	close(done)
+	if httpServer == nil {
+		t.Fatalf("expected the image to be served while scanning")
+	}
+	defer httpServer.Close()
+	if err := <-polled; err != nil {
+		t.Errorf("expected the metadata and the results to be served while scanning, got %v", err)
+	}
+
+	var meta iiapi.InspectorMetadata
+	if err := get(httpServer.URL, METADATA_URL_PATH, &meta); err != nil {
+		t.Fatalf("unable to get the metadata: %v", err)
+	}
+	if len(meta.Scanners) != 1 || meta.Scanners[0].Status != iiapi.StatusSuccess {
+		t.Errorf("expected the successful scan to be served once done, got %+v", meta.Scanners)
+	}
+	var partial iiapi.ScanResult
+	if err := get(httpServer.URL, RESULT_API_URL_PATH, &partial); err != nil || !partial.Complete || len(partial.Results) != len(results) {
+		t.Errorf("expected the complete results to be served once done, got %+v (%v)", partial, err)
+	}
+}
+
+func TestInspectOutputFile(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	image := &docker.Image{ID: "sha256:0123456789abcdef"}
+	client := &mockDockerRuntimeClient{
+		images:         map[string]*docker.Image{"image": image, image.ID: image},
+		containerImage: image.ID,
+		downloads:      map[string][]byte{"/": newTarball(t)},
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	dir, err := ioutil.TempDir("", "output-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var posted []byte
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer sink.Close()
+
+	for k, v := range map[string]struct {
+		outputFile string
+		gzip       bool
+		compressed bool
+		shouldFail bool
+	}{
+		"output file":        {outputFile: path.Join(dir, "results.json")},
+		"gz extension":       {outputFile: path.Join(dir, "results.json.gz"), compressed: true},
+		"output gzip":        {outputFile: path.Join(dir, "results.gzip.json"), gzip: true, compressed: true},
+		"missing output dir": {outputFile: path.Join(dir, "nosuchdir", "results.json"), shouldFail: true},
+	} {
+		posted = nil
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = path.Join(dir, "rootfs")
+		opts.PullPolicy = iiapi.PullNever
+		opts.ScanType = "unowned"
+		opts.OutputFile = v.outputFile
+		opts.OutputGzip = v.gzip
+		opts.PostResultURL = sink.URL
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &SuccMockScanner{}, nil
+		}
+		server := &mockImageServer{}
+		ii.imageServer = server
+
+		err := ii.Inspect()
+		if v.shouldFail {
+			if err == nil || !strings.Contains(err.Error(), "Unable to write the results file") {
+				t.Errorf("%s: expected the inspection to fail writing the results, got %v", k, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected the inspection to succeed, got %v", k, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(v.outputFile)
+		if err != nil {
+			t.Errorf("%s: expected the results file to be written: %v", k, err)
+			continue
+		}
+		if v.compressed {
+			gz, err := gzip.NewReader(bytes.NewReader(content))
+			if err != nil {
+				t.Errorf("%s: expected the results file to be gzip-compressed: %v", k, err)
+				continue
+			}
+			if content, err = ioutil.ReadAll(gz); err != nil {
+				t.Errorf("%s: expected the results file to decompress: %v", k, err)
+				continue
+			}
+		}
+		var results iiapi.ScanResult
+		if err := json.Unmarshal(content, &results); err != nil {
+			t.Errorf("%s: expected the results file to hold JSON, got %q: %v", k, content, err)
+		}
+		if results.ImageID != image.ID || results.ImageName != "docker.io/library/image:latest" {
+			t.Errorf("%s: unexpected results %+v", k, results)
+		}
+		// the results are still posted and served
+		if string(posted) != string(content) || server.served != 1 || server.results.ImageID != image.ID {
+			t.Errorf("%s: expected the results to be posted and served too, posted %q and served %d", k, posted, server.served)
+		}
+	}
+}
+
+func TestInspectCleanup(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	image := &docker.Image{ID: "sha256:0123456789abcdef"}
+	client := &mockDockerRuntimeClient{
+		images:         map[string]*docker.Image{"image": image, image.ID: image},
+		containerImage: image.ID,
+		downloads: map[string][]byte{"/": newTarball(t,
+			tarEntry{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+			tarEntry{hdr: tar.Header{Name: "rootfs/etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+		)},
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	for k, v := range map[string]struct {
+		cleanup bool
+	}{
+		"cleanup":    {cleanup: true},
+		"no cleanup": {cleanup: false},
+	} {
+		dir, err := ioutil.TempDir("", "cleanup-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		resultsDir := path.Join(dir, "results")
+		if err := os.Mkdir(resultsDir, 0755); err != nil {
+			t.Fatalf("unable to create the results directory: %v", err)
+		}
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "image"
+		opts.DstPath = path.Join(dir, "rootfs")
+		opts.ScanResultsDir = resultsDir
+		opts.PullPolicy = iiapi.PullNever
+		opts.ScanType = "unowned"
+		opts.Cleanup = v.cleanup
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("%s: expected the options to validate, got %v", k, err)
+		}
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &SuccMockScanner{}, nil
+		}
+		if err := ii.Inspect(); err != nil {
+			t.Errorf("%s: expected the inspection to succeed, got %v", k, err)
+			continue
+		}
+
+		for _, p := range []string{path.Join(opts.DstPath, "etc", "hosts"), resultsDir} {
+			_, err := os.Stat(p)
+			if v.cleanup && !os.IsNotExist(err) {
+				t.Errorf("%s: expected %s to be removed, got %v", k, p, err)
+			}
+			if !v.cleanup && err != nil {
+				t.Errorf("%s: expected %s to be kept, got %v", k, p, err)
+			}
+		}
+	}
+}
+
+func TestPullProgress(t *testing.T) {
+	messages := `{"Status": "Pulling from library/fedora", "Id": "26"}
+{"Status": "Pulling fs layer", "Id": "a"}
+{"Status": "Pulling fs layer", "Id": "b"}
+{"Status": "Already exists", "Id": "c"}
+{"Status": "Downloading", "Id": "a", "ProgressDetail": {"Current": 1024, "Total": 4096}}
+{"Status": "Downloading", "Id": "b", "ProgressDetail": {"Current": 1024, "Total": 12288}}
+{"Status": "Downloading", "Id": "a", "ProgressDetail": {"Current": 3072, "Total": 4096}}`
+	progress := newPullProgress()
+	dec := json.NewDecoder(strings.NewReader(messages))
+	for dec.More() {
+		var v pullMessage
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("unable to decode the pull messages: %v", err)
+		}
+		progress.update(v)
+	}
+	expected := pullTotals{Downloaded: 4096, Total: 16384, Layers: 3, Complete: 1}
+	if totals := progress.totals(); totals != expected {
+		t.Errorf("expected the totals %+v, got %+v", expected, totals)
+	}
+	if percent := expected.percent(); percent != 25 {
+		t.Errorf("expected 25%% downloaded, got %d%%", percent)
+	}
+	if eta := expected.eta(10 * time.Second); eta != 30*time.Second {
+		t.Errorf("expected the ETA 30s, got %v", eta)
+	}
+
+	for _, v := range []struct {
+		message  pullMessage
+		expected pullTotals
+	}{
+		{
+			message:  pullMessage{Status: "Download complete", Id: "a"},
+			expected: pullTotals{Downloaded: 5120, Total: 16384, Layers: 3, Complete: 1},
+		},
+		{
+			message:  pullMessage{Status: "Extracting", Id: "a"},
+			expected: pullTotals{Downloaded: 5120, Total: 16384, Layers: 3, Extracting: 1, Complete: 1},
+		},
+		{
+			message:  pullMessage{Status: "Pull complete", Id: "a"},
+			expected: pullTotals{Downloaded: 5120, Total: 16384, Layers: 3, Complete: 2},
+		},
+		{
+			message:  pullMessage{Status: "Pull complete", Id: "b"},
+			expected: pullTotals{Downloaded: 16384, Total: 16384, Layers: 3, Complete: 3},
+		},
+	} {
+		progress.update(v.message)
+		if totals := progress.totals(); totals != v.expected {
+			t.Errorf("%s %s: expected the totals %+v, got %+v", v.message.Status, v.message.Id, v.expected, totals)
+		}
+	}
+	if (pullTotals{Downloaded: 1024}).percent() != -1 {
+		t.Errorf("expected no percentage without the total size")
+	}
+}
+
+func TestProcessTarStreamProgress(t *testing.T) {
+	dst, err := ioutil.TempDir("", "extract-progress-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "rootfs/etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, content: "localhost"},
+		{hdr: tar.Header{Name: "rootfs/etc/motd", Typeflag: tar.TypeReg, Mode: 0644}, content: "welcome"},
+		{hdr: tar.Header{Name: "rootfs/etc/issue", Typeflag: tar.TypeSymlink, Linkname: "motd"}},
+	}
+	calls := [][2]int64{}
+	progress := &extractProgress{}
+	opts := tarExtractOptions{prefix: DOCKER_TAR_PREFIX, progress: func(files int, bytes int64) {
+		calls = append(calls, [2]int64{int64(files), bytes})
+		progress.add(files, bytes)
+	}}
+	if err := processTarStream(newTarReader(t, entries...), dst, opts); err != nil {
+		t.Fatalf("unable to process the tar stream: %v", err)
+	}
+
+	expected := [][2]int64{{1, 0}, {1, 9}, {1, 7}, {1, 0}}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the progress calls %v, got %v", expected, calls)
+	}
+	if files, bytes := progress.counts(); files != 4 || bytes != 16 {
+		t.Errorf("expected 4 files and 16 bytes extracted, got %d files and %d bytes", files, bytes)
+	}
+}
@@ -0,0 +1,535 @@
+package inspector
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/logging"
+)
+
+const (
+	// saveManifestFile is the name of the manifest in a docker save tarball
+	saveManifestFile = "manifest.json"
+	// whiteoutPrefix marks a file removed from the lower layers
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaqueDir marks a directory whose lower layers content is hidden
+	whiteoutOpaqueDir = whiteoutPrefix + whiteoutPrefix + ".opq"
+	// layerLockSuffix is the suffix of the lock files of the cached layers
+	layerLockSuffix = ".lock"
+)
+
+// lockLayer locks the extraction of the layer into layerDir and returns the unlock function.
+// The lock is a file lock next to layerDir so that the processes sharing the layer cache, as
+// well as the goroutines of this one, never extract the same layer at once while different
+// layers are extracted concurrently.
+func lockLayer(layerDir string) (func(), error) {
+	file, err := os.OpenFile(layerDir+layerLockSuffix, os.O_CREATE|os.O_RDWR, OWNER_PERM_RW)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the layer lock: %v\n", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Unable to lock the layer: %v\n", err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// saveManifestEntry is an image entry of the manifest in a docker save tarball.
+type saveManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// layerCache keeps the extracted content of image layers in a directory, keyed by the
+// digest of the layer content, so that layers shared between images are extracted once.
+type layerCache struct {
+	dir string
+}
+
+// layerPath returns the directory holding the extracted layer with the given digest.
+func (c *layerCache) layerPath(digest string) string {
+	return path.Join(c.dir, strings.Replace(digest, ":", "-", 1))
+}
+
+// ensureLayer extracts the layer tarball into the cache unless a layer with the same digest
+// is already present. It returns true if the layer was extracted.
+func (c *layerCache) ensureLayer(digest, layerTar string) (bool, error) {
+	layerDir := c.layerPath(digest)
+	unlock, err := lockLayer(layerDir)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(layerDir); err == nil {
+		return false, nil
+	}
+
+	// extract in a temporary directory so that partially extracted layers are never reused
+	tmpDir, err := ioutil.TempDir(c.dir, "extracting-")
+	if err != nil {
+		return false, fmt.Errorf("Unable to create temporary layer directory: %v\n", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file, err := os.Open(layerTar)
+	if err != nil {
+		return false, fmt.Errorf("Unable to open layer %s: %v\n", digest, err)
+	}
+	defer file.Close()
+
+	// symlinks are kept as they are and the symlink policy is applied when the layers
+	// are assembled into the destination path.
+	if err := processTarStream(tar.NewReader(file), tmpDir, tarExtractOptions{symlinkPolicy: iiapi.SymlinkKeep}); err != nil {
+		return false, fmt.Errorf("Unable to extract layer %s: %v", digest, err)
+	}
+	if err := os.Rename(tmpDir, layerDir); err != nil {
+		// a cache shared with a filesystem not honoring the locks may have raced us
+		if _, statErr := os.Stat(layerDir); statErr == nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to store layer %s: %v\n", digest, err)
+	}
+	return true, nil
+}
+
+// layerPrefetcher extracts the layers into the cache while the rest of the image is still
+// being exported, at most as many at once as it has slots.
+type layerPrefetcher struct {
+	ctx    context.Context
+	cache  *layerCache
+	denied map[string]struct{}
+	// slots bound the concurrent extractions, the prefetching is disabled when nil
+	slots chan struct{}
+	wg    sync.WaitGroup
+	// lock protects extracted
+	lock sync.Mutex
+	// extracted are the digests of the layers extracted by the prefetcher
+	extracted map[string]bool
+}
+
+func newLayerPrefetcher(ctx context.Context, cache *layerCache, concurrency int, denied map[string]struct{}) *layerPrefetcher {
+	p := &layerPrefetcher{ctx: ctx, cache: cache, denied: denied, extracted: map[string]bool{}}
+	if concurrency > 0 {
+		p.slots = make(chan struct{}, concurrency)
+	}
+	return p
+}
+
+// isLayerEntry returns whether the entry of a docker save tarball may be a layer, i.e. a
+// layer.tar of the legacy format or a blob of the OCI format.
+func isLayerEntry(name string) bool {
+	return strings.HasSuffix(name, ".tar") || strings.HasPrefix(name, "blobs/")
+}
+
+// spooled starts the extraction of the spooled entry name into the cache when it may be a
+// layer. The failures are ignored since the entry may not be a layer at all, the layers of
+// the manifest are extracted again and their failures reported once the image is exported.
+func (p *layerPrefetcher) spooled(name, layerTar, digest string) {
+	if p.slots == nil || !isLayerEntry(name) {
+		return
+	}
+	if _, denied := p.denied[digest]; denied {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.slots <- struct{}{}
+		defer func() { <-p.slots }()
+		if p.ctx.Err() != nil {
+			return
+		}
+		if extracted, err := p.cache.ensureLayer(digest, layerTar); err == nil && extracted {
+			p.lock.Lock()
+			p.extracted[digest] = true
+			p.lock.Unlock()
+		}
+	}()
+}
+
+// wait waits for the started extractions to end.
+func (p *layerPrefetcher) wait() {
+	p.wg.Wait()
+}
+
+// wasExtracted returns whether the layer was extracted by the prefetcher.
+func (p *layerPrefetcher) wasExtracted(digest string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.extracted[digest]
+}
+
+// exportAndExtractImage exports the option's image and assembles its filesystem into the
+// option's destination path from the layers in cache, extracting only the layers that were
+// not cached yet.
+func (i *defaultImageInspector) exportAndExtractImage(ctx context.Context, client DockerRuntimeClient, cache *layerCache) (*docker.Image, error) {
+	imageMetadata, err := client.InspectImage(i.opts.Image)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get docker image information: %v\n", err)
+	}
+
+	if i.opts.DstPath, err = createOutputDir(i.opts.DstPath, "image-inspector-", i.opts.OutputDirMode.Mode); err != nil {
+		return imageMetadata, err
+	}
+
+	spoolDir, err := ioutil.TempDir(cache.dir, "export-")
+	if err != nil {
+		return imageMetadata, fmt.Errorf("Unable to create temporary export directory: %v\n", err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	// the layers are extracted while the image is exported, the spooled files are removed
+	// only once their extractions ended
+	prefetcher := newLayerPrefetcher(ctx, cache, i.opts.ExtractConcurrency, i.deniedDigests)
+	defer prefetcher.wait()
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	defer closeOnCancel(ctx, reader)()
+
+	log.Printf("Exporting image %s", i.opts.Image)
+
+	errorChannel := make(chan error)
+	go func() {
+		err := client.ExportImage(docker.ExportImageOptions{
+			Name:         i.opts.Image,
+			OutputStream: writer,
+		})
+		writer.CloseWithError(err)
+		errorChannel <- err
+	}()
+
+	digests, spoolErr := spoolImageTarball(tar.NewReader(reader), spoolDir, prefetcher.spooled)
+	// unblock the export when the spooling ended early
+	reader.Close()
+	exportErr := <-errorChannel
+	if ctx.Err() != nil {
+		return imageMetadata, ctx.Err()
+	}
+	if spoolErr != nil {
+		return imageMetadata, spoolErr
+	}
+	if exportErr != nil {
+		return imageMetadata, fmt.Errorf("Unable to export image: %v\n", exportErr)
+	}
+
+	logging.Info(fmt.Sprintf("Extracting image %s to %s", i.opts.Image, i.opts.DstPath),
+		logging.Fields{"image": i.opts.Image, "phase": "extract", "path": i.opts.DstPath})
+
+	_, err = i.assembleImage(ctx, cache, spoolDir, digests, prefetcher)
+	return imageMetadata, err
+}
+
+// assembleImage assembles the filesystem of the docker save tarball spooled into spoolDir,
+// whose files have the given digests, into the option's destination path from the layers in
+// cache. It returns the manifest of the image.
+func (i *defaultImageInspector) assembleImage(ctx context.Context, cache *layerCache, spoolDir string, digests map[string]string, prefetcher *layerPrefetcher) (*saveManifestEntry, error) {
+	manifest, err := readSaveManifest(path.Join(spoolDir, saveManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	layerDigests := []string{}
+	for _, layer := range manifest.Layers {
+		layerDigests = append(layerDigests, digests[layer])
+	}
+	if err := i.checkDeniedDigests(layerDigests); err != nil {
+		return nil, err
+	}
+
+	prefetcher.wait()
+	for _, layer := range manifest.Layers {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		digest, ok := digests[layer]
+		if !ok {
+			return nil, fmt.Errorf("Layer %s is missing from the exported image\n", layer)
+		}
+		extracted, err := cache.ensureLayer(digest, path.Join(spoolDir, layer))
+		if err != nil {
+			return nil, err
+		}
+		if !extracted && !prefetcher.wasExtracted(digest) {
+			log.Printf("Reusing cached layer %s", digest)
+		}
+		if err := applyLayer(cache.layerPath(digest), i.opts.DstPath, i.opts.SymlinkPolicy); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// extractImageTar assembles the filesystem of the option's docker save tarball, read from
+// stdin when it's "-", into the option's destination path without involving the docker
+// daemon. The tarball is read as a stream, its files are spooled to disk since the
+// manifest may come after the layers. It returns the metadata of the image config.
+func (i *defaultImageInspector) extractImageTar(ctx context.Context) (*docker.Image, error) {
+	reader := imageTarStdin
+	if i.opts.ImageTar != "-" {
+		file, err := os.Open(i.opts.ImageTar)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open the image tarball: %v\n", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var err error
+	if i.opts.DstPath, err = createOutputDir(i.opts.DstPath, "image-inspector-", i.opts.OutputDirMode.Mode); err != nil {
+		return nil, err
+	}
+
+	// the layers are extracted into a throwaway cache, their files are copied into the
+	// destination path
+	cacheDir, err := ioutil.TempDir("", "image-inspector-tar-")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create temporary layer directory: %v\n", err)
+	}
+	defer os.RemoveAll(cacheDir)
+	cache := &layerCache{dir: cacheDir}
+	spoolDir, err := ioutil.TempDir(cacheDir, "export-")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create temporary export directory: %v\n", err)
+	}
+
+	prefetcher := newLayerPrefetcher(ctx, cache, i.opts.ExtractConcurrency, i.deniedDigests)
+	defer prefetcher.wait()
+
+	logging.Info(fmt.Sprintf("Extracting image tarball %s to %s", i.opts.ImageTar, i.opts.DstPath),
+		logging.Fields{"image": i.opts.ImageTar, "phase": "extract", "path": i.opts.DstPath})
+	digests, err := spoolImageTarball(tar.NewReader(reader), spoolDir, prefetcher.spooled)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := i.assembleImage(ctx, cache, spoolDir, digests, prefetcher)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(spoolDir, path.Clean(manifest.Config)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the image config: %v\n", err)
+	}
+	// the field names of the image config match the ones of the docker image metadata
+	imageMetadata := &docker.Image{}
+	if err := json.Unmarshal(content, imageMetadata); err != nil {
+		return nil, fmt.Errorf("Unable to parse the image config: %v\n", err)
+	}
+	imageMetadata.ID = digests[path.Clean(manifest.Config)]
+	imageMetadata.RepoTags = manifest.RepoTags
+	return imageMetadata, nil
+}
+
+// spoolImageTarball writes the regular files of a docker save tarball into dir and returns
+// the digests of their content keyed by entry name. spooled, when set, is called with the
+// name, path and digest of each file once it's written.
+func spoolImageTarball(tr *tar.Reader, dir string, spooled func(name, dstpath, digest string)) (map[string]string, error) {
+	digests := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return digests, nil
+			}
+			return nil, fmt.Errorf("Unable to read exported image: %v\n", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("Invalid entry %s in exported image\n", hdr.Name)
+		}
+		dstpath := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(dstpath), 0700); err != nil {
+			return nil, fmt.Errorf("Unable to create directory: %v", err)
+		}
+		file, err := os.OpenFile(dstpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OWNER_PERM_RW)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create file: %v", err)
+		}
+		hash := sha256.New()
+		_, err = io.Copy(file, io.TeeReader(tr, hash))
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to write into file: %v", err)
+		}
+		digests[name] = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		if spooled != nil {
+			spooled(name, dstpath, digests[name])
+		}
+	}
+}
+
+// readSaveManifest returns the single image entry of a docker save manifest.
+func readSaveManifest(manifestPath string) (*saveManifestEntry, error) {
+	content, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the exported image manifest: %v\n", err)
+	}
+	var manifest []saveManifestEntry
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("Unable to parse the exported image manifest: %v\n", err)
+	}
+	if len(manifest) != 1 {
+		return nil, fmt.Errorf("Expected one image in the exported image manifest, found %d\n", len(manifest))
+	}
+	for i, layer := range manifest[0].Layers {
+		manifest[0].Layers[i] = path.Clean(layer)
+	}
+	return &manifest[0], nil
+}
+
+// applyLayer applies the extracted layer in layerDir on top of the content of destination,
+// honoring the whiteout files that remove content of the lower layers.
+func applyLayer(layerDir, destination, symlinkPolicy string) error {
+	// whiteouts only refer to lower layers, they are handled before adding any content
+	err := filepath.Walk(layerDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(p)
+		if !strings.HasPrefix(name, whiteoutPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(layerDir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		dstDir := filepath.Join(destination, rel)
+		if name == whiteoutOpaqueDir {
+			entries, err := ioutil.ReadDir(dstDir)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, entry := range entries {
+				removed := filepath.Join(dstDir, entry.Name())
+				if !insideDestination(destination, removed) {
+					log.Printf("Skipping whiteout %s which is outside of the extraction root", filepath.Join(rel, name))
+					continue
+				}
+				if err := os.RemoveAll(removed); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		removed := filepath.Join(dstDir, strings.TrimPrefix(name, whiteoutPrefix))
+		if !insideDestination(destination, removed) {
+			log.Printf("Skipping whiteout %s which is outside of the extraction root", filepath.Join(rel, name))
+			return nil
+		}
+		return os.RemoveAll(removed)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to apply layer whiteouts: %v", err)
+	}
+
+	err = filepath.Walk(layerDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), whiteoutPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(layerDir, p)
+		if err != nil {
+			return err
+		}
+		dstpath := filepath.Join(destination, rel)
+		// never write through a symlinked directory of the lower layers since it may point
+		// outside of the extraction root
+		if !insideDestination(destination, dstpath) {
+			log.Printf("Skipping %s which is outside of the extraction root", rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// an entry replaces whatever the lower layers had at the same path unless both
+		// are directories, in which case their content is merged.
+		if dstInfo, err := os.Lstat(dstpath); err == nil && rel != "." {
+			if !(dstInfo.IsDir() && info.IsDir()) {
+				if err := os.RemoveAll(dstpath); err != nil {
+					return err
+				}
+			}
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(dstpath, mode.Perm()|OWNER_PERM_RW|0100); err != nil {
+				return err
+			}
+			return os.Chmod(dstpath, mode.Perm()|OWNER_PERM_RW|0100)
+		case mode.IsRegular():
+			// the files are copied rather than linked since the destination path is
+			// served and may be written to, which must never alter the cached layers
+			return copyFile(p, dstpath, mode)
+		case mode&os.ModeSymlink != 0:
+			linkname, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			target := symlinkTarget(destination, filepath.ToSlash(rel), linkname, symlinkPolicy)
+			if len(target) == 0 {
+				log.Printf("Skipping symlink %s with target %s outside the image", rel, linkname)
+				return nil
+			}
+			return os.Symlink(target, dstpath)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to apply layer: %v", err)
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
@@ -0,0 +1,404 @@
+package inspector
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+// newImageTarball returns a docker save tarball of an image with the given layers.
+func newImageTarball(t *testing.T, layers map[string][]byte, order []string) []byte {
+	manifest, err := json.Marshal([]saveManifestEntry{{Config: "config.json", Layers: order}})
+	if err != nil {
+		t.Fatalf("unable to marshal manifest: %v", err)
+	}
+	entries := []tarEntry{{hdr: tar.Header{Name: "config.json", Typeflag: tar.TypeReg, Mode: 0644}, content: "{}"}}
+	for _, name := range order {
+		entries = append(entries, tarEntry{
+			hdr:     tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644},
+			content: string(layers[name]),
+		})
+	}
+	entries = append(entries, tarEntry{
+		hdr:     tar.Header{Name: saveManifestFile, Typeflag: tar.TypeReg, Mode: 0644},
+		content: string(manifest),
+	})
+	return newTarball(t, entries...)
+}
+
+// cachedLayers returns the layer directories of the cache, leaving out their lock files.
+func cachedLayers(cache *layerCache) []os.FileInfo {
+	layers := []os.FileInfo{}
+	entries, _ := ioutil.ReadDir(cache.dir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			layers = append(layers, entry)
+		}
+	}
+	return layers
+}
+
+func TestExportAndExtractImageReusesCachedLayers(t *testing.T) {
+	base := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, content: "ID=rhel"},
+		tarEntry{hdr: tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0644}, content: "hello"},
+	)
+	appA := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "usr/app-a", Typeflag: tar.TypeReg, Mode: 0755}, content: "a"},
+	)
+	appB := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/.wh.motd", Typeflag: tar.TypeReg, Mode: 0644}},
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "usr/app-b", Typeflag: tar.TypeReg, Mode: 0755}, content: "b"},
+	)
+
+	client := &mockDockerRuntimeClient{
+		images: map[string]*docker.Image{
+			"image-a": {ID: "a"},
+			"image-b": {ID: "b"},
+		},
+		exports: map[string][]byte{
+			"image-a": newImageTarball(t, map[string][]byte{"base/layer.tar": base, "a/layer.tar": appA},
+				[]string{"base/layer.tar", "a/layer.tar"}),
+			"image-b": newImageTarball(t, map[string][]byte{"base/layer.tar": base, "b/layer.tar": appB},
+				[]string{"base/layer.tar", "b/layer.tar"}),
+		},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "layer-cache-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cache := &layerCache{dir: path.Join(tmpDir, "cache")}
+	if err := os.Mkdir(cache.dir, 0755); err != nil {
+		t.Fatalf("unable to create cache directory: %v", err)
+	}
+
+	inspect := func(image string) string {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = image
+		opts.DstPath = path.Join(tmpDir, image)
+		ii := &defaultImageInspector{opts: *opts}
+		if _, err := ii.exportAndExtractImage(context.Background(), client, cache); err != nil {
+			t.Fatalf("unable to extract %s: %v", image, err)
+		}
+		return ii.opts.DstPath
+	}
+
+	dstA := inspect("image-a")
+	for _, f := range []string{"etc/os-release", "etc/motd", "usr/app-a"} {
+		if _, err := os.Stat(path.Join(dstA, f)); err != nil {
+			t.Errorf("expected %s to be extracted in image-a: %v", f, err)
+		}
+	}
+
+	// a file added to the cached base layer shows up only if the layer is reused
+	baseDigest := ""
+	entries := cachedLayers(cache)
+	for _, entry := range entries {
+		if _, err := os.Stat(path.Join(cache.dir, entry.Name(), "etc/os-release")); err == nil {
+			baseDigest = entry.Name()
+		}
+	}
+	if len(entries) != 2 || len(baseDigest) == 0 {
+		t.Fatalf("expected two cached layers including the base layer, got %v", entries)
+	}
+	if err := ioutil.WriteFile(path.Join(cache.dir, baseDigest, "etc", "cached"), []byte{}, 0644); err != nil {
+		t.Fatalf("unable to mark the cached layer: %v", err)
+	}
+
+	// the extracted files are served and may be written to without altering the cache
+	if err := ioutil.WriteFile(path.Join(dstA, "etc/os-release"), []byte("ID=modified"), 0644); err != nil {
+		t.Fatalf("unable to modify the extracted file: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path.Join(cache.dir, baseDigest, "etc/os-release")); string(content) != "ID=rhel" {
+		t.Errorf("expected the cached layer not to be modified, got %q", content)
+	}
+
+	dstB := inspect("image-b")
+	if _, err := os.Stat(path.Join(dstB, "etc/cached")); err != nil {
+		t.Errorf("expected the cached base layer to be reused for image-b: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dstB, "usr/app-b")); err != nil {
+		t.Errorf("expected the new layer to be extracted for image-b: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dstB, "usr/app-a")); !os.IsNotExist(err) {
+		t.Errorf("expected usr/app-a not to be part of image-b: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dstB, "etc/motd")); !os.IsNotExist(err) {
+		t.Errorf("expected etc/motd to be removed by the whiteout in image-b: %v", err)
+	}
+	if entries := cachedLayers(cache); len(entries) != 3 {
+		t.Errorf("expected three cached layers, got %d", len(entries))
+	}
+}
+
+// overlapExportClient exports the images in two parts, waiting after the first part for the
+// layer with the digest waitFor to be extracted into the cache.
+type overlapExportClient struct {
+	*mockDockerRuntimeClient
+	split      int
+	waitFor    string
+	cache      *layerCache
+	overlapped bool
+}
+
+func (c *overlapExportClient) ExportImage(opts docker.ExportImageOptions) error {
+	export := c.exports[opts.Name]
+	if _, err := opts.OutputStream.Write(export[:c.split]); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(c.cache.layerPath(c.waitFor)); err == nil {
+			c.overlapped = true
+			break
+		}
+	}
+	_, err := opts.OutputStream.Write(export[c.split:])
+	return err
+}
+
+func TestExportAndExtractImageOverlapsExtraction(t *testing.T) {
+	base := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, content: "ID=rhel"},
+		tarEntry{hdr: tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0644}, content: "hello"},
+	)
+	app := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/.wh.motd", Typeflag: tar.TypeReg, Mode: 0644}},
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "usr/app", Typeflag: tar.TypeReg, Mode: 0755}, content: "app"},
+	)
+	baseSum := sha256.Sum256(base)
+
+	tmpDir, err := ioutil.TempDir("", "layer-cache-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cache := &layerCache{dir: path.Join(tmpDir, "cache")}
+	if err := os.Mkdir(cache.dir, 0755); err != nil {
+		t.Fatalf("unable to create cache directory: %v", err)
+	}
+
+	// the first part ends with the content of the base layer, after the config.json entry
+	blocks := func(n int) int { return (n + 511) / 512 * 512 }
+	client := &overlapExportClient{
+		mockDockerRuntimeClient: &mockDockerRuntimeClient{
+			images: map[string]*docker.Image{"image": {ID: "image"}},
+			exports: map[string][]byte{
+				"image": newImageTarball(t, map[string][]byte{"base/layer.tar": base, "app/layer.tar": app},
+					[]string{"base/layer.tar", "app/layer.tar"}),
+			},
+		},
+		split:   512 + blocks(len("{}")) + 512 + blocks(len(base)),
+		waitFor: "sha256:" + hex.EncodeToString(baseSum[:]),
+		cache:   cache,
+	}
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.DstPath = path.Join(tmpDir, "image")
+	ii := &defaultImageInspector{opts: *opts}
+	if _, err := ii.exportAndExtractImage(context.Background(), client, cache); err != nil {
+		t.Fatalf("unable to extract the image: %v", err)
+	}
+	if !client.overlapped {
+		t.Errorf("expected the base layer to be extracted before the image was exported")
+	}
+	for _, f := range []string{"etc/os-release", "usr/app"} {
+		if _, err := os.Stat(path.Join(opts.DstPath, f)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(path.Join(opts.DstPath, "etc/motd")); !os.IsNotExist(err) {
+		t.Errorf("expected etc/motd to be removed by the whiteout: %v", err)
+	}
+	if entries := cachedLayers(cache); len(entries) != 2 {
+		t.Errorf("expected two cached layers, got %d", len(entries))
+	}
+}
+
+func TestEnsureLayerExtractsOnce(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "layer-cache-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cache := &layerCache{dir: tmpDir}
+	layerTar := path.Join(tmpDir, "layer.tar")
+	layer := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, content: "ID=rhel"},
+	)
+	if err := ioutil.WriteFile(layerTar, layer, 0644); err != nil {
+		t.Fatalf("unable to write the layer: %v", err)
+	}
+
+	results := make(chan bool, 8)
+	var wg sync.WaitGroup
+	for n := 0; n < cap(results); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			extracted, err := cache.ensureLayer("sha256:layer", layerTar)
+			if err != nil {
+				t.Errorf("unexpected error extracting the layer: %v", err)
+			}
+			results <- extracted
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	extractions := 0
+	for extracted := range results {
+		if extracted {
+			extractions++
+		}
+	}
+	if extractions != 1 {
+		t.Errorf("expected the layer to be extracted once, got %d", extractions)
+	}
+	if entries := cachedLayers(cache); len(entries) != 1 {
+		t.Errorf("expected one cached layer, got %d", len(entries))
+	}
+}
+
+func TestInspectImageTarFromStdin(t *testing.T) {
+	oldNewDockerClient, oldImageTarStdin := newDockerClient, imageTarStdin
+	defer func() { newDockerClient, imageTarStdin = oldNewDockerClient, oldImageTarStdin }()
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) {
+		return nil, fmt.Errorf("the docker daemon must not be used")
+	}
+
+	base := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, content: "ID=rhel"},
+		tarEntry{hdr: tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0644}, content: "hello"},
+	)
+	app := newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "etc/.wh.motd", Typeflag: tar.TypeReg, Mode: 0644}},
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{hdr: tar.Header{Name: "usr/app", Typeflag: tar.TypeReg, Mode: 0755}, content: "app"},
+	)
+	config := `{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/usr/bin"],"Cmd":["/usr/app"]}}`
+	configSum := sha256.Sum256([]byte(config))
+	manifest, err := json.Marshal([]saveManifestEntry{{
+		Config:   "0123.json",
+		RepoTags: []string{"app:latest"},
+		Layers:   []string{"base/layer.tar", "app/layer.tar"},
+	}})
+	if err != nil {
+		t.Fatalf("unable to marshal manifest: %v", err)
+	}
+	// the manifest comes last, after the layers, as in the docker save tarballs
+	imageTarStdin = bytes.NewReader(newTarball(t,
+		tarEntry{hdr: tar.Header{Name: "base/layer.tar", Typeflag: tar.TypeReg, Mode: 0644}, content: string(base)},
+		tarEntry{hdr: tar.Header{Name: "app/layer.tar", Typeflag: tar.TypeReg, Mode: 0644}, content: string(app)},
+		tarEntry{hdr: tar.Header{Name: "0123.json", Typeflag: tar.TypeReg, Mode: 0644}, content: config},
+		tarEntry{hdr: tar.Header{Name: saveManifestFile, Typeflag: tar.TypeReg, Mode: 0644}, content: string(manifest)},
+	))
+
+	tmpDir, err := ioutil.TempDir("", "image-tar-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.ImageTar = "-"
+	opts.DstPath = path.Join(tmpDir, "rootfs")
+	opts.ScanType = "hygiene"
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("expected the options to validate, got %v", err)
+	}
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed, got %v", err)
+	}
+
+	for _, f := range []string{"etc/os-release", "usr/app"} {
+		if _, err := os.Stat(path.Join(opts.DstPath, f)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(path.Join(opts.DstPath, "etc/motd")); !os.IsNotExist(err) {
+		t.Errorf("expected etc/motd to be removed by the whiteout: %v", err)
+	}
+	image := ii.meta.Image
+	if image.ID != "sha256:"+hex.EncodeToString(configSum[:]) || image.Architecture != "amd64" {
+		t.Errorf("unexpected image metadata %+v", image)
+	}
+	if image.Config == nil || len(image.Config.Env) != 1 || image.Config.Env[0] != "PATH=/usr/bin" {
+		t.Errorf("expected the image config to be read, got %+v", image.Config)
+	}
+	if len(image.RepoTags) != 1 || image.RepoTags[0] != "app:latest" {
+		t.Errorf("expected the repo tags of the manifest, got %v", image.RepoTags)
+	}
+}
+
+func TestApplyLayerOutsideDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply-layer-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a lower layer left a symlinked directory pointing outside of the image
+	outside, layerDir, destination := path.Join(dir, "outside"), path.Join(dir, "layer"), path.Join(dir, "rootfs")
+	for _, d := range []string{outside, path.Join(layerDir, "escape"), destination} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("unable to create directory: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(outside, "victim"), []byte("host"), 0644); err != nil {
+		t.Fatalf("unable to create file: %v", err)
+	}
+	if err := os.Symlink(outside, path.Join(destination, "escape")); err != nil {
+		t.Fatalf("unable to create symlink: %v", err)
+	}
+	for name, content := range map[string]string{
+		"escape/dropped":                      "image",
+		"escape/" + whiteoutPrefix + "victim": "",
+		"escape/" + whiteoutOpaqueDir:         "",
+		"inside":                              "image",
+	} {
+		if err := ioutil.WriteFile(path.Join(layerDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unable to create file: %v", err)
+		}
+	}
+
+	if err := applyLayer(layerDir, destination, iiapi.SymlinkKeep); err != nil {
+		t.Fatalf("unexpected error applying the layer: %v", err)
+	}
+	if _, err := os.Stat(path.Join(outside, "victim")); err != nil {
+		t.Errorf("expected the whiteouts not to remove files outside of the extraction root: %v", err)
+	}
+	if _, err := os.Stat(path.Join(outside, "dropped")); err == nil {
+		t.Errorf("expected the layer not to write files outside of the extraction root")
+	}
+	if _, err := os.Stat(path.Join(destination, "inside")); err != nil {
+		t.Errorf("expected the files inside the extraction root to be applied: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package inspector
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// newImageMetadata describes image from its history, given from the most recent layer.
+func newImageMetadata(image *docker.Image, history []docker.ImageHistory) *iiapi.ImageMetadata {
+	metadata := &iiapi.ImageMetadata{Layers: []iiapi.LayerMetadata{}}
+	for n := len(history) - 1; n >= 0; n-- {
+		// the instructions that don't change the filesystem are in the history without
+		// adding a layer
+		if history[n].Size == 0 {
+			continue
+		}
+		metadata.Layers = append(metadata.Layers, iiapi.LayerMetadata{
+			ID:        history[n].ID,
+			Size:      history[n].Size,
+			CreatedBy: history[n].CreatedBy,
+		})
+		metadata.TotalSize += history[n].Size
+	}
+	metadata.LayerCount = len(metadata.Layers)
+	if image.Config != nil {
+		metadata.Labels = image.Config.Labels
+	}
+	return metadata
+}
+
+// inspectMetadata reports the metadata of the image available in the docker daemon,
+// without creating a container to extract it.
+func (i *defaultImageInspector) inspectMetadata(ctx context.Context, client DockerRuntimeClient, image *docker.Image, scanResults iiapi.ScanResult) error {
+	history, err := client.ImageHistory(image.ID)
+	if err != nil {
+		return fmt.Errorf("Unable to get the history of image %s: %v\n", i.opts.Image, err)
+	}
+	i.meta.Image = *image
+	scanResults.ImageID = image.ID
+	scanResults.ImageMetadata = newImageMetadata(image, history)
+	scanResults.Complete = true
+	log.Printf("Image %s has %d layers of %d bytes in total", i.opts.Image,
+		scanResults.ImageMetadata.LayerCount, scanResults.ImageMetadata.TotalSize)
+
+	if len(i.opts.OutputFile) > 0 {
+		if err := writeResults(i.opts.OutputFile, i.opts.OutputGzip, scanResults); err != nil {
+			return err
+		}
+	}
+
+	if len(i.opts.PostResultURL) > 0 {
+		if err := i.postResults(ctx, scanResults, "", ""); err != nil {
+			log.Printf("Error posting results: %v", err)
+			if !i.opts.IgnorePostErrors {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
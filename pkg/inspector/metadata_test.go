@@ -0,0 +1,102 @@
+package inspector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestInspectMetadataOnly(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	image := &docker.Image{
+		ID:     "sha256:0123456789abcdef",
+		Config: &docker.Config{Labels: map[string]string{"name": "app", "version": "1.0"}},
+	}
+	client := &mockDockerRuntimeClient{
+		images: map[string]*docker.Image{"image": image, image.ID: image},
+		histories: map[string][]docker.ImageHistory{image.ID: {
+			{ID: image.ID, CreatedBy: "/bin/sh -c #(nop)  LABEL version=1.0"},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop) COPY file:app in /app", Size: 2048},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop)  ENV PATH=/usr/bin"},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c yum install -y httpd", Size: 30000},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop) ADD file:base in /", Size: 200000},
+		}},
+		// no container can be created to extract the image
+		createErr: fmt.Errorf("unexpected container creation"),
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	dir, err := ioutil.TempDir("", "output-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.PullPolicy = iiapi.PullNever
+	opts.MetadataOnly = true
+	opts.OutputFile = path.Join(dir, "results.json")
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("expected the options to validate, got %v", err)
+	}
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed, got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(opts.OutputFile)
+	if err != nil {
+		t.Fatalf("expected the results file to be written: %v", err)
+	}
+	var results iiapi.ScanResult
+	if err := json.Unmarshal(content, &results); err != nil {
+		t.Fatalf("expected the results file to hold JSON, got %q: %v", content, err)
+	}
+	expected := &iiapi.ImageMetadata{
+		LayerCount: 3,
+		Layers: []iiapi.LayerMetadata{
+			{ID: "<missing>", Size: 200000, CreatedBy: "/bin/sh -c #(nop) ADD file:base in /"},
+			{ID: "<missing>", Size: 30000, CreatedBy: "/bin/sh -c yum install -y httpd"},
+			{ID: "<missing>", Size: 2048, CreatedBy: "/bin/sh -c #(nop) COPY file:app in /app"},
+		},
+		TotalSize: 232048,
+		Labels:    map[string]string{"name": "app", "version": "1.0"},
+	}
+	if !reflect.DeepEqual(results.ImageMetadata, expected) {
+		t.Errorf("expected the image metadata %+v, got %+v", expected, results.ImageMetadata)
+	}
+	if results.ImageID != image.ID || !results.Complete || len(results.Results) > 0 {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if ii.meta.Image.ID != image.ID || len(ii.meta.Scanners) > 0 {
+		t.Errorf("expected the metadata of the image without scans, got %+v", ii.meta)
+	}
+}
+
+func TestInspectMetadataOnlyWithoutHistory(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	image := &docker.Image{ID: "sha256:0123456789abcdef"}
+	client := &mockDockerRuntimeClient{images: map[string]*docker.Image{"image": image}}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.PullPolicy = iiapi.PullNever
+	opts.MetadataOnly = true
+	err := NewDefaultImageInspector(*opts).Inspect()
+	if err == nil {
+		t.Errorf("expected the inspection to fail without the image history")
+	}
+}
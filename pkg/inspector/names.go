@@ -0,0 +1,92 @@
+package inspector
+
+import (
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/version"
+)
+
+const (
+	// defaultRegistry is the registry of the image names without a registry
+	defaultRegistry = "docker.io"
+	// officialRepoPrefix is the namespace of the single component names of defaultRegistry
+	officialRepoPrefix = "library/"
+	// defaultTag is the tag of the image names without a tag or a digest
+	defaultTag = "latest"
+)
+
+// newScanResult returns the empty results of the scan of the option's image.
+func (i *defaultImageInspector) newScanResult() iiapi.ScanResult {
+	scanResults := iiapi.ScanResult{
+		APIVersion: iiapi.DefaultResultsAPIVersion,
+		ImageName:  normalizeImageName(i.opts.Image),
+		Results:    []iiapi.Result{},
+	}
+	if i.opts.KeepOriginalImageName {
+		scanResults.OriginalImageName = i.opts.Image
+	}
+	if i.opts.EmbedProvenance {
+		provenance := version.Provenance()
+		scanResults.ToolProvenance = &provenance
+	}
+	return scanResults
+}
+
+// normalizeImageName returns the canonical form of an image name, i.e. registry/repo:tag
+// or registry/repo@digest, filling in the defaults of the docker client for the registry,
+// the namespace and the tag.
+func normalizeImageName(name string) string {
+	if len(name) == 0 {
+		return name
+	}
+
+	remainder, digest := name, ""
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		remainder, digest = remainder[:i], remainder[i+1:]
+	}
+	repo, tag := docker.ParseRepositoryTag(remainder)
+
+	registry, repo := splitRegistry(repo)
+	if registry == defaultRegistry && !strings.Contains(repo, "/") {
+		repo = officialRepoPrefix + repo
+	}
+
+	switch {
+	case len(digest) > 0:
+		return registry + "/" + repo + "@" + digest
+	case len(tag) > 0:
+		return registry + "/" + repo + ":" + tag
+	}
+	return registry + "/" + repo + ":" + defaultTag
+}
+
+// splitRegistry splits repo into its registry, defaultRegistry when missing, and the
+// remaining repository path.
+func splitRegistry(repo string) (string, string) {
+	if i := strings.Index(repo, "/"); i >= 0 {
+		if first := repo[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			return first, repo[i+1:]
+		}
+	}
+	return defaultRegistry, repo
+}
+
+// mirrorImageName returns the name of the image in the registry mirror, e.g.
+// mirror.example.com/library/fedora:26 for fedora:26, mirror can include a path prefix.
+func mirrorImageName(name, mirror string) string {
+	normalized := normalizeImageName(name)
+	return strings.TrimSuffix(mirror, "/") + normalized[strings.Index(normalized, "/"):]
+}
+
+// imageRegistry returns the registry of the image name, defaultRegistry when missing.
+func imageRegistry(name string) string {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	repo, _ := docker.ParseRepositoryTag(name)
+	registry, _ := splitRegistry(repo)
+	return registry
+}
@@ -0,0 +1,85 @@
+package inspector
+
+import (
+	"reflect"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+	"github.com/openshift/image-inspector/pkg/version"
+)
+
+func TestNormalizeImageName(t *testing.T) {
+	digest := "sha256:b7b28f4f8c7ad3e0f3d2e3b6a4e8e2a1c2d5f6a7b8c9d0e1f2a3b4c5d6e7f8a9"
+	for name, expected := range map[string]string{
+		"":                                     "",
+		"fedora":                               "docker.io/library/fedora:latest",
+		"fedora:26":                            "docker.io/library/fedora:26",
+		"mfojtik/virus-test":                   "docker.io/mfojtik/virus-test:latest",
+		"docker.io/library/fedora:26":          "docker.io/library/fedora:26",
+		"registry.access.redhat.com/rhel7":     "registry.access.redhat.com/rhel7:latest",
+		"172.30.203.184:5000/myproject/app:v1": "172.30.203.184:5000/myproject/app:v1",
+		"localhost/app":                        "localhost/app:latest",
+		"localhost:5000/app":                   "localhost:5000/app:latest",
+		"fedora@" + digest:                     "docker.io/library/fedora@" + digest,
+		"quay.io/org/app:v1@" + digest:         "quay.io/org/app@" + digest,
+	} {
+		if normalized := normalizeImageName(name); normalized != expected {
+			t.Errorf("expected %q to be normalized to %q, got %q", name, expected, normalized)
+		}
+	}
+}
+
+func TestNewScanResultImageName(t *testing.T) {
+	for k, v := range map[string]struct {
+		image            string
+		keepOriginal     bool
+		expectedName     string
+		expectedOriginal string
+	}{
+		"short name":                   {image: "fedora", expectedName: "docker.io/library/fedora:latest"},
+		"short name keeping original":  {image: "fedora", keepOriginal: true, expectedName: "docker.io/library/fedora:latest", expectedOriginal: "fedora"},
+		"canonical name":               {image: "quay.io/org/app:v1", keepOriginal: true, expectedName: "quay.io/org/app:v1", expectedOriginal: "quay.io/org/app:v1"},
+		"tagged name without original": {image: "centos:7", expectedName: "docker.io/library/centos:7"},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = v.image
+		opts.KeepOriginalImageName = v.keepOriginal
+		ii := &defaultImageInspector{opts: *opts}
+
+		result := ii.newScanResult()
+		if result.ImageName != v.expectedName {
+			t.Errorf("%s: expected image name %q, got %q", k, v.expectedName, result.ImageName)
+		}
+		if result.OriginalImageName != v.expectedOriginal {
+			t.Errorf("%s: expected original image name %q, got %q", k, v.expectedOriginal, result.OriginalImageName)
+		}
+	}
+}
+
+func TestNewScanResultProvenance(t *testing.T) {
+	oldVersion, oldCommit, oldBuildDate := version.Version, version.Commit, version.BuildDate
+	defer func() { version.Version, version.Commit, version.BuildDate = oldVersion, oldCommit, oldBuildDate }()
+	version.Version, version.Commit, version.BuildDate = "v2.1.0", "0123abc", "2018-01-02T03:04:05Z"
+
+	for k, v := range map[string]struct {
+		embed    bool
+		expected *iiapi.ToolProvenance
+	}{
+		"provenance not requested": {embed: false},
+		"provenance embedded": {
+			embed:    true,
+			expected: &iiapi.ToolProvenance{Version: "v2.1.0", Commit: "0123abc", BuildDate: "2018-01-02T03:04:05Z"},
+		},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.Image = "fedora"
+		opts.EmbedProvenance = v.embed
+		ii := &defaultImageInspector{opts: *opts}
+
+		result := ii.newScanResult()
+		if !reflect.DeepEqual(result.ToolProvenance, v.expected) {
+			t.Errorf("%s: expected provenance %v, got %v", k, v.expected, result.ToolProvenance)
+		}
+	}
+}
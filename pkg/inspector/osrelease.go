@@ -0,0 +1,149 @@
+package inspector
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/unowned"
+)
+
+// osReleaseFiles are the os-release files, relative to the image root, in lookup order.
+var osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+
+// redhatReleaseFile identifies the OS of the images predating os-release.
+const redhatReleaseFile = "etc/redhat-release"
+
+// osFamilies maps the os-release ID and ID_LIKE values to their OS family.
+var osFamilies = map[string]string{
+	"rhel":      iiapi.OSFamilyRHEL,
+	"centos":    iiapi.OSFamilyRHEL,
+	"fedora":    iiapi.OSFamilyRHEL,
+	"rocky":     iiapi.OSFamilyRHEL,
+	"almalinux": iiapi.OSFamilyRHEL,
+	"debian":    iiapi.OSFamilyDebian,
+	"ubuntu":    iiapi.OSFamilyDebian,
+	"alpine":    iiapi.OSFamilyAlpine,
+}
+
+// osFamilyFiles are the files, relative to the image root, that identify the OS family of
+// an image without an os-release file.
+var osFamilyFiles = []struct{ name, family string }{
+	{redhatReleaseFile, iiapi.OSFamilyRHEL},
+	{"etc/debian_version", iiapi.OSFamilyDebian},
+	{"etc/alpine-release", iiapi.OSFamilyAlpine},
+}
+
+// packageManagers are the package managers detected by their database, relative to the
+// image root, and the OS family they belong to.
+var packageManagers = []struct{ db, manager, family string }{
+	{unowned.RPMDBDir, iiapi.PackageManagerRPM, iiapi.OSFamilyRHEL},
+	{"usr/lib/sysimage/rpm", iiapi.PackageManagerRPM, iiapi.OSFamilyRHEL},
+	{unowned.DpkgInfoDir, iiapi.PackageManagerDpkg, iiapi.OSFamilyDebian},
+	{unowned.ApkInstalledDB, iiapi.PackageManagerApk, iiapi.OSFamilyAlpine},
+}
+
+// hasOSRelease returns true when the image extracted in root has an os-release or a
+// redhat-release file. The files are not followed, since absolute symlinks would resolve
+// on the host.
+func hasOSRelease(root string) bool {
+	for _, name := range append([]string{redhatReleaseFile}, osReleaseFiles...) {
+		if _, err := os.Lstat(path.Join(root, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOSRelease returns true when the OS dependent scanners must be skipped because the
+// OS of the image, which has no OS release file, is unknown.
+func (i *defaultImageInspector) checkOSRelease() bool {
+	if hasOSRelease(i.opts.DstPath) {
+		return false
+	}
+	if i.opts.UnknownOSPolicy == iiapi.UnknownOSSkip {
+		log.Printf("WARNING: No OS release file was found in the image, skipping the OS dependent scanners")
+		return true
+	}
+	log.Printf("WARNING: No OS release file was found in the image, probing its OS")
+	return false
+}
+
+// detectOS returns the OS family and the package manager of the image extracted in root,
+// empty when unknown. The family is taken from the os-release file, falling back to the
+// family specific release files, and the package manager from the package databases,
+// falling back to the one of the family.
+func detectOS(root string) (family, manager string) {
+	family = osReleaseFamily(root)
+	for _, f := range osFamilyFiles {
+		if len(family) > 0 {
+			break
+		}
+		if _, err := os.Lstat(path.Join(root, f.name)); err == nil {
+			family = f.family
+		}
+	}
+	for _, pm := range packageManagers {
+		if _, err := os.Lstat(path.Join(root, pm.db)); err == nil {
+			manager = pm.manager
+			if len(family) == 0 {
+				family = pm.family
+			}
+			return family, manager
+		}
+	}
+	for _, pm := range packageManagers {
+		if pm.family == family {
+			return family, pm.manager
+		}
+	}
+	return family, ""
+}
+
+// osReleaseFamily returns the OS family of the ID, or of the first known ID_LIKE, of the
+// os-release file of the image extracted in root. Only regular files are read, since
+// absolute symlinks would resolve on the host.
+func osReleaseFamily(root string) string {
+	for _, name := range osReleaseFiles {
+		fi, err := os.Lstat(path.Join(root, name))
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(path.Join(root, name))
+		if err != nil {
+			log.Printf("WARNING: Unable to read %s: %v", name, err)
+			continue
+		}
+		defer f.Close()
+		var ids []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value := splitOSReleaseLine(scanner.Text())
+			switch key {
+			case "ID":
+				ids = append([]string{value}, ids...)
+			case "ID_LIKE":
+				ids = append(ids, strings.Fields(value)...)
+			}
+		}
+		for _, id := range ids {
+			if family, ok := osFamilies[id]; ok {
+				return family
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// splitOSReleaseLine returns the key and the unquoted value of an os-release line.
+func splitOSReleaseLine(line string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], strings.ToLower(strings.Trim(parts[1], `"'`))
+}
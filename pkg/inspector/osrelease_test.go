@@ -0,0 +1,205 @@
+package inspector
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestInspectUnknownOSPolicy(t *testing.T) {
+	for k, v := range map[string]struct {
+		releaseFile    string
+		symlink        bool
+		policy         string
+		expectedStatus iiapi.OpenSCAPStatus
+	}{
+		"unknown os skip":       {policy: iiapi.UnknownOSSkip, expectedStatus: iiapi.StatusUnknownOS},
+		"unknown os probe":      {policy: iiapi.UnknownOSProbe, expectedStatus: iiapi.StatusSuccess},
+		"os-release skip":       {releaseFile: "etc/os-release", policy: iiapi.UnknownOSSkip, expectedStatus: iiapi.StatusSuccess},
+		"usr os-release skip":   {releaseFile: "usr/lib/os-release", policy: iiapi.UnknownOSSkip, expectedStatus: iiapi.StatusSuccess},
+		"redhat-release skip":   {releaseFile: "etc/redhat-release", policy: iiapi.UnknownOSSkip, expectedStatus: iiapi.StatusSuccess},
+		"dangling symlink skip": {releaseFile: "etc/os-release", symlink: true, policy: iiapi.UnknownOSSkip, expectedStatus: iiapi.StatusSuccess},
+	} {
+		rootfs, err := ioutil.TempDir("", "rootfs-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(rootfs)
+		resultsDir, err := ioutil.TempDir("", "results-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(resultsDir)
+
+		// the minimal image has a filesystem, but no release file
+		if err := os.MkdirAll(path.Join(rootfs, "etc"), 0755); err != nil {
+			t.Fatalf("unable to create the fixture: %v", err)
+		}
+		if err := os.MkdirAll(path.Join(rootfs, "usr", "lib"), 0755); err != nil {
+			t.Fatalf("unable to create the fixture: %v", err)
+		}
+		switch {
+		case len(v.releaseFile) == 0:
+		case v.symlink:
+			// an absolute symlink, which is not followed, is enough to identify the image
+			err = os.Symlink("/usr/lib/no-such-os-release", path.Join(rootfs, v.releaseFile))
+		default:
+			err = ioutil.WriteFile(path.Join(rootfs, v.releaseFile), []byte("NAME=Fedora\n"), 0644)
+		}
+		if err != nil {
+			t.Fatalf("unable to create the fixture: %v", err)
+		}
+
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "openscap"
+		opts.ScanResultsDir = resultsDir
+		opts.UnknownOSPolicy = v.policy
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		scanned := false
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			scanned = true
+			return &SuccMockScanner{}, nil
+		}
+
+		if err := ii.Inspect(); err != nil {
+			t.Errorf("%s: the inspection failed: %v", k, err)
+			continue
+		}
+		if ii.meta.OpenSCAP.Status != v.expectedStatus {
+			t.Errorf("%s: expected the OpenSCAP status %s, got %s", k, v.expectedStatus, ii.meta.OpenSCAP.Status)
+		}
+		if skipped := v.expectedStatus == iiapi.StatusUnknownOS; scanned == skipped {
+			t.Errorf("%s: expected the scanner to be run to be %t", k, !skipped)
+		}
+		if len(ii.meta.Scanners) != 1 || ii.meta.Scanners[0].Status != v.expectedStatus {
+			t.Errorf("%s: expected the scanner status %s to be recorded, got %v", k, v.expectedStatus, ii.meta.Scanners)
+		}
+	}
+}
+
+func TestDetectOS(t *testing.T) {
+	type file struct {
+		name, content string
+		dir           bool
+	}
+	for k, v := range map[string]struct {
+		files           []file
+		expectedFamily  string
+		expectedManager string
+	}{
+		"rhel": {
+			files: []file{
+				{name: "etc/os-release", content: "NAME=\"Red Hat Enterprise Linux\"\nID=\"rhel\"\nID_LIKE=\"fedora\"\n"},
+				{name: "var/lib/rpm", dir: true},
+			},
+			expectedFamily: iiapi.OSFamilyRHEL, expectedManager: iiapi.PackageManagerRPM,
+		},
+		"centos without os-release": {
+			files:          []file{{name: "etc/redhat-release", content: "CentOS release 6.10 (Final)\n"}},
+			expectedFamily: iiapi.OSFamilyRHEL, expectedManager: iiapi.PackageManagerRPM,
+		},
+		"fedora sysimage rpmdb": {
+			files: []file{
+				{name: "usr/lib/os-release", content: "NAME=Fedora\nID=fedora\n"},
+				{name: "usr/lib/sysimage/rpm", dir: true},
+			},
+			expectedFamily: iiapi.OSFamilyRHEL, expectedManager: iiapi.PackageManagerRPM,
+		},
+		"debian": {
+			files: []file{
+				{name: "etc/os-release", content: "PRETTY_NAME=\"Debian GNU/Linux 9 (stretch)\"\nID=debian\n"},
+				{name: "var/lib/dpkg/info", dir: true},
+			},
+			expectedFamily: iiapi.OSFamilyDebian, expectedManager: iiapi.PackageManagerDpkg,
+		},
+		"ubuntu derivative": {
+			files: []file{
+				{name: "etc/os-release", content: "NAME=\"Linux Mint\"\nID=linuxmint\nID_LIKE=\"ubuntu debian\"\n"},
+			},
+			expectedFamily: iiapi.OSFamilyDebian, expectedManager: iiapi.PackageManagerDpkg,
+		},
+		"alpine": {
+			files: []file{
+				{name: "etc/os-release", content: "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.8.1\n"},
+				{name: "lib/apk/db/installed", content: "P:musl\n"},
+			},
+			expectedFamily: iiapi.OSFamilyAlpine, expectedManager: iiapi.PackageManagerApk,
+		},
+		"alpine without os-release": {
+			files:          []file{{name: "etc/alpine-release", content: "3.8.1\n"}},
+			expectedFamily: iiapi.OSFamilyAlpine, expectedManager: iiapi.PackageManagerApk,
+		},
+		"package database only": {
+			files:          []file{{name: "lib/apk/db/installed", content: "P:musl\n"}},
+			expectedFamily: iiapi.OSFamilyAlpine, expectedManager: iiapi.PackageManagerApk,
+		},
+		"unknown id": {
+			files: []file{{name: "etc/os-release", content: "ID=plan9\n"}},
+		},
+		"empty image": {},
+	} {
+		rootfs, err := ioutil.TempDir("", "rootfs-")
+		if err != nil {
+			t.Fatalf("unable to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(rootfs)
+		for _, f := range v.files {
+			name := path.Join(rootfs, f.name)
+			if f.dir {
+				err = os.MkdirAll(name, 0755)
+			} else if err = os.MkdirAll(path.Dir(name), 0755); err == nil {
+				err = ioutil.WriteFile(name, []byte(f.content), 0644)
+			}
+			if err != nil {
+				t.Fatalf("%s: unable to create the fixture: %v", k, err)
+			}
+		}
+
+		family, manager := detectOS(rootfs)
+		if family != v.expectedFamily || manager != v.expectedManager {
+			t.Errorf("%s: expected %q and %q, got %q and %q", k, v.expectedFamily, v.expectedManager, family, manager)
+		}
+	}
+}
+
+func TestInspectDetectOS(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	if err := os.MkdirAll(path.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("unable to create the fixture: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(rootfs, "etc", "alpine-release"), []byte("3.8.1\n"), 0644); err != nil {
+		t.Fatalf("unable to create the fixture: %v", err)
+	}
+
+	for _, detect := range []bool{false, true} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.URI = ""
+		opts.RootfsPath = rootfs
+		opts.ScanType = "unowned"
+		opts.DetectOS = detect
+		ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+		ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return &SuccMockScanner{}, nil
+		}
+		if err := ii.Inspect(); err != nil {
+			t.Fatalf("the inspection failed: %v", err)
+		}
+		expected := ""
+		if detect {
+			expected = iiapi.OSFamilyAlpine
+		}
+		if ii.meta.OSFamily != expected {
+			t.Errorf("expected the OS family %q when detect-os is %t, got %q", expected, detect, ii.meta.OSFamily)
+		}
+	}
+}
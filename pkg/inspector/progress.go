@@ -0,0 +1,220 @@
+package inspector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift/image-inspector/pkg/logging"
+)
+
+// pullMessage is a message of the docker daemon about the progress of a pull.
+type pullMessage struct {
+	Status, Id     string
+	ProgressDetail struct {
+		Current, Total int
+	}
+	Error string
+}
+
+// pullTotals are the totals of a pull across the layers of the image.
+type pullTotals struct {
+	// Downloaded are the bytes of the layers downloaded so far
+	Downloaded int
+	// Total is the size of the layers whose size is known, their size is reported once
+	// their download starts
+	Total int
+	// Layers is the number of layers reported, Complete those pulled and Extracting those
+	// downloaded and being extracted
+	Layers, Extracting, Complete int
+}
+
+// percent returns the percentage of the bytes downloaded, -1 when the total is not known.
+func (t pullTotals) percent() int {
+	if t.Total == 0 {
+		return -1
+	}
+	return t.Downloaded * 100 / t.Total
+}
+
+// eta estimates the time to download the rest of the layers at the rate of the first
+// elapsed, -1 when not known.
+func (t pullTotals) eta(elapsed time.Duration) time.Duration {
+	if t.Downloaded == 0 || t.Total < t.Downloaded {
+		return -1
+	}
+	return time.Duration(float64(elapsed) * float64(t.Total-t.Downloaded) / float64(t.Downloaded)).Round(time.Second)
+}
+
+// layerProgress is the progress of the pull of a layer.
+type layerProgress struct {
+	downloaded, total int
+	extracting        bool
+	complete          bool
+}
+
+// pullProgress aggregates the progress of a pull from the pull messages.
+type pullProgress struct {
+	lock   sync.Mutex
+	layers map[string]*layerProgress
+}
+
+func newPullProgress() *pullProgress {
+	return &pullProgress{layers: map[string]*layerProgress{}}
+}
+
+// layerStatuses are the statuses of the pull messages about a layer, the id of the other
+// messages is the tag of the image.
+var layerStatuses = map[string]bool{
+	"Pulling fs layer":   true,
+	"Waiting":            true,
+	"Downloading":        true,
+	"Verifying Checksum": true,
+	"Download complete":  true,
+	"Extracting":         true,
+	"Pull complete":      true,
+	"Already exists":     true,
+}
+
+// update records the progress reported by the pull message v.
+func (p *pullProgress) update(v pullMessage) {
+	if len(v.Id) == 0 || !layerStatuses[v.Status] {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	layer, ok := p.layers[v.Id]
+	if !ok {
+		layer = &layerProgress{}
+		p.layers[v.Id] = layer
+	}
+	switch v.Status {
+	case "Downloading":
+		layer.downloaded = v.ProgressDetail.Current
+		if v.ProgressDetail.Total > 0 {
+			layer.total = v.ProgressDetail.Total
+		}
+	case "Download complete", "Verifying Checksum":
+		layer.downloaded = layer.total
+	case "Extracting":
+		// the download is done, the progress detail is the one of the extraction
+		if v.ProgressDetail.Total > 0 {
+			layer.total = v.ProgressDetail.Total
+		}
+		layer.downloaded, layer.extracting = layer.total, true
+	case "Pull complete", "Already exists":
+		layer.downloaded, layer.extracting, layer.complete = layer.total, false, true
+	}
+}
+
+// totals returns the totals of the pull so far.
+func (p *pullProgress) totals() pullTotals {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	t := pullTotals{Layers: len(p.layers)}
+	for _, layer := range p.layers {
+		t.Downloaded += layer.downloaded
+		t.Total += layer.total
+		if layer.extracting {
+			t.Extracting++
+		}
+		if layer.complete {
+			t.Complete++
+		}
+	}
+	return t
+}
+
+// reportPullProgress logs the totals of progress every PULL_LOG_INTERVAL_SEC seconds when
+// they changed, and once more when done is closed.
+func reportPullProgress(progress *pullProgress, done <-chan struct{}) {
+	started := time.Now()
+	ticker := time.NewTicker(PULL_LOG_INTERVAL_SEC * time.Second)
+	defer ticker.Stop()
+	var last pullTotals
+	for {
+		select {
+		case <-done:
+			t := progress.totals()
+			logging.Info(fmt.Sprintf("Finished Downloading Image (%dKb downloaded, %d of %d layers complete)",
+				t.Downloaded/1024, t.Complete, t.Layers), pullFields(t, time.Since(started)))
+			return
+		case <-ticker.C:
+			t := progress.totals()
+			if t == last {
+				continue
+			}
+			last = t
+			elapsed := time.Since(started)
+			msg := fmt.Sprintf("Downloading Image (%dKb downloaded", t.Downloaded/1024)
+			if percent := t.percent(); percent >= 0 {
+				msg = fmt.Sprintf("Downloading Image (%dKb of %dKb downloaded, %d%%", t.Downloaded/1024, t.Total/1024, percent)
+				if eta := t.eta(elapsed); eta >= 0 {
+					msg += fmt.Sprintf(", ETA %v", eta)
+				}
+			}
+			logging.Info(fmt.Sprintf("%s, %d extracting, %d of %d layers complete)", msg, t.Extracting, t.Complete, t.Layers),
+				pullFields(t, elapsed))
+		}
+	}
+}
+
+// pullFields are the fields of the logged totals of a pull.
+func pullFields(t pullTotals, elapsed time.Duration) logging.Fields {
+	fields := logging.Fields{"phase": "pull", "bytes": t.Downloaded, "duration": elapsed,
+		"layers": t.Layers, "complete": t.Complete}
+	if percent := t.percent(); percent >= 0 {
+		fields["total"], fields["percent"] = t.Total, percent
+		if eta := t.eta(elapsed); eta >= 0 {
+			fields["eta"] = eta
+		}
+	}
+	return fields
+}
+
+// extractProgress counts the entries and the bytes extracted from a tar stream.
+type extractProgress struct {
+	lock  sync.Mutex
+	files int
+	bytes int64
+}
+
+// add is the progress callback of processTarStream.
+func (p *extractProgress) add(files int, bytes int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.files += files
+	p.bytes += bytes
+}
+
+// counts returns the entries and the bytes extracted so far.
+func (p *extractProgress) counts() (int, int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.files, p.bytes
+}
+
+// reportExtractProgress logs the counts of progress, extracting srcPath of image, every
+// PULL_LOG_INTERVAL_SEC seconds when they changed, until done is closed. The totals are
+// logged once the image is extracted.
+func reportExtractProgress(image, srcPath string, progress *extractProgress, done <-chan struct{}) {
+	started := time.Now()
+	ticker := time.NewTicker(PULL_LOG_INTERVAL_SEC * time.Second)
+	defer ticker.Stop()
+	lastFiles := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			files, bytes := progress.counts()
+			if files == lastFiles {
+				continue
+			}
+			lastFiles = files
+			logging.Info(fmt.Sprintf("Extracting image %s (%d files, %dKb written)", image, files, bytes/1024),
+				logging.Fields{"image": image, "phase": "extract", "path": srcPath, "files": files, "bytes": bytes,
+					"duration": time.Since(started)})
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package inspector
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/clamav"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+	"github.com/openshift/image-inspector/pkg/hygiene"
+	"github.com/openshift/image-inspector/pkg/kmod"
+	"github.com/openshift/image-inspector/pkg/permissions"
+	"github.com/openshift/image-inspector/pkg/shadowed"
+	"github.com/openshift/image-inspector/pkg/unowned"
+)
+
+// eicarTestFile is the standard anti-virus test file, detected by any anti-virus as a virus.
+const eicarTestFile = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// selfTestFile is a file of a self-test fixture.
+type selfTestFile struct {
+	name    string
+	content string
+	mode    int64
+}
+
+// selfTestFixture is a tiny image filesystem with known problems that a scanner must report.
+type selfTestFixture struct {
+	files []selfTestFile
+	// expected are the references of the results the scanner must report
+	expected []string
+}
+
+// selfTestFixtures are the self-test fixtures keyed by scan type.
+var selfTestFixtures = map[string]selfTestFixture{
+	clamav.ScannerName: {
+		files: []selfTestFile{
+			{name: "etc/hostname", content: "selftest\n", mode: 0644},
+			{name: "eicar.com", content: eicarTestFile, mode: 0644},
+		},
+		expected: []string{"file:///eicar.com"},
+	},
+	unowned.ScannerName: {
+		files: []selfTestFile{
+			{name: unowned.DpkgInfoDir + "/coreutils.list", content: "/usr/bin/ls\n", mode: 0644},
+			{name: "usr/bin/ls", content: "ls", mode: 0755},
+			{name: "usr/bin/dropped", content: "dropped", mode: 0755},
+		},
+		expected: []string{"file:///usr/bin/dropped"},
+	},
+	hygiene.ScannerName: {
+		files: []selfTestFile{
+			{name: "etc/hostname", content: "selftest\n", mode: 0644},
+			{name: "root/.bash_history", content: "ls\n", mode: 0600},
+		},
+		expected: []string{"file:///root/.bash_history"},
+	},
+	kmod.ScannerName: {
+		files: []selfTestFile{
+			{name: "etc/hostname", content: "selftest\n", mode: 0644},
+			{name: "etc/rc.local", content: "#!/bin/sh\nmodprobe dummy\n", mode: 0755},
+		},
+		expected: []string{"file:///etc/rc.local"},
+	},
+	permissions.ScannerName: {
+		files: []selfTestFile{
+			{name: "etc/hostname", content: "selftest\n", mode: 0644},
+			{name: "etc/shadow", content: "root:*:18000:0:99999:7:::\n", mode: 0644},
+		},
+		expected: []string{"file:///etc/shadow"},
+	},
+	shadowed.ScannerName: {
+		files: []selfTestFile{
+			{name: "usr/bin/ls", content: "ls", mode: 0755},
+			{name: "usr/local/bin/ls", content: "trojan", mode: 0755},
+		},
+		expected: []string{"file:///usr/local/bin/ls"},
+	},
+}
+
+// SelfTest validates the whole inspection pipeline: it extracts a tiny built-in image with
+// known problems, scans it with the option's scan type and returns an error unless exactly
+// the expected problems are reported.
+func SelfTest(ctx context.Context, opts iicmd.ImageInspectorOptions) error {
+	return runSelfTest(ctx, opts, newDefaultScanner)
+}
+
+func runSelfTest(ctx context.Context, opts iicmd.ImageInspectorOptions, newScanner scannerFunc) error {
+	fixture, ok := selfTestFixtures[opts.ScanType]
+	if !ok {
+		available := []string{}
+		for scanType := range selfTestFixtures {
+			available = append(available, scanType)
+		}
+		sort.Strings(available)
+		return fmt.Errorf("selftest is not available for scan-type %q, there is no built-in fixture for it, the scan-types with a fixture are %v",
+			opts.ScanType, available)
+	}
+
+	tarball, err := fixture.tarball()
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := createOutputDir(opts.DstPath, "image-inspector-selftest-", opts.OutputDirMode.Mode)
+	if err != nil {
+		return err
+	}
+	if len(opts.DstPath) == 0 {
+		defer os.RemoveAll(dstPath)
+	}
+
+	log.Printf("Extracting the selftest image to %s", dstPath)
+	if err := processTarStream(tar.NewReader(bytes.NewReader(tarball)), dstPath, tarExtractOptions{
+		symlinkPolicy: opts.SymlinkPolicy,
+	}); err != nil {
+		return err
+	}
+
+	scanner, err := newScanner(opts)
+	if err != nil {
+		return fmt.Errorf("Unable to initialize the %s scanner: %v\n", opts.ScanType, err)
+	}
+	results, _, err := safeScan(ctx, scanner, dstPath, &docker.Image{ID: "selftest"}, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to scan the selftest image: %v\n", err)
+	}
+
+	return compareSelfTestResults(fixture.expected, results)
+}
+
+// tarball returns the fixture files as a tar archive.
+func (f *selfTestFixture) tarball() ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]struct{}{}
+	for _, file := range f.files {
+		// the parent directories are added before their first file
+		parents := []string{}
+		for dir := path.Dir(file.name); dir != "."; dir = path.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if _, ok := dirs[dir]; ok {
+				continue
+			}
+			dirs[dir] = struct{}{}
+			if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+				return nil, fmt.Errorf("Unable to create the selftest image: %v\n", err)
+			}
+		}
+		hdr := &tar.Header{
+			Name:     file.name,
+			Typeflag: tar.TypeReg,
+			Mode:     file.mode,
+			Size:     int64(len(file.content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("Unable to create the selftest image: %v\n", err)
+		}
+		if _, err := tw.Write([]byte(file.content)); err != nil {
+			return nil, fmt.Errorf("Unable to create the selftest image: %v\n", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Unable to create the selftest image: %v\n", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// compareSelfTestResults returns an error listing the expected references missing from
+// results and the references of the unexpected results.
+func compareSelfTestResults(expected []string, results []iiapi.Result) error {
+	reported := map[string]struct{}{}
+	for _, r := range results {
+		reported[r.Reference] = struct{}{}
+	}
+	missing := []string{}
+	for _, ref := range expected {
+		if _, ok := reported[ref]; !ok {
+			missing = append(missing, ref)
+		}
+		delete(reported, ref)
+	}
+	unexpected := []string{}
+	for ref := range reported {
+		unexpected = append(unexpected, ref)
+	}
+	sort.Strings(unexpected)
+
+	if len(missing) > 0 || len(unexpected) > 0 {
+		return fmt.Errorf("selftest failed: missing findings %v, unexpected findings %v", missing, unexpected)
+	}
+	return nil
+}
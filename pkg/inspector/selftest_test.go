@@ -0,0 +1,78 @@
+package inspector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+// fixtureMockScanner reports the files of the scanned path whose content contains marker.
+type fixtureMockScanner struct {
+	marker string
+}
+
+func (s *fixtureMockScanner) Scan(ctx context.Context, p string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	results := []iiapi.Result{}
+	for _, name := range []string{"eicar.com", "etc/hostname"} {
+		content, err := ioutil.ReadFile(path.Join(p, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.Contains(string(content), s.marker) {
+			results = append(results, iiapi.Result{Name: "mock", Reference: "file:///" + name})
+		}
+	}
+	return results, nil, nil
+}
+
+func (s *fixtureMockScanner) Name() string {
+	return "mock"
+}
+
+func TestRunSelfTest(t *testing.T) {
+	ctx := context.Background()
+	for k, v := range map[string]struct {
+		scanType   string
+		scanner    iiapi.Scanner
+		scannerErr error
+		shouldFail bool
+	}{
+		"expected findings":       {scanType: "clamav", scanner: &fixtureMockScanner{marker: "EICAR"}},
+		"missing findings":        {scanType: "clamav", scanner: &fixtureMockScanner{marker: "not-found"}, shouldFail: true},
+		"unexpected findings":     {scanType: "clamav", scanner: &fixtureMockScanner{marker: ""}, shouldFail: true},
+		"scanner fails":           {scanType: "clamav", scanner: &FailMockScanner{}, shouldFail: true},
+		"scanner panics":          {scanType: "clamav", scanner: &PanicMockScanner{}, shouldFail: true},
+		"scanner can't be set up": {scanType: "clamav", scannerErr: fmt.Errorf("no clamd"), shouldFail: true},
+		"no fixture":              {scanType: "openscap", scanner: &SuccMockScanner{}, shouldFail: true},
+	} {
+		opts := iicmd.NewDefaultImageInspectorOptions()
+		opts.ScanType = v.scanType
+		err := runSelfTest(ctx, *opts, func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+			return v.scanner, v.scannerErr
+		})
+		if v.shouldFail && err == nil {
+			t.Errorf("%s should have failed but it didn't!", k)
+		}
+		if v.scanType == "openscap" && (err == nil || !strings.Contains(err.Error(), "no built-in fixture")) {
+			t.Errorf("%s: expected the missing fixture to be reported, got %v", k, err)
+		}
+		if !v.shouldFail && err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+		}
+	}
+}
+
+func TestSelfTestUnowned(t *testing.T) {
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.ScanType = "unowned"
+	if err := SelfTest(context.Background(), *opts); err != nil {
+		t.Errorf("the unowned selftest should have succeeded but failed with %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package inspector
+
+import (
+	"path"
+	"sort"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// splitResults partitions the results by the scanner that found them. The scanners that ran
+// are given a partition even when they found nothing. Each partition holds the identity of
+// the image with the results of its scanner only.
+func splitResults(scanResults iiapi.ScanResult, scanners []iiapi.ScannerMetadata) map[string]iiapi.ScanResult {
+	partitions := map[string]iiapi.ScanResult{}
+	partition := func(name string) iiapi.ScanResult {
+		p, ok := partitions[name]
+		if !ok {
+			p = scanResults
+			p.Results = []iiapi.Result{}
+			// the delta compares all the results to the baseline, it's kept in the combined results
+			p.Delta = nil
+		}
+		return p
+	}
+	for _, scan := range scanners {
+		partitions[scan.Name] = partition(scan.Name)
+	}
+	for _, r := range scanResults.Results {
+		p := partition(r.Name)
+		p.Results = append(p.Results, r)
+		partitions[r.Name] = p
+	}
+	return partitions
+}
+
+// writeSplitResults writes the results of each scanner in JSON to <scanner>.json in dir.
+func writeSplitResults(dir string, scanResults iiapi.ScanResult, scanners []iiapi.ScannerMetadata) error {
+	partitions := splitResults(scanResults, scanners)
+	names := []string{}
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// the names of the scanners are not paths
+		if err := writeResults(path.Join(dir, path.Base(name)+".json"), false, partitions[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package inspector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	iicmd "github.com/openshift/image-inspector/pkg/cmd"
+)
+
+func TestInspectSplitResults(t *testing.T) {
+	oldNewDockerClient := newDockerClient
+	defer func() { newDockerClient = oldNewDockerClient }()
+	image := &docker.Image{ID: "sha256:0123456789abcdef"}
+	client := &mockDockerRuntimeClient{
+		images:         map[string]*docker.Image{"image": image, image.ID: image},
+		containerImage: image.ID,
+		downloads:      map[string][]byte{"/": newTarball(t)},
+	}
+	newDockerClient = func(iicmd.ImageInspectorOptions) (DockerRuntimeClient, error) { return client, nil }
+
+	dir, err := ioutil.TempDir("", "split-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cve, virus, eicar := finding("openscap", "CVE-1"), finding("clamav", "file:///virus"), finding("clamav", "file:///eicar.com")
+	opts := iicmd.NewDefaultImageInspectorOptions()
+	opts.Image = "image"
+	opts.DstPath = path.Join(dir, "rootfs")
+	opts.PullPolicy = iiapi.PullNever
+	opts.ScanType = "clamav"
+	opts.OutputFile = path.Join(dir, "results.json")
+	opts.SplitResults = path.Join(dir, "split")
+	ii := NewDefaultImageInspector(*opts).(*defaultImageInspector)
+	ii.newScanner = func(iicmd.ImageInspectorOptions) (iiapi.Scanner, error) {
+		return &resultsMockScanner{results: []iiapi.Result{virus, cve, eicar}}, nil
+	}
+	if err := ii.Inspect(); err != nil {
+		t.Fatalf("expected the inspection to succeed, got %v", err)
+	}
+
+	read := func(name string) iiapi.ScanResult {
+		var results iiapi.ScanResult
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expected %s to be written: %v", name, err)
+		}
+		if err := json.Unmarshal(content, &results); err != nil {
+			t.Fatalf("expected %s to hold JSON, got %q: %v", name, content, err)
+		}
+		return results
+	}
+	// the combined results are still written
+	if combined := read(opts.OutputFile); len(combined.Results) != 3 {
+		t.Errorf("expected the combined results to hold all the results, got %v", combined.Results)
+	}
+	// the scanner that ran is given a file even if the results are found by other scanners
+	for name, expected := range map[string][]iiapi.Result{
+		"clamav.json":      {virus, eicar},
+		"openscap.json":    {cve},
+		"MockScanner.json": {},
+	} {
+		results := read(path.Join(opts.SplitResults, name))
+		if results.ImageID != image.ID || results.ImageName != "docker.io/library/image:latest" || !results.Complete {
+			t.Errorf("%s: expected the identity of the image, got %+v", name, results)
+		}
+		if len(results.Results) != len(expected) || (len(expected) > 0 && !reflect.DeepEqual(results.Results, expected)) {
+			t.Errorf("%s: expected the results %v, got %v", name, expected, results.Results)
+		}
+	}
+	files, err := ioutil.ReadDir(opts.SplitResults)
+	if err != nil || len(files) != 3 {
+		t.Errorf("expected a file per scanner, got %v: %v", files, err)
+	}
+}
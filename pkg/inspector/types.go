@@ -1 +1,30 @@
 package inspector
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// DockerRuntimeClient is the subset of the docker client API used by the image inspector.
+type DockerRuntimeClient interface {
+	// InspectImage returns the metadata of the given image.
+	InspectImage(name string) (*docker.Image, error)
+	// PullImage pulls an image from a remote registry.
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	// CreateContainer creates a new container.
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	// RemoveContainer removes a container.
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	// InspectContainer returns the metadata of the given container.
+	InspectContainer(id string) (*docker.Container, error)
+	// ContainerChanges returns the changes in the filesystem of the given container.
+	ContainerChanges(id string) ([]docker.Change, error)
+	// DownloadFromContainer downloads a tar archive of files or folders in a container.
+	DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error
+	// ExportImage exports an image as a docker save tarball.
+	ExportImage(opts docker.ExportImageOptions) error
+	// ImageHistory returns the history of the given image, from the most recent layer.
+	ImageHistory(name string) ([]docker.ImageHistory, error)
+}
+
+// ensures the docker client always implements the interface or fail compilation.
+var _ DockerRuntimeClient = &docker.Client{}
@@ -0,0 +1,183 @@
+package kmod
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "kmod"
+	ScannerVersion = "0.1"
+
+	// maxInitScriptSize is the size above which the files matching the init paths are not
+	// read, they are not scripts
+	maxInitScriptSize = 1 << 20
+
+	escapeRisk = "loading kernel modules requires privileges that allow escaping to the host"
+)
+
+var (
+	// ModulesDirs are the directories where the kernel modules are installed.
+	ModulesDirs = []string{"/lib/modules", "/usr/lib/modules"}
+
+	// ModuleGlobs are the globs of the file names of the kernel modules.
+	ModuleGlobs = []string{"*.ko", "*.ko.xz", "*.ko.gz", "*.ko.zst"}
+
+	// DefaultInitPaths are the globs of the init scripts searched for commands loading
+	// kernel modules, matched against the file names when they have no slash.
+	DefaultInitPaths = []string{
+		"/etc/rc.local", "/etc/rc.d/rc.local", "/etc/init.d/*", "/etc/rc.d/init.d/*",
+		"/etc/systemd/system/*.service", "/usr/lib/systemd/system/*.service", "/lib/systemd/system/*.service",
+		"entrypoint.sh", "docker-entrypoint.sh",
+	}
+
+	// loadCommand matches the commands loading kernel modules in a line of a script
+	loadCommand = regexp.MustCompile(`(^|[\s;&|(/=` + "`" + `])(insmod|modprobe)(\s|;|$)`)
+)
+
+type kmodScanner struct {
+	walker.Sink
+	initPaths []string
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &kmodScanner{}
+
+// NewScanner returns a new scanner reporting the kernel modules of the image and the init
+// scripts matching the initPaths globs that load kernel modules, the DefaultInitPaths are
+// searched when no glob is given.
+func NewScanner(initPaths []string) iiapi.Scanner {
+	if len(initPaths) == 0 {
+		initPaths = DefaultInitPaths
+	}
+	return &kmodScanner{initPaths: initPaths}
+}
+
+// matchAny returns whether the image path name matches one of globs, the globs without a
+// slash are matched against the file name.
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		target := name
+		if !strings.Contains(glob, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(glob, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCommands returns the commands loading kernel modules run by the script p, the
+// commented lines are ignored.
+func loadCommands(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	found := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, m := range loadCommand.FindAllStringSubmatch(line, -1) {
+			found[m[2]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	commands := []string{}
+	for command := range found {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands, nil
+}
+
+// Scan reports the kernel modules under path and the init scripts loading kernel modules.
+func (s *kmodScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("kmod")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := func(name, description string, severity iiapi.Severity) {
+		scanResults.Add(iiapi.Result{
+			Name:           ScannerName,
+			ScannerVersion: ScannerVersion,
+			Timestamp:      scanResults.Started(),
+			Reference:      fmt.Sprintf("file://%s", name),
+			Description:    description,
+			Summary:        []iiapi.Summary{{Label: severity}},
+		})
+	}
+
+	err = walker.Walk(ctx, root, filter, func(p string, info os.FileInfo) error {
+		if p == root {
+			return nil
+		}
+		name := path.Join("/", strings.TrimPrefix(p, root))
+		if info.IsDir() {
+			for _, dir := range ModulesDirs {
+				if name != dir {
+					continue
+				}
+				// the modules of the directory are reported at once
+				if count := walker.NonEmptyFiles(p); count > 0 {
+					report(name, fmt.Sprintf("kernel modules directory (%d files), %s", count, escapeRisk), iiapi.SeverityModerate)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if matchAny(ModuleGlobs, name) {
+			report(name, fmt.Sprintf("kernel module, %s", escapeRisk), iiapi.SeverityModerate)
+			return nil
+		}
+		if !matchAny(s.initPaths, name) || info.Size() > maxInitScriptSize {
+			return nil
+		}
+		commands, err := loadCommands(p)
+		if err != nil {
+			log.Printf("WARNING: Unable to read the init script %s: %v", name, err)
+			return nil
+		}
+		if len(commands) > 0 {
+			report(name, fmt.Sprintf("init script loading kernel modules with %s, %s", strings.Join(commands, " and "), escapeRisk), iiapi.SeverityImportant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to scan %s: %v\n", mountPath, err)
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *kmodScanner) Name() string {
+	return ScannerName
+}
@@ -0,0 +1,119 @@
+package kmod
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+func TestScan(t *testing.T) {
+	root := walkertest.NewFixture(t, walkertest.Contents(map[string]string{
+		"usr/lib/modules/5.14.0/kernel/drivers/net/dummy.ko.xz": "module",
+		"usr/lib/modules/5.14.0/modules.dep":                    "kernel/drivers/net/dummy.ko.xz:\n",
+		"lib/modules/empty/modules.dep":                         "",
+		"opt/driver/rootkit.ko":                                 "module",
+		"etc/rc.d/rc.local":                                     "#!/bin/sh\n/sbin/modprobe dummy && insmod /opt/driver/rootkit.ko\n",
+		"etc/init.d/network":                                    "#!/bin/sh\n# modprobe is run by the host\nip link set lo up\n",
+		"etc/systemd/system/bridge.service":                     "[Service]\nExecStart=/usr/sbin/modprobe br_netfilter\n",
+		"etc/modprobe.d/blacklist.conf":                         "blacklist dummy\n",
+		"usr/bin/modprobe-wrapper":                              "#!/bin/sh\nmodprobe $1\n",
+		"app/entrypoint.sh":                                     "#!/bin/sh\nexec /app/server\n",
+		"skipped/driver.ko":                                     "module",
+	})...)
+	defer os.RemoveAll(root)
+	filter := func(p string, info os.FileInfo) bool {
+		return !strings.HasPrefix(p, path.Join(root, "skipped"))
+	}
+
+	results, _, err := NewScanner(nil).Scan(context.Background(), root, nil, filter)
+	if err != nil {
+		t.Fatalf("expected to succeed but failed with %v", err)
+	}
+	expected := map[string]struct {
+		description string
+		severity    iiapi.Severity
+	}{
+		"file:///usr/lib/modules": {
+			"kernel modules directory (2 files), " + escapeRisk, iiapi.SeverityModerate},
+		"file:///opt/driver/rootkit.ko": {
+			"kernel module, " + escapeRisk, iiapi.SeverityModerate},
+		"file:///etc/rc.d/rc.local": {
+			"init script loading kernel modules with insmod and modprobe, " + escapeRisk, iiapi.SeverityImportant},
+		"file:///etc/systemd/system/bridge.service": {
+			"init script loading kernel modules with modprobe, " + escapeRisk, iiapi.SeverityImportant},
+	}
+	if len(results) != len(expected) {
+		t.Errorf("expected %d results, got %v", len(expected), results)
+	}
+	for _, r := range results {
+		e, ok := expected[r.Reference]
+		if !ok {
+			t.Errorf("unexpected result %v", r)
+			continue
+		}
+		if r.Name != ScannerName || r.Description != e.description ||
+			!reflect.DeepEqual(r.Summary, []iiapi.Summary{{Label: e.severity}}) {
+			t.Errorf("expected the result of %s to be %q of severity %s, got %v", r.Reference, e.description, e.severity, r)
+		}
+	}
+}
+
+func TestScanInitPaths(t *testing.T) {
+	root := walkertest.NewFixture(t, walkertest.Contents(map[string]string{
+		"etc/rc.local":      "modprobe dummy\n",
+		"app/start.sh":      "modprobe dummy; exec /app/server\n",
+		"app/conf/start.sh": "insmod dummy.ko\n",
+	})...)
+	defer os.RemoveAll(root)
+
+	for k, v := range map[string]struct {
+		initPaths []string
+		expected  []string
+	}{
+		"default init paths": {expected: []string{"file:///etc/rc.local"}},
+		"file name":          {initPaths: []string{"start.sh"}, expected: []string{"file:///app/conf/start.sh", "file:///app/start.sh"}},
+		"path":               {initPaths: []string{"/app/*.sh"}, expected: []string{"file:///app/start.sh"}},
+	} {
+		results, _, err := NewScanner(v.initPaths).Scan(context.Background(), root, nil, nil)
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		references := []string{}
+		for _, r := range results {
+			references = append(references, r.Reference)
+		}
+		if !reflect.DeepEqual(references, v.expected) {
+			t.Errorf("%s: expected the results %v, got %v", k, v.expected, references)
+		}
+	}
+}
+
+func TestLoadCommand(t *testing.T) {
+	for line, expected := range map[string]bool{
+		"modprobe dummy":                    true,
+		"/sbin/modprobe -r dummy":           true,
+		"ExecStartPre=/sbin/insmod foo.ko":  true,
+		"[ -x /sbin/modprobe ] && modprobe": true,
+		"echo $(modprobe -c)":               true,
+		"cp /etc/modprobe.d/foo.conf /tmp":  false,
+		"modprobe-wrapper dummy":            false,
+		"rmmod dummy":                       false,
+	} {
+		if loadCommand.MatchString(line) != expected {
+			t.Errorf("%q: expected to match %v", line, expected)
+		}
+	}
+}
+
+func TestScanRequiresDirectory(t *testing.T) {
+	if _, _, err := NewScanner(nil).Scan(context.Background(), "kmod.go", nil, nil); err == nil {
+		t.Errorf("expected the scan of a file to fail")
+	}
+}
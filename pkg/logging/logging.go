@@ -0,0 +1,210 @@
+// Package logging writes leveled messages with structured fields, either as text like the
+// standard log package or as JSON lines for the log pipelines. Once configured it also
+// handles the messages of the standard log package, their level is read from the prefix
+// such as "WARNING: ".
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a message, the messages below the configured level are dropped.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarningLevel
+	ErrorLevel
+)
+
+const (
+	// TextFormat writes the messages like the standard log package, the fields as key=value
+	TextFormat = "text"
+	// JSONFormat writes each message as a JSON object on its own line
+	JSONFormat = "json"
+)
+
+var (
+	// LevelOptions are the names of the levels, in increasing severity
+	LevelOptions = []string{"debug", "info", "warning", "error"}
+	// FormatOptions are the available formats of the messages
+	FormatOptions = []string{TextFormat, JSONFormat}
+
+	// levelPrefixes mark the level of the messages in the text format, and of the messages
+	// of the standard log package
+	levelPrefixes = []struct {
+		prefix string
+		level  Level
+	}{
+		{"DEBUG: ", DebugLevel},
+		{"WARNING: ", WarningLevel},
+		{"!!!WARNING!!! ", WarningLevel},
+		{"ERROR: ", ErrorLevel},
+		{"Error: ", ErrorLevel},
+	}
+)
+
+func (l Level) String() string {
+	if l < DebugLevel || int(l) >= len(LevelOptions) {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return LevelOptions[l]
+}
+
+// ParseLevel returns the level named s, one of the LevelOptions.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range LevelOptions {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	return DebugLevel, fmt.Errorf("%s is not one of the available log levels which are %v", s, LevelOptions)
+}
+
+// Fields are the structured fields of a message, e.g. image, phase, bytes and duration.
+// The time.Duration fields are written in seconds in the JSON format.
+type Fields map[string]interface{}
+
+// logger writes the messages of at least level to out in format, out is nil until the
+// logger is configured and the messages are left to the standard log package.
+type logger struct {
+	lock   sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	now    func() time.Time
+}
+
+var std = &logger{level: DebugLevel, format: TextFormat, now: time.Now}
+
+// Configure makes the messages of at least level, and those of the standard log package,
+// be written to out in format.
+func Configure(out io.Writer, level, format string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != TextFormat && format != JSONFormat {
+		return fmt.Errorf("%s is not one of the available log formats which are %v", format, FormatOptions)
+	}
+	std.lock.Lock()
+	std.out, std.level, std.format = out, l, format
+	std.lock.Unlock()
+	// the timestamps are added by the logger, whatever the format
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+	return nil
+}
+
+// Debug logs msg with fields at the debug level.
+func Debug(msg string, fields Fields) {
+	std.log(DebugLevel, msg, fields)
+}
+
+// Info logs msg with fields at the info level.
+func Info(msg string, fields Fields) {
+	std.log(InfoLevel, msg, fields)
+}
+
+// Warning logs msg with fields at the warning level.
+func Warning(msg string, fields Fields) {
+	std.log(WarningLevel, msg, fields)
+}
+
+// Error logs msg with fields at the error level.
+func Error(msg string, fields Fields) {
+	std.log(ErrorLevel, msg, fields)
+}
+
+func (lg *logger) log(level Level, msg string, fields Fields) {
+	lg.lock.Lock()
+	if level < lg.level {
+		lg.lock.Unlock()
+		return
+	}
+	if lg.out == nil {
+		lg.lock.Unlock()
+		log.Print(textMessage(level, msg, fields))
+		return
+	}
+	defer lg.lock.Unlock()
+	lg.write(level, msg, fields)
+}
+
+// write writes msg to out, it must be called holding the lock.
+func (lg *logger) write(level Level, msg string, fields Fields) {
+	now := lg.now()
+	if lg.format == TextFormat {
+		fmt.Fprintf(lg.out, "%s %s\n", now.Format("2006/01/02 15:04:05"), textMessage(level, msg, fields))
+		return
+	}
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		if d, ok := v.(time.Duration); ok {
+			v = d.Seconds()
+		}
+		entry[k] = v
+	}
+	entry["time"] = now.Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"time": entry["time"].(string), "level": level.String(),
+			"msg": fmt.Sprintf("%s (unable to encode the fields: %v)", msg, err)})
+	}
+	lg.out.Write(append(line, '\n'))
+}
+
+// textMessage formats msg with the prefix of level and the fields sorted by key.
+func textMessage(level Level, msg string, fields Fields) string {
+	prefix := ""
+	switch level {
+	case DebugLevel, WarningLevel, ErrorLevel:
+		prefix = strings.ToUpper(level.String()) + ": "
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{prefix + msg}
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// stdWriter passes the messages of the standard log package to the configured logger.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level, text := InfoLevel, msg
+	for _, lp := range levelPrefixes {
+		if strings.HasPrefix(msg, lp.prefix) {
+			level, msg = lp.level, strings.TrimPrefix(msg, lp.prefix)
+			break
+		}
+	}
+	std.lock.Lock()
+	defer std.lock.Unlock()
+	if level < std.level || std.out == nil {
+		return len(p), nil
+	}
+	// the text messages are written as they were given, prefix included
+	if std.format == TextFormat {
+		fmt.Fprintf(std.out, "%s %s\n", std.now().Format("2006/01/02 15:04:05"), text)
+	} else {
+		std.write(level, msg, nil)
+	}
+	return len(p), nil
+}
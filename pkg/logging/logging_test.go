@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configure configures the logger to write to a buffer at a fixed time, until the returned
+// function restores it.
+func configure(t *testing.T, level, format string) (*bytes.Buffer, func()) {
+	out := &bytes.Buffer{}
+	if err := Configure(out, level, format); err != nil {
+		t.Fatalf("unable to configure the logger: %v", err)
+	}
+	std.now = func() time.Time { return time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return out, func() {
+		std.out, std.level, std.format, std.now = nil, DebugLevel, TextFormat, time.Now
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	out, restore := configure(t, "info", JSONFormat)
+	defer restore()
+
+	Info("Pulled image", Fields{"image": "fedora:26", "phase": "pull", "bytes": 2048, "duration": 1500 * time.Millisecond})
+	Debug("dropped below the level", nil)
+	log.Printf("WARNING: Unable to remove %s", "/tmp/image")
+	log.Printf("DEBUG: dropped below the level")
+	log.Printf("Results written to %s", "results.json")
+
+	expected := []map[string]interface{}{
+		{"time": "2018-01-02T03:04:05Z", "level": "info", "msg": "Pulled image", "image": "fedora:26", "phase": "pull",
+			"bytes": float64(2048), "duration": 1.5},
+		{"time": "2018-01-02T03:04:05Z", "level": "warning", "msg": "Unable to remove /tmp/image"},
+		{"time": "2018-01-02T03:04:05Z", "level": "info", "msg": "Results written to results.json"},
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), out.String())
+	}
+	for n, line := range lines {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("expected the line %q to be JSON, got %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(entry, expected[n]) {
+			t.Errorf("expected the line %v, got %v", expected[n], entry)
+		}
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	out, restore := configure(t, "warning", TextFormat)
+	defer restore()
+
+	Warning("It is insecure", Fields{"phase": "serve", "addr": "[::]:8080"})
+	Info("dropped below the level", nil)
+	log.Printf("!!!WARNING!!! kept as it was written")
+	log.Printf("Results written to %s", "results.json")
+
+	expected := "2018/01/02 03:04:05 WARNING: It is insecure addr=[::]:8080 phase=serve\n" +
+		"2018/01/02 03:04:05 !!!WARNING!!! kept as it was written\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestConfigureRejectsUnknownOptions(t *testing.T) {
+	for _, v := range [][2]string{{"verbose", TextFormat}, {"info", "xml"}} {
+		if err := Configure(&bytes.Buffer{}, v[0], v[1]); err == nil {
+			t.Errorf("expected the level %q and the format %q to be rejected", v[0], v[1])
+		}
+	}
+}
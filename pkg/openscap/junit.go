@@ -0,0 +1,86 @@
+package openscap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	xmldom "github.com/subchen/go-xmldom"
+)
+
+// junitTestSuite is the JUnit report of the rules evaluated by a scan, one test case
+// per rule.
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitReport returns the JUnit report of the rule results of the ARF report doc: the
+// failed rules are failures, the rules that couldn't be evaluated are errors and the rules
+// that weren't evaluated are skipped.
+func junitReport(doc *xmldom.Document) *junitTestSuite {
+	suite := &junitTestSuite{Name: OpenSCAP, TestCases: []junitTestCase{}}
+	titles := map[string]string{}
+	for _, r := range doc.Root.Query("//Benchmark//Rule") {
+		if title := r.GetChild("title"); title != nil {
+			titles[r.GetAttributeValue("id")] = strings.TrimSpace(title.Text)
+		}
+	}
+	for _, c := range doc.Root.Query("//rule-result") {
+		idref := c.GetAttributeValue("idref")
+		testCase := junitTestCase{Name: idref, ClassName: OpenSCAP}
+		message := &junitMessage{Message: titles[idref]}
+		if len(message.Message) == 0 {
+			message.Message = idref
+		}
+		result := ""
+		if r := c.GetChild("result"); r != nil {
+			result = strings.TrimSpace(r.Text)
+		}
+		switch result {
+		case "pass", "fixed":
+		case "fail":
+			testCase.Failure = message
+			suite.Failures++
+		case "error", "unknown":
+			testCase.Error = message
+			suite.Errors++
+		default:
+			testCase.Skipped = &junitMessage{Message: result}
+			suite.Skipped++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Tests = len(suite.TestCases)
+	return suite
+}
+
+// writeJUnitReport writes the JUnit report of the ARF report doc into the file name.
+func writeJUnitReport(doc *xmldom.Document, name string) error {
+	content, err := xml.MarshalIndent(junitReport(doc), "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to marshal the JUnit report: %v\n", err)
+	}
+	if err := ioutil.WriteFile(name, append([]byte(xml.Header), content...), 0644); err != nil {
+		return fmt.Errorf("Unable to write the JUnit report: %v\n", err)
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package openscap
 
 import (
+	"bufio"
+	"compress/bzip2"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,6 +14,8 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -23,11 +28,16 @@ import (
 
 const (
 	CPE             = "oval:org.open-scap.cpe.rhel:def:"
+	CentOSCPE       = "oval:org.open-scap.cpe.centos:def:"
+	FedoraCPE       = "oval:org.open-scap.cpe.fedora:def:"
 	CPEDict         = "/usr/share/openscap/cpe/openscap-cpe-oval.xml"
 	CVEUrl          = "https://www.redhat.com/security/data/metrics/ds/"
 	DistCVENameFmt  = "com.redhat.rhsa-RHEL%d.ds.xml.bz2"
+	V2CVENameFmt    = "v2/RHEL%[1]d/rhel-%[1]d.ds.xml.bz2"
 	ArfResultFile   = "results-arf.xml"
 	HTMLResultFile  = "results.html"
+	XCCDFResultFile = "results-xccdf.xml"
+	JUnitResultFile = "results-junit.xml"
 	TmpDir          = "/tmp"
 	Linux           = "Linux"
 	OpenSCAP        = "openscap"
@@ -37,18 +47,98 @@ const (
 	OpenSCAPVersion = "1.2"
 
 	CVEDetailsUrl = "https://cve.mitre.org/cgi-bin/cvename.cgi?name="
+
+	// FetchRemoteResourcesArg makes oscap download the remote resources referenced by the
+	// CVE feed
+	FetchRemoteResourcesArg = "--fetch-remote-resources"
 )
 
+// Distribution describes how the releases of a distribution are detected and which CVE
+// feeds are used to scan them.
+type Distribution struct {
+	// Name is the human readable name of the distribution
+	Name string
+	// ID is the ID of the os-release file of the distribution
+	ID string
+	// CPE is the prefix of the OVAL definition ids of the distribution releases in CPEDict
+	CPE string
+	// Releases are the release numbers that are probed
+	Releases []int
+	// CVEUrl is the default source of the CVE feeds, the feeds must be provided with an
+	// alternative source or a local file when empty
+	CVEUrl string
+	// CVENameFmt is the name format of the CVE feed of a release, relative to CVEUrl
+	CVENameFmt string
+}
+
+// Dist is a detected release of a distribution.
+type Dist struct {
+	*Distribution
+	Release int
+}
+
+func (d Dist) String() string {
+	return fmt.Sprintf("%s%d", d.Name, d.Release)
+}
+
+// CVEName returns the name of the CVE feed of the release.
+func (d Dist) CVEName() string {
+	return fmt.Sprintf(d.CVENameFmt, d.Release)
+}
+
+// CVEFileName returns the file name of the CVE feed of the release.
+func (d Dist) CVEFileName() string {
+	return path.Base(d.CVEName())
+}
+
 var (
-	RHELDistNumbers = [...]int{5, 6, 7}
-	osSetEnv        = os.Setenv
+	// Distributions are the distributions probed, in order, to detect the image dist. The
+	// CentOS releases are scanned with the feeds of the matching RHEL release. The feeds of
+	// RHEL 8 and later are only published in the v2 layout, one directory per release.
+	Distributions = []*Distribution{
+		{Name: "RHEL", ID: "rhel", CPE: CPE, Releases: []int{5, 6, 7}, CVEUrl: CVEUrl, CVENameFmt: DistCVENameFmt},
+		{Name: "RHEL", ID: "rhel", CPE: CPE, Releases: []int{8, 9}, CVEUrl: CVEUrl, CVENameFmt: V2CVENameFmt},
+		{Name: "CentOS", ID: "centos", CPE: CentOSCPE, Releases: []int{5, 6, 7}, CVEUrl: CVEUrl, CVENameFmt: DistCVENameFmt},
+		{Name: "Fedora", ID: "fedora", CPE: FedoraCPE, Releases: []int{25, 26, 27, 28}, CVENameFmt: "fedora-%d-cve.ds.xml.bz2"},
+	}
+	// ManagedArgs are the oscap xccdf eval options of the output files written and read
+	// back by the scanner, they can't be passed as extra arguments.
+	ManagedArgs = []string{"--results", "--results-arf", "--report", "--oval-results"}
+	// ProxyEnv are the proxy variables passed to oscap in lower case, the only case curl
+	// honors for http_proxy, when fetching the remote resources
+	ProxyEnv = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+	osSetEnv = os.Setenv
+	// execLookPath looks up the oscap binary, replaced in the tests
+	execLookPath = exec.LookPath
 )
 
-// rhelDistFunc provides an injectable way to get the rhel dist for testing.
-type rhelDistFunc func(context.Context) (int, error)
+// CheckBinary returns an error if the oscap binary run by the scanner isn't in the PATH, so
+// that its absence is reported before fetching the CVE feed and scanning the image.
+func CheckBinary() error {
+	if _, err := execLookPath("oscap"); err != nil {
+		return fmt.Errorf("oscap not found in PATH: %v", err)
+	}
+	return nil
+}
+
+// CheckExtraArgs returns an error if any of the extra oscap xccdf eval arguments sets one of
+// the ManagedArgs.
+func CheckExtraArgs(args []string) error {
+	for _, arg := range args {
+		for _, managed := range ManagedArgs {
+			if arg == managed || strings.HasPrefix(arg, managed+"=") {
+				return fmt.Errorf("the oscap option %s is managed by image-inspector and can't be passed as an extra argument", managed)
+			}
+		}
+	}
+	return nil
+}
+
+// distFunc provides an injectable way to get the image dist for testing.
+type distFunc func(context.Context) (Dist, error)
 
 // inputCVEFunc provides an injectable way to get the cve file for testing.
-type inputCVEFunc func(int) (string, error)
+type inputCVEFunc func(Dist) (string, error)
 
 // chrootOscapFunc provides an injectable way to chroot and execute oscap for testing.
 type chrootOscapFunc func(context.Context, ...string) ([]byte, error)
@@ -56,74 +146,143 @@ type chrootOscapFunc func(context.Context, ...string) ([]byte, error)
 // setEnvFunc provides an injectable way to get the cve file for testing.
 type setEnvFunc func() error
 
-// OpenSCAPReport holds the both Arf and HTML versions of openscap report.
+// OpenSCAPReport holds the paths of both the Arf and HTML versions of openscap report,
+// the reports are left on disk since they can be very large. HTMLPath, XCCDFPath and
+// JUnitPath are empty when those reports were not generated.
 type OpenSCAPReport struct {
-	ArfBytes  []byte
-	HTMLBytes []byte
+	ArfPath   string
+	HTMLPath  string
+	XCCDFPath string
+	JUnitPath string
+}
+
+// Options are the options of the OpenSCAP scan.
+type Options struct {
+	// CVEUrlAltPath An alternative source for the cve files, the source of the detected
+	// distribution is used when empty
+	CVEUrlAltPath string
+	// LocalCVEFile is a local cve file used instead of downloading it
+	LocalCVEFile string
+	// Whether or not to generate an HTML report
+	HTML bool
+	// XCCDFResults controls whether the plain XCCDF results document is written besides
+	// the ARF report
+	XCCDFResults bool
+	// JUnit controls whether a JUnit report of the evaluated rules is generated out of the
+	// ARF report
+	JUnit bool
+	// ExtraArgs are appended verbatim to the oscap xccdf eval arguments
+	ExtraArgs []string
+	// FetchRemote controls whether oscap downloads the remote resources of the CVE feed
+	FetchRemote bool
+	// Profile is the id of the XCCDF profile evaluated, e.g. a CIS or STIG profile, the
+	// default profile of the data stream is evaluated when empty
+	Profile string
+	// TailoringFile is the XCCDF tailoring file customizing the rules selected by the
+	// profile, not used when empty
+	TailoringFile string
+	// ValidateCVE controls whether the CVE feed is checked to be a well-formed datastream
+	// that oscap can read before scanning
+	ValidateCVE bool
 }
 
 type defaultOSCAPScanner struct {
+	Options
+
 	// CVEDir is the directory where the CVE file is saved
 	CVEDir string
 	// ResultsDir is the directory to which the arf report will be written
 	ResultsDir string
-	// CVEUrlAltPath An alternative source for the cve files
-	CVEUrlAltPath string
 
 	// Image is the metadata of the inspected image
 	image *docker.Image
 	// ImageMountPath is the path where the image to be scanned is mounted
 	imageMountPath string
+	// downloadedCVE is the CVE file downloaded into CVEDir for the scan, removed once the
+	// scan is done
+	downloadedCVE string
 
-	rhelDist    rhelDistFunc
+	dist        distFunc
 	inputCVE    inputCVEFunc
 	chrootOscap chrootOscapFunc
 	setEnv      setEnvFunc
-
-	// Whether or not to generate an HTML report
-	HTML bool
-
-	reports OpenSCAPReport
 }
 
 // ensure interface is implemented
 var _ iiapi.Scanner = &defaultOSCAPScanner{}
 
-// NewDefaultScanner returns a new OpenSCAP scanner
-func NewDefaultScanner(cveDir, resultsDir, CVEUrlAltPath string, html bool) iiapi.Scanner {
+// NewDefaultScanner returns a new OpenSCAP scanner saving the CVE file in cveDir and
+// writing its reports in resultsDir
+func NewDefaultScanner(cveDir, resultsDir string, opts Options) iiapi.Scanner {
 	scanner := &defaultOSCAPScanner{
-		CVEDir:        cveDir,
-		ResultsDir:    resultsDir,
-		CVEUrlAltPath: CVEUrlAltPath,
-		HTML:          html,
+		Options:    opts,
+		CVEDir:     cveDir,
+		ResultsDir: resultsDir,
 	}
 
-	scanner.rhelDist = scanner.getRHELDist
+	scanner.dist = scanner.getDist
 	scanner.inputCVE = scanner.getInputCVE
 	scanner.chrootOscap = scanner.oscapChroot
 	scanner.setEnv = scanner.setOscapChrootEnv
-	scanner.reports = OpenSCAPReport{}
 
 	return scanner
 }
 
-func (s *defaultOSCAPScanner) getRHELDist(ctx context.Context) (int, error) {
-	for _, dist := range RHELDistNumbers {
-		output, err := s.chrootOscap(ctx, "oval", "eval", "--id",
-			fmt.Sprintf("%s%d", CPE, dist), CPEDict)
+// getDist detects the dist of the image from its os-release file. The CPE OVAL definitions
+// of the Distributions releases are probed, which is slower and requires CPEDict, only when
+// the image has no os-release file.
+func (s *defaultOSCAPScanner) getDist(ctx context.Context) (Dist, error) {
+	if len(s.imageMountPath) > 0 {
+		fields, err := parseOSRelease(s.imageMountPath)
 		if err != nil {
-			return 0, err
+			return Dist{}, err
+		}
+		if fields != nil {
+			return osReleaseDist(fields)
+		}
+	}
+	for _, distribution := range Distributions {
+		for _, release := range distribution.Releases {
+			id := fmt.Sprintf("%s%d", distribution.CPE, release)
+			output, err := s.chrootOscap(ctx, "oval", "eval", "--id", id, CPEDict)
+			if err != nil {
+				return Dist{}, err
+			}
+			if strings.Contains(string(output), id+": true") {
+				return Dist{Distribution: distribution, Release: release}, nil
+			}
 		}
-		if strings.Contains(string(output), fmt.Sprintf("%s%d: true", CPE, dist)) {
-			return dist, nil
+	}
+	return Dist{}, fmt.Errorf("could not find the image dist")
+}
+
+// osReleaseDist returns the release of the Distributions identified by the ID and the major
+// VERSION_ID of the os-release fields.
+func osReleaseDist(fields map[string]string) (Dist, error) {
+	id, version := fields["ID"], fields["VERSION_ID"]
+	release, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err == nil {
+		for _, distribution := range Distributions {
+			if distribution.ID != id {
+				continue
+			}
+			for _, r := range distribution.Releases {
+				if r == release {
+					return Dist{Distribution: distribution, Release: release}, nil
+				}
+			}
 		}
 	}
-	return 0, fmt.Errorf("could not find RHEL dist")
+	return Dist{}, fmt.Errorf("could not find the image dist, the os-release ID %q and VERSION_ID %q are not of a supported release", id, version)
 }
 
-func (s *defaultOSCAPScanner) getInputCVE(dist int) (string, error) {
-	cveName := fmt.Sprintf(DistCVENameFmt, dist)
-	cveFileName := path.Join(s.CVEDir, cveName)
+func (s *defaultOSCAPScanner) getInputCVE(dist Dist) (string, error) {
+	cveName := dist.CVEName()
+	if len(s.LocalCVEFile) > 0 {
+		return s.getLocalCVE(dist.CVEFileName(), dist)
+	}
+	// the feed is decompressed while downloading it
+	cveFileName := path.Join(s.CVEDir, strings.TrimSuffix(dist.CVEFileName(), path.Ext(cveName)))
 	var err error
 	var cveURL *url.URL
 	if len(s.CVEUrlAltPath) > 0 {
@@ -131,8 +290,10 @@ func (s *defaultOSCAPScanner) getInputCVE(dist int) (string, error) {
 			return "", fmt.Errorf("Could not parse CVE URL %s: %v\n",
 				s.CVEUrlAltPath, err)
 		}
+	} else if len(dist.CVEUrl) > 0 {
+		cveURL, _ = url.Parse(dist.CVEUrl)
 	} else {
-		cveURL, _ = url.Parse(CVEUrl)
+		return "", fmt.Errorf("No CVE feed source is known for %s, an alternative source or a local CVE file is required\n", dist)
 	}
 	cveURL.Path = path.Join(cveURL.Path, cveName)
 
@@ -148,14 +309,90 @@ func (s *defaultOSCAPScanner) getInputCVE(dist int) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return cveFileName, err
+	if _, err = io.Copy(out, bzip2.NewReader(resp.Body)); err != nil {
+		os.Remove(cveFileName)
+		return "", fmt.Errorf("Could not decompress file %s: %v\n", cveURL, err)
+	}
+	s.downloadedCVE = cveFileName
+	return cveFileName, nil
+}
+
+// removeDownloadedCVE removes the CVE file downloaded for the scan, the feeds are large and
+// downloaded again by each scan.
+func (s *defaultOSCAPScanner) removeDownloadedCVE() {
+	if len(s.downloadedCVE) == 0 {
+		return
+	}
+	if err := os.Remove(s.downloadedCVE); err != nil && !os.IsNotExist(err) {
+		log.Printf("WARNING: Unable to remove the CVE file %s: %v", s.downloadedCVE, err)
+	}
+	s.downloadedCVE = ""
+}
+
+var (
+	// osReleaseFiles are the os-release files, relative to the image root, in lookup order
+	osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+	// redhatReleaseFile is read for the version of the images predating os-release
+	redhatReleaseFile = "etc/redhat-release"
+	// redhatReleaseVersion matches the version in redhatReleaseFile, e.g. release 7.9 (Maipo)
+	redhatReleaseVersion = regexp.MustCompile(`release ([0-9][0-9.]*)`)
+)
+
+// parseOSRelease returns the unquoted fields of the os-release file of the image extracted
+// in mountPath, nil when it has none. Only regular files are read, since absolute symlinks
+// would resolve on the host.
+func parseOSRelease(mountPath string) (map[string]string, error) {
+	for _, name := range osReleaseFiles {
+		if fi, err := os.Lstat(path.Join(mountPath, name)); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(mountPath, name))
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+			if len(parts) == 2 {
+				fields[parts[0]] = strings.Trim(parts[1], `"'`)
+			}
+		}
+		return fields, nil
+	}
+	return nil, nil
+}
+
+// readOSRelease returns the OS version of the image extracted in mountPath, the VERSION_ID
+// of the os-release file or the release of the redhat-release file.
+func readOSRelease(mountPath string) (string, error) {
+	fields, err := parseOSRelease(mountPath)
+	if err != nil {
+		return "", err
+	}
+	if len(fields["VERSION_ID"]) > 0 {
+		return fields["VERSION_ID"], nil
+	}
+	if fi, err := os.Lstat(path.Join(mountPath, redhatReleaseFile)); err == nil && fi.Mode().IsRegular() {
+		data, err := ioutil.ReadFile(path.Join(mountPath, redhatReleaseFile))
+		if err != nil {
+			return "", err
+		}
+		if m := redhatReleaseVersion.FindStringSubmatch(string(data)); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("no OS version was found in the os-release or redhat-release files")
 }
 
 func (s *defaultOSCAPScanner) setOscapChrootEnv() error {
+	version, err := readOSRelease(s.imageMountPath)
+	if err != nil {
+		log.Printf("WARNING: Unable to read the OS version of the image, probing as version %s: %v", LinuxVersionPH, err)
+		version = LinuxVersionPH
+	}
 	for k, v := range map[string]string{
 		"OSCAP_PROBE_ROOT":         s.imageMountPath,
-		"OSCAP_PROBE_OS_VERSION":   LinuxVersionPH, // FIXME place holder value
+		"OSCAP_PROBE_OS_VERSION":   version,
 		"OSCAP_PROBE_ARCHITECTURE": util.StrOrDefault(s.image.Architecture, Unknown),
 		"OSCAP_PROBE_OS_NAME":      Linux,
 		"OSCAP_PROBE_PRIMARY_HOST_NAME": fmt.Sprintf("docker-image-%s",
@@ -166,6 +403,19 @@ func (s *defaultOSCAPScanner) setOscapChrootEnv() error {
 			return err
 		}
 	}
+	if s.FetchRemote {
+		for _, k := range ProxyEnv {
+			lower := strings.ToLower(k)
+			if _, ok := os.LookupEnv(lower); ok {
+				continue
+			}
+			if v, ok := os.LookupEnv(k); ok {
+				if err := osSetEnv(lower, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
 	return nil
 }
 
@@ -191,6 +441,53 @@ func (s *defaultOSCAPScanner) oscapChroot(ctx context.Context, oscapArgs ...stri
 	return out, err
 }
 
+// getLocalCVE returns the local cve file, which must be the cve file of the given dist.
+func (s *defaultOSCAPScanner) getLocalCVE(cveName string, dist Dist) (string, error) {
+	if path.Base(s.LocalCVEFile) != cveName {
+		return "", fmt.Errorf("CVE file %s does not match the %s dist, expected %s\n",
+			s.LocalCVEFile, dist, cveName)
+	}
+	fi, err := os.Stat(s.LocalCVEFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not read CVE file %s: %v\n", s.LocalCVEFile, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("CVE file %s is not a regular file\n", s.LocalCVEFile)
+	}
+	return s.LocalCVEFile, nil
+}
+
+// validateCVE checks that the CVE file is a well-formed XML datastream that oscap can read,
+// a corrupt or truncated feed would otherwise make the scan fail cryptically.
+func (s *defaultOSCAPScanner) validateCVE(ctx context.Context, cveFileName string) error {
+	f, err := os.Open(cveFileName)
+	if err != nil {
+		return fmt.Errorf("Could not read CVE file %s: %v\n", cveFileName, err)
+	}
+	defer f.Close()
+	decoder := xml.NewDecoder(bufio.NewReader(f))
+	elements := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CVE datastream %s: %v\n", cveFileName, err)
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			elements++
+		}
+	}
+	if elements == 0 {
+		return fmt.Errorf("invalid CVE datastream %s: no XML element found\n", cveFileName)
+	}
+	if _, err := s.chrootOscap(ctx, "info", cveFileName); err != nil {
+		return fmt.Errorf("invalid CVE datastream %s: %v\n", cveFileName, err)
+	}
+	return nil
+}
+
 func (s *defaultOSCAPScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
 	fi, err := os.Stat(mountPath)
 	if err != nil || os.IsNotExist(err) || !fi.IsDir() {
@@ -202,23 +499,49 @@ func (s *defaultOSCAPScanner) Scan(ctx context.Context, mountPath string, image
 	s.image = image
 	s.imageMountPath = mountPath
 
-	rhelDist, err := s.rhelDist(ctx)
+	if err := CheckExtraArgs(s.ExtraArgs); err != nil {
+		return nil, nil, err
+	}
+
+	dist, err := s.dist(ctx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Unable to get RHEL distribution number: %v\n", err)
+		return nil, nil, fmt.Errorf("Unable to get the distribution release: %v\n", err)
 	}
+	log.Printf("Detected %s, scanning with the %s CVE feed", dist, dist.CVEName())
 
-	cveFileName, err := s.inputCVE(rhelDist)
+	defer s.removeDownloadedCVE()
+	cveFileName, err := s.inputCVE(dist)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Unable to retreive the CVE file: %v\n", err)
 	}
+	if s.ValidateCVE {
+		if err := s.validateCVE(ctx, cveFileName); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	args := []string{"xccdf", "eval", "--results-arf", path.Join(s.ResultsDir, ArfResultFile)}
 
 	if s.HTML {
 		args = append(args, "--report", path.Join(s.ResultsDir, HTMLResultFile))
 	}
+	if s.XCCDFResults {
+		args = append(args, "--results", path.Join(s.ResultsDir, XCCDFResultFile))
+	}
 	log.Printf("Writing OpenSCAP results to %s", s.ResultsDir)
 
+	if len(s.Profile) > 0 {
+		args = append(args, "--profile", s.Profile)
+	}
+	if len(s.TailoringFile) > 0 {
+		args = append(args, "--tailoring-file", s.TailoringFile)
+	}
+
+	if s.FetchRemote && !util.StringInList(FetchRemoteResourcesArg, s.ExtraArgs) {
+		log.Printf("WARNING: Fetching the remote resources of the CVE feed, the scan depends on the network")
+		args = append(args, FetchRemoteResourcesArg)
+	}
+	args = append(args, s.ExtraArgs...)
 	args = append(args, cveFileName)
 
 	_, err = s.chrootOscap(ctx, args...)
@@ -226,33 +549,45 @@ func (s *defaultOSCAPScanner) Scan(ctx context.Context, mountPath string, image
 		return nil, nil, err
 	}
 
-	// for mock/testing
-	if len(s.reports.ArfBytes) > 0 {
-		return ParseResults(s.reports.ArfBytes), s.reports, nil
+	reports, err := s.openSCAPReports()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	s.reports.ArfBytes, s.reports.HTMLBytes, err = s.readOpenSCAPReports()
+	doc, err := parseReportFile(reports.ArfPath)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	return ParseResults(s.reports.ArfBytes), s.reports, nil
+	if doc == nil {
+		return []iiapi.Result{}, reports, nil
+	}
+	if s.JUnit {
+		reports.JUnitPath = path.Join(s.ResultsDir, JUnitResultFile)
+		if err := writeJUnitReport(doc, reports.JUnitPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return parseResultsDocument(doc), reports, nil
 }
 
-func (s *defaultOSCAPScanner) readOpenSCAPReports() ([]byte, []byte, error) {
-	empty := []byte{}
-	arfResults, err := ioutil.ReadFile(path.Join(s.ResultsDir, ArfResultFile))
-	if err != nil {
-		return empty, empty, err
-	}
+// openSCAPReports returns the reports written by oscap in ResultsDir.
+func (s *defaultOSCAPScanner) openSCAPReports() (OpenSCAPReport, error) {
+	reports := OpenSCAPReport{ArfPath: path.Join(s.ResultsDir, ArfResultFile)}
 	if s.HTML {
-		htmlResults, err := ioutil.ReadFile(path.Join(s.ResultsDir, HTMLResultFile))
-		if err != nil {
-			return empty, empty, err
+		reports.HTMLPath = path.Join(s.ResultsDir, HTMLResultFile)
+	}
+	if s.XCCDFResults {
+		reports.XCCDFPath = path.Join(s.ResultsDir, XCCDFResultFile)
+	}
+	for _, name := range []string{reports.ArfPath, reports.HTMLPath, reports.XCCDFPath} {
+		if len(name) == 0 {
+			continue
+		}
+		if _, err := os.Stat(name); err != nil {
+			return OpenSCAPReport{}, fmt.Errorf("Unable to find the OpenSCAP report: %v\n", err)
 		}
-		return htmlResults, arfResults, nil
 	}
-	return arfResults, empty, nil
+	return reports, nil
 }
 
 func (s *defaultOSCAPScanner) Name() string {
@@ -260,29 +595,128 @@ func (s *defaultOSCAPScanner) Name() string {
 }
 
 func ParseResults(report []byte) []iiapi.Result {
-	ret := []iiapi.Result{}
 	doc, err := xmldom.ParseXML(string(report))
 	if err != nil {
 		log.Printf("Error parsing result XML: %v", err)
 		return []iiapi.Result{}
 	}
-	node := xmldom.Must(doc, nil).Root
+	return parseResultsDocument(doc)
+}
+
+// ParseResultsFile parses the results of the ARF report file name, reading it from disk
+// instead of loading it in memory first.
+func ParseResultsFile(name string) ([]iiapi.Result, error) {
+	doc, err := parseReportFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return []iiapi.Result{}, nil
+	}
+	return parseResultsDocument(doc), nil
+}
+
+// parseReportFile parses the ARF report file name, it returns a nil document when the
+// report isn't valid XML.
+func parseReportFile(name string) (*xmldom.Document, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the OpenSCAP report: %v\n", err)
+	}
+	defer f.Close()
+	doc, err := xmldom.Parse(f)
+	if err != nil {
+		log.Printf("Error parsing result XML: %v", err)
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// parseResultsDocument returns the results of the rules failed in the ARF report doc.
+func parseResultsDocument(doc *xmldom.Document) []iiapi.Result {
+	ret := []iiapi.Result{}
+	node := doc.Root
+	rules := map[string]*xmldom.Node{}
+	for _, r := range node.Query("//Benchmark//Rule") {
+		rules[r.GetAttributeValue("id")] = r
+	}
+	// the OVAL definitions of the vendor advisories carry their own severity ratings
+	advisories := map[string]string{}
+	for _, d := range node.Query("//definition") {
+		if severity := d.QueryOne("metadata/advisory/severity"); severity != nil {
+			advisories[d.GetAttributeValue("id")] = strings.TrimSpace(severity.Text)
+		}
+	}
+
 	for _, c := range node.Query("//rule-result") {
-		if !strings.Contains(c.GetChild("result").Text, "fail") {
+		if r := c.GetChild("result"); r == nil || strings.TrimSpace(r.Text) != "fail" {
 			continue
 		}
+		idref := c.GetAttributeValue("idref")
+		ruleDef := rules[idref]
 		result := iiapi.Result{
 			Name:           OpenSCAP,
 			ScannerVersion: OpenSCAPVersion,
 			Timestamp:      time.Now(),
-			Reference:      fmt.Sprintf("%s=%s", CVEDetailsUrl, strings.TrimSpace(c.GetChild("ident").Text)),
+			Reference:      ruleReference(idref, c, ruleDef),
+			Description:    idref,
 		}
+		severity := c.GetAttributeValue("severity")
 		// If we have rule definition, we can provide more details
-		if ruleDef := node.QueryOne(fmt.Sprintf("//Benchmark//Rule[@id='%s']", c.GetAttribute("idref").Value)); ruleDef != nil {
-			result.Description = strings.TrimSpace(ruleDef.GetChild("title").Text)
-			result.Summary = []iiapi.Summary{{Label: iiapi.Severity(ruleDef.GetAttribute("severity").Value)}}
+		if ruleDef != nil {
+			if title := ruleDef.GetChild("title"); title != nil {
+				result.Description = strings.TrimSpace(title.Text)
+			}
+			if len(severity) == 0 {
+				severity = ruleDef.GetAttributeValue("severity")
+			}
+		}
+		for _, ref := range c.Query("check/check-content-ref") {
+			if advisory, ok := advisories[ref.GetAttributeValue("name")]; ok {
+				severity = advisory
+				break
+			}
+		}
+		if label, ok := severityLabel(severity); ok {
+			result.Summary = []iiapi.Summary{{Label: label}}
 		}
 		ret = append(ret, result)
 	}
 	return ret
 }
+
+// ruleReference returns the reference of the failed rule idref, the details of the first CVE
+// identifying it or its first identifier, if any, from ruleResult or its definition ruleDef.
+func ruleReference(idref string, ruleResult, ruleDef *xmldom.Node) string {
+	idents := ruleResult.GetChildren("ident")
+	if ruleDef != nil {
+		idents = append(idents, ruleDef.GetChildren("ident")...)
+	}
+	for _, ident := range idents {
+		if id := strings.TrimSpace(ident.Text); strings.HasPrefix(id, "CVE-") {
+			return CVEDetailsUrl + id
+		}
+	}
+	for _, ident := range idents {
+		if id := strings.TrimSpace(ident.Text); len(id) > 0 {
+			return id
+		}
+	}
+	return idref
+}
+
+// severityLabel maps the XCCDF rule severities and the severities of the vendor advisories to
+// the result severities. The unknown severities have no label.
+func severityLabel(severity string) (iiapi.Severity, bool) {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case "info", "low":
+		return iiapi.SeverityLow, true
+	case "medium", "moderate":
+		return iiapi.SeverityModerate, true
+	case "high", "important":
+		return iiapi.SeverityImportant, true
+	case "critical":
+		return iiapi.SeverityCritical, true
+	}
+	return "", false
+}
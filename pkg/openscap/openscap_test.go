@@ -2,27 +2,37 @@ package openscap
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
 	"strings"
 	"testing"
 
 	docker "github.com/fsouza/go-dockerclient"
 
 	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/util"
 )
 
-func noRHELDist(context.Context) (int, error) {
-	return 0, fmt.Errorf("could not find RHEL dist")
+var rhel = Distributions[0]
+
+func noRHELDist(context.Context) (Dist, error) {
+	return Dist{}, fmt.Errorf("could not find the image dist")
 }
 
-func rhel7Dist(context.Context) (int, error) {
-	return 7, nil
+func rhel7Dist(context.Context) (Dist, error) {
+	return Dist{Distribution: rhel, Release: 7}, nil
 }
 
-func noInputCVE(int) (string, error) {
+func noInputCVE(Dist) (string, error) {
 	return "", fmt.Errorf("No Input CVE")
 }
-func inputCVEMock(int) (string, error) {
+func inputCVEMock(Dist) (string, error) {
 	return "cve_file", nil
 }
 
@@ -34,86 +44,181 @@ func okChrootOscap(context.Context, ...string) ([]byte, error) {
 	return []byte(""), nil
 }
 
-func rhel3OscapChroot(ctx context.Context, args ...string) ([]byte, error) {
-	return []byte("oval:org.open-scap.cpe.rhel:def:3: true"), nil
-}
-
-func rhel7OscapChroot(ctx context.Context, args ...string) ([]byte, error) {
-	if strings.Contains(args[3], "7") {
-		return []byte("oval:org.open-scap.cpe.rhel:def:7: true"), nil
+// cpeOscapChroot returns a chrootOscap mock for an image matching the given CPE OVAL id
+func cpeOscapChroot(cpe string) chrootOscapFunc {
+	return func(ctx context.Context, args ...string) ([]byte, error) {
+		if args[3] == cpe {
+			return []byte(cpe + ": true"), nil
+		}
+		return []byte(args[3] + ": false"), nil
 	}
-	return []byte(""), nil
 }
 
-func TestGetRhelDist(t *testing.T) {
+func TestGetDist(t *testing.T) {
 	ctx := context.Background()
 
-	tsRhel7ItIs := &defaultOSCAPScanner{chrootOscap: rhel7OscapChroot}
-	tsRhel3Always := &defaultOSCAPScanner{chrootOscap: rhel3OscapChroot}
-	noDistErr := fmt.Errorf("could not find RHEL dist")
-	tsCantChroot := &defaultOSCAPScanner{chrootOscap: unableToChroot}
-	_, cantChrootErr := unableToChroot(ctx)
-
 	tests := map[string]struct {
-		ts            *defaultOSCAPScanner
-		shouldFail    bool
-		expectedError error
-		expectedDist  int
+		chrootOscap     chrootOscapFunc
+		expectedError   string
+		expectedDist    string
+		expectedCVEName string
 	}{
 		"unable to chroot": {
-			ts:            tsCantChroot,
-			shouldFail:    true,
-			expectedError: cantChrootErr,
+			chrootOscap:   unableToChroot,
+			expectedError: "can't chroot",
 		},
 		"Always wrong dist": {
-			ts:            tsRhel3Always,
-			shouldFail:    true,
-			expectedError: noDistErr,
+			chrootOscap:   cpeOscapChroot("oval:org.open-scap.cpe.rhel:def:3"),
+			expectedError: "could not find the image dist",
 		},
-		"happy flow": {
-			ts:           tsRhel7ItIs,
-			shouldFail:   false,
-			expectedDist: 7,
+		"rhel": {
+			chrootOscap:     cpeOscapChroot("oval:org.open-scap.cpe.rhel:def:7"),
+			expectedDist:    "RHEL7",
+			expectedCVEName: "com.redhat.rhsa-RHEL7.ds.xml.bz2",
+		},
+		"rhel8": {
+			chrootOscap:     cpeOscapChroot("oval:org.open-scap.cpe.rhel:def:8"),
+			expectedDist:    "RHEL8",
+			expectedCVEName: "v2/RHEL8/rhel-8.ds.xml.bz2",
+		},
+		"rhel9": {
+			chrootOscap:     cpeOscapChroot("oval:org.open-scap.cpe.rhel:def:9"),
+			expectedDist:    "RHEL9",
+			expectedCVEName: "v2/RHEL9/rhel-9.ds.xml.bz2",
+		},
+		"centos": {
+			chrootOscap:     cpeOscapChroot("oval:org.open-scap.cpe.centos:def:6"),
+			expectedDist:    "CentOS6",
+			expectedCVEName: "com.redhat.rhsa-RHEL6.ds.xml.bz2",
+		},
+		"fedora": {
+			chrootOscap:     cpeOscapChroot("oval:org.open-scap.cpe.fedora:def:27"),
+			expectedDist:    "Fedora27",
+			expectedCVEName: "fedora-27-cve.ds.xml.bz2",
 		},
 	}
 
 	for k, v := range tests {
-		dist, err := v.ts.getRHELDist(ctx)
-		if v.shouldFail && !strings.Contains(err.Error(), v.expectedError.Error()) {
-			t.Errorf("%s expected  to cause error:\n%v\nBut got:\n%v", k, v.expectedError, err)
+		ts := &defaultOSCAPScanner{chrootOscap: v.chrootOscap}
+		dist, err := ts.getDist(ctx)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s expected  to cause error:\n%v\nBut got:\n%v", k, v.expectedError, err)
+			}
+			continue
 		}
-		if !v.shouldFail && err != nil {
+		if err != nil {
+			t.Errorf("%s expected to succeed but failed with %v", k, err)
+			continue
+		}
+		if dist.String() != v.expectedDist || dist.CVEName() != v.expectedCVEName {
+			t.Errorf("%s expected to succeed with dist=%s (%s) but got %s (%s)",
+				k, v.expectedDist, v.expectedCVEName, dist, dist.CVEName())
+		}
+	}
+}
+
+func TestGetDistOSRelease(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "os-release-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	for k, v := range map[string]struct {
+		osRelease     string
+		expectedError string
+		expectedDist  string
+	}{
+		"rhel":        {osRelease: "NAME=\"Red Hat Enterprise Linux\"\nVERSION=\"8.4 (Ootpa)\"\nID=\"rhel\"\nVERSION_ID=\"8.4\"\n", expectedDist: "RHEL8"},
+		"centos":      {osRelease: "NAME=\"CentOS Linux\"\nID=\"centos\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"\n", expectedDist: "CentOS7"},
+		"fedora":      {osRelease: "NAME=Fedora\nID=fedora\nVERSION_ID=27\n", expectedDist: "Fedora27"},
+		"unsupported": {osRelease: "ID=ubuntu\nVERSION_ID=\"20.04\"\n", expectedError: `the os-release ID "ubuntu" and VERSION_ID "20.04" are not of a supported release`},
+		"old release": {osRelease: "ID=fedora\nVERSION_ID=24\n", expectedError: "could not find the image dist"},
+		// the CPE OVAL definitions are probed only without os-release
+		"no os-release": {expectedDist: "CentOS6"},
+	} {
+		os.RemoveAll(path.Join(root, "etc"))
+		if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+			t.Fatalf("unable to create etc: %v", err)
+		}
+		if len(v.osRelease) > 0 {
+			if err := ioutil.WriteFile(path.Join(root, "etc/os-release"), []byte(v.osRelease), 0644); err != nil {
+				t.Fatalf("unable to write os-release: %v", err)
+			}
+		}
+		probed := false
+		ts := &defaultOSCAPScanner{imageMountPath: root, chrootOscap: func(ctx context.Context, args ...string) ([]byte, error) {
+			probed = true
+			return cpeOscapChroot("oval:org.open-scap.cpe.centos:def:6")(ctx, args...)
+		}}
+		dist, err := ts.getDist(ctx)
+		if probed != (len(v.osRelease) == 0) {
+			t.Errorf("%s expected the CPE to be probed only without os-release, probed: %v", k, probed)
+		}
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s expected to cause error:\n%v\nBut got:\n%v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
 			t.Errorf("%s expected to succeed but failed with %v", k, err)
+			continue
 		}
-		if !v.shouldFail && dist != v.expectedDist {
-			t.Errorf("%s expected to succeed with dist=%d but got %d",
-				k, v.expectedDist, dist)
+		if dist.String() != v.expectedDist {
+			t.Errorf("%s expected the dist %s but got %s", k, v.expectedDist, dist)
 		}
 	}
 }
 
+func TestGetInputCVEUnknownSource(t *testing.T) {
+	fedora := Dist{Distribution: Distributions[3], Release: 27}
+	ts := &defaultOSCAPScanner{CVEDir: "."}
+	if _, err := ts.getInputCVE(fedora); err == nil || !strings.Contains(err.Error(), "No CVE feed source is known for Fedora27") {
+		t.Errorf("expected the missing feed source to be reported, got %v", err)
+	}
+}
+
 func TestScan(t *testing.T) {
 	ctx := context.Background()
 
-	tsNoRhelDist := &defaultOSCAPScanner{rhelDist: noRHELDist}
+	tsNoRhelDist := &defaultOSCAPScanner{dist: noRHELDist}
 	_, noRhelDistErr := noRHELDist(ctx)
 
-	tsNoInputCVE := &defaultOSCAPScanner{rhelDist: rhel7Dist, inputCVE: noInputCVE}
-	_, noInputCVEErr := noInputCVE(0)
+	tsNoInputCVE := &defaultOSCAPScanner{dist: rhel7Dist, inputCVE: noInputCVE}
+	_, noInputCVEErr := noInputCVE(Dist{})
 
 	tsCantChroot := &defaultOSCAPScanner{
-		rhelDist:    rhel7Dist,
+		dist:        rhel7Dist,
 		inputCVE:    inputCVEMock,
 		chrootOscap: unableToChroot,
 	}
 	_, cantChrootErr := unableToChroot(ctx)
 
+	resultsDir, err := ioutil.TempDir("", "openscap-results-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultsDir)
+	arf := "<mock><rule-result><result>pass</result></rule-result></mock>"
+	if err := ioutil.WriteFile(path.Join(resultsDir, ArfResultFile), []byte(arf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
 	tsSuccessMocks := &defaultOSCAPScanner{
-		rhelDist:    rhel7Dist,
+		ResultsDir:  resultsDir,
+		dist:        rhel7Dist,
+		inputCVE:    inputCVEMock,
+		chrootOscap: okChrootOscap,
+	}
+	tsMissingHTML := &defaultOSCAPScanner{
+		Options:     Options{HTML: true},
+		ResultsDir:  resultsDir,
+		dist:        rhel7Dist,
 		inputCVE:    inputCVEMock,
 		chrootOscap: okChrootOscap,
-		HTML:        false,
-		reports:     OpenSCAPReport{ArfBytes: []byte("<mock><rule-result><result>pass</result></rule-result></mock>")},
 	}
 
 	tests := map[string]struct {
@@ -141,6 +246,11 @@ func TestScan(t *testing.T) {
 			ts:         tsSuccessMocks,
 			shouldFail: false,
 		},
+		"html report not written": {
+			ts:            tsMissingHTML,
+			shouldFail:    true,
+			expectedError: fmt.Errorf("Unable to find the OpenSCAP report"),
+		},
 		"happy flow with reports": {
 			ts:         tsSuccessMocks,
 			shouldFail: false,
@@ -150,8 +260,8 @@ func TestScan(t *testing.T) {
 					t.Logf("evalReport: unable to convert %#v into OpenSCAPReport", r)
 					return false
 				}
-				if len(report.ArfBytes) == 0 {
-					t.Log("evalReport: expected arf results, got empty bytes")
+				if report.ArfPath != path.Join(resultsDir, ArfResultFile) || len(report.HTMLPath) > 0 {
+					t.Logf("evalReport: unexpected report paths %#v", report)
 					return false
 				}
 				return true
@@ -189,6 +299,415 @@ func TestScan(t *testing.T) {
 
 }
 
+func TestScanExtraArgs(t *testing.T) {
+	resultsDir, err := ioutil.TempDir("", "openscap-results-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultsDir)
+	arf := "<mock><rule-result><result>pass</result></rule-result></mock>"
+	for _, report := range []string{ArfResultFile, XCCDFResultFile} {
+		if err := ioutil.WriteFile(path.Join(resultsDir, report), []byte(arf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for k, v := range map[string]struct {
+		xccdfResults  bool
+		extraArgs     []string
+		fetchRemote   bool
+		profile       string
+		tailoring     string
+		expectedArgs  []string
+		expectedError string
+	}{
+		"no extra args": {
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile), "cve_file"},
+		},
+		"extra args": {
+			extraArgs: []string{"--fetch-remote-resources", "--skip-valid", "--profile", "standard"},
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--fetch-remote-resources", "--skip-valid", "--profile", "standard", "cve_file"},
+		},
+		"fetch remote resources": {
+			fetchRemote: true,
+			extraArgs:   []string{"--skip-valid"},
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--fetch-remote-resources", "--skip-valid", "cve_file"},
+		},
+		"fetch remote resources passed as extra arg": {
+			fetchRemote: true,
+			extraArgs:   []string{"--fetch-remote-resources"},
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--fetch-remote-resources", "cve_file"},
+		},
+		"profile": {
+			profile: "xccdf_org.ssgproject.content_profile_cis",
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--profile", "xccdf_org.ssgproject.content_profile_cis", "cve_file"},
+		},
+		"profile with extra args": {
+			profile:     "xccdf_org.ssgproject.content_profile_stig",
+			fetchRemote: true,
+			extraArgs:   []string{"--skip-valid"},
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--profile", "xccdf_org.ssgproject.content_profile_stig", "--fetch-remote-resources", "--skip-valid", "cve_file"},
+		},
+		"tailoring file": {
+			profile:   "xccdf_org.ssgproject.content_profile_cis_customized",
+			tailoring: "/etc/tailoring.xml",
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--profile", "xccdf_org.ssgproject.content_profile_cis_customized", "--tailoring-file", "/etc/tailoring.xml", "cve_file"},
+		},
+		"xccdf results": {
+			xccdfResults: true,
+			extraArgs:    []string{"--skip-valid"},
+			expectedArgs: []string{"xccdf", "eval", "--results-arf", path.Join(resultsDir, ArfResultFile),
+				"--results", path.Join(resultsDir, XCCDFResultFile), "--skip-valid", "cve_file"},
+		},
+		"managed results arf": {
+			extraArgs:     []string{"--skip-valid", "--results-arf", "/tmp/arf.xml"},
+			expectedError: "the oscap option --results-arf is managed",
+		},
+		"managed report with value": {
+			extraArgs:     []string{"--report=/tmp/report.html"},
+			expectedError: "the oscap option --report is managed",
+		},
+	} {
+		var invoked []string
+		ts := &defaultOSCAPScanner{
+			Options: Options{
+				XCCDFResults:  v.xccdfResults,
+				ExtraArgs:     v.extraArgs,
+				FetchRemote:   v.fetchRemote,
+				Profile:       v.profile,
+				TailoringFile: v.tailoring,
+			},
+			ResultsDir: resultsDir,
+			dist:       rhel7Dist,
+			inputCVE:   inputCVEMock,
+			chrootOscap: func(ctx context.Context, args ...string) ([]byte, error) {
+				invoked = args
+				return []byte(""), nil
+			},
+		}
+		_, _, err := ts.Scan(context.Background(), ".", &docker.Image{}, nil)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s expected to fail with %q but got %v", k, v.expectedError, err)
+			}
+			if invoked != nil {
+				t.Errorf("%s expected oscap not to be invoked, got %v", k, invoked)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s expected to succeed but failed with %v", k, err)
+		}
+		if !reflect.DeepEqual(invoked, v.expectedArgs) {
+			t.Errorf("%s expected the oscap invocation %v but got %v", k, v.expectedArgs, invoked)
+		}
+	}
+}
+
+func TestScanReports(t *testing.T) {
+	resultsDir, err := ioutil.TempDir("", "openscap-results-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultsDir)
+
+	var invoked []string
+	ts := &defaultOSCAPScanner{
+		Options:    Options{XCCDFResults: true, JUnit: true},
+		ResultsDir: resultsDir,
+		dist:       rhel7Dist,
+		inputCVE:   inputCVEMock,
+		chrootOscap: func(ctx context.Context, args ...string) ([]byte, error) {
+			invoked = args
+			for n, arg := range args {
+				if arg == "--results-arf" || arg == "--results" {
+					if err := ioutil.WriteFile(args[n+1], []byte(sampleArf), 0644); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return []byte(""), nil
+		},
+	}
+	results, r, err := ts.Scan(context.Background(), ".", &docker.Image{}, nil)
+	if err != nil {
+		t.Fatalf("expected to succeed but failed with %v", err)
+	}
+	if !util.StringInList("--results", invoked) {
+		t.Errorf("expected oscap to write the XCCDF results, got %v", invoked)
+	}
+	expected := OpenSCAPReport{
+		ArfPath:   path.Join(resultsDir, ArfResultFile),
+		XCCDFPath: path.Join(resultsDir, XCCDFResultFile),
+		JUnitPath: path.Join(resultsDir, JUnitResultFile),
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("expected the reports %#v but got %#v", expected, r)
+	}
+	if len(results) != 4 {
+		t.Errorf("expected 4 results but got %v", results)
+	}
+
+	content, err := ioutil.ReadFile(expected.JUnitPath)
+	if err != nil {
+		t.Fatalf("unable to read the JUnit report: %v", err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(content, &suite); err != nil {
+		t.Fatalf("unable to parse the JUnit report: %v", err)
+	}
+	if suite.Tests != 6 || suite.Failures != 4 || suite.Errors != 0 || suite.Skipped != 1 || len(suite.TestCases) != 6 {
+		t.Errorf("unexpected JUnit test suite %+v", suite)
+	}
+	if c := suite.TestCases[0]; c.Name != "xccdf_rule_rhsa-2019-0001" || c.Failure == nil ||
+		c.Failure.Message != "RHSA-2019:0001: openssl security update (Important)" {
+		t.Errorf("expected the first test case to be the failed openssl rule, got %+v", c)
+	}
+	if c := suite.TestCases[3]; c.Failure != nil || c.Error != nil || c.Skipped != nil {
+		t.Errorf("expected the zlib rule to pass, got %+v", c)
+	}
+}
+
+func TestScanValidateCVE(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openscap-validate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	arf := "<mock><rule-result><result>pass</result></rule-result></mock>"
+	if err := ioutil.WriteFile(path.Join(dir, ArfResultFile), []byte(arf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	datastream := "<?xml version=\"1.0\"?>\n<ds:data-stream-collection xmlns:ds=\"http://scap.nist.gov/schema/scap/source/1.2\">\n" +
+		"<ds:data-stream id=\"cve\"/>\n</ds:data-stream-collection>\n"
+
+	for k, v := range map[string]struct {
+		feed          string
+		infoErr       error
+		expectedError string
+	}{
+		"valid feed":     {feed: datastream},
+		"truncated feed": {feed: datastream[:len(datastream)/2], expectedError: "invalid CVE datastream"},
+		"empty feed":     {feed: "", expectedError: "invalid CVE datastream"},
+		"not xml":        {feed: "BZh91AY&SY", expectedError: "invalid CVE datastream"},
+		"oscap info failure": {feed: datastream, infoErr: fmt.Errorf("OpenSCAP error: 1"),
+			expectedError: "invalid CVE datastream"},
+	} {
+		cveFile := path.Join(dir, "com.redhat.rhsa-RHEL7.ds.xml")
+		if err := ioutil.WriteFile(cveFile, []byte(v.feed), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var invoked [][]string
+		ts := &defaultOSCAPScanner{
+			Options:    Options{ValidateCVE: true},
+			ResultsDir: dir,
+			dist:       rhel7Dist,
+			inputCVE:   func(Dist) (string, error) { return cveFile, nil },
+			chrootOscap: func(ctx context.Context, args ...string) ([]byte, error) {
+				invoked = append(invoked, args)
+				if args[0] == "info" {
+					return []byte(""), v.infoErr
+				}
+				return []byte(""), nil
+			},
+		}
+		_, _, err := ts.Scan(context.Background(), ".", &docker.Image{}, nil)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s expected to fail with %q but got %v", k, v.expectedError, err)
+			}
+			for _, args := range invoked {
+				if args[0] == "xccdf" {
+					t.Errorf("%s expected the scan not to be run with the invalid feed, got %v", k, invoked)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s expected to succeed but failed with %v", k, err)
+		}
+		if len(invoked) != 2 || !reflect.DeepEqual(invoked[0], []string{"info", cveFile}) || invoked[1][0] != "xccdf" {
+			t.Errorf("%s expected oscap info to be run before the scan, got %v", k, invoked)
+		}
+	}
+}
+
+func TestScanRemovesDownloadedCVE(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openscap-cve-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	arf := "<mock><rule-result><result>pass</result></rule-result></mock>"
+	if err := ioutil.WriteFile(path.Join(dir, ArfResultFile), []byte(arf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localCVE := path.Join(dir, "com.redhat.rhsa-RHEL7.ds.xml.bz2")
+	if err := ioutil.WriteFile(localCVE, []byte(compressedDatastream), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(compressedDatastream))
+	}))
+	defer server.Close()
+
+	for k, v := range map[string]struct {
+		localCVEFile string
+		chrootOscap  chrootOscapFunc
+		expectedKept string
+		shouldFail   bool
+	}{
+		"successful scan": {chrootOscap: okChrootOscap},
+		"failed scan":     {chrootOscap: unableToChroot, shouldFail: true},
+		"local cve file":  {localCVEFile: localCVE, chrootOscap: okChrootOscap, expectedKept: localCVE},
+	} {
+		var scanned string
+		ts := NewDefaultScanner(dir, dir, Options{CVEUrlAltPath: server.URL, LocalCVEFile: v.localCVEFile}).(*defaultOSCAPScanner)
+		ts.dist = rhel7Dist
+		ts.chrootOscap = func(ctx context.Context, args ...string) ([]byte, error) {
+			scanned = args[len(args)-1]
+			return v.chrootOscap(ctx, args...)
+		}
+		_, _, err := ts.Scan(context.Background(), ".", &docker.Image{}, nil)
+		if v.shouldFail != (err != nil) {
+			t.Errorf("%s: expected the scan to fail %v, got %v", k, v.shouldFail, err)
+		}
+		if len(v.expectedKept) > 0 {
+			if _, err := os.Stat(v.expectedKept); err != nil {
+				t.Errorf("%s: expected %s to be kept, got %v", k, v.expectedKept, err)
+			}
+			continue
+		}
+		if scanned != path.Join(dir, "com.redhat.rhsa-RHEL7.ds.xml") {
+			t.Errorf("%s: expected the downloaded feed to be scanned, got %s", k, scanned)
+		}
+		if _, err := os.Stat(scanned); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the downloaded feed to be removed, got %v", k, err)
+		}
+	}
+}
+
+func TestGetInputCVELocalFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openscap-cve-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rhel7CVE := path.Join(dir, fmt.Sprintf(DistCVENameFmt, 7))
+	if err := ioutil.WriteFile(rhel7CVE, []byte("cve"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rhel5CVEDir := path.Join(dir, fmt.Sprintf(DistCVENameFmt, 5))
+	if err := os.Mkdir(rhel5CVEDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rhel8CVE := path.Join(dir, "rhel-8.ds.xml.bz2")
+	if err := ioutil.WriteFile(rhel8CVE, []byte("cve"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rhel8 := Distributions[1]
+
+	for k, v := range map[string]struct {
+		localCVEFile  string
+		dist          Dist
+		expectedError string
+	}{
+		"matching dist":      {localCVEFile: rhel7CVE, dist: Dist{rhel, 7}},
+		"matching v2 dist":   {localCVEFile: rhel8CVE, dist: Dist{rhel8, 8}},
+		"mismatching dist":   {localCVEFile: rhel7CVE, dist: Dist{rhel, 6}, expectedError: "does not match the RHEL6 dist"},
+		"mismatching v2":     {localCVEFile: rhel8CVE, dist: Dist{rhel8, 9}, expectedError: "does not match the RHEL9 dist"},
+		"missing file":       {localCVEFile: path.Join(dir, fmt.Sprintf(DistCVENameFmt, 6)), dist: Dist{rhel, 6}, expectedError: "Could not read CVE file"},
+		"not a regular file": {localCVEFile: rhel5CVEDir, dist: Dist{rhel, 5}, expectedError: "is not a regular file"},
+	} {
+		// an unreachable url makes sure that the cve file is never downloaded
+		ts := &defaultOSCAPScanner{CVEDir: dir, Options: Options{CVEUrlAltPath: "http://127.0.0.1:0/", LocalCVEFile: v.localCVEFile}}
+		cveFileName, err := ts.getInputCVE(v.dist)
+		if len(v.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), v.expectedError) {
+				t.Errorf("%s expected to cause error %q but got %v", k, v.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s expected to succeed but failed with %v", k, err)
+		}
+		if cveFileName != v.localCVEFile {
+			t.Errorf("%s expected cve file %s but got %s", k, v.localCVEFile, cveFileName)
+		}
+	}
+}
+
+const (
+	datastreamXML = "<?xml version=\"1.0\"?>\n<ds:data-stream-collection/>\n"
+	// the bzip2 compressed datastreamXML
+	compressedDatastream = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x91\x2b\xb9\xfd\x00\x00\x06\x59\x80\x00" +
+		"\x10\x50\x03\xe0\x17\xae\x27\x9d\x40\x20\x00\x54\x53\x46\x80\x34\x00\x01\x14\x69" +
+		"\x91\x8c\xa6\xd4\xcd\x09\xb2\x08\x58\xa4\x86\x57\x21\xfc\x41\xf4\xac\x2b\xac\x61" +
+		"\x1d\x78\x69\x16\x34\xb3\xd5\x19\xc0\xac\x11\xf4\xc8\xe5\xe1\x35\xbe\x2e\xe4\x8a" +
+		"\x70\xa1\x21\x22\x57\x73\xfa"
+)
+
+func TestGetInputCVEDecompresses(t *testing.T) {
+	xml, compressed := datastreamXML, compressedDatastream
+
+	for k, v := range map[string]struct {
+		payload    string
+		dist       Dist
+		feed       string
+		file       string
+		shouldFail bool
+	}{
+		"compressed feed":    {payload: compressed, dist: Dist{rhel, 7}, feed: "/feeds/com.redhat.rhsa-RHEL7.ds.xml.bz2", file: "com.redhat.rhsa-RHEL7.ds.xml"},
+		"compressed v2 feed": {payload: compressed, dist: Dist{Distributions[1], 8}, feed: "/feeds/v2/RHEL8/rhel-8.ds.xml.bz2", file: "rhel-8.ds.xml"},
+		"uncompressed feed":  {payload: xml, dist: Dist{rhel, 7}, feed: "/feeds/com.redhat.rhsa-RHEL7.ds.xml.bz2", file: "com.redhat.rhsa-RHEL7.ds.xml", shouldFail: true},
+	} {
+		dir, err := ioutil.TempDir("", "openscap-cve-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		requested := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = r.URL.Path
+			w.Write([]byte(v.payload))
+		}))
+		defer server.Close()
+
+		ts := &defaultOSCAPScanner{CVEDir: dir, Options: Options{CVEUrlAltPath: server.URL + "/feeds/"}}
+		cveFileName, err := ts.getInputCVE(v.dist)
+		if requested != v.feed {
+			t.Errorf("%s: unexpected feed %s requested", k, requested)
+		}
+		if v.shouldFail {
+			if err == nil {
+				t.Errorf("%s: expected the decompression to fail", k)
+			}
+			if _, err := os.Stat(path.Join(dir, v.file)); !os.IsNotExist(err) {
+				t.Errorf("%s: expected the partial file to be removed", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+			continue
+		}
+		if cveFileName != path.Join(dir, v.file) {
+			t.Errorf("%s: unexpected cve file %s", k, cveFileName)
+		}
+		if content, err := ioutil.ReadFile(cveFileName); err != nil || string(content) != xml {
+			t.Errorf("%s: expected the decompressed feed %q, got %q (%v)", k, xml, content, err)
+		}
+	}
+}
+
 func notEmptyValue(k, v string) error {
 	if len(v) == 0 {
 		return fmt.Errorf("the value should'nt be empty for key %s", k)
@@ -232,3 +751,246 @@ func TestSetOscapChrootEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestSetOscapChrootEnvOSVersion(t *testing.T) {
+	oldSetVar := osSetEnv
+	defer func() { osSetEnv = oldSetVar }()
+	root, err := ioutil.TempDir("", "os-version-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+	image := docker.Image{ID: "12345678901234567890"}
+
+	for k, v := range map[string]struct {
+		osRelease, redhatRelease string
+		expected                 string
+	}{
+		"os-release":          {osRelease: "NAME=\"Red Hat Enterprise Linux\"\nVERSION_ID=\"8.4\"\nID=\"rhel\"\n", expected: "8.4"},
+		"unquoted os-release": {osRelease: "ID=fedora\nVERSION_ID=34\n", expected: "34"},
+		"redhat-release":      {redhatRelease: "Red Hat Enterprise Linux Server release 7.9 (Maipo)\n", expected: "7.9"},
+		"no version":          {osRelease: "ID=alpine\n", expected: LinuxVersionPH},
+		"no release file":     {expected: LinuxVersionPH},
+	} {
+		os.RemoveAll(path.Join(root, "etc"))
+		if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+			t.Fatalf("unable to create etc: %v", err)
+		}
+		for name, content := range map[string]string{"etc/os-release": v.osRelease, "etc/redhat-release": v.redhatRelease} {
+			if len(content) == 0 {
+				continue
+			}
+			if err := ioutil.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatalf("unable to write %s: %v", name, err)
+			}
+		}
+		env := map[string]string{}
+		osSetEnv = func(k, v string) error {
+			env[k] = v
+			return nil
+		}
+		ts := &defaultOSCAPScanner{image: &image, imageMountPath: root}
+		if err := ts.setOscapChrootEnv(); err != nil {
+			t.Errorf("%s failed but shouldn't have. The error is %v", k, err)
+		}
+		if env["OSCAP_PROBE_OS_VERSION"] != v.expected {
+			t.Errorf("%s expected the OS version %q but got %q", k, v.expected, env["OSCAP_PROBE_OS_VERSION"])
+		}
+	}
+}
+
+func TestSetOscapChrootEnvProxy(t *testing.T) {
+	oldSetVar := osSetEnv
+	defer func() { osSetEnv = oldSetVar }()
+	for _, k := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Unsetenv(k)
+	}
+	os.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	os.Setenv("NO_PROXY", "localhost")
+	os.Setenv("no_proxy", "example.com")
+
+	image := docker.Image{ID: "12345678901234567890"}
+	for k, v := range map[string]struct {
+		fetchRemote bool
+		expectedEnv map[string]string
+	}{
+		"no remote resources": {expectedEnv: map[string]string{}},
+		"remote resources": {
+			fetchRemote: true,
+			// the lower case variables that are set are kept
+			expectedEnv: map[string]string{"https_proxy": "http://proxy.example.com:3128"},
+		},
+	} {
+		env := map[string]string{}
+		osSetEnv = func(k, v string) error {
+			if !strings.HasPrefix(k, "OSCAP_") {
+				env[k] = v
+			}
+			return nil
+		}
+		ts := &defaultOSCAPScanner{image: &image, imageMountPath: ".", Options: Options{FetchRemote: v.fetchRemote}}
+		if err := ts.setOscapChrootEnv(); err != nil {
+			t.Errorf("%s failed but shouldn't have. The error is %v", k, err)
+		}
+		if !reflect.DeepEqual(env, v.expectedEnv) {
+			t.Errorf("%s expected the proxy env %v but got %v", k, v.expectedEnv, env)
+		}
+	}
+}
+
+const sampleArf = `<?xml version="1.0" encoding="UTF-8"?>
+<arf:asset-report-collection xmlns:arf="http://scap.nist.gov/schema/asset-reporting-format/1.1">
+  <arf:report-requests>
+    <arf:report-request id="collection1">
+      <arf:content>
+        <Benchmark xmlns="http://checklists.nist.gov/xccdf/1.2" id="xccdf_org.open-scap_benchmark_from-OVAL">
+          <Rule id="xccdf_rule_rhsa-2019-0001" severity="high">
+            <title>RHSA-2019:0001: openssl security update (Important)</title>
+            <ident system="http://cve.mitre.org">CVE-2019-0001</ident>
+          </Rule>
+          <Rule id="xccdf_rule_rhsa-2019-0002" severity="medium">
+            <title>RHSA-2019:0002: bash security update (Moderate)</title>
+            <ident system="https://rhn.redhat.com/errata">RHSA-2019:0002</ident>
+          </Rule>
+          <Rule id="xccdf_rule_rhsa-2019-0003" severity="high">
+            <title>RHSA-2019:0003: kernel security update (Critical)</title>
+          </Rule>
+          <Rule id="xccdf_rule_rhsa-2019-0004" severity="low">
+            <title>RHSA-2019:0004: zlib security update (Low)</title>
+          </Rule>
+        </Benchmark>
+      </arf:content>
+    </arf:report-request>
+  </arf:report-requests>
+  <arf:reports>
+    <arf:report id="xccdf1">
+      <arf:content>
+        <TestResult xmlns="http://checklists.nist.gov/xccdf/1.2">
+          <rule-result idref="xccdf_rule_rhsa-2019-0001">
+            <result>fail</result>
+          </rule-result>
+          <rule-result idref="xccdf_rule_rhsa-2019-0002" severity="low">
+            <result>fail</result>
+            <ident system="http://cve.mitre.org">CVE-2019-0002</ident>
+          </rule-result>
+          <rule-result idref="xccdf_rule_rhsa-2019-0003">
+            <result>fail</result>
+            <check system="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+              <check-content-ref name="oval:com.redhat.rhsa:def:20190003" href="#oval0"/>
+            </check>
+          </rule-result>
+          <rule-result idref="xccdf_rule_rhsa-2019-0004">
+            <result>pass</result>
+          </rule-result>
+          <rule-result idref="xccdf_rule_unknown">
+            <result>fail</result>
+          </rule-result>
+          <rule-result idref="xccdf_rule_rhsa-2019-0001">
+            <result>notchecked</result>
+          </rule-result>
+        </TestResult>
+      </arf:content>
+    </arf:report>
+    <arf:report id="oval0">
+      <arf:content>
+        <oval_results xmlns="http://oval.mitre.org/XMLSchema/oval-results-5">
+          <oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+            <definitions>
+              <definition id="oval:com.redhat.rhsa:def:20190003" class="patch">
+                <metadata>
+                  <advisory><severity>Critical</severity></advisory>
+                </metadata>
+              </definition>
+            </definitions>
+          </oval_definitions>
+        </oval_results>
+      </arf:content>
+    </arf:report>
+  </arf:reports>
+</arf:asset-report-collection>
+`
+
+func TestParseResults(t *testing.T) {
+	type finding struct {
+		reference   string
+		description string
+		severities  []iiapi.Severity
+	}
+	expected := []finding{
+		{CVEDetailsUrl + "CVE-2019-0001", "RHSA-2019:0001: openssl security update (Important)", []iiapi.Severity{iiapi.SeverityImportant}},
+		{CVEDetailsUrl + "CVE-2019-0002", "RHSA-2019:0002: bash security update (Moderate)", []iiapi.Severity{iiapi.SeverityLow}},
+		{"xccdf_rule_rhsa-2019-0003", "RHSA-2019:0003: kernel security update (Critical)", []iiapi.Severity{iiapi.SeverityCritical}},
+		{"xccdf_rule_unknown", "xccdf_rule_unknown", nil},
+	}
+
+	results := ParseResults([]byte(sampleArf))
+	findings := []finding{}
+	for _, r := range results {
+		if r.Name != OpenSCAP || r.ScannerVersion != OpenSCAPVersion {
+			t.Errorf("unexpected scanner of the result %v", r)
+		}
+		f := finding{reference: r.Reference, description: r.Description}
+		for _, s := range r.Summary {
+			f.severities = append(f.severities, s.Label)
+		}
+		findings = append(findings, f)
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("expected the findings\n%v\ngot\n%v", expected, findings)
+	}
+
+	if results := ParseResults([]byte("<invalid")); len(results) != 0 {
+		t.Errorf("expected no results from an invalid report, got %v", results)
+	}
+}
+
+func TestSeverityLabel(t *testing.T) {
+	for severity, expected := range map[string]iiapi.Severity{
+		"info":      iiapi.SeverityLow,
+		"low":       iiapi.SeverityLow,
+		"medium":    iiapi.SeverityModerate,
+		"Moderate":  iiapi.SeverityModerate,
+		"high":      iiapi.SeverityImportant,
+		"Important": iiapi.SeverityImportant,
+		"Critical":  iiapi.SeverityCritical,
+		"unknown":   "",
+		"":          "",
+	} {
+		label, ok := severityLabel(severity)
+		if label != expected || ok != (len(expected) > 0) {
+			t.Errorf("expected the severity %q to be labeled %q, got %q (%v)", severity, expected, label, ok)
+		}
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	oldLookPath := execLookPath
+	defer func() { execLookPath = oldLookPath }()
+
+	for k, v := range map[string]struct {
+		lookPath      func(string) (string, error)
+		expectedError string
+	}{
+		"oscap installed": {lookPath: func(file string) (string, error) { return "/usr/bin/" + file, nil }},
+		"oscap missing": {
+			lookPath: func(file string) (string, error) {
+				return "", fmt.Errorf("exec: %q: executable file not found in $PATH", file)
+			},
+			expectedError: "oscap not found in PATH: exec: \"oscap\": executable file not found in $PATH",
+		},
+	} {
+		execLookPath = v.lookPath
+		err := CheckBinary()
+		if len(v.expectedError) == 0 && err != nil {
+			t.Errorf("%s: expected to succeed but failed with %v", k, err)
+		}
+		if len(v.expectedError) > 0 && (err == nil || err.Error() != v.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", k, v.expectedError, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "permissions"
+	ScannerVersion = "0.1"
+
+	// maxPrivateKeySize is the size above which the files are not searched for private keys
+	maxPrivateKeySize = 16 << 10
+
+	// groupOtherPerm are the permissions of the group and others
+	groupOtherPerm = 0077
+)
+
+// SensitiveFile describes the paths of a kind of file that must not be accessible to all the
+// users of the image.
+type SensitiveFile struct {
+	// Glob is matched against the absolute paths in the image, or against the file names
+	// anywhere in the image when it has no slash.
+	Glob string
+	// Description is what the matching files are
+	Description string
+	// Denied are the permissions of the group and others the matching files must not have
+	Denied os.FileMode
+}
+
+var (
+	// DefaultSensitiveFiles are the sensitive files found by their paths, the other regular
+	// files are searched for PEM private keys.
+	DefaultSensitiveFiles = []SensitiveFile{
+		{Glob: "/etc/shadow", Description: "password hashes file", Denied: 0006},
+		{Glob: "/etc/shadow-", Description: "password hashes backup", Denied: 0006},
+		{Glob: "/etc/gshadow", Description: "group password hashes file", Denied: 0006},
+		{Glob: "/etc/gshadow-", Description: "group password hashes backup", Denied: 0006},
+		{Glob: "/etc/passwd", Description: "user database", Denied: 0022},
+		{Glob: "/etc/group", Description: "group database", Denied: 0022},
+		{Glob: "/etc/sudoers", Description: "sudo policy", Denied: 0022},
+		{Glob: "/etc/sudoers.d/*", Description: "sudo policy", Denied: 0022},
+		{Glob: "/etc/ssh/ssh_host_*_key", Description: "SSH host private key", Denied: groupOtherPerm},
+		{Glob: "id_rsa", Description: "SSH private key", Denied: groupOtherPerm},
+		{Glob: "id_dsa", Description: "SSH private key", Denied: groupOtherPerm},
+		{Glob: "id_ecdsa", Description: "SSH private key", Denied: groupOtherPerm},
+		{Glob: "id_ed25519", Description: "SSH private key", Denied: groupOtherPerm},
+		{Glob: ".pgpass", Description: "PostgreSQL password file", Denied: groupOtherPerm},
+		{Glob: ".netrc", Description: "netrc credentials file", Denied: groupOtherPerm},
+		{Glob: ".git-credentials", Description: "git credentials file", Denied: groupOtherPerm},
+	}
+
+	// privateKeyHeader matches the header of the PEM private keys, e.g. RSA, EC or OpenSSH
+	privateKeyHeader = regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY-----`)
+)
+
+type permissionsScanner struct {
+	walker.Sink
+	files []SensitiveFile
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &permissionsScanner{}
+
+// NewScanner returns a new scanner reporting the DefaultSensitiveFiles and the private keys
+// that the group or others can access. The modes of the files must have been preserved
+// when extracting the image.
+func NewScanner() iiapi.Scanner {
+	return &permissionsScanner{files: DefaultSensitiveFiles}
+}
+
+// match returns the sensitive file matching the image path name.
+func (s *permissionsScanner) match(name string) *SensitiveFile {
+	for n, f := range s.files {
+		target := name
+		if !strings.Contains(f.Glob, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(f.Glob, target); ok {
+			return &s.files[n]
+		}
+	}
+	return nil
+}
+
+// isPrivateKey returns whether the file p holds a PEM private key.
+func isPrivateKey(p string, info os.FileInfo) (bool, error) {
+	if info.Size() > maxPrivateKeySize {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	return privateKeyHeader.Match(data), nil
+}
+
+// chmodArgs returns the symbolic chmod mode removing the permissions perm of the group and
+// others, e.g. go-r for 0044.
+func chmodArgs(perm os.FileMode) string {
+	args := []string{}
+	byWho := map[string]string{}
+	for _, who := range []struct {
+		name string
+		read os.FileMode
+	}{{"g", 0040}, {"o", 0004}} {
+		bits := ""
+		for n, flag := range "rwx" {
+			if perm&(who.read>>uint(n)) != 0 {
+				bits += string(flag)
+			}
+		}
+		if len(bits) == 0 {
+			continue
+		}
+		if _, ok := byWho[bits]; !ok {
+			args = append(args, bits)
+		}
+		byWho[bits] += who.name
+	}
+	for n, bits := range args {
+		args[n] = byWho[bits] + "-" + bits
+	}
+	return strings.Join(args, ",")
+}
+
+// Scan reports the sensitive files under path that the group or others can access.
+func (s *permissionsScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("permissions")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = walker.Walk(ctx, root, filter, func(p string, info os.FileInfo) error {
+		perm := info.Mode().Perm()
+		if !info.Mode().IsRegular() || perm&groupOtherPerm == 0 {
+			return nil
+		}
+		name := path.Join("/", strings.TrimPrefix(p, root))
+		description, denied := "", os.FileMode(0)
+		if f := s.match(name); f != nil {
+			description, denied = f.Description, f.Denied
+		} else if perm&0044 != 0 {
+			ok, err := isPrivateKey(p, info)
+			if err != nil {
+				log.Printf("WARNING: Unable to read %s: %v", name, err)
+				return nil
+			}
+			if ok {
+				description, denied = "private key", groupOtherPerm
+			}
+		}
+		if perm&denied == 0 {
+			return nil
+		}
+		scanResults.Add(iiapi.Result{
+			Name:           ScannerName,
+			ScannerVersion: ScannerVersion,
+			Timestamp:      scanResults.Started(),
+			Reference:      fmt.Sprintf("file://%s", name),
+			Description: fmt.Sprintf("%s with the mode %04o accessible to the group or others, run chmod %s on it in the layer that creates it",
+				description, perm, chmodArgs(perm&denied)),
+			Summary: []iiapi.Summary{{Label: iiapi.SeverityImportant}},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to scan %s: %v\n", mountPath, err)
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *permissionsScanner) Name() string {
+	return ScannerName
+}
@@ -0,0 +1,184 @@
+package shadowed
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "shadowed"
+	ScannerVersion = "0.1"
+
+	// DefaultPath is the PATH of the containers whose image doesn't set one
+	DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// maxSymlinkHops is the number of symlinks followed when resolving a path in the image
+	maxSymlinkHops = 40
+)
+
+type shadowedScanner struct {
+	walker.Sink
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &shadowedScanner{}
+
+// NewScanner returns a new scanner reporting the executables of the PATH directories of the
+// image that shadow an executable with the same name in a later PATH directory.
+func NewScanner() iiapi.Scanner {
+	return &shadowedScanner{}
+}
+
+// executable is an executable found in a PATH directory.
+type executable struct {
+	// name is the path of the executable, in the PATH directory as written in the PATH
+	name string
+	info os.FileInfo
+}
+
+// pathDirs returns the absolute directories of the PATH of image, or of the DefaultPath
+// when the image doesn't set one.
+func pathDirs(image *docker.Image) []string {
+	env := DefaultPath
+	if image != nil && image.Config != nil {
+		for _, e := range image.Config.Env {
+			if strings.HasPrefix(e, "PATH=") {
+				env = strings.TrimPrefix(e, "PATH=")
+			}
+		}
+	}
+	dirs := []string{}
+	for _, dir := range strings.Split(env, ":") {
+		// the relative directories depend on the working directory of the commands
+		if path.IsAbs(dir) {
+			dirs = append(dirs, path.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+// resolveInRoot resolves the symlinks of p as if root was the root directory. The symlinks
+// can't be resolved by the host since root may itself be a symlink (e.g. /proc/<pid>/root).
+func resolveInRoot(root, p string) string {
+	resolved := "/"
+	parts := strings.Split(p, "/")
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil || hops >= maxSymlinkHops {
+			resolved = next
+			continue
+		}
+		hops++
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return resolved
+}
+
+// Scan reports the executables of the PATH directories of image under mountPath that shadow
+// another executable with the same name in a later PATH directory.
+func (s *shadowedScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("shadowed binaries")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// found are the executables of each name in the order of the PATH directories
+	found := map[string][]executable{}
+	// the directories symlinked to each other, e.g. /bin -> usr/bin, are listed once
+	listed := map[string]struct{}{}
+	for _, dir := range pathDirs(image) {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		resolved := resolveInRoot(root, dir)
+		if _, ok := listed[resolved]; ok {
+			continue
+		}
+		listed[resolved] = struct{}{}
+		dirInfo, err := os.Stat(path.Join(root, resolved))
+		if err != nil || !dirInfo.IsDir() {
+			continue
+		}
+		if filter != nil && !filter(path.Join(root, resolved), dirInfo) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(path.Join(root, resolved))
+		if err != nil {
+			log.Printf("WARNING: Unable to list %s: %v", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			// the symlinks are resolved in the image, the executable is what they point to
+			p := path.Join(root, resolveInRoot(root, path.Join(resolved, entry.Name())))
+			info, err := os.Lstat(p)
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			if filter != nil && !filter(p, info) {
+				continue
+			}
+			found[entry.Name()] = append(found[entry.Name()], executable{name: path.Join(dir, entry.Name()), info: info})
+		}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		first := found[name][0]
+		shadowed := []string{}
+		for _, e := range found[name][1:] {
+			// the symlinks and hard links to the same executable don't shadow it
+			if !os.SameFile(first.info, e.info) {
+				shadowed = append(shadowed, e.name)
+			}
+		}
+		if len(shadowed) == 0 {
+			continue
+		}
+		scanResults.Add(iiapi.Result{
+			Name:           ScannerName,
+			ScannerVersion: ScannerVersion,
+			Timestamp:      scanResults.Started(),
+			Reference:      fmt.Sprintf("file://%s", first.name),
+			Description: fmt.Sprintf("executable shadowing %s later in the PATH, check that it's not replacing the command of the image",
+				strings.Join(shadowed, ", ")),
+			Summary: []iiapi.Summary{{Label: iiapi.SeverityModerate}},
+		})
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *shadowedScanner) Name() string {
+	return ScannerName
+}
@@ -0,0 +1,111 @@
+package shadowed
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+func TestScan(t *testing.T) {
+	root := walkertest.NewFixture(t,
+		walkertest.File{Name: "usr/local/bin/ls", Content: "usr/local/bin/ls", Mode: 0755},
+		walkertest.File{Name: "usr/bin/ls", Content: "usr/bin/ls", Mode: 0755},
+		walkertest.File{Name: "usr/local/bin/python", Content: "usr/local/bin/python", Mode: 0755},
+		walkertest.File{Name: "usr/bin/cat", Content: "usr/bin/cat", Mode: 0755},
+		walkertest.File{Name: "usr/local/bin/README", Content: "usr/local/bin/README", Mode: 0644},
+		walkertest.File{Name: "usr/bin/README", Content: "usr/bin/README", Mode: 0644},
+		walkertest.File{Name: "usr/local/sbin/id", Content: "usr/local/sbin/id", Mode: 0755},
+		walkertest.File{Name: "usr/sbin/id", Content: "usr/sbin/id", Mode: 0755},
+		walkertest.File{Name: "skipped/bin/cat", Content: "skipped/bin/cat", Mode: 0755},
+	)
+	defer os.RemoveAll(root)
+	for link, target := range map[string]string{
+		// the usrmerge symlinks don't make all the executables shadowed
+		"bin":  "usr/bin",
+		"sbin": "/usr/sbin",
+		// neither do the symlinks to the shadowed executable
+		"usr/local/bin/cat": "../../bin/cat",
+	} {
+		if err := os.Symlink(target, path.Join(root, link)); err != nil {
+			t.Fatalf("unable to create symlink %s: %v", link, err)
+		}
+	}
+
+	results, _, err := NewScanner().Scan(context.Background(), root, nil, nil)
+	if err != nil {
+		t.Fatalf("expected to succeed but failed with %v", err)
+	}
+	expected := map[string]string{
+		"file:///usr/local/bin/ls":  "executable shadowing /usr/bin/ls later in the PATH, check that it's not replacing the command of the image",
+		"file:///usr/local/sbin/id": "executable shadowing /usr/sbin/id later in the PATH, check that it's not replacing the command of the image",
+	}
+	if len(results) != len(expected) {
+		t.Errorf("expected %d results, got %v", len(expected), results)
+	}
+	for _, r := range results {
+		description, ok := expected[r.Reference]
+		if !ok {
+			t.Errorf("unexpected result %v", r)
+			continue
+		}
+		if r.Name != ScannerName || r.Description != description ||
+			!reflect.DeepEqual(r.Summary, []iiapi.Summary{{Label: iiapi.SeverityModerate}}) {
+			t.Errorf("expected the result of %s to be %q, got %v", r.Reference, description, r)
+		}
+	}
+
+	// the PATH of the image replaces the default one, the filter is applied to the executables
+	image := &docker.Image{Config: &docker.Config{Env: []string{"HOME=/root", "PATH=/skipped/bin:/usr/bin:relative"}}}
+	filter := func(p string, info os.FileInfo) bool {
+		return !strings.HasPrefix(p, path.Join(root, "skipped"))
+	}
+	for _, v := range []struct {
+		filter   iiapi.FilesFilter
+		expected []string
+	}{
+		{filter: nil, expected: []string{"file:///skipped/bin/cat"}},
+		{filter: filter, expected: []string{}},
+	} {
+		results, _, err := NewScanner().Scan(context.Background(), root, image, v.filter)
+		if err != nil {
+			t.Fatalf("expected to succeed but failed with %v", err)
+		}
+		references := []string{}
+		for _, r := range results {
+			references = append(references, r.Reference)
+		}
+		if !reflect.DeepEqual(references, v.expected) {
+			t.Errorf("expected the results %v, got %v", v.expected, references)
+		}
+	}
+}
+
+func TestScanRequiresDirectory(t *testing.T) {
+	if _, _, err := NewScanner().Scan(context.Background(), "shadowed.go", nil, nil); err == nil {
+		t.Errorf("expected the scan of a file to fail")
+	}
+}
+
+func TestPathDirs(t *testing.T) {
+	for k, v := range map[string]struct {
+		image    *docker.Image
+		expected []string
+	}{
+		"no image":  {image: nil, expected: strings.Split(DefaultPath, ":")},
+		"no config": {image: &docker.Image{}, expected: strings.Split(DefaultPath, ":")},
+		"no PATH":   {image: &docker.Image{Config: &docker.Config{Env: []string{"HOME=/root"}}}, expected: strings.Split(DefaultPath, ":")},
+		"PATH":      {image: &docker.Image{Config: &docker.Config{Env: []string{"PATH=/opt/app/bin/:/usr/bin::bin"}}}, expected: []string{"/opt/app/bin", "/usr/bin"}},
+	} {
+		if dirs := pathDirs(v.image); !reflect.DeepEqual(dirs, v.expected) {
+			t.Errorf("%s: expected %v, got %v", k, v.expected, dirs)
+		}
+	}
+}
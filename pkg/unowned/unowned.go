@@ -0,0 +1,242 @@
+package unowned
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker"
+)
+
+const (
+	ScannerName    = "unowned"
+	ScannerVersion = "0.1"
+
+	// DpkgInfoDir is the directory holding the file lists of the installed deb packages
+	DpkgInfoDir = "var/lib/dpkg/info"
+	// ApkInstalledDB is the database of the installed apk packages
+	ApkInstalledDB = "lib/apk/db/installed"
+	// RPMDBDir is the directory holding the database of the installed rpm packages
+	RPMDBDir = "var/lib/rpm"
+
+	// maxSymlinkHops limits the symlinks followed when resolving a path in the image
+	maxSymlinkHops = 40
+)
+
+var (
+	// ExecutableDirs are the directories, relative to the image root, searched for executables
+	// that are not owned by any package.
+	ExecutableDirs = []string{
+		"bin", "sbin", "lib", "lib64",
+		"usr/bin", "usr/sbin", "usr/lib", "usr/lib64",
+		"usr/local/bin", "usr/local/sbin", "usr/local/lib", "usr/local/lib64",
+	}
+)
+
+// rpmFilesFunc provides an injectable way to list the files owned by the rpm packages for testing.
+type rpmFilesFunc func(ctx context.Context, root string) ([]string, error)
+
+type unownedScanner struct {
+	walker.Sink
+	rpmFiles rpmFilesFunc
+}
+
+// ensure interface is implemented
+var _ iiapi.IncrementalScanner = &unownedScanner{}
+
+// NewScanner returns a new scanner reporting the executables not owned by the package manager.
+func NewScanner() iiapi.Scanner {
+	return &unownedScanner{rpmFiles: queryRPMFiles}
+}
+
+// queryRPMFiles returns the files owned by the rpm packages installed under root.
+func queryRPMFiles(ctx context.Context, root string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "rpm", "--root", root, "-qa", "--queryformat", "[%{FILENAMES}\n]")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query the rpm database: %v\n", err)
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// dpkgFiles returns the files owned by the deb packages installed under root.
+func dpkgFiles(root string) ([]string, error) {
+	lists, err := filepath.Glob(path.Join(root, DpkgInfoDir, "*.list"))
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, list := range lists {
+		content, err := ioutil.ReadFile(list)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read dpkg file list %s: %v\n", list, err)
+		}
+		files = append(files, strings.Split(strings.TrimSpace(string(content)), "\n")...)
+	}
+	return files, nil
+}
+
+// apkFiles returns the files owned by the apk packages installed under root.
+func apkFiles(root string) ([]string, error) {
+	db, err := os.Open(path.Join(root, ApkInstalledDB))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the apk database: %v\n", err)
+	}
+	defer db.Close()
+
+	files := []string{}
+	dir := ""
+	scanner := bufio.NewScanner(db)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "F:"):
+			dir = strings.TrimPrefix(line, "F:")
+		case strings.HasPrefix(line, "R:"):
+			files = append(files, path.Join("/", dir, strings.TrimPrefix(line, "R:")))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the apk database: %v\n", err)
+	}
+	return files, nil
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// resolveInRoot resolves the symlinks of p as if root was the root directory. The symlinks
+// can't be resolved by the host since root may itself be a symlink (e.g. /proc/<pid>/root).
+func resolveInRoot(root, p string) string {
+	resolved := "/"
+	parts := strings.Split(p, "/")
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil || hops >= maxSymlinkHops {
+			resolved = next
+			continue
+		}
+		hops++
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return resolved
+}
+
+// ownedFiles returns the set of files, relative to root, owned by the packages installed
+// under root. The directories of the package databases are resolved in the image so that
+// files installed through symlinked directories (e.g. /bin -> usr/bin) are matched.
+func (s *unownedScanner) ownedFiles(ctx context.Context, root string) (map[string]struct{}, error) {
+	var (
+		files []string
+		found bool
+	)
+	for _, db := range []struct {
+		path  string
+		files func() ([]string, error)
+	}{
+		{path.Join(root, RPMDBDir), func() ([]string, error) { return s.rpmFiles(ctx, root) }},
+		{path.Join(root, DpkgInfoDir), func() ([]string, error) { return dpkgFiles(root) }},
+		{path.Join(root, ApkInstalledDB), func() ([]string, error) { return apkFiles(root) }},
+	} {
+		if !exists(db.path) {
+			continue
+		}
+		dbFiles, err := db.files()
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		files = append(files, dbFiles...)
+	}
+	if !found {
+		return nil, fmt.Errorf("no package database found in %s", root)
+	}
+
+	resolvedDirs := map[string]string{}
+	owned := map[string]struct{}{}
+	for _, f := range files {
+		f = path.Clean(path.Join("/", strings.TrimSpace(f)))
+		owned[f] = struct{}{}
+
+		dir := path.Dir(f)
+		resolved, ok := resolvedDirs[dir]
+		if !ok {
+			resolved = resolveInRoot(root, dir)
+			resolvedDirs[dir] = resolved
+		}
+		owned[path.Join(resolved, path.Base(f))] = struct{}{}
+	}
+	return owned, nil
+}
+
+// Scan reports the executables under the ExecutableDirs of path that are not owned by any package.
+func (s *unownedScanner) Scan(ctx context.Context, mountPath string, image *docker.Image, filter iiapi.FilesFilter) ([]iiapi.Result, interface{}, error) {
+	scanResults := s.Start("unowned files")
+	defer scanResults.Done()
+
+	root, err := walker.Root(mountPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	owned, err := s.ownedFiles(ctx, root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, dir := range ExecutableDirs {
+		// skipping symlinked directories since their content is walked through their targets
+		if fi, err := os.Lstat(path.Join(root, dir)); err != nil || !fi.IsDir() {
+			continue
+		}
+		err := walker.Walk(ctx, path.Join(root, dir), filter, func(p string, info os.FileInfo) error {
+			if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				return nil
+			}
+			name := path.Join("/", strings.TrimPrefix(p, root))
+			if _, ok := owned[name]; ok {
+				return nil
+			}
+			scanResults.Add(iiapi.Result{
+				Name:           ScannerName,
+				ScannerVersion: ScannerVersion,
+				Timestamp:      scanResults.Started(),
+				Reference:      fmt.Sprintf("file://%s", name),
+				Description:    "executable not owned by any installed package",
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to scan %s: %v\n", dir, err)
+		}
+	}
+
+	return scanResults.List(), nil, nil
+}
+
+func (s *unownedScanner) Name() string {
+	return ScannerName
+}
@@ -0,0 +1,123 @@
+package unowned
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+func references(results []iiapi.Result) []string {
+	refs := []string{}
+	for _, r := range results {
+		refs = append(refs, r.Reference)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	noRPM := func(context.Context, string) ([]string, error) {
+		return nil, fmt.Errorf("rpm should not be queried")
+	}
+
+	for k, v := range map[string]struct {
+		files      []walkertest.File
+		rpmFiles   rpmFilesFunc
+		shouldFail bool
+		expected   []string
+	}{
+		"dpkg image with an unowned binary": {
+			files: []walkertest.File{
+				{Name: "bin", Link: "usr/bin"},
+				{Name: "usr/bin/ls", Mode: 0755},
+				{Name: "usr/bin/cat", Mode: 0755},
+				{Name: "usr/bin/dropped", Mode: 0755},
+				{Name: "usr/lib/libc.so.6", Mode: 0644},
+				{Name: "usr/lib/x86_64/helper", Mode: 0755},
+				{Name: "etc/not-scanned", Mode: 0755},
+				{Name: DpkgInfoDir + "/coreutils.list", Content: "/.\n/bin\n/bin/ls\n/usr/bin/cat\n"},
+				{Name: DpkgInfoDir + "/libc.list", Content: "/usr/lib/libc.so.6\n/usr/lib/x86_64/helper\n"},
+			},
+			rpmFiles: noRPM,
+			expected: []string{"file:///usr/bin/dropped"},
+		},
+		"rpm image with an unowned binary": {
+			files: []walkertest.File{
+				{Name: RPMDBDir + "/Packages", Content: "db"},
+				{Name: "usr/bin/bash", Mode: 0755},
+				{Name: "usr/local/bin/tool", Mode: 0755},
+			},
+			rpmFiles: func(context.Context, string) ([]string, error) {
+				return []string{"/usr/bin/bash", "/usr/share/doc/bash"}, nil
+			},
+			expected: []string{"file:///usr/local/bin/tool"},
+		},
+		"apk image with an unowned binary": {
+			files: []walkertest.File{
+				{Name: ApkInstalledDB, Content: "P:busybox\nF:bin\nR:busybox\n\nP:musl\nF:lib\nR:ld-musl.so.1\n"},
+				{Name: "bin/busybox", Mode: 0755},
+				{Name: "lib/ld-musl.so.1", Mode: 0755},
+				{Name: "sbin/dropped", Mode: 0755},
+			},
+			rpmFiles: noRPM,
+			expected: []string{"file:///sbin/dropped"},
+		},
+		"no package database": {
+			files:      []walkertest.File{{Name: "usr/bin/dropped", Mode: 0755}},
+			rpmFiles:   noRPM,
+			shouldFail: true,
+		},
+	} {
+		root := walkertest.NewFixture(t, v.files...)
+		defer os.RemoveAll(root)
+
+		scanner := &unownedScanner{rpmFiles: v.rpmFiles}
+		results, _, err := scanner.Scan(ctx, root, nil, nil)
+		if v.shouldFail {
+			if err == nil {
+				t.Errorf("%s should have failed but it didn't!", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s should have succeeded but failed with %v", k, err)
+			continue
+		}
+		if refs := references(results); !reflect.DeepEqual(refs, v.expected) {
+			t.Errorf("%s: expected %v to be reported, got %v", k, v.expected, refs)
+		}
+		for _, r := range results {
+			if r.Name != ScannerName {
+				t.Errorf("%s: unexpected result name %s", k, r.Name)
+			}
+		}
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := walkertest.NewFixture(t,
+		walkertest.File{Name: "bin", Link: "usr/bin"},
+		walkertest.File{Name: "usr/bin/ls", Mode: 0755},
+		walkertest.File{Name: "lib", Link: "/usr/lib"},
+		walkertest.File{Name: "usr/lib/escape", Link: "../../../../etc"},
+	)
+	defer os.RemoveAll(root)
+
+	for p, expected := range map[string]string{
+		"/bin":            "/usr/bin",
+		"/lib/x86_64":     "/usr/lib/x86_64",
+		"/usr/lib/escape": "/etc",
+		"/missing/dir":    "/missing/dir",
+	} {
+		if resolved := resolveInRoot(root, p); resolved != expected {
+			t.Errorf("expected %s to resolve to %s, got %s", p, expected, resolved)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package util
 
+import "strings"
+
 func StrOrDefault(s string, d string) string {
 	if len(s) == 0 { // s || d
 		return d
@@ -14,6 +16,17 @@ func Min(x, y int) int {
 	return y
 }
 
+// SplitList splits a sep separated list dropping the surrounding spaces and the empty items.
+func SplitList(s string, sep string) []string {
+	l := []string{}
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
 func StringInList(s string, l []string) bool {
 	for _, opt := range l {
 		if s == opt {
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -30,3 +31,15 @@ func TestStringInList(t *testing.T) {
 		t.Errorf("Is not found in the list")
 	}
 }
+
+func TestSplitList(t *testing.T) {
+	for s, expected := range map[string][]string{
+		"":                     {},
+		"GET":                  {"GET"},
+		"GET, HEAD,,PROPFIND ": {"GET", "HEAD", "PROPFIND"},
+	} {
+		if l := SplitList(s, ","); !reflect.DeepEqual(l, expected) {
+			t.Errorf("expected %q to be split into %v, got %v", s, expected, l)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package version
+
+import (
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// The build provenance of image-inspector, set at build time with the -X linker flag (see
+// hack/common.sh).
+var (
+	// Version is the version of image-inspector
+	Version = "unknown"
+	// Commit is the git commit image-inspector was built from
+	Commit = "unknown"
+	// BuildDate is the time image-inspector was built at, in RFC3339 format
+	BuildDate = "unknown"
+)
+
+// Provenance returns the build provenance of image-inspector.
+func Provenance() iiapi.ToolProvenance {
+	return iiapi.ToolProvenance{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+	}
+}
+
+// String returns the build provenance in human readable form.
+func String() string {
+	return "image-inspector " + Version + " (commit " + Commit + ", built " + BuildDate + ")"
+}
@@ -0,0 +1,23 @@
+package version
+
+import (
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+func TestProvenance(t *testing.T) {
+	oldVersion, oldCommit, oldBuildDate := Version, Commit, BuildDate
+	defer func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldBuildDate }()
+
+	// the variables injected by the build
+	Version, Commit, BuildDate = "v2.1.0", "0123abc", "2018-01-02T03:04:05Z"
+
+	expected := iiapi.ToolProvenance{Version: "v2.1.0", Commit: "0123abc", BuildDate: "2018-01-02T03:04:05Z"}
+	if provenance := Provenance(); provenance != expected {
+		t.Errorf("expected provenance %v, got %v", expected, provenance)
+	}
+	if s := String(); s != "image-inspector v2.1.0 (commit 0123abc, built 2018-01-02T03:04:05Z)" {
+		t.Errorf("unexpected version string %q", s)
+	}
+}
@@ -0,0 +1,113 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+)
+
+// Sink implements the SetResultsSink of the iiapi.IncrementalScanner for the scanners
+// embedding it.
+type Sink struct {
+	// sink is passed each result as soon as it's found, when set
+	sink func(iiapi.Result)
+}
+
+// SetResultsSink makes the scanner pass each result to sink as soon as it's found.
+func (s *Sink) SetResultsSink(sink func(iiapi.Result)) {
+	s.sink = sink
+}
+
+// Start starts a scan whose results are passed to the sink as soon as they're found.
+func (s *Sink) Start(name string) *Results {
+	return Start(name, s.sink)
+}
+
+// Results are the results of a scan.
+type Results struct {
+	name    string
+	started time.Time
+	sink    func(iiapi.Result)
+	results []iiapi.Result
+}
+
+// Start starts the scan name, e.g. "unowned files", passing each result to sink as soon as
+// it's found when sink is set.
+func Start(name string, sink func(iiapi.Result)) *Results {
+	return &Results{name: name, started: time.Now(), sink: sink, results: []iiapi.Result{}}
+}
+
+// Started returns when the scan started, the timestamp of its results.
+func (r *Results) Started() time.Time {
+	return r.started
+}
+
+// Add adds a result found by the scan.
+func (r *Results) Add(result iiapi.Result) {
+	r.results = append(r.results, result)
+	if r.sink != nil {
+		r.sink(result)
+	}
+}
+
+// List returns the results found so far.
+func (r *Results) List() []iiapi.Result {
+	return r.results
+}
+
+// Done logs how long the scan took and how many results it found.
+func (r *Results) Done() {
+	log.Printf("%s scan took %ds (%d problems found)", r.name, int64(time.Since(r.started).Seconds()), len(r.results))
+}
+
+// Root returns the cleaned mountPath, or an error when it's not a directory.
+func Root(mountPath string) (string, error) {
+	fi, err := os.Stat(mountPath)
+	if err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory, error: %v", mountPath, err)
+	}
+	return path.Clean(mountPath), nil
+}
+
+// Walk calls fn for the files and directories under root that filter accepts, the
+// directories it doesn't accept are skipped altogether. The entries that can't be read are
+// logged and skipped, the walk stops with an error only when ctx is done or fn fails.
+func Walk(ctx context.Context, root string, filter iiapi.FilesFilter, fn func(p string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("WARNING: Unable to scan %s: %v", p, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if filter != nil && !filter(p, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(p, info)
+	})
+}
+
+// NonEmptyFiles returns the number of regular files under dir that aren't empty.
+func NonEmptyFiles(dir string) int {
+	count := 0
+	Walk(context.Background(), dir, nil, func(p string, info os.FileInfo) error {
+		if info.Mode().IsRegular() && info.Size() > 0 {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,86 @@
+package walker
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	iiapi "github.com/openshift/image-inspector/pkg/api"
+	"github.com/openshift/image-inspector/pkg/walker/walkertest"
+)
+
+func TestWalk(t *testing.T) {
+	root := walkertest.NewFixture(t, walkertest.Contents(map[string]string{
+		"etc/os-release":   "ID=rhel\n",
+		"skipped/file":     "",
+		"skipped/dir/file": "",
+		"usr/bin/ls":       "",
+	})...)
+	defer os.RemoveAll(root)
+	filter := func(p string, info os.FileInfo) bool {
+		return !strings.HasPrefix(p, path.Join(root, "skipped"))
+	}
+
+	walked := []string{}
+	err := Walk(context.Background(), root, filter, func(p string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			walked = append(walked, strings.TrimPrefix(p, root))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error walking the fixture: %v", err)
+	}
+	sort.Strings(walked)
+	if expected := []string{"/etc/os-release", "/usr/bin/ls"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected to walk %v, got %v", expected, walked)
+	}
+
+	// the entries that can't be read are skipped
+	if err := Walk(context.Background(), path.Join(root, "missing"), nil, func(p string, info os.FileInfo) error {
+		t.Errorf("unexpected entry %s", p)
+		return nil
+	}); err != nil {
+		t.Errorf("expected the missing entries to be skipped, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Walk(ctx, root, nil, func(string, os.FileInfo) error { return nil }); err != context.Canceled {
+		t.Errorf("expected the walk to stop once cancelled, got %v", err)
+	}
+}
+
+func TestNonEmptyFiles(t *testing.T) {
+	root := walkertest.NewFixture(t, walkertest.Contents(map[string]string{
+		"cache/archive.deb": "deb",
+		"cache/lock":        "",
+		"cache/partial/":    "",
+	})...)
+	defer os.RemoveAll(root)
+
+	if count := NonEmptyFiles(path.Join(root, "cache")); count != 1 {
+		t.Errorf("expected one non-empty file, got %d", count)
+	}
+}
+
+func TestResults(t *testing.T) {
+	sunk := []iiapi.Result{}
+	s := &Sink{}
+	s.SetResultsSink(func(r iiapi.Result) { sunk = append(sunk, r) })
+
+	results := s.Start("test")
+	results.Add(iiapi.Result{Reference: "file:///usr/bin/ls"})
+	results.Done()
+	if expected := []iiapi.Result{{Reference: "file:///usr/bin/ls"}}; !reflect.DeepEqual(results.List(), expected) ||
+		!reflect.DeepEqual(sunk, expected) {
+		t.Errorf("expected the result to be listed and passed to the sink, got %v and %v", results.List(), sunk)
+	}
+	if _, err := Root("walker.go"); err == nil {
+		t.Errorf("expected a file not to be a scan root")
+	}
+}
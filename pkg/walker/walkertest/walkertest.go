@@ -0,0 +1,79 @@
+package walkertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// File is a file of a fixture created by NewFixture.
+type File struct {
+	// Name is the path of the file in the fixture, a directory when it ends with a slash
+	Name string
+	// Content is the content of a regular file
+	Content string
+	// Mode is the mode of the file, 0644 for the regular files and 0755 for the
+	// directories when unset
+	Mode os.FileMode
+	// Link makes the file a symlink to Link when set
+	Link string
+}
+
+// Contents returns the regular files of the contents, name to content, in name order. The
+// names ending with a slash are directories.
+func Contents(contents map[string]string) []File {
+	files := []File{}
+	for name, content := range contents {
+		files = append(files, File{Name: name, Content: content})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// NewFixture creates the files in a new directory, the caller removes it.
+func NewFixture(t *testing.T, files ...File) string {
+	root, err := ioutil.TempDir("", "fixture-")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	for _, f := range files {
+		p := path.Join(root, f.Name)
+		if strings.HasSuffix(f.Name, "/") {
+			mode := f.Mode
+			if mode == 0 {
+				mode = 0755
+			}
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatalf("unable to create %s: %v", f.Name, err)
+			}
+			if err := os.Chmod(p, mode); err != nil {
+				t.Fatalf("unable to set the mode of %s: %v", f.Name, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("unable to create directory for %s: %v", f.Name, err)
+		}
+		if len(f.Link) > 0 {
+			if err := os.Symlink(f.Link, p); err != nil {
+				t.Fatalf("unable to create %s: %v", f.Name, err)
+			}
+			continue
+		}
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := ioutil.WriteFile(p, []byte(f.Content), 0600); err != nil {
+			t.Fatalf("unable to create %s: %v", f.Name, err)
+		}
+		// the mode is set after creating the file so that the umask doesn't apply
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatalf("unable to set the mode of %s: %v", f.Name, err)
+		}
+	}
+	return root
+}